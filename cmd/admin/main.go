@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -13,6 +14,7 @@ import (
 	"self-driving-car-dispatch-system/internal/delivery/http1"
 	"self-driving-car-dispatch-system/internal/repo/redis"
 	"self-driving-car-dispatch-system/internal/usecase/service"
+	"self-driving-car-dispatch-system/pkg/pki"
 	redisClient "self-driving-car-dispatch-system/pkg/redis"
 	"syscall"
 	"time"
@@ -34,13 +36,34 @@ func main() {
 	viper.AddConfigPath("./config")         // Папка с конфигурацией
 	viper.AddConfigPath(".")                // Корень проекта
 	viper.AddConfigPath("./config/example") // Если не нашли актуальную конфигурацию, то читаем пример конфигурации
+	viper.SetDefault("vehicle_certificate_ttl", 365*24*time.Hour)
+	viper.SetDefault("token_ttl", time.Hour)
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf("Ошибка чтения файла конфигурации: %s", err)
 	}
 	if err := viper.Unmarshal(&cfg); err != nil {
 		log.Fatalf("Ошибка чтения файла конфигурации: %s", err)
 	}
-	cfg.SecretKey = os.Getenv("SECRET_KEY")
+	cfg.TokenKey = os.Getenv("ADMIN_TOKEN_KEY")
+	cfg.AdminLogin = os.Getenv("ADMIN_LOGIN")
+	cfg.AdminPassword = os.Getenv("ADMIN_PASSWORD")
+	tokenKey := []byte(cfg.TokenKey)
+	if len(tokenKey) == 0 {
+		log.Warnln("ADMIN_TOKEN_KEY не задан, токены администраторов будут недействительны после перезапуска сервера")
+		tokenKey = make([]byte, 32)
+		if _, err := rand.Read(tokenKey); err != nil {
+			log.Fatalf("Ошибка создания ключа подписи токенов: %s", err)
+		}
+	}
+	// Без удостоверяющего центра администратор не может выпускать сертификаты ТС
+	var vehicleCA *pki.CA
+	if cfg.VehicleCACertFile != "" && cfg.VehicleCAKeyFile != "" {
+		ca, err := pki.LoadCA(cfg.VehicleCACertFile, cfg.VehicleCAKeyFile)
+		if err != nil {
+			log.Fatalf("Ошибка загрузки удостоверяющего центра ТС: %s", err)
+		}
+		vehicleCA = ca
+	}
 	/*
 		Подключение к redis
 	*/
@@ -48,12 +71,30 @@ func main() {
 	if err != nil {
 		log.Fatalf("Ошибка подключения к redis: %s", err)
 	}
+	// записи, созданные до появления индексов ТС и диспетчеров, добавляются в индексы один раз
+	if err := redis.BuildIndexes(rdsClient); err != nil {
+		log.Fatalf("Ошибка построения индексов: %s", err)
+	}
 	/*
 		Инициализация репозиториев, сервисов и обработчиков
 	*/
 	dispatcherRepo := redis.NewDispatcherRepo(rdsClient)
 	vehicleRepo := redis.NewVehicleRepo(rdsClient)
-	adminUsecase := service.NewAdminService(vehicleRepo, dispatcherRepo, cfg.SecretKey)
+	streamHealthRepo := redis.NewStreamHealthRepo(rdsClient)
+	certificateRepo := redis.NewCertificateRepo(rdsClient)
+	adminRepo := redis.NewAdminRepo(rdsClient)
+	auditRepo := redis.NewAuditRepo(rdsClient)
+	revocationRepo := redis.NewRevocationRepo(rdsClient)
+	if cfg.AdminLogin != "" && cfg.AdminPassword != "" {
+		created, err := service.BootstrapAdmin(adminRepo, cfg.AdminLogin, cfg.AdminPassword)
+		if err != nil {
+			log.Fatalf("Ошибка создания суперадминистратора: %s", err)
+		}
+		if created {
+			log.Infof("Создан суперадминистратор %s", cfg.AdminLogin)
+		}
+	}
+	adminUsecase := service.NewAdminService(vehicleRepo, dispatcherRepo, streamHealthRepo, certificateRepo, adminRepo, auditRepo, revocationRepo, vehicleCA, cfg.VehicleCertificateTTL, tokenKey, cfg.TokenTTL)
 	adminDelivery := http1.NewAdminDelivery(log, adminUsecase)
 	/*
 		Запуск сервера
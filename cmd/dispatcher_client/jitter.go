@@ -0,0 +1,164 @@
+package main
+
+import (
+	"golang.org/x/exp/slices"
+	"io"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"sync"
+	"time"
+)
+
+// jitterDelay - сколько ждать опоздавшие и переставленные пакеты кадра, прежде чем считать кадр потерянным
+const jitterDelay = 100 * time.Millisecond
+
+// pendingFrame - кадр, пакеты которого ещё собираются
+type pendingFrame struct {
+	timestamp uint32
+	keyframe  bool
+	packets   map[uint16]*protocol.VideoPacket
+	// first и last - номера первого и последнего пакетов кадра, если они уже получены
+	first, last       uint16
+	hasFirst, hasLast bool
+}
+
+func (f *pendingFrame) complete() bool {
+	return f.hasFirst && f.hasLast && len(f.packets) == int(f.last-f.first)+1
+}
+
+func (f *pendingFrame) bytes() []byte {
+	var frame []byte
+	for seq := f.first; ; seq++ {
+		frame = append(frame, f.packets[seq].Payload...)
+		if seq == f.last {
+			return frame
+		}
+	}
+}
+
+// jitterBuffer собирает кадры одной камеры из пакетов, пришедших в datagram, и передаёт их в output по порядку.
+// Кадр передаётся сразу, как только собран и все предыдущие кадры переданы или отброшены. Неполный кадр ждёт
+// пакеты до своего времени плюс jitterDelay, затем отбрасывается, и до ключевого кадра видео не передаётся,
+// так как следующие кадры могут ссылаться на потерянный
+type jitterBuffer struct {
+	mu     sync.Mutex
+	output io.Writer
+	frames []*pendingFrame
+	// baseTime и baseTimestamp связывают метки времени видео с локальным временем
+	baseTime      time.Time
+	baseTimestamp uint32
+	started       bool
+	// nextSeq - номер пакета, с которого должен начинаться следующий кадр, если synced
+	nextSeq uint16
+	synced  bool
+	// released - метка времени последнего переданного или отброшенного кадра
+	released     uint32
+	waitKeyframe bool
+}
+
+func newJitterBuffer(output io.Writer) *jitterBuffer {
+	return &jitterBuffer{output: output, waitKeyframe: true}
+}
+
+// before сравнивает метки времени с учётом переполнения
+func before(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// push добавляет пакет
+func (j *jitterBuffer) push(packet *protocol.VideoPacket) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.started {
+		j.started = true
+		j.baseTime = time.Now()
+		j.baseTimestamp = packet.Timestamp
+		j.released = packet.Timestamp - 1
+	}
+	if !before(j.released, packet.Timestamp) {
+		// кадр уже передан или отброшен, пакет опоздал
+		return
+	}
+	i, found := slices.BinarySearchFunc(j.frames, packet.Timestamp, func(f *pendingFrame, timestamp uint32) int {
+		switch {
+		case f.timestamp == timestamp:
+			return 0
+		case before(f.timestamp, timestamp):
+			return -1
+		default:
+			return 1
+		}
+	})
+	if !found {
+		j.frames = slices.Insert(j.frames, i, &pendingFrame{
+			timestamp: packet.Timestamp,
+			packets:   make(map[uint16]*protocol.VideoPacket),
+		})
+	}
+	f := j.frames[i]
+	f.packets[packet.Seq] = packet
+	f.keyframe = f.keyframe || packet.Keyframe
+	if packet.Start {
+		f.first, f.hasFirst = packet.Seq, true
+	}
+	if packet.End {
+		f.last, f.hasLast = packet.Seq, true
+	}
+}
+
+// deadline возвращает время, после которого неполный кадр отбрасывается
+func (j *jitterBuffer) deadline(f *pendingFrame) time.Time {
+	elapsed := time.Duration(int32(f.timestamp-j.baseTimestamp)) * time.Second / protocol.VideoClockRate
+	return j.baseTime.Add(elapsed + jitterDelay)
+}
+
+// pop возвращает собранные кадры, которые можно передать декодеру
+func (j *jitterBuffer) pop(now time.Time) [][]byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var ready [][]byte
+	for len(j.frames) > 0 {
+		f := j.frames[0]
+		// кадр можно передать, только если до него не пропало ни одного пакета
+		inOrder := f.complete() && (!j.synced || f.first == j.nextSeq)
+		if !inOrder && now.Before(j.deadline(f)) {
+			break
+		}
+		j.frames = j.frames[1:]
+		j.released = f.timestamp
+		if f.hasLast {
+			j.nextSeq, j.synced = f.last+1, true
+		}
+		switch {
+		case !f.complete():
+			j.waitKeyframe = true
+		case f.keyframe:
+			j.waitKeyframe = false
+			ready = append(ready, f.bytes())
+		case !inOrder:
+			// кадр целый, но перед ним потерялись пакеты
+			j.waitKeyframe = true
+		case !j.waitKeyframe:
+			ready = append(ready, f.bytes())
+		}
+	}
+	return ready
+}
+
+// run передаёт собранные кадры в output, пока запись не завершится ошибкой или не закроется done
+func (j *jitterBuffer) run(done <-chan struct{}) error {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-done:
+			return nil
+		}
+		for _, frame := range j.pop(now) {
+			if _, err := j.output.Write(frame); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"golang.org/x/exp/slices"
+	"math"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"testing"
+	"time"
+)
+
+// frameInterval - интервал между кадрами при 30 кадрах в секунду в единицах VideoClockRate
+const frameInterval = protocol.VideoClockRate / 30
+
+// testFrame возвращает данные кадра i, которые занимают два пакета
+func testFrame(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, protocol.MaxVideoPacketSize+100)
+}
+
+// packetize разбивает кадры на пакеты: K - ключевой кадр, P - остальные
+func packetize(kinds string, start uint32) []*protocol.VideoPacket {
+	packetizer := protocol.NewPacketizer(0)
+	var packets []*protocol.VideoPacket
+	for i, kind := range kinds {
+		packets = append(packets, packetizer.Packetize(testFrame(i), start+uint32(i*frameInterval), kind == 'K')...)
+	}
+	return packets
+}
+
+// frameIndexes переводит собранные кадры в номера кадров из packetize
+func frameIndexes(frames [][]byte) []int {
+	indexes := []int{}
+	for _, frame := range frames {
+		indexes = append(indexes, int(frame[0]))
+	}
+	return indexes
+}
+
+func TestJitterBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames string
+		start  uint32
+		// order - порядок прихода пакетов, если он отличается от порядка отправки
+		order []int
+		// drop - номера потерянных пакетов, у каждого кадра два пакета
+		drop []int
+		// wantEarly - кадры, переданные сразу, wantLate - после jitterDelay
+		wantEarly []int
+		wantLate  []int
+	}{
+		{name: "in order", frames: "KPP", wantEarly: []int{0, 1, 2}, wantLate: []int{}},
+		{name: "reordered", frames: "KPP", order: []int{1, 0, 4, 5, 3, 2}, wantEarly: []int{0, 1, 2}, wantLate: []int{}},
+		{name: "timestamp wraparound", frames: "KPP", start: math.MaxUint32 - frameInterval, wantEarly: []int{0, 1, 2}, wantLate: []int{}},
+		{name: "starts from keyframe", frames: "PKP", wantEarly: []int{1, 2}, wantLate: []int{}},
+		{
+			// неполный кадр задерживает следующие до своего срока, затем видео ждёт ключевой кадр
+			name:      "lost packet",
+			frames:    "KPPK",
+			drop:      []int{3},
+			wantEarly: []int{0},
+			wantLate:  []int{3},
+		},
+		{
+			// кадр потерян целиком: следующий кадр целый, но по номерам пакетов видно, что перед ним пропуск
+			name:      "lost frame",
+			frames:    "KPPK",
+			drop:      []int{2, 3},
+			wantEarly: []int{0},
+			wantLate:  []int{3},
+		},
+		{name: "lost keyframe packet", frames: "KP", drop: []int{0}, wantEarly: []int{}, wantLate: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packets := packetize(tt.frames, tt.start)
+			if tt.order != nil {
+				reordered := make([]*protocol.VideoPacket, len(tt.order))
+				for i, n := range tt.order {
+					reordered[i] = packets[n]
+				}
+				packets = reordered
+			}
+			j := newJitterBuffer(nil)
+			for i, packet := range packets {
+				if !slices.Contains(tt.drop, i) {
+					j.push(packet)
+				}
+			}
+			if got := frameIndexes(j.pop(j.baseTime)); !slices.Equal(got, tt.wantEarly) {
+				t.Errorf("frames before deadline = %v, want %v", got, tt.wantEarly)
+			}
+			if got := frameIndexes(j.pop(j.baseTime.Add(time.Hour))); !slices.Equal(got, tt.wantLate) {
+				t.Errorf("frames after deadline = %v, want %v", got, tt.wantLate)
+			}
+		})
+	}
+}
+
+// TestJitterBufferLatePacket проверяет, что пакет уже переданного кадра не создаёт кадр заново
+func TestJitterBufferLatePacket(t *testing.T) {
+	packets := packetize("KP", 1000)
+	j := newJitterBuffer(nil)
+	for _, packet := range packets {
+		j.push(packet)
+	}
+	if got := frameIndexes(j.pop(j.baseTime)); !slices.Equal(got, []int{0, 1}) {
+		t.Fatalf("frames = %v, want [0 1]", got)
+	}
+	j.push(packets[0])
+	j.push(packets[1])
+	if len(j.frames) != 0 {
+		t.Errorf("pending frames = %d after late packets, want 0", len(j.frames))
+	}
+}
+
+func TestJitterBufferBytes(t *testing.T) {
+	j := newJitterBuffer(nil)
+	for _, packet := range packetize("K", 0) {
+		j.push(packet)
+	}
+	frames := j.pop(j.baseTime)
+	if len(frames) != 1 || !bytes.Equal(frames[0], testFrame(0)) {
+		t.Errorf("frames = %d, want frame 0 reassembled", len(frames))
+	}
+}
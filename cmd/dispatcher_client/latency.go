@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/pkg/clock"
+	"self-driving-car-dispatch-system/pkg/h264"
+	"time"
+)
+
+// latencyReportInterval - как часто выводится задержка телеметрии и видео каждой камеры
+const latencyReportInterval = time.Second
+
+// formatLatency описывает задержку по участкам пути: от ТС до сервера, на сервере и от сервера до диспетчера.
+// received - время получения по часам сервера. Пустые метки (например, если ТС их не передаёт) пропускаются
+func formatLatency(stamps entity.LatencyStamps, received time.Time) string {
+	hop := func(from, to time.Time) string {
+		if from.IsZero() || to.IsZero() {
+			return "?"
+		}
+		return to.Sub(from).Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("ТС→сервер %s, сервер %s, сервер→диспетчер %s, всего %s",
+		hop(stamps.Capture, stamps.Ingress), hop(stamps.Ingress, stamps.Egress),
+		hop(stamps.Egress, received), hop(stamps.Capture, received))
+}
+
+// latencyMonitor передаёт видео камеры в output без изменений и выводит задержку кадров
+// по меткам времени, которые ТС и сервер добавляют в SEI. Задержка декодирования и показа не учитывается
+type latencyMonitor struct {
+	camera      string
+	output      io.Writer
+	serverClock *clock.Estimator
+	splitter    *h264.Splitter
+	reported    time.Time
+}
+
+func newLatencyMonitor(camera string, output io.Writer, serverClock *clock.Estimator) *latencyMonitor {
+	return &latencyMonitor{
+		camera:      camera,
+		output:      output,
+		serverClock: serverClock,
+		splitter:    h264.NewSplitter(),
+	}
+}
+
+func (m *latencyMonitor) Write(p []byte) (int, error) {
+	received := m.serverClock.Now()
+	_, _ = m.splitter.Write(p)
+	for nal := m.splitter.Next(); nal != nil; nal = m.splitter.Next() {
+		timestamps, ok := h264.ParseTimestampsSEI(nal)
+		if !ok || len(timestamps) != 3 || received.Sub(m.reported) < latencyReportInterval {
+			continue
+		}
+		m.reported = received
+		stamps := entity.LatencyStamps{Capture: timestamps[0], Ingress: timestamps[1], Egress: timestamps[2]}
+		log.Printf("Задержка видео камеры %s: %s\n", m.camera, formatLatency(stamps, received))
+	}
+	return m.output.Write(p)
+}
+
+// syncClock периодически измеряет смещение часов сервера относительно локальных часов
+func syncClock(clockStream io.ReadWriter, serverClock *clock.Estimator, errChan chan error) {
+	decoder := json.NewDecoder(clockStream)
+	encoder := json.NewEncoder(clockStream)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		sent := time.Now()
+		if err := encoder.Encode(entity.ClockSync{ClientSent: sent}); err != nil {
+			report(errChan, fmt.Errorf("ошибка записи в clockStream: %w", err))
+			return
+		}
+		var sync entity.ClockSync
+		if err := decoder.Decode(&sync); err != nil {
+			report(errChan, fmt.Errorf("ошибка чтения из clockStream: %w", err))
+			return
+		}
+		serverClock.Add(sent, sync.ServerReceived, sync.ServerSent, time.Now())
+		<-ticker.C
+	}
+}
@@ -1,137 +1,725 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/quic-go/quic-go"
+	"golang.org/x/exp/slices"
+	"io"
 	"log"
+	"math"
+	"math/rand/v2"
+	"os"
 	"os/exec"
+	"self-driving-car-dispatch-system/internal/codec"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/pkg/clock"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var (
+	encodingName = flag.String("encoding", "json", "формат, в котором диспетчер получает телеметрию: json или protobuf")
+	cameraList   = flag.String("cameras", "", "ID камер ТС через запятую, видео с которых нужно показать; по умолчанию все камеры")
+	datagrams    = flag.Bool("datagrams", false, "получать видео в QUIC datagram: меньше задержка, но потерянные кадры пропускаются")
+	vehicleID    = flag.Int("vehicle", 2, "ID ТС, которым диспетчер может управлять; 0 - только наблюдать за ТС из -watch")
+	watchList    = flag.String("watch", "", "ID ТС через запятую, за которыми диспетчер наблюдает в том же соединении")
+)
+
+// Размер изображения одной камеры в общем окне
+const (
+	tileWidth  = 640
+	tileHeight = 360
+)
+
+const (
+	serverAddr = "localhost:4243"
+	// dialTimeout - сколько ждать подключения к серверу и ответа на приветствие
+	dialTimeout = 10 * time.Second
+	// После обрыва соединения клиент переподключается с паузой, которая растёт вдвое после каждой неудачной
+	// попытки от minReconnectDelay до maxReconnectDelay
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// console - консоль оператора. Она переживает переподключения к серверу: ввод команд, окна с видео и
+// смещение часов сервера остаются прежними, а после переподключения диспетчер снова подписывается на те же ТС
+type console struct {
+	hello       *protocol.Hello
+	serverClock *clock.Estimator
+	display     *videoDisplay
+	password    string
+	// token - токен сессии из ответа сервера. С ним диспетчер переподключается без пароля, пока токен действует
+	token string
+
+	commands             chan entity.Command
+	leaseRequests        chan entity.LeaseRequest
+	subscriptionRequests chan entity.SubscriptionRequest
+	holding              atomic.Bool
+	// connected - соединение с сервером установлено. Пока его нет, команды оператора не отправляются
+	connected atomic.Bool
+
+	mu sync.Mutex
+	// watched - ТС, на которые диспетчер подписан через управляющий поток
+	watched map[int]struct{}
+	// watchDisplays - окна с видео камер ТС из watched, по окну на камеру
+	watchDisplays map[string]*videoDisplay
+}
+
 func main() {
-	// Подключаемся к серверу
+	flag.Parse()
+	encoding, err := protocol.ParseEncoding(*encodingName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Сессионные билеты TLS сохраняются между подключениями, поэтому при переподключении
+	// приветствие отправляется в 0-RTT, не дожидаясь рукопожатия
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: true, // Отключить проверку сертификатов
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
 	}
 
 	quicConfig := &quic.Config{
 		EnableDatagrams: true,
 	}
 
-	conn, err := quic.DialAddr(context.Background(), "localhost:4243", tlsConfig, quicConfig)
+	hello := &protocol.Hello{
+		Version:      protocol.Version,
+		Role:         protocol.RoleDispatcher,
+		VehicleID:    uint32(*vehicleID),
+		DispatcherID: 2, // ID диспетчера = 2
+		Capabilities: protocol.CapabilityCommands | protocol.CapabilityLease | protocol.CapabilityClock | protocol.CapabilityEvents |
+			protocol.CapabilitySubscriptions | protocol.CapabilityFleet,
+		Encoding: encoding,
+	}
+	if *cameraList != "" {
+		hello.Cameras = strings.Split(*cameraList, ",")
+	}
+	if *datagrams {
+		hello.Capabilities |= protocol.CapabilityDatagramVideo
+	}
+
+	c := &console{
+		hello:                hello,
+		serverClock:          clock.NewEstimator(),
+		display:              &videoDisplay{},
+		password:             "example", // Пароль: example
+		commands:             make(chan entity.Command, 10),
+		leaseRequests:        make(chan entity.LeaseRequest, 10),
+		subscriptionRequests: make(chan entity.SubscriptionRequest, 64),
+		watched:              make(map[int]struct{}),
+		watchDisplays:        make(map[string]*videoDisplay),
+	}
+	for _, id := range strings.Split(*watchList, ",") {
+		if id == "" {
+			continue
+		}
+		watchedID, err := strconv.Atoi(id)
+		if err != nil {
+			log.Fatalf("Некорректный ID ТС в -watch: %v", err)
+		}
+		c.watched[watchedID] = struct{}{}
+	}
+	go c.readOperatorInput()
+	go renewLease(&c.holding, c.leaseRequests)
+
+	delay := minReconnectDelay
+	for {
+		accepted, err := c.run(tlsConfig, quicConfig)
+		// отказ в доступе не исправится переподключением
+		var reject *protocol.RejectError
+		if errors.As(err, &reject) && reject.Code == protocol.CodeAuthFailed && c.token != "" {
+			// токен истёк или сервер перезапустился с другим ключом подписи: входим по паролю
+			log.Println("Сервер не принял токен сессии, вход по паролю")
+			c.token = ""
+			continue
+		}
+		if errors.As(err, &reject) && reject.Code != protocol.CodeInternal {
+			log.Fatalf("Сервер отклонил подключение: %v", err)
+		}
+		if accepted {
+			delay = minReconnectDelay
+		}
+		// случайная добавка к паузе, чтобы диспетчеры не переподключались одновременно после сбоя сервера
+		wait := delay + rand.N(delay/2)
+		log.Printf("Соединение с сервером потеряно: %v. Переподключение через %s\n", err, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// report передаёт первую ошибку соединения, остальные ошибки того же соединения отбрасываются
+func report(errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	default:
+	}
+}
+
+// sendHello открывает управляющий поток, отправляет приветствие и ждёт ответа сервера
+func sendHello(ctx context.Context, conn quic.Connection, hello *protocol.Hello) (quic.Stream, *protocol.HelloReply, error) {
+	controlStream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = protocol.WriteHello(controlStream, hello); err != nil {
+		return nil, nil, err
+	}
+	reply, err := protocol.ReadHelloReply(controlStream)
 	if err != nil {
-		log.Fatalf("Не удалось подключиться к серверу: %v", err)
+		return nil, nil, fmt.Errorf("не удалось получить ответ сервера: %w", err)
 	}
-	defer conn.CloseWithError(0, "Connection closed")
+	return controlStream, reply, reply.Err()
+}
 
-	// Согласно протоколу, в первые четыре байта записываем ID транспортного средства, далее ID диспетчера, затем пароль
-	data := []byte{0x00, 0x00, 0x00, 0x02}                 // ID = 2
-	data = append(data, []byte{0x00, 0x00, 0x00, 0x02}...) // ID = 2
-	data = append(data, []byte("example")...)              // Пароль: example
-	log.Printf("Отправка информации о диспетчере: %v", data)
-	err = conn.SendDatagram(data)
+// run подключается к серверу и получает трансляции ТС, пока соединение не оборвётся.
+// accepted сообщает, что сервер принял приветствие
+func (c *console) run(tlsConfig *tls.Config, quicConfig *quic.Config) (accepted bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	early, err := quic.DialAddrEarly(ctx, serverAddr, tlsConfig, quicConfig)
 	if err != nil {
-		log.Fatal(err)
+		return false, fmt.Errorf("не удалось подключиться к серверу: %w", err)
 	}
+	defer early.CloseWithError(0, "Connection closed")
 
-	infoStream, err := conn.AcceptStream(context.Background())
+	// Согласно протоколу, первым открываем управляющий поток и отправляем приветствие с ID ТС, ID диспетчера
+	// и токеном сессии прошлого подключения или паролем
+	log.Printf("Отправка информации о диспетчере %d", c.hello.DispatcherID)
+	c.hello.Secret = c.password
+	if c.token != "" {
+		c.hello.Secret = c.token
+	}
+	var conn quic.Connection = early
+	controlStream, reply, err := sendHello(ctx, conn, c.hello)
+	if errors.Is(err, quic.Err0RTTRejected) {
+		// сервер не принял 0-RTT, и потоки, открытые до рукопожатия, сброшены: повторяем приветствие
+		if conn, err = early.NextConnection(ctx); err != nil {
+			return false, err
+		}
+		controlStream, reply, err = sendHello(ctx, conn, c.hello)
+	}
 	if err != nil {
-		log.Fatalf("Не удалось открыть поток: %v", err)
+		return false, err
+	}
+	defer controlStream.Close()
+	c.token = reply.Token
+	log.Printf("Сервер принял подключение, камеры: %s, 0-RTT: %t", strings.Join(reply.Cameras, ", "),
+		conn.ConnectionState().Used0RTT)
+
+	// Без ТС в приветствии сервер открывает только потоки подписок
+	var infoStream quic.Stream
+	if c.hello.VehicleID != 0 {
+		infoStream, err = conn.AcceptStream(ctx)
+		if err != nil {
+			return true, fmt.Errorf("не удалось открыть поток: %w", err)
+		}
+		defer infoStream.Close()
+		log.Printf("Открыт infoStream с %s\n", conn.RemoteAddr())
+	}
+
+	// Сервер открывает по видеопотоку на каждую камеру в том порядке, в котором они перечислены в ответе,
+	// или передаёт видео в datagram, тогда кадры собираются из пакетов в jitter buffer
+	var videoStreams []quic.Stream
+	for _, camera := range reply.Cameras {
+		if reply.Capabilities.Has(protocol.CapabilityDatagramVideo) {
+			break
+		}
+		videoStream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return true, fmt.Errorf("не удалось открыть видеопоток: %w", err)
+		}
+		defer videoStream.Close()
+		videoStreams = append(videoStreams, videoStream)
+		log.Printf("Открыт videoStream камеры %s с %s\n", camera, conn.RemoteAddr())
+	}
+
+	var leaseStream quic.Stream
+	if reply.Capabilities.Has(protocol.CapabilityLease) {
+		leaseStream, err = conn.AcceptStream(ctx)
+		if err != nil {
+			return true, fmt.Errorf("не удалось открыть поток аренды управления: %w", err)
+		}
+		defer leaseStream.Close()
+		log.Printf("Открыт leaseStream с %s\n", conn.RemoteAddr())
+	}
+
+	// Поток синхронизации часов открываем раньше потока команд: сервер принимает потоки по порядку
+	errChan := make(chan error, 1)
+	if reply.Capabilities.Has(protocol.CapabilityClock) {
+		clockStream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			return true, fmt.Errorf("не удалось открыть поток синхронизации часов: %w", err)
+		}
+		defer clockStream.Close()
+		go syncClock(clockStream, c.serverClock, errChan)
 	}
-	defer infoStream.Close()
-	log.Printf("Открыт infoStream с %s\n", conn.RemoteAddr())
 
-	videoStream, err := conn.AcceptStream(context.Background())
+	// Поток команд открывает диспетчер, сервер увидит его после отправки первой команды
+	var commandStream quic.Stream
+	if reply.Capabilities.Has(protocol.CapabilityCommands) {
+		commandStream, err = conn.OpenStreamSync(ctx)
+		if err != nil {
+			return true, fmt.Errorf("не удалось открыть поток команд: %w", err)
+		}
+		defer commandStream.Close()
+	}
+
+	pipes, err := c.display.open(reply.Cameras, c.serverClock)
 	if err != nil {
-		log.Fatalf("Не удалось открыть видеопоток: %v", err)
+		log.Fatal(err)
+	}
+	// команды и запросы, введённые до обрыва прошлого соединения, устарели
+	drain(c.commands)
+	drain(c.leaseRequests)
+	drain(c.subscriptionRequests)
+	connCtx := conn.Context()
+	if reply.Capabilities.Has(protocol.CapabilityDatagramVideo) {
+		go getVideoDatagrams(conn, pipes, errChan)
+	}
+	for i, videoStream := range videoStreams {
+		go getVideoStream(videoStream, pipes[i], errChan)
+	}
+	if infoStream != nil {
+		go getInfoStream(int(c.hello.VehicleID), infoStream, reply.Encoding, c.serverClock, errChan)
+	}
+	if commandStream != nil {
+		go sendCommands(connCtx, commandStream, c.commands, errChan)
+		go getCommandAcks(commandStream, errChan)
+	}
+	if leaseStream != nil {
+		go sendLeaseRequests(connCtx, leaseStream, c.leaseRequests, errChan)
+		go getLeaseStates(leaseStream, &c.holding, errChan)
+	}
+	go c.acceptStreams(conn, reply, errChan)
+	if reply.Capabilities.Has(protocol.CapabilitySubscriptions) {
+		go sendSubscriptionRequests(connCtx, controlStream, c.subscriptionRequests, errChan)
+		go getSubscriptionStates(controlStream, errChan)
+		c.resubscribe()
+	} else if len(c.watchedVehicles()) > 0 {
+		log.Println("Сервер не поддерживает подписки, ТС из списка наблюдения не показываются")
 	}
-	defer videoStream.Close()
-	log.Printf("Открыт videoStream с %s\n", conn.RemoteAddr())
 
-	log.Printf("Информация о диспетчере отправлена.")
+	c.connected.Store(true)
+	defer c.holding.Store(false)
+	defer c.connected.Store(false)
+	select {
+	case err = <-errChan:
+	case <-connCtx.Done():
+		err = context.Cause(connCtx)
+	}
+	return true, err
+}
 
-	wg := &sync.WaitGroup{}
-	errChan := make(chan error)
-	wg.Add(2)
-	go getVideoStream(wg, videoStream, errChan)
-	go getInfoStream(wg, infoStream, errChan)
+// drain удаляет из канала все сообщения
+func drain[T any](messages chan T) {
+	for {
+		select {
+		case <-messages:
+		default:
+			return
+		}
+	}
+}
 
-	// Ожидаем ошибку
-	go func() {
-		for {
-			select {
-			case err := <-errChan:
-				log.Fatal(err)
-				return
+// getVideoDatagrams получает пакеты видео из datagram и раскладывает их по jitter buffer камер
+func getVideoDatagrams(conn quic.Connection, pipes []io.Writer, errChan chan error) {
+	jitterBuffers := make([]*jitterBuffer, len(pipes))
+	for i, pipe := range pipes {
+		jitterBuffers[i] = newJitterBuffer(pipe)
+		go func() {
+			if err := jitterBuffers[i].run(conn.Context().Done()); err != nil {
+				log.Fatalf("Ошибка записи в GStreamer: %v", err)
 			}
+		}()
+	}
+	for {
+		data, err := conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			report(errChan, fmt.Errorf("ошибка получения datagram: %w", err))
+			return
 		}
-	}()
+		packet, err := protocol.UnmarshalVideoPacket(data)
+		if err != nil || int(packet.Camera) >= len(jitterBuffers) {
+			log.Printf("Некорректный пакет видео из %d байт\n", len(data))
+			continue
+		}
+		jitterBuffers[packet.Camera].push(packet)
+	}
+}
 
-	wg.Wait()
+// videoDisplay - окно GStreamer, в котором видео со всех камер расположено сеткой. Окно открывается
+// при первом подключении, в ответ на которое сервер передал камеры, и не закрывается при переподключении
+type videoDisplay struct {
+	cameras []string
+	pipes   []io.Writer
 }
 
-func getVideoStream(wg *sync.WaitGroup, videoStream quic.Stream, errChan chan error) {
-	defer wg.Done()
+// open открывает окно для камер cameras, если оно ещё не открыто, и возвращает каналы для видео этих камер.
+// Видео камер, которых нет в окне, отбрасывается
+func (d *videoDisplay) open(cameras []string, serverClock *clock.Estimator) ([]io.Writer, error) {
+	if d.cameras == nil && len(cameras) > 0 {
+		if err := d.start(cameras, serverClock); err != nil {
+			return nil, err
+		}
+	}
+	pipes := make([]io.Writer, len(cameras))
+	for i, camera := range cameras {
+		pipes[i] = io.Discard
+		if j := slices.Index(d.cameras, camera); j >= 0 {
+			pipes[i] = d.pipes[j]
+		} else {
+			log.Printf("Камеры %s нет в окне видео, её видео не показывается\n", camera)
+		}
+	}
+	return pipes, nil
+}
 
-	// Запускаем GStreamer
-	cmd := exec.Command(
-		"gst-launch-1.0",
-		"-v", "fdsrc", // Получаем данные из pipe
-		"!", "h264parse",
-		"!", "avdec_h264",
-		"!", "videoconvert",
-		"!", "autovideosink",
-	)
-
-	// Подключаем stdout к потоку данных
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		errChan <- fmt.Errorf("ошибка создания stdin pipe для GStreamer: %w", err)
+// start запускает GStreamer
+func (d *videoDisplay) start(cameras []string, serverClock *clock.Estimator) error {
+	// Видео каждой камеры передается в GStreamer через отдельный канал, начиная с дескриптора 3
+	columns := int(math.Ceil(math.Sqrt(float64(len(cameras)))))
+	args := []string{"-v", "compositor", "name=comp"}
+	for i := range cameras {
+		args = append(args,
+			fmt.Sprintf("sink_%d::xpos=%d", i, i%columns*tileWidth),
+			fmt.Sprintf("sink_%d::ypos=%d", i, i/columns*tileHeight),
+		)
+	}
+	args = append(args, "!", "videoconvert", "!", "autovideosink")
+	for i := range cameras {
+		args = append(args,
+			"fdsrc", fmt.Sprintf("fd=%d", 3+i), // Получаем данные из pipe
+			"!", "h264parse",
+			"!", "avdec_h264",
+			"!", "videoconvert",
+			"!", "videoscale",
+			"!", fmt.Sprintf("video/x-raw,width=%d,height=%d", tileWidth, tileHeight),
+			"!", fmt.Sprintf("comp.sink_%d", i),
+		)
+	}
+	cmd := exec.Command("gst-launch-1.0", args...)
+
+	pipes := make([]io.Writer, len(cameras))
+	for i, camera := range cameras {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("ошибка создания pipe для GStreamer: %w", err)
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, r)
+		pipes[i] = newLatencyMonitor(camera, w, serverClock)
 	}
-	defer stdin.Close()
 
 	// Запускаем GStreamer
-	err = cmd.Start()
-	if err != nil {
-		errChan <- fmt.Errorf("ошибка запуска GStreamer: %w", err)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ошибка запуска GStreamer: %w", err)
 	}
+	// концы каналов для чтения теперь есть у GStreamer
+	for _, r := range cmd.ExtraFiles {
+		_ = r.Close()
+	}
+	d.cameras, d.pipes = cameras, pipes
+
+	// Окно общее для всех подключений, поэтому ошибка GStreamer завершает клиент
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Fatalf("GStreamer завершился с ошибкой: %v", err)
+		}
+	}()
+	return nil
+}
 
-	// Читаем данные из QUIC потока и отправляем в GStreamer
+// getVideoStream читает видео камеры из QUIC потока и отправляет в GStreamer
+func getVideoStream(videoStream quic.Stream, pipe io.Writer, errChan chan error) {
+	if err := copyVideo(videoStream, pipe); err != nil {
+		report(errChan, fmt.Errorf("ошибка чтения из видеопотока: %w", err))
+	}
+}
+
+// copyVideo передаёт видео из потока в GStreamer, пока чтение из потока не завершится ошибкой
+func copyVideo(videoStream io.Reader, pipe io.Writer) error {
 	buffer := make([]byte, 4096)
 	for {
 		n, err := videoStream.Read(buffer)
 		if err != nil {
-			errChan <- fmt.Errorf("ошибка чтения из видеопотока: %w", err)
-			break
+			return err
 		}
 
-		_, err = stdin.Write(buffer[:n])
+		_, err = pipe.Write(buffer[:n])
 		if err != nil {
-			errChan <- fmt.Errorf("ошибка записи в GStreamer: %w", err)
-			break
+			log.Fatalf("Ошибка записи в GStreamer: %v", err)
 		}
 	}
+}
 
-	// Ждем завершения GStreamer
-	err = cmd.Wait()
-	if err != nil {
-		errChan <- fmt.Errorf("GStreamer завершился с ошибкой: %w", err)
+func getInfoStream(vehicleID int, infoStream quic.Stream, encoding protocol.Encoding, serverClock *clock.Estimator, errChan chan error) {
+	if err := readTelemetry(vehicleID, infoStream, encoding, serverClock); err != nil {
+		report(errChan, fmt.Errorf("ошибка чтения из infoStream: %w", err))
 	}
 }
 
-func getInfoStream(wg *sync.WaitGroup, infoStream quic.Stream, errChan chan error) {
-	defer wg.Done()
-
-	// Читаем информацию о транспортном средстве
-	buffer := make([]byte, 4096)
+// readTelemetry выводит телеметрию ТС из потока, пока чтение из него не завершится ошибкой
+func readTelemetry(vehicleID int, infoStream io.Reader, encoding protocol.Encoding, serverClock *clock.Estimator) error {
+	var reported time.Time
+	// Читаем информацию о транспортном средстве, каждое сообщение передается отдельным кадром
 	for {
-		n, err := infoStream.Read(buffer)
+		frame, err := protocol.ReadFrame(infoStream, protocol.MaxFrameSize)
+		if errors.Is(err, protocol.ErrFrameTooLarge) {
+			log.Println("Пропущен слишком большой кадр телеметрии")
+			continue
+		}
 		if err != nil {
-			errChan <- fmt.Errorf("ошибка чтения из infoStream: %w", err)
-			break
+			return err
+		}
+		var telemetry entity.Telemetry
+		if err = codec.UnmarshalTelemetry(encoding, frame, &telemetry); err != nil {
+			log.Printf("Некорректное сообщение телеметрии: %v\n", err)
+			continue
+		}
+		log.Printf("ТС %d, телеметрия #%d: руль %.3f, газ %.3f, тормоз %.3f, скорость %.2f\n",
+			vehicleID, telemetry.Seq, telemetry.Steering, telemetry.Throttle, telemetry.Brake, telemetry.Speed)
+		if len(telemetry.Flags) > 0 {
+			log.Printf("Значения вне допустимого диапазона: %s\n", strings.Join(telemetry.Flags, ", "))
+		}
+		if len(telemetry.Faults) > 0 {
+			log.Printf("Неисправности ТС: %s\n", strings.Join(telemetry.Faults, ", "))
+		}
+		if received := serverClock.Now(); telemetry.Latency != nil && received.Sub(reported) >= latencyReportInterval {
+			reported = received
+			log.Printf("Задержка телеметрии: %s\n", formatLatency(*telemetry.Latency, received))
+		}
+	}
+}
+
+// readOperatorInput читает команды оператора из stdin. Команды телеуправления:
+//
+//	setpoint <руль> <газ> <тормоз>
+//	stop
+//	pullover
+//	resume
+//
+// Команды аренды управления:
+//
+//	acquire
+//	release
+//	takeover
+//	handover <ID диспетчера>
+//	history
+//
+// Команды наблюдения за другими ТС в том же соединении:
+//
+//	watch <ID ТС>
+//	unwatch <ID ТС>
+//
+// Пока нет соединения с сервером, команды не отправляются
+func (c *console) readOperatorInput() {
+	scanner := bufio.NewScanner(os.Stdin)
+	var seq uint64
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		command := entity.Command{}
+		switch fields[0] {
+		case "setpoint":
+			command.Type = entity.SetpointCommand
+			if len(fields) != 4 {
+				log.Println("Использование: setpoint <руль> <газ> <тормоз>")
+				continue
+			}
+			values := make([]float64, 3)
+			var err error
+			for i := range values {
+				if values[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				log.Printf("Некорректное значение уставки: %v\n", err)
+				continue
+			}
+			command.Steering, command.Throttle, command.Brake = values[0], values[1], values[2]
+		case "stop":
+			command.Type = entity.EmergencyStopCommand
+		case "pullover":
+			command.Type = entity.PullOverCommand
+		case "resume":
+			command.Type = entity.ResumeAutonomyCommand
+		case "acquire", "release", "takeover", "history":
+			c.requestLease(entity.LeaseRequest{Action: entity.LeaseAction(fields[0])})
+			continue
+		case "handover":
+			if len(fields) != 2 {
+				log.Println("Использование: handover <ID диспетчера>")
+				continue
+			}
+			targetID, err := strconv.Atoi(fields[1])
+			if err != nil {
+				log.Printf("Некорректный ID диспетчера: %v\n", err)
+				continue
+			}
+			c.requestLease(entity.LeaseRequest{Action: entity.HandoverLease, TargetDispatcherID: targetID})
+			continue
+		case "watch", "unwatch":
+			if len(fields) != 2 {
+				log.Printf("Использование: %s <ID ТС>\n", fields[0])
+				continue
+			}
+			watchedID, err := strconv.Atoi(fields[1])
+			if err != nil {
+				log.Printf("Некорректный ID ТС: %v\n", err)
+				continue
+			}
+			action := entity.SubscribeAction
+			if fields[0] == "unwatch" {
+				action = entity.UnsubscribeAction
+			}
+			c.watch(action, watchedID)
+			continue
+		default:
+			log.Printf("Неизвестная команда %q\n", fields[0])
+			continue
+		}
+		if !c.connected.Load() {
+			log.Printf("Нет соединения с сервером, команда %s не отправлена\n", command.Type)
+			continue
+		}
+		seq++
+		command.Seq = seq
+		c.commands <- command
+	}
+}
+
+// requestLease передаёт запрос аренды управления, если есть соединение с сервером
+func (c *console) requestLease(request entity.LeaseRequest) {
+	if !c.connected.Load() {
+		log.Printf("Нет соединения с сервером, запрос %s не отправлен\n", request.Action)
+		return
+	}
+	c.leaseRequests <- request
+}
+
+func sendCommands(ctx context.Context, commandStream quic.Stream, commands chan entity.Command, errChan chan error) {
+	encoder := json.NewEncoder(commandStream)
+	for {
+		select {
+		case command := <-commands:
+			if err := encoder.Encode(command); err != nil {
+				report(errChan, fmt.Errorf("ошибка записи в commandStream: %w", err))
+				return
+			}
+			log.Printf("Отправлена команда %d: %s\n", command.Seq, command.Type)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func getCommandAcks(commandStream quic.Stream, errChan chan error) {
+	decoder := json.NewDecoder(commandStream)
+	for {
+		var ack entity.CommandAck
+		if err := decoder.Decode(&ack); err != nil {
+			report(errChan, fmt.Errorf("ошибка чтения из commandStream: %w", err))
+			return
+		}
+		if ack.Status == entity.CommandApplied {
+			log.Printf("Команда %d применена\n", ack.Seq)
+		} else {
+			log.Printf("Команда %d отклонена: %s\n", ack.Seq, ack.Error)
+		}
+	}
+}
+
+func sendLeaseRequests(ctx context.Context, leaseStream quic.Stream, leaseRequests chan entity.LeaseRequest, errChan chan error) {
+	encoder := json.NewEncoder(leaseStream)
+	for {
+		select {
+		case request := <-leaseRequests:
+			if err := encoder.Encode(request); err != nil {
+				report(errChan, fmt.Errorf("ошибка записи в leaseStream: %w", err))
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func getLeaseStates(leaseStream quic.Stream, holding *atomic.Bool, errChan chan error) {
+	decoder := json.NewDecoder(leaseStream)
+	for {
+		var state entity.LeaseState
+		if err := decoder.Decode(&state); err != nil {
+			report(errChan, fmt.Errorf("ошибка чтения из leaseStream: %w", err))
+			return
+		}
+		holding.Store(state.Role == entity.ControlRole)
+		switch {
+		case state.Error != "":
+			log.Printf("Запрос аренды управления отклонён: %s\n", state.Error)
+		case state.History != nil:
+			for _, record := range state.History {
+				log.Printf("Диспетчер %d управлял ТС %d с %s до %s (%s, %s)\n", record.DispatcherID, record.VehicleID,
+					record.AcquiredAt.Format(time.TimeOnly), record.ReleasedAt.Format(time.TimeOnly), record.Action, record.ReleaseReason)
+			}
+		case state.Role == entity.ControlRole:
+			log.Printf("Вы управляете ТС %d до %s\n", state.VehicleID, state.ExpiresAt.Format(time.TimeOnly))
+		case state.HolderID != 0:
+			log.Printf("ТС %d управляет диспетчер %d, режим наблюдения\n", state.VehicleID, state.HolderID)
+		default:
+			log.Printf("ТС %d никто не управляет, режим наблюдения\n", state.VehicleID)
+		}
+	}
+}
+
+// getEvents выводит события о потоках ТС: если данные перестали приходить, то картинка и телеметрия устарели
+func getEvents(eventStream quic.Stream, errChan chan error) {
+	defer eventStream.Close()
+
+	decoder := json.NewDecoder(eventStream)
+	for {
+		var event entity.StreamEvent
+		if err := decoder.Decode(&event); err != nil {
+			report(errChan, fmt.Errorf("ошибка чтения из eventStream: %w", err))
+			return
+		}
+		silence := event.At.Sub(event.LastPacket).Round(time.Second)
+		switch event.State {
+		case entity.StreamStale:
+			log.Printf("ВНИМАНИЕ: поток %s ТС %d завис, данных нет %s\n", event.Stream, event.VehicleID, silence)
+		case entity.StreamLost:
+			log.Printf("ВНИМАНИЕ: поток %s ТС %d потерян, данных нет %s\n", event.Stream, event.VehicleID, silence)
+		case entity.SessionDisconnected:
+			log.Printf("ВНИМАНИЕ: соединение с ТС %d потеряно, ждём переподключения\n", event.VehicleID)
+		case entity.SessionReconnected:
+			log.Printf("ТС %d переподключилось\n", event.VehicleID)
+		case entity.SessionClosed:
+			log.Printf("ВНИМАНИЕ: ТС %d не переподключилось, трансляция завершена\n", event.VehicleID)
+		default:
+			log.Printf("Поток %s ТС %d восстановлен\n", event.Stream, event.VehicleID)
+		}
+	}
+}
+
+// renewLease продлевает аренду управления, пока диспетчер управляет ТС
+func renewLease(holding *atomic.Bool, leaseRequests chan entity.LeaseRequest) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if holding.Load() {
+			leaseRequests <- entity.LeaseRequest{Action: entity.RenewLease}
 		}
-		log.Printf("Получена информация о транспортном средстве: %v\n", string(buffer[:n]))
 	}
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"golang.org/x/exp/slices"
+	"io"
+	"log"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"strconv"
+	"strings"
+)
+
+// acceptStreams принимает потоки, которые сервер открывает после подключения: поток событий и поток обзора
+// парка, если они согласованы, затем потоки подписок на ТС
+func (c *console) acceptStreams(conn quic.Connection, reply *protocol.HelloReply, errChan chan error) {
+	ctx := conn.Context()
+	if reply.Capabilities.Has(protocol.CapabilityEvents) {
+		// Поток событий сервер открывает последним, после потока аренды управления. Клиент увидит его
+		// только с первым событием или потоком подписки, поэтому поток принимается здесь, а не вместе с остальными
+		eventStream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			report(errChan, fmt.Errorf("не удалось открыть поток событий: %w", err))
+			return
+		}
+		log.Printf("Открыт eventStream с %s\n", conn.RemoteAddr())
+		go getEvents(eventStream, errChan)
+	}
+	if reply.Capabilities.Has(protocol.CapabilityFleet) {
+		fleetStream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			report(errChan, fmt.Errorf("не удалось открыть поток обзора парка: %w", err))
+			return
+		}
+		log.Printf("Открыт fleetStream с %s\n", conn.RemoteAddr())
+		go getFleetStatus(fleetStream, errChan)
+	}
+	if !reply.Capabilities.Has(protocol.CapabilitySubscriptions) {
+		return
+	}
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			// соединение закрылось, об этом сообщат остальные потоки
+			return
+		}
+		go c.getSubscriptionStream(stream, reply.Encoding)
+	}
+}
+
+// getSubscriptionStream читает поток подписки: телеметрию выводит в консоль, а видео показывает в отдельном окне
+// для каждой камеры. Поток подписки закрывается при отписке, поэтому ошибка чтения не обрывает соединение
+func (c *console) getSubscriptionStream(stream quic.Stream, encoding protocol.Encoding) {
+	defer stream.Close()
+	header, err := protocol.ReadStreamHeader(stream)
+	if err != nil {
+		log.Printf("Ошибка чтения заголовка потока подписки: %v\n", err)
+		stream.CancelRead(0)
+		return
+	}
+	vehicleID := int(header.VehicleID)
+	switch header.Kind {
+	case protocol.StreamInfo:
+		err = readTelemetry(vehicleID, stream, encoding, c.serverClock)
+	case protocol.StreamVideo:
+		var pipe io.Writer
+		if pipe, err = c.watchDisplay(vehicleID, header.Camera); err == nil {
+			err = copyVideo(stream, pipe)
+		}
+	default:
+		err = fmt.Errorf("неизвестный тип потока %d", header.Kind)
+	}
+	log.Printf("Поток подписки на ТС %d закрыт: %v\n", vehicleID, err)
+	stream.CancelRead(0)
+}
+
+// watchDisplay возвращает канал в окно с видео камеры ТС, на которое диспетчер подписан. Окно открывается с первым
+// видео камеры и остаётся открытым после отписки и переподключений
+func (c *console) watchDisplay(vehicleID int, camera string) (io.Writer, error) {
+	label := strconv.Itoa(vehicleID) + "/" + camera
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	display, ok := c.watchDisplays[label]
+	if !ok {
+		display = &videoDisplay{}
+		c.watchDisplays[label] = display
+	}
+	pipes, err := display.open([]string{label}, c.serverClock)
+	if err != nil {
+		return nil, err
+	}
+	return pipes[0], nil
+}
+
+// watch добавляет ТС в список наблюдения или убирает из него и, если соединение установлено,
+// сразу отправляет запрос подписки серверу. После переподключения диспетчер снова подписывается на весь список
+func (c *console) watch(action entity.SubscriptionAction, vehicleID int) {
+	c.mu.Lock()
+	if action == entity.SubscribeAction {
+		c.watched[vehicleID] = struct{}{}
+	} else {
+		delete(c.watched, vehicleID)
+	}
+	c.mu.Unlock()
+	if !c.connected.Load() {
+		log.Println("Нет соединения с сервером, подписка изменится после переподключения")
+		return
+	}
+	c.requestSubscription(entity.SubscriptionRequest{Action: action, VehicleID: vehicleID})
+}
+
+// resubscribe подписывается на все ТС из списка наблюдения
+func (c *console) resubscribe() {
+	for _, vehicleID := range c.watchedVehicles() {
+		c.requestSubscription(entity.SubscriptionRequest{Action: entity.SubscribeAction, VehicleID: vehicleID})
+	}
+}
+
+// watchedVehicles возвращает ТС из списка наблюдения по возрастанию ID
+func (c *console) watchedVehicles() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vehicles := make([]int, 0, len(c.watched))
+	for vehicleID := range c.watched {
+		vehicles = append(vehicles, vehicleID)
+	}
+	slices.Sort(vehicles)
+	return vehicles
+}
+
+func (c *console) requestSubscription(request entity.SubscriptionRequest) {
+	select {
+	case c.subscriptionRequests <- request:
+	default:
+		log.Println("Слишком много запросов подписки, запрос отброшен")
+	}
+}
+
+func sendSubscriptionRequests(ctx context.Context, controlStream quic.Stream, subscriptionRequests chan entity.SubscriptionRequest, errChan chan error) {
+	encoder := json.NewEncoder(controlStream)
+	for {
+		select {
+		case request := <-subscriptionRequests:
+			if err := encoder.Encode(request); err != nil {
+				report(errChan, fmt.Errorf("ошибка записи в controlStream: %w", err))
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func getSubscriptionStates(controlStream quic.Stream, errChan chan error) {
+	decoder := json.NewDecoder(controlStream)
+	for {
+		var state entity.SubscriptionState
+		if err := decoder.Decode(&state); err != nil {
+			report(errChan, fmt.Errorf("ошибка чтения из controlStream: %w", err))
+			return
+		}
+		switch state.Status {
+		case entity.Subscribed:
+			log.Printf("Подписка на ТС %d оформлена, камеры: %v\n", state.VehicleID, state.Cameras)
+		case entity.Unsubscribed:
+			log.Printf("Подписка на ТС %d отменена\n", state.VehicleID)
+		case entity.SubscriptionRejected:
+			log.Printf("Подписка на ТС %d отклонена: %s\n", state.VehicleID, state.Error)
+		default:
+			log.Printf("Подписка на ТС %d закончилась: %s\n", state.VehicleID, state.Error)
+		}
+	}
+}
+
+// getFleetStatus выводит обзор парка. Сервер присылает его каждую секунду, а выводится он только при изменении
+// подключений ТС, их диспетчеров или аренды управления
+func getFleetStatus(fleetStream quic.Stream, errChan chan error) {
+	defer fleetStream.Close()
+	decoder := json.NewDecoder(fleetStream)
+	var last string
+	for {
+		var status entity.FleetStatus
+		if err := decoder.Decode(&status); err != nil {
+			report(errChan, fmt.Errorf("ошибка чтения из fleetStream: %w", err))
+			return
+		}
+		var overview strings.Builder
+		for _, vehicle := range status.Vehicles {
+			fmt.Fprintf(&overview, "\n  ТС %d: %s, камеры %v, диспетчеры %v", vehicle.VehicleID, vehicle.State, vehicle.Cameras, vehicle.Watchers)
+			if vehicle.ControllerID != 0 {
+				fmt.Fprintf(&overview, ", управляет диспетчер %d", vehicle.ControllerID)
+			}
+		}
+		if overview.String() == last {
+			continue
+		}
+		last = overview.String()
+		log.Printf("Обзор парка:%s\n", last)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/tls"
 	"fmt"
 	"github.com/quic-go/quic-go"
@@ -13,6 +14,7 @@ import (
 	"self-driving-car-dispatch-system/internal/delivery/http3"
 	"self-driving-car-dispatch-system/internal/repo/redis"
 	"self-driving-car-dispatch-system/internal/usecase/service"
+	"self-driving-car-dispatch-system/pkg/pki"
 	redisClient "self-driving-car-dispatch-system/pkg/redis"
 	"syscall"
 	"time"
@@ -34,6 +36,8 @@ func main() {
 	viper.AddConfigPath("./config")         // Папка с конфигурацией
 	viper.AddConfigPath(".")                // Корень проекта
 	viper.AddConfigPath("./config/example") // Если не нашли актуальную конфигурацию, то читаем пример конфигурации
+	viper.SetDefault("session_grace_period", 15*time.Second)
+	viper.SetDefault("token_ttl", 15*time.Minute)
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf("Ошибка чтения файла конфигурации: %s", err)
 	}
@@ -41,6 +45,15 @@ func main() {
 		log.Fatalf("Ошибка чтения файла конфигурации: %s", err)
 	}
 	cfg.SecretKey = os.Getenv("SECRET_KEY")
+	cfg.TokenKey = os.Getenv("TOKEN_KEY")
+	tokenKey := []byte(cfg.TokenKey)
+	if len(tokenKey) == 0 {
+		logger.Warnln("TOKEN_KEY не задан, токены сессий будут недействительны после перезапуска сервера")
+		tokenKey = make([]byte, 32)
+		if _, err := rand.Read(tokenKey); err != nil {
+			log.Fatalf("Ошибка создания ключа подписи токенов: %s", err)
+		}
+	}
 	/*
 		Подключение к redis
 	*/
@@ -48,12 +61,20 @@ func main() {
 	if err != nil {
 		log.Fatalf("Ошибка подключения к redis: %s", err)
 	}
+	// записи, созданные до появления индексов ТС и диспетчеров, добавляются в индексы один раз
+	if err := redis.BuildIndexes(rdsClient); err != nil {
+		log.Fatalf("Ошибка построения индексов: %s", err)
+	}
 	/*
 		Инициализация репозиториев, сервисов и обработчиков
 	*/
 	vehicleRepo := redis.NewVehicleRepo(rdsClient)
 	dispatcherRepo := redis.NewDispatcherRepo(rdsClient)
-	broadcastUsecase := service.NewBroadcastService(vehicleRepo, dispatcherRepo)
+	streamHealthRepo := redis.NewStreamHealthRepo(rdsClient)
+	certificateRepo := redis.NewCertificateRepo(rdsClient)
+	auditRepo := redis.NewAuditRepo(rdsClient)
+	revocationRepo := redis.NewRevocationRepo(rdsClient)
+	broadcastUsecase := service.NewBroadcastService(vehicleRepo, dispatcherRepo, streamHealthRepo, certificateRepo, auditRepo, revocationRepo, cfg.SessionGracePeriod, tokenKey, cfg.TokenTTL)
 
 	certFile := "config/localhost.pem"
 	keyFile := "config/localhost-key.pem"
@@ -64,11 +85,21 @@ func main() {
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{tlsCert},
 	}
+	// ТС может предъявить клиентский сертификат вместо пароля, диспетчеры входят только по паролю
+	vehicleTLSConfig := tlsConfig.Clone()
+	if cfg.VehicleCAFile != "" {
+		vehicleCAs, err := pki.LoadCertPool(cfg.VehicleCAFile)
+		if err != nil {
+			log.Fatal("Ошибка загрузки сертификата удостоверяющего центра ТС:", err)
+		}
+		vehicleTLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		vehicleTLSConfig.ClientCAs = vehicleCAs
+	}
 	quicConfig := &quic.Config{
 		EnableDatagrams: true,
 	}
 
-	vehicleDelivery := http3.NewVehicleDelivery(broadcastUsecase, logger, tlsConfig, quicConfig)
+	vehicleDelivery := http3.NewVehicleDelivery(broadcastUsecase, logger, vehicleTLSConfig, quicConfig)
 	dispatcherDelivery := http3.NewDispatcherDelivery(broadcastUsecase, logger, tlsConfig, quicConfig)
 
 	/*
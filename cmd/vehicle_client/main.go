@@ -5,21 +5,71 @@ import (
 	"crypto/tls"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/quic-go/quic-go"
+	"golang.org/x/exp/slices"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"self-driving-car-dispatch-system/internal/codec"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/pkg/clock"
+	"self-driving-car-dispatch-system/pkg/h264"
+	"self-driving-car-dispatch-system/pkg/pki"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+var (
+	encodingName = flag.String("encoding", "json", "формат, в котором ТС отправляет телеметрию: json или protobuf")
+	cameraList   = flag.String("cameras", "front", "ID камер ТС через запятую, для примера все камеры транслируют одно видео")
+	certFile     = flag.String("cert", "", "клиентский сертификат ТС, выпущенный администратором; с ним пароль не нужен")
+	keyFile      = flag.String("key", "", "закрытый ключ клиентского сертификата ТС")
+	serverCAFile = flag.String("server-ca", "", "сертификат удостоверяющего центра сервера; без него сертификат сервера не проверяется")
+)
+
+// videoLayers - параметры слоёв качества видео, от лучшего к худшему. Все слои кодирует один процесс FFmpeg,
+// поэтому ключевые кадры слоёв совпадают и сервер быстро переключает диспетчера между слоями
+var videoLayers = [][]string{
+	{"-b:v", "2M"},
+	{"-vf", "scale=-2:360", "-b:v", "500k"},
+}
+
 func main() {
-	// Настройка TLS для QUIC (самоподписанный сертификат для разработки)
+	flag.Parse()
+	encoding, err := protocol.ParseEncoding(*encodingName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Настройка TLS для QUIC (по умолчанию самоподписанный сертификат сервера для разработки не проверяется)
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: true,
 	}
+	if *serverCAFile != "" {
+		rootCAs, err := pki.LoadCertPool(*serverCAFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig.InsecureSkipVerify = false
+		tlsConfig.RootCAs = rootCAs
+	}
+	// С клиентским сертификатом ТС входит без пароля
+	secret := "example" // Пароль: example
+	if *certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+		secret = ""
+	}
 
 	quicConfig := &quic.Config{
 		EnableDatagrams: true,
@@ -32,36 +82,76 @@ func main() {
 	}
 	defer conn.CloseWithError(0, "Connection closed")
 
-	// Открываем текстовый поток для передачи информации о транспортном средстве
-	infoStream, err := conn.OpenStreamSync(context.Background())
+	// Согласно протоколу, первым открываем управляющий поток и отправляем приветствие с ID и паролем ТС
+	controlStream, err := conn.OpenStreamSync(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer infoStream.Close()
-
-	// Открываем видеопоток для передачи данных
-	videoStream, err := conn.OpenStreamSync(context.Background())
-	if err != nil {
+	defer controlStream.Close()
+	hello := &protocol.Hello{
+		Version:      protocol.Version,
+		Role:         protocol.RoleVehicle,
+		VehicleID:    2, // ID = 2
+		Secret:       secret,
+		Capabilities: protocol.CapabilityCommands | protocol.CapabilityClock,
+		Encoding:     encoding,
+		Layers:       uint8(len(videoLayers)),
+		Cameras:      strings.Split(*cameraList, ","),
+	}
+	log.Printf("Отправка информации о транспортном средстве %d", hello.VehicleID)
+	if err = protocol.WriteHello(controlStream, hello); err != nil {
 		log.Fatal(err)
 	}
-	defer videoStream.Close()
+	reply, err := protocol.ReadHelloReply(controlStream)
+	if err != nil {
+		log.Fatalf("Не удалось получить ответ сервера: %v", err)
+	}
+	if err = reply.Err(); err != nil {
+		log.Fatalf("Сервер отклонил подключение: %v", err)
+	}
+	log.Printf("Сервер принял подключение.")
 
-	// Согласно протоколу, в первые четыре байта записываем ID транспортного средства, далее пароль
-	data := []byte{0x00, 0x00, 0x00, 0x02}    // ID = 2
-	data = append(data, []byte("example")...) // Пароль: example
-	log.Printf("Отправка информации о транспортном средстве: %v", data)
-	err = conn.SendDatagram(data)
+	// Открываем текстовый поток для передачи информации о транспортном средстве
+	infoStream, err := conn.OpenStreamSync(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer infoStream.Close()
 
-	log.Printf("Информация о транспортном средстве отправлена.")
+	// Открываем видеопотоки для передачи данных: для каждой камеры по одному на каждый слой качества
+	videoStreams := make([][]quic.Stream, len(hello.Cameras))
+	for i := range videoStreams {
+		videoStreams[i] = make([]quic.Stream, len(videoLayers))
+		for j := range videoStreams[i] {
+			videoStreams[i][j], err = conn.OpenStreamSync(context.Background())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer videoStreams[i][j].Close()
+		}
+	}
 
-	wg := &sync.WaitGroup{}
+	// Открываем поток синхронизации часов: время съёмки телеметрии и видео отмечается по часам сервера
+	serverClock := clock.NewEstimator()
 	errChan := make(chan error)
-	wg.Add(2)
-	go sendVideoStream(wg, videoStream, errChan)
-	go sendInfoStream(wg, infoStream, errChan)
+	if reply.Capabilities.Has(protocol.CapabilityClock) {
+		clockStream, err := conn.OpenStreamSync(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer clockStream.Close()
+		go syncClock(clockStream, serverClock, errChan)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1 + len(videoStreams))
+	for _, streams := range videoStreams {
+		go sendVideoStream(wg, streams, serverClock, errChan)
+	}
+	go sendInfoStream(wg, infoStream, reply.Encoding, serverClock, errChan)
+	if reply.Capabilities.Has(protocol.CapabilityCommands) {
+		go handleCommandStream(conn, errChan)
+	}
 
 	// Ожидаем ошибку
 	go func() {
@@ -77,25 +167,63 @@ func main() {
 	wg.Wait()
 }
 
-func sendVideoStream(wg *sync.WaitGroup, stream quic.Stream, errChan chan error) {
+func sendVideoStream(wg *sync.WaitGroup, streams []quic.Stream, serverClock *clock.Estimator, errChan chan error) {
 	defer wg.Done()
-	// Используем FFmpeg для сжатия и отправки видеопотока через стандартный вывод
-	cmd := exec.Command("ffmpeg",
+	// Используем FFmpeg для сжатия видео: первый слой передается через стандартный вывод,
+	// остальные - через дополнительные каналы, начиная с дескриптора 3
+	args := []string{
 		"-re",                     // Реальное время
 		"-i", "assets/output.mp4", // Исходное видео
-		"-c:v", "libx264", // Кодек H.264
-		"-preset", "ultrafast", // Быстрое кодирование
-		"-tune", "zerolatency", // Низкая задержка
-		"-f", "h264", // Сырой вывод
-		"pipe:1",
-	)
-
-	// Связываем stdout FFmpeg с потоком QUIC
-	cmd.Stdout = stream
-	// cmd.Stderr = stream
+	}
+	cmd := exec.Command("ffmpeg")
+	var pipes []*os.File
+	for i, stream := range streams {
+		// Видео каждого слоя читаем из канала, чтобы перед отправкой отметить в кадрах время съёмки
+		r, w, err := os.Pipe()
+		if err != nil {
+			errChan <- err
+			return
+		}
+		pipes = append(pipes, w)
+		output := "pipe:1"
+		if i == 0 {
+			cmd.Stdout = w
+		} else {
+			cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+			output = fmt.Sprintf("pipe:%d", 2+i)
+		}
+		go func() {
+			defer r.Close()
+			if err := sendAccessUnits(stream, r, serverClock); err != nil {
+				errChan <- err
+			}
+		}()
+		args = append(args,
+			"-c:v", "libx264", // Кодек H.264
+			"-preset", "ultrafast", // Быстрое кодирование
+			"-tune", "zerolatency", // Низкая задержка
+			"-g", "50", // Ключевой кадр каждые 50 кадров: с него начинают показ новые и отстающие диспетчеры
+			"-aud", "1", // AUD в начале кадра: по нему кадр собирается, как только начинается следующий
+		)
+		args = append(args, videoLayers[i]...)
+		args = append(args,
+			"-f", "h264", // Сырой вывод
+			output,
+		)
+	}
+	cmd.Args = append(cmd.Args, args...)
 
 	fmt.Println("Отправка видеопотока на сервер через QUIC...")
-	err := cmd.Run()
+	err := cmd.Start()
+	if err != nil {
+		errChan <- err
+		return
+	}
+	// концы каналов для записи теперь есть у FFmpeg, закрываем свои, чтобы чтение завершилось вместе с FFmpeg
+	for _, w := range pipes {
+		_ = w.Close()
+	}
+	err = cmd.Wait()
 	if err != nil {
 		errChan <- err
 		return
@@ -103,7 +231,57 @@ func sendVideoStream(wg *sync.WaitGroup, stream quic.Stream, errChan chan error)
 	fmt.Println("Отправка видеопотока остановлена")
 }
 
-func sendInfoStream(wg *sync.WaitGroup, infoStream quic.Stream, errChan chan error) {
+// sendAccessUnits передаёт видео из FFmpeg в поток QUIC целыми кадрами. В каждый кадр добавляется SEI со временем
+// съёмки по часам сервера - временем, когда начало кадра вышло из кодировщика. AUD, с которого FFmpeg начинает кадр,
+// переносится в конец предыдущего кадра, чтобы сервер собрал кадр сразу, а не по приходу следующего
+func sendAccessUnits(stream io.Writer, r io.Reader, serverClock *clock.Estimator) error {
+	splitter := h264.NewSplitter()
+	assembler := h264.NewAssembler()
+	var capture time.Time
+	send := func(au *h264.AccessUnit, capture time.Time) error {
+		if au == nil {
+			return nil
+		}
+		au.NALs = slices.DeleteFunc(au.NALs, func(nal []byte) bool {
+			return h264.Type(nal) == h264.NALAUD
+		})
+		au.InsertBeforeVCL(h264.NewTimestampsSEI(capture))
+		_, err := stream.Write(append(au.Bytes(), h264.NewAccessUnitDelimiter()...))
+		return err
+	}
+	buffer := make([]byte, 64<<10)
+	for {
+		n, err := r.Read(buffer)
+		now := serverClock.Now()
+		_, _ = splitter.Write(buffer[:n])
+		for nal := splitter.Next(); nal != nil; nal = splitter.Next() {
+			au := assembler.Add(nal)
+			if sendErr := send(au, capture); sendErr != nil {
+				return sendErr
+			}
+			// кадр начался с этого NAL unit
+			if au != nil || capture.IsZero() {
+				capture = now
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			if nal := splitter.Flush(); nal != nil {
+				if au := assembler.Add(nal); au != nil {
+					if sendErr := send(au, capture); sendErr != nil {
+						return sendErr
+					}
+					capture = now
+				}
+			}
+			return send(assembler.Flush(), capture)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func sendInfoStream(wg *sync.WaitGroup, infoStream quic.Stream, encoding protocol.Encoding, serverClock *clock.Estimator, errChan chan error) {
 	defer wg.Done()
 
 	// открываем CSV-файл с данными о транспортном средстве
@@ -129,6 +307,8 @@ func sendInfoStream(wg *sync.WaitGroup, infoStream quic.Stream, errChan chan err
 
 	fmt.Println("Отправка текстового потока на сервер через QUIC...")
 
+	var seq uint64
+
 	for {
 		select {
 		case <-ticker.C:
@@ -143,21 +323,33 @@ func sendInfoStream(wg *sync.WaitGroup, infoStream quic.Stream, errChan chan err
 				return
 			}
 
-			// Конвертируем в JSON
-			data := map[string]string{
-				"steering": record[0],
-				"throttle": record[1],
-				"brake":    record[2],
-				"speed":    record[3],
+			// Конвертируем строку CSV в сообщение телеметрии
+			values := make([]float64, 4)
+			for i := range values {
+				if values[i], err = strconv.ParseFloat(record[i], 64); err != nil {
+					errChan <- fmt.Errorf("некорректное значение в строке %v: %w", record, err)
+					return
+				}
 			}
-			jsonData, err := json.Marshal(data)
+			seq++
+			data := entity.Telemetry{
+				Seq:       seq,
+				Timestamp: time.Now(),
+				Steering:  values[0],
+				Throttle:  values[1],
+				Brake:     values[2],
+				Speed:     values[3],
+				Mode:      entity.AutonomousMode,
+				Latency:   &entity.LatencyStamps{Capture: serverClock.Now()},
+			}
+			frame, err := codec.MarshalTelemetry(encoding, &data)
 			if err != nil {
 				errChan <- err
 				return
 			}
 
-			// Отправляем JSON на сервер
-			_, err = infoStream.Write(jsonData)
+			// Отправляем сообщение на сервер отдельным кадром
+			err = protocol.WriteFrame(infoStream, frame)
 			if err != nil {
 				errChan <- err
 				return
@@ -165,3 +357,57 @@ func sendInfoStream(wg *sync.WaitGroup, infoStream quic.Stream, errChan chan err
 		}
 	}
 }
+
+func handleCommandStream(conn quic.Connection, errChan chan error) {
+	// Поток команд открывает сервер
+	commandStream, err := conn.AcceptStream(context.Background())
+	if err != nil {
+		errChan <- err
+		return
+	}
+	defer commandStream.Close()
+	log.Printf("Открыт commandStream с %s\n", conn.RemoteAddr())
+
+	decoder := json.NewDecoder(commandStream)
+	encoder := json.NewEncoder(commandStream)
+	for {
+		var command entity.Command
+		if err := decoder.Decode(&command); err != nil {
+			errChan <- fmt.Errorf("ошибка чтения из commandStream: %w", err)
+			return
+		}
+		// Симулятор не управляет реальным ТС, поэтому просто применяет команду и подтверждает её
+		log.Printf("Получена команда %d: %+v\n", command.Seq, command)
+		ack := entity.CommandAck{Seq: command.Seq, Status: entity.CommandApplied}
+		if !entity.IsCommandValid(&command) {
+			ack.Status = entity.CommandRejected
+			ack.Error = "invalid command"
+		}
+		if err := encoder.Encode(ack); err != nil {
+			errChan <- fmt.Errorf("ошибка записи в commandStream: %w", err)
+			return
+		}
+	}
+}
+
+// syncClock периодически измеряет смещение часов сервера относительно локальных часов
+func syncClock(clockStream quic.Stream, serverClock *clock.Estimator, errChan chan error) {
+	decoder := json.NewDecoder(clockStream)
+	encoder := json.NewEncoder(clockStream)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		sent := time.Now()
+		if err := encoder.Encode(entity.ClockSync{ClientSent: sent}); err != nil {
+			errChan <- fmt.Errorf("ошибка записи в clockStream: %w", err)
+			return
+		}
+		var sync entity.ClockSync
+		if err := decoder.Decode(&sync); err != nil {
+			errChan <- fmt.Errorf("ошибка чтения из clockStream: %w", err)
+			return
+		}
+		serverClock.Add(sent, sync.ServerReceived, sync.ServerSent, time.Now())
+		<-ticker.C
+	}
+}
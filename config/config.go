@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type ServerConfig struct {
 	DatabaseUrl    string `mapstructure:"database_url"`
 	DatabaseNumber int    `mapstructure:"database_number"`
@@ -7,14 +9,37 @@ type ServerConfig struct {
 	VehiclePort    int    `mapstructure:"vehicle_port"`
 	DispatcherHost string `mapstructure:"dispatcher_host"`
 	DispatcherPort int    `mapstructure:"dispatcher_port"`
-	SecretKey      string
+	// SessionGracePeriod - сколько сервер ждёт переподключения ТС, прежде чем отключить его диспетчеров
+	SessionGracePeriod time.Duration `mapstructure:"session_grace_period"`
+	// TokenTTL - сколько действует токен сессии ТС или диспетчера
+	TokenTTL  time.Duration `mapstructure:"token_ttl"`
+	SecretKey string
+	// TokenKey - ключ подписи токенов сессий. Если он не задан, то ключ создаётся при запуске,
+	// и после перезапуска сервера клиенты снова входят по паролю
+	TokenKey string
+	// VehicleCAFile - сертификат удостоверяющего центра, которым подписаны сертификаты ТС.
+	// Если он не задан, то ТС входят только по паролю
+	VehicleCAFile string `mapstructure:"vehicle_ca_cert_file"`
 }
 
 type AdminConfig struct {
 	DatabaseUrl    string `mapstructure:"database_url"`
 	DatabaseNumber int    `mapstructure:"database_number"`
 	Addr           string `mapstructure:"address"`
-	SecretKey      string
+	// TokenTTL - сколько действует токен администратора
+	TokenTTL time.Duration `mapstructure:"token_ttl"`
+	// TokenKey - ключ подписи токенов администраторов. Если он не задан, то ключ создаётся при запуске,
+	// и после перезапуска сервера администраторы входят заново
+	TokenKey string
+	// AdminLogin и AdminPassword - суперадминистратор, который создаётся при запуске, если его ещё нет
+	AdminLogin    string
+	AdminPassword string
+	// VehicleCACertFile и VehicleCAKeyFile - удостоверяющий центр для выпуска сертификатов ТС.
+	// Если они не заданы, то выпуск сертификатов отключён
+	VehicleCACertFile string `mapstructure:"vehicle_ca_cert_file"`
+	VehicleCAKeyFile  string `mapstructure:"vehicle_ca_key_file"`
+	// VehicleCertificateTTL - срок действия сертификата ТС по умолчанию
+	VehicleCertificateTTL time.Duration `mapstructure:"vehicle_certificate_ttl"`
 }
 
 type ClientConfig struct {
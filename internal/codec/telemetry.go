@@ -0,0 +1,305 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"time"
+)
+
+var ErrUnsupportedEncoding = errors.New("unsupported encoding")
+
+// MarshalTelemetry кодирует сообщение телеметрии в указанном формате
+func MarshalTelemetry(encoding protocol.Encoding, telemetry *entity.Telemetry) ([]byte, error) {
+	switch encoding {
+	case protocol.EncodingJSON:
+		return json.Marshal(telemetry)
+	case protocol.EncodingProtobuf:
+		return marshalTelemetryProto(telemetry), nil
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+}
+
+// UnmarshalTelemetry декодирует сообщение телеметрии из указанного формата
+func UnmarshalTelemetry(encoding protocol.Encoding, data []byte, telemetry *entity.Telemetry) error {
+	switch encoding {
+	case protocol.EncodingJSON:
+		return json.Unmarshal(data, telemetry)
+	case protocol.EncodingProtobuf:
+		return unmarshalTelemetryProto(data, telemetry)
+	default:
+		return ErrUnsupportedEncoding
+	}
+}
+
+// Номера полей из telemetry.proto
+const (
+	fieldSeq       protowire.Number = 1
+	fieldTimestamp protowire.Number = 2
+	fieldSteering  protowire.Number = 3
+	fieldThrottle  protowire.Number = 4
+	fieldBrake     protowire.Number = 5
+	fieldSpeed     protowire.Number = 6
+	fieldPosition  protowire.Number = 7
+	fieldHeading   protowire.Number = 8
+	fieldBattery   protowire.Number = 9
+	fieldMode      protowire.Number = 10
+	fieldFaults    protowire.Number = 11
+	fieldFlags     protowire.Number = 12
+	fieldLatency   protowire.Number = 13
+
+	fieldLatitude  protowire.Number = 1
+	fieldLongitude protowire.Number = 2
+
+	fieldCapture protowire.Number = 1
+	fieldIngress protowire.Number = 2
+	fieldEgress  protowire.Number = 3
+)
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendTime кодирует время в наносекундах с начала эпохи Unix, нулевое время не передаётся
+func appendTime(b []byte, num protowire.Number, v time.Time) []byte {
+	if v.IsZero() {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v.UnixNano()))
+}
+
+// marshalTelemetryProto кодирует сообщение в Protobuf. Как и в proto3, нулевые значения не передаются,
+// кроме optional-полей, которые передаются, если они заданы
+func marshalTelemetryProto(telemetry *entity.Telemetry) []byte {
+	var b []byte
+	if telemetry.Seq != 0 {
+		b = protowire.AppendTag(b, fieldSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, telemetry.Seq)
+	}
+	b = appendTime(b, fieldTimestamp, telemetry.Timestamp)
+	for _, field := range []struct {
+		num   protowire.Number
+		value float64
+	}{
+		{fieldSteering, telemetry.Steering},
+		{fieldThrottle, telemetry.Throttle},
+		{fieldBrake, telemetry.Brake},
+		{fieldSpeed, telemetry.Speed},
+	} {
+		if field.value != 0 {
+			b = appendDouble(b, field.num, field.value)
+		}
+	}
+	if telemetry.Position != nil {
+		var position []byte
+		position = appendDouble(position, fieldLatitude, telemetry.Position.Latitude)
+		position = appendDouble(position, fieldLongitude, telemetry.Position.Longitude)
+		b = protowire.AppendTag(b, fieldPosition, protowire.BytesType)
+		b = protowire.AppendBytes(b, position)
+	}
+	if telemetry.Heading != nil {
+		b = appendDouble(b, fieldHeading, *telemetry.Heading)
+	}
+	if telemetry.Battery != nil {
+		b = appendDouble(b, fieldBattery, *telemetry.Battery)
+	}
+	if telemetry.Mode != "" {
+		b = appendString(b, fieldMode, string(telemetry.Mode))
+	}
+	for _, fault := range telemetry.Faults {
+		b = appendString(b, fieldFaults, fault)
+	}
+	for _, flag := range telemetry.Flags {
+		b = appendString(b, fieldFlags, flag)
+	}
+	if telemetry.Latency != nil {
+		var latency []byte
+		latency = appendTime(latency, fieldCapture, telemetry.Latency.Capture)
+		latency = appendTime(latency, fieldIngress, telemetry.Latency.Ingress)
+		latency = appendTime(latency, fieldEgress, telemetry.Latency.Egress)
+		b = protowire.AppendTag(b, fieldLatency, protowire.BytesType)
+		b = protowire.AppendBytes(b, latency)
+	}
+	return b
+}
+
+// consumeDouble читает значение поля типа double
+func consumeDouble(typ protowire.Type, b []byte) (float64, int, error) {
+	if typ != protowire.Fixed64Type {
+		return 0, 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeFixed64(b)
+	if n < 0 {
+		return 0, 0, protowire.ParseError(n)
+	}
+	return math.Float64frombits(v), n, nil
+}
+
+// consumeBytes читает значение поля типа string, bytes или вложенного сообщения
+func consumeBytes(typ protowire.Type, b []byte) ([]byte, int, error) {
+	if typ != protowire.BytesType {
+		return nil, 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return nil, 0, protowire.ParseError(n)
+	}
+	return v, n, nil
+}
+
+func unmarshalPositionProto(b []byte) (*entity.Position, error) {
+	position := &entity.Position{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		var err error
+		switch num {
+		case fieldLatitude:
+			position.Latitude, n, err = consumeDouble(typ, b)
+		case fieldLongitude:
+			position.Longitude, n, err = consumeDouble(typ, b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return position, nil
+}
+
+// consumeTime читает время в наносекундах с начала эпохи Unix
+func consumeTime(typ protowire.Type, b []byte) (time.Time, int, error) {
+	if typ != protowire.VarintType {
+		return time.Time{}, 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return time.Time{}, 0, protowire.ParseError(n)
+	}
+	return time.Unix(0, int64(v)), n, nil
+}
+
+func unmarshalLatencyProto(b []byte) (*entity.LatencyStamps, error) {
+	latency := &entity.LatencyStamps{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		var err error
+		switch num {
+		case fieldCapture:
+			latency.Capture, n, err = consumeTime(typ, b)
+		case fieldIngress:
+			latency.Ingress, n, err = consumeTime(typ, b)
+		case fieldEgress:
+			latency.Egress, n, err = consumeTime(typ, b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return latency, nil
+}
+
+func unmarshalTelemetryProto(b []byte, telemetry *entity.Telemetry) error {
+	*telemetry = entity.Telemetry{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var err error
+		var value float64
+		var data []byte
+		switch num {
+		case fieldSeq, fieldTimestamp:
+			if typ != protowire.VarintType {
+				return fmt.Errorf("unexpected wire type %d", typ)
+			}
+			var v uint64
+			if v, n = protowire.ConsumeVarint(b); n >= 0 {
+				if num == fieldSeq {
+					telemetry.Seq = v
+				} else {
+					telemetry.Timestamp = time.Unix(0, int64(v))
+				}
+			}
+		case fieldSteering:
+			telemetry.Steering, n, err = consumeDouble(typ, b)
+		case fieldThrottle:
+			telemetry.Throttle, n, err = consumeDouble(typ, b)
+		case fieldBrake:
+			telemetry.Brake, n, err = consumeDouble(typ, b)
+		case fieldSpeed:
+			telemetry.Speed, n, err = consumeDouble(typ, b)
+		case fieldHeading:
+			if value, n, err = consumeDouble(typ, b); err == nil {
+				telemetry.Heading = &value
+			}
+		case fieldBattery:
+			if value, n, err = consumeDouble(typ, b); err == nil {
+				telemetry.Battery = &value
+			}
+		case fieldPosition:
+			if data, n, err = consumeBytes(typ, b); err == nil {
+				telemetry.Position, err = unmarshalPositionProto(data)
+			}
+		case fieldMode:
+			if data, n, err = consumeBytes(typ, b); err == nil {
+				telemetry.Mode = entity.AutonomyMode(data)
+			}
+		case fieldFaults:
+			if data, n, err = consumeBytes(typ, b); err == nil {
+				telemetry.Faults = append(telemetry.Faults, string(data))
+			}
+		case fieldFlags:
+			if data, n, err = consumeBytes(typ, b); err == nil {
+				telemetry.Flags = append(telemetry.Flags, string(data))
+			}
+		case fieldLatency:
+			if data, n, err = consumeBytes(typ, b); err == nil {
+				telemetry.Latency, err = unmarshalLatencyProto(data)
+			}
+		default:
+			// неизвестные поля пропускаем для совместимости с будущими версиями схемы
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
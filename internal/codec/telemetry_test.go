@@ -0,0 +1,265 @@
+package codec
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"os"
+	"reflect"
+	"regexp"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	protoComment = regexp.MustCompile(`//.*`)
+	protoMessage = regexp.MustCompile(`^message (\w+) \{$`)
+	protoField   = regexp.MustCompile(`^(optional |repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+var protoScalars = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+}
+
+// loadSchema разбирает telemetry.proto и возвращает описание сообщения Telemetry.
+// Разбирается только то подмножество синтаксиса, которое используется в схеме
+func loadSchema(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	data, err := os.ReadFile("telemetry.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String("telemetry.proto"),
+		Syntax: proto.String("proto3"),
+	}
+	var message *descriptorpb.DescriptorProto
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(protoComment.ReplaceAllString(line, ""))
+		switch {
+		case line == "" || line == `syntax = "proto3";`:
+		case strings.HasPrefix(line, "package "):
+			file.Package = proto.String(strings.TrimSuffix(strings.TrimPrefix(line, "package "), ";"))
+		case protoMessage.MatchString(line):
+			message = &descriptorpb.DescriptorProto{Name: proto.String(protoMessage.FindStringSubmatch(line)[1])}
+			file.MessageType = append(file.MessageType, message)
+		case line == "}" && message != nil:
+			message = nil
+		case protoField.MatchString(line) && message != nil:
+			m := protoField.FindStringSubmatch(line)
+			number, _ := strconv.Atoi(m[4])
+			field := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(m[3]),
+				JsonName: proto.String(m[3]),
+				Number:   proto.Int32(int32(number)),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}
+			if typ, ok := protoScalars[m[2]]; ok {
+				field.Type = typ.Enum()
+			} else {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String("." + file.GetPackage() + "." + m[2])
+			}
+			switch m[1] {
+			case "repeated ":
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			case "optional ":
+				// optional в proto3 описывается синтетическим oneof
+				field.Proto3Optional = proto.Bool(true)
+				field.OneofIndex = proto.Int32(int32(len(message.OneofDecl)))
+				message.OneofDecl = append(message.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + m[3])})
+			}
+			message.Field = append(message.Field, field)
+		default:
+			t.Fatalf("telemetry.proto:%d: unsupported line %q", i+1, line)
+		}
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("telemetry.proto: %v", err)
+	}
+	telemetry := fd.Messages().ByName("Telemetry")
+	if telemetry == nil {
+		t.Fatal("telemetry.proto: no Telemetry message")
+	}
+	return telemetry
+}
+
+// schemaMessage заполняет динамическое сообщение по схеме значениями telemetry, обращаясь к полям по именам из схемы
+func schemaMessage(desc protoreflect.MessageDescriptor, telemetry *entity.Telemetry) *dynamicpb.Message {
+	m := dynamicpb.NewMessage(desc)
+	set := func(m *dynamicpb.Message, name string, v protoreflect.Value) {
+		m.Set(m.Descriptor().Fields().ByName(protoreflect.Name(name)), v)
+	}
+	setTime := func(m *dynamicpb.Message, name string, v time.Time) {
+		if !v.IsZero() {
+			set(m, name, protoreflect.ValueOfInt64(v.UnixNano()))
+		}
+	}
+	setDouble := func(name string, v float64) {
+		if v != 0 {
+			set(m, name, protoreflect.ValueOfFloat64(v))
+		}
+	}
+	if telemetry.Seq != 0 {
+		set(m, "seq", protoreflect.ValueOfUint64(telemetry.Seq))
+	}
+	setTime(m, "timestamp", telemetry.Timestamp)
+	setDouble("steering", telemetry.Steering)
+	setDouble("throttle", telemetry.Throttle)
+	setDouble("brake", telemetry.Brake)
+	setDouble("speed", telemetry.Speed)
+	if telemetry.Position != nil {
+		position := dynamicpb.NewMessage(desc.Fields().ByName("position").Message())
+		set(position, "latitude", protoreflect.ValueOfFloat64(telemetry.Position.Latitude))
+		set(position, "longitude", protoreflect.ValueOfFloat64(telemetry.Position.Longitude))
+		set(m, "position", protoreflect.ValueOfMessage(position))
+	}
+	if telemetry.Heading != nil {
+		set(m, "heading", protoreflect.ValueOfFloat64(*telemetry.Heading))
+	}
+	if telemetry.Battery != nil {
+		set(m, "battery", protoreflect.ValueOfFloat64(*telemetry.Battery))
+	}
+	if telemetry.Mode != "" {
+		set(m, "mode", protoreflect.ValueOfString(string(telemetry.Mode)))
+	}
+	for name, values := range map[string][]string{"faults": telemetry.Faults, "flags": telemetry.Flags} {
+		list := m.Mutable(desc.Fields().ByName(protoreflect.Name(name))).List()
+		for _, v := range values {
+			list.Append(protoreflect.ValueOfString(v))
+		}
+	}
+	if telemetry.Latency != nil {
+		latency := dynamicpb.NewMessage(desc.Fields().ByName("latency").Message())
+		setTime(latency, "capture", telemetry.Latency.Capture)
+		setTime(latency, "ingress", telemetry.Latency.Ingress)
+		setTime(latency, "egress", telemetry.Latency.Egress)
+		set(m, "latency", protoreflect.ValueOfMessage(latency))
+	}
+	return m
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
+
+// TestTelemetrySchema проверяет, что ручной кодек совместим со схемой telemetry.proto в обе стороны
+func TestTelemetrySchema(t *testing.T) {
+	desc := loadSchema(t)
+	tests := []struct {
+		name      string
+		telemetry entity.Telemetry
+	}{
+		{name: "empty"},
+		{
+			name: "full",
+			telemetry: entity.Telemetry{
+				Seq:       1 << 40,
+				Timestamp: time.Unix(0, 1700000000123456789),
+				Steering:  -0.25,
+				Throttle:  0.5,
+				Brake:     0.125,
+				Speed:     87.5,
+				Position:  &entity.Position{Latitude: 55.7558, Longitude: 37.6173},
+				Heading:   ptr(270),
+				Battery:   ptr(64.5),
+				Mode:      entity.TeleoperatedMode,
+				Faults:    []string{"LIDAR_1", "GPS"},
+				Flags:     []string{"speed"},
+				Latency: &entity.LatencyStamps{
+					Capture: time.Unix(0, 1700000000000000000),
+					Ingress: time.Unix(0, 1700000000100000000),
+				},
+			},
+		},
+		{
+			// optional-поля с нулевым значением должны передаваться, а обычные нулевые поля - нет
+			name:      "zero optional",
+			telemetry: entity.Telemetry{Timestamp: time.Unix(0, 1), Heading: ptr(0), Battery: ptr(0), Position: &entity.Position{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := schemaMessage(desc, &tt.telemetry)
+
+			data, err := MarshalTelemetry(protocol.EncodingProtobuf, &tt.telemetry)
+			if err != nil {
+				t.Fatalf("MarshalTelemetry: %v", err)
+			}
+			got := dynamicpb.NewMessage(desc)
+			if err := proto.Unmarshal(data, got); err != nil {
+				t.Fatalf("proto.Unmarshal: %v", err)
+			}
+			if !proto.Equal(got, want) {
+				t.Errorf("proto.Unmarshal(MarshalTelemetry) = %v, want %v", got, want)
+			}
+
+			data, err = proto.Marshal(want)
+			if err != nil {
+				t.Fatalf("proto.Marshal: %v", err)
+			}
+			var telemetry entity.Telemetry
+			if err := UnmarshalTelemetry(protocol.EncodingProtobuf, data, &telemetry); err != nil {
+				t.Fatalf("UnmarshalTelemetry: %v", err)
+			}
+			if !reflect.DeepEqual(telemetry, tt.telemetry) {
+				t.Errorf("UnmarshalTelemetry(proto.Marshal) = %+v, want %+v", telemetry, tt.telemetry)
+			}
+		})
+	}
+}
+
+func TestUnmarshalTelemetryProtoErrors(t *testing.T) {
+	valid, err := MarshalTelemetry(protocol.EncodingProtobuf, &entity.Telemetry{
+		Seq:       1,
+		Timestamp: time.Unix(0, 1),
+		Position:  &entity.Position{Latitude: 1, Longitude: 2},
+		Mode:      entity.AutonomousMode,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "truncated tag", data: []byte{0x80}, wantErr: true},
+		{name: "truncated varint", data: valid[:1], wantErr: true},
+		{name: "truncated message", data: valid[:len(valid)-1], wantErr: true},
+		{name: "seq as fixed64", data: protowire.AppendFixed64(protowire.AppendTag(nil, fieldSeq, protowire.Fixed64Type), 1), wantErr: true},
+		{name: "speed as varint", data: protowire.AppendVarint(protowire.AppendTag(nil, fieldSpeed, protowire.VarintType), 1), wantErr: true},
+		{name: "truncated position", data: protowire.AppendBytes(protowire.AppendTag(nil, fieldPosition, protowire.BytesType), []byte{0x09, 1, 2}), wantErr: true},
+		// неизвестные поля пропускаются
+		{name: "unknown field", data: protowire.AppendString(protowire.AppendTag(valid, 99, protowire.BytesType), "future")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var telemetry entity.Telemetry
+			err := UnmarshalTelemetry(protocol.EncodingProtobuf, tt.data, &telemetry)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTelemetryUnsupportedEncoding(t *testing.T) {
+	if _, err := MarshalTelemetry(protocol.Encoding(0xff), &entity.Telemetry{}); err != ErrUnsupportedEncoding {
+		t.Errorf("MarshalTelemetry: err = %v, want ErrUnsupportedEncoding", err)
+	}
+	if err := UnmarshalTelemetry(protocol.Encoding(0xff), nil, &entity.Telemetry{}); err != ErrUnsupportedEncoding {
+		t.Errorf("UnmarshalTelemetry: err = %v, want ErrUnsupportedEncoding", err)
+	}
+}
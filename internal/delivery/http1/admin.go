@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"io"
 	"net/http"
 	"self-driving-car-dispatch-system/internal/entity"
 	"self-driving-car-dispatch-system/internal/usecase"
 	"strconv"
+	"strings"
 )
 
 type AdminDelivery struct {
@@ -24,19 +26,174 @@ func NewAdminDelivery(logger *logrus.Logger, adminUsecase usecase.AdminUsecase)
 }
 
 func (a AdminDelivery) Configure(handler *gin.RouterGroup) {
+	// Вход администратора и маршруты для работы с учётными записями администраторов
+	handler.POST("/login", a.Login)
+	handler.GET("/account/:login", a.GetAdmin)
+	handler.POST("/account", a.AddAdmin)
+	handler.PUT("/account", a.EditAdmin)
+	handler.DELETE("/account/:login", a.DeleteAdmin)
 	// Маршруты для работы с диспетчерами
+	handler.GET("/dispatcher", a.ListDispatchers)
 	handler.GET("/dispatcher/:id", a.GetDispatcher)
 	handler.POST("/dispatcher", a.AddDispatcher)
 	handler.PUT("/dispatcher", a.EditDispatcherGrants)
 	handler.DELETE("/dispatcher/:id", a.DeleteDispatcher)
+	handler.POST("/dispatcher/:id/credential", a.RotateDispatcherCredential)
 	// Маршруты для работы с ТС
+	handler.GET("/vehicle", a.ListVehicles)
 	handler.GET("/vehicle/:id", a.GetVehicle)
 	handler.POST("/vehicle", a.AddVehicle)
+	handler.PUT("/vehicle", a.EditVehicle)
 	handler.DELETE("/vehicle/:id", a.DeleteVehicle)
+	handler.GET("/vehicle/:id/streams", a.GetVehicleStreams)
+	handler.POST("/vehicle/:id/credential", a.RotateVehicleCredential)
+	// Маршруты для работы с сертификатами ТС
+	handler.POST("/vehicle/:id/certificate", a.IssueVehicleCertificate)
+	handler.GET("/vehicle/:id/certificate", a.GetVehicleCertificates)
+	handler.DELETE("/vehicle/:id/certificate/:serial", a.RevokeVehicleCertificate)
+	// Журнал аудита
+	handler.GET("/audit", a.GetAudit)
+}
+
+// bearerToken возвращает токен администратора из заголовка Authorization: Bearer <token>
+func bearerToken(c *gin.Context) string {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token
+}
+
+// Admin account
+
+func (a AdminDelivery) Login(c *gin.Context) {
+	loginRequest := entity.AdminLoginRequest{}
+	if err := c.BindJSON(&loginRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	session, err := a.adminUsecase.Login(&loginRequest)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid login or password"})
+	case err == nil:
+		c.JSON(http.StatusOK, session)
+	default:
+		a.logger.Errorf("failed to log in admin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func (a AdminDelivery) GetAdmin(c *gin.Context) {
+	token := bearerToken(c)
+	admin, err := a.adminUsecase.GetAdmin(token, c.Param("login"))
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrAdminNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin not found"})
+	case err == nil:
+		c.JSON(http.StatusOK, admin)
+	default:
+		a.logger.Errorf("failed to get admin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func (a AdminDelivery) AddAdmin(c *gin.Context) {
+	adminRequest := entity.AddAdminRequest{}
+	if err := c.BindJSON(&adminRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	admin, err := a.adminUsecase.AddAdmin(token, &adminRequest)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrAdminAlreadyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "admin already exists"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		c.JSON(http.StatusCreated, gin.H{"login": admin.Login})
+	default:
+		a.logger.Errorf("failed to add admin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func (a AdminDelivery) EditAdmin(c *gin.Context) {
+	adminRequest := entity.EditAdminRequest{}
+	if err := c.BindJSON(&adminRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	err := a.adminUsecase.EditAdmin(token, &adminRequest)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrAdminNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin not found"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		c.JSON(http.StatusNoContent, nil)
+	default:
+		a.logger.Errorf("failed to edit admin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func (a AdminDelivery) DeleteAdmin(c *gin.Context) {
+	token := bearerToken(c)
+	err := a.adminUsecase.DeleteAdmin(token, c.Param("login"))
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrAdminNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin not found"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		c.JSON(http.StatusNoContent, nil)
+	default:
+		a.logger.Errorf("failed to delete admin: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
 }
 
 // Dispatcher
 
+func (a AdminDelivery) ListDispatchers(c *gin.Context) {
+	filter := entity.DispatcherFilter{}
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	dispatchers, err := a.adminUsecase.ListDispatchers(token, &filter)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		c.JSON(http.StatusOK, dispatchers)
+	default:
+		a.logger.Errorf("failed to list dispatchers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
 func (a AdminDelivery) GetDispatcher(c *gin.Context) {
 	var id int
 	var err error
@@ -44,9 +201,11 @@ func (a AdminDelivery) GetDispatcher(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	secret := c.GetHeader("X-Secret")
-	dispatcher, err := a.adminUsecase.GetDispatcher(secret, id)
+	token := bearerToken(c)
+	dispatcher, err := a.adminUsecase.GetDispatcher(token, id)
 	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 	case errors.Is(err, usecase.ErrAccessDenied):
 		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
 	case errors.Is(err, usecase.ErrDispatcherNotFound):
@@ -65,9 +224,11 @@ func (a AdminDelivery) AddDispatcher(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
-	secret := c.GetHeader("X-Secret")
-	dispatcher, err := a.adminUsecase.AddDispatcher(secret, &dispatcherRequest)
+	token := bearerToken(c)
+	dispatcher, err := a.adminUsecase.AddDispatcher(token, &dispatcherRequest)
 	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 	case errors.Is(err, usecase.ErrAccessDenied):
 		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
 	case errors.Is(err, usecase.ErrDispatcherAlreadyExists):
@@ -88,9 +249,11 @@ func (a AdminDelivery) EditDispatcherGrants(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
-	secret := c.GetHeader("X-Secret")
-	err := a.adminUsecase.EditDispatcher(secret, &dispatcher)
+	token := bearerToken(c)
+	err := a.adminUsecase.EditDispatcher(token, &dispatcher)
 	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 	case errors.Is(err, usecase.ErrAccessDenied):
 		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
 	case errors.Is(err, usecase.ErrDispatcherNotFound):
@@ -112,9 +275,11 @@ func (a AdminDelivery) DeleteDispatcher(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid request: %v", err)})
 		return
 	}
-	secret := c.GetHeader("X-Secret")
-	err = a.adminUsecase.DeleteDispatcher(secret, id)
+	token := bearerToken(c)
+	err = a.adminUsecase.DeleteDispatcher(token, id)
 	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 	case errors.Is(err, usecase.ErrAccessDenied):
 		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
 	case errors.Is(err, usecase.ErrDispatcherNotFound):
@@ -129,6 +294,29 @@ func (a AdminDelivery) DeleteDispatcher(c *gin.Context) {
 
 // Vehicle
 
+func (a AdminDelivery) ListVehicles(c *gin.Context) {
+	filter := entity.VehicleFilter{}
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	vehicles, err := a.adminUsecase.ListVehicles(token, &filter)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		c.JSON(http.StatusOK, vehicles)
+	default:
+		a.logger.Errorf("failed to list vehicles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
 func (a AdminDelivery) GetVehicle(c *gin.Context) {
 	var id int
 	var err error
@@ -136,9 +324,11 @@ func (a AdminDelivery) GetVehicle(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
-	secret := c.GetHeader("X-Secret")
-	vehicle, err := a.adminUsecase.GetVehicle(secret, id)
+	token := bearerToken(c)
+	vehicle, err := a.adminUsecase.GetVehicle(token, id)
 	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 	case errors.Is(err, usecase.ErrAccessDenied):
 		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
 	case errors.Is(err, usecase.ErrVehicleNotFound):
@@ -157,9 +347,11 @@ func (a AdminDelivery) AddVehicle(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
-	secret := c.GetHeader("X-Secret")
-	vehicle, err := a.adminUsecase.AddVehicle(secret, &vehicleRequest)
+	token := bearerToken(c)
+	vehicle, err := a.adminUsecase.AddVehicle(token, &vehicleRequest)
 	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 	case errors.Is(err, usecase.ErrAccessDenied):
 		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
 	case errors.Is(err, usecase.ErrVehicleAlreadyExists):
@@ -174,6 +366,31 @@ func (a AdminDelivery) AddVehicle(c *gin.Context) {
 	}
 }
 
+func (a AdminDelivery) EditVehicle(c *gin.Context) {
+	vehicleRequest := entity.EditVehicleRequest{}
+	if err := c.BindJSON(&vehicleRequest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	err := a.adminUsecase.EditVehicle(token, &vehicleRequest)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrVehicleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "vehicle not found"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		c.JSON(http.StatusNoContent, nil)
+	default:
+		a.logger.Errorf("failed to edit vehicle: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
 func (a AdminDelivery) DeleteVehicle(c *gin.Context) {
 	var id int
 	var err error
@@ -181,9 +398,11 @@ func (a AdminDelivery) DeleteVehicle(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid request: %v", err)})
 		return
 	}
-	secret := c.GetHeader("X-Secret")
-	err = a.adminUsecase.DeleteVehicle(secret, id)
+	token := bearerToken(c)
+	err = a.adminUsecase.DeleteVehicle(token, id)
 	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 	case errors.Is(err, usecase.ErrAccessDenied):
 		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
 	case errors.Is(err, usecase.ErrVehicleNotFound):
@@ -195,3 +414,208 @@ func (a AdminDelivery) DeleteVehicle(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 	}
 }
+
+func (a AdminDelivery) GetVehicleStreams(c *gin.Context) {
+	var id int
+	var err error
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	token := bearerToken(c)
+	health, err := a.adminUsecase.GetVehicleStreams(token, id)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrVehicleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "vehicle not found"})
+	case err == nil:
+		c.JSON(http.StatusOK, health)
+	default:
+		a.logger.Errorf("failed to get vehicle streams: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+// Vehicle certificate
+
+func (a AdminDelivery) IssueVehicleCertificate(c *gin.Context) {
+	var id int
+	var err error
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	// тело запроса необязательно: без него сертификат выпускается на срок из конфигурации
+	certificateRequest := entity.IssueCertificateRequest{}
+	if err = c.ShouldBindJSON(&certificateRequest); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	certificate, err := a.adminUsecase.IssueVehicleCertificate(token, id, &certificateRequest)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrVehicleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "vehicle not found"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case errors.Is(err, usecase.ErrNotConfigured):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "vehicle certificates are not configured"})
+	case err == nil:
+		c.JSON(http.StatusCreated, certificate)
+	default:
+		a.logger.Errorf("failed to issue vehicle certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func (a AdminDelivery) GetVehicleCertificates(c *gin.Context) {
+	var id int
+	var err error
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	token := bearerToken(c)
+	certificates, err := a.adminUsecase.GetVehicleCertificates(token, id)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrVehicleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "vehicle not found"})
+	case err == nil:
+		c.JSON(http.StatusOK, certificates)
+	default:
+		a.logger.Errorf("failed to get vehicle certificates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func (a AdminDelivery) RevokeVehicleCertificate(c *gin.Context) {
+	var id int
+	var err error
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	token := bearerToken(c)
+	err = a.adminUsecase.RevokeVehicleCertificate(token, id, c.Param("serial"))
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrCertificateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "certificate not found"})
+	case err == nil:
+		c.JSON(http.StatusNoContent, nil)
+	default:
+		a.logger.Errorf("failed to revoke vehicle certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+// Credential rotation
+
+func (a AdminDelivery) RotateVehicleCredential(c *gin.Context) {
+	var id int
+	var err error
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	// тело запроса необязательно: без него прежний пароль отзывается сразу
+	rotateRequest := entity.RotateCredentialRequest{}
+	if err = c.ShouldBindJSON(&rotateRequest); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	credential, err := a.adminUsecase.RotateVehicleCredential(token, id, &rotateRequest)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrVehicleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "vehicle not found"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		if !credential.SessionsRevoked {
+			a.logger.Warnf("vehicle %d credential rotated, but relay sessions were not notified", id)
+		}
+		c.JSON(http.StatusOK, credential)
+	default:
+		a.logger.Errorf("failed to rotate vehicle credential: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func (a AdminDelivery) RotateDispatcherCredential(c *gin.Context) {
+	var id int
+	var err error
+	if id, err = strconv.Atoi(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	// тело запроса необязательно: без него прежний пароль отзывается сразу
+	rotateRequest := entity.RotateCredentialRequest{}
+	if err = c.ShouldBindJSON(&rotateRequest); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	credential, err := a.adminUsecase.RotateDispatcherCredential(token, id, &rotateRequest)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrDispatcherNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "dispatcher not found"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		if !credential.SessionsRevoked {
+			a.logger.Warnf("dispatcher %d credential rotated, but relay sessions were not notified", id)
+		}
+		c.JSON(http.StatusOK, credential)
+	default:
+		a.logger.Errorf("failed to rotate dispatcher credential: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+// Audit
+
+func (a AdminDelivery) GetAudit(c *gin.Context) {
+	filter := entity.AuditFilter{}
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	token := bearerToken(c)
+	records, err := a.adminUsecase.GetAudit(token, &filter)
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	case errors.Is(err, usecase.ErrAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	case errors.Is(err, usecase.ErrBadRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+	case err == nil:
+		c.JSON(http.StatusOK, records)
+	default:
+		a.logger.Errorf("failed to get audit records: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
@@ -0,0 +1,37 @@
+package http3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"self-driving-car-dispatch-system/internal/entity"
+	"time"
+)
+
+// serveClock отвечает на запросы синхронизации часов: возвращает каждое сообщение entity.ClockSync с временем
+// получения и отправки по часам сервера. Время отмечается в той же горутине, что читает и пишет поток,
+// чтобы очереди между горутинами не вносили ошибку в оценку смещения часов клиента
+func serveClock(ctx context.Context, clockStream io.ReadWriter, errChan chan error) {
+	decoder := json.NewDecoder(clockStream)
+	encoder := json.NewEncoder(clockStream)
+	for {
+		var sync entity.ClockSync
+		err := decoder.Decode(&sync)
+		if err == nil {
+			sync.ServerReceived = time.Now()
+			sync.ServerSent = time.Now()
+			err = encoder.Encode(sync)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+				select {
+				case errChan <- err:
+				case <-ctx.Done():
+				}
+			}
+			return
+		}
+	}
+}
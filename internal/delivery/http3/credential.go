@@ -0,0 +1,55 @@
+package http3
+
+import (
+	"context"
+	"self-driving-car-dispatch-system/internal/entity"
+	"sync/atomic"
+	"time"
+)
+
+// tokenRetryInterval - через сколько повторяется неудачное продление токена
+const tokenRetryInterval = 10 * time.Second
+
+// credential - токен сессии диспетчера, с которым соединение обращается к BroadcastUsecase после рукопожатия.
+// Соединение может жить дольше токена, а подписки и поток команд открываются позже, поэтому токен продлевается
+type credential struct {
+	dispatcherID int
+	session      atomic.Pointer[entity.SessionToken]
+}
+
+func newCredential(dispatcherID int, session *entity.SessionToken) *credential {
+	c := &credential{dispatcherID: dispatcherID}
+	c.session.Store(session)
+	return c
+}
+
+// String возвращает текущий токен
+func (c *credential) String() string {
+	return c.session.Load().Token
+}
+
+// refreshCredential продлевает токен соединения на середине срока его действия, пока соединение открыто.
+// Если диспетчера удалили, то токен не продлевается, и после его окончания новые подписки отклоняются
+func (v *DispatcherDelivery) refreshCredential(ctx context.Context, c *credential) {
+	for {
+		session := c.session.Load()
+		wait := time.Until(session.ExpiresAt) / 2
+		for {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			next, err := v.broadcastUsecase.LoginDispatcher(c.dispatcherID, session.Token)
+			if err == nil {
+				c.session.Store(next)
+				break
+			}
+			v.logger.Errorf("Не удалось продлить токен диспетчера %d: %s\n", c.dispatcherID, err)
+			if time.Until(session.ExpiresAt) <= tokenRetryInterval {
+				return
+			}
+			wait = tokenRetryInterval
+		}
+	}
+}
@@ -6,16 +6,38 @@ import (
 	"fmt"
 	"github.com/quic-go/quic-go"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	"self-driving-car-dispatch-system/internal/entity"
 	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/h264"
+	"self-driving-car-dispatch-system/pkg/protocol"
 	"sync"
 	"time"
 )
 
+// dispatcherCapabilities - возможности, которые сервер поддерживает для диспетчеров
+const dispatcherCapabilities = protocol.CapabilityCommands | protocol.CapabilityLease | protocol.CapabilityDatagramVideo |
+	protocol.CapabilityClock | protocol.CapabilityEvents | protocol.CapabilitySubscriptions | protocol.CapabilityFleet
+
+const (
+	// layerCheckInterval - как часто проверяется состояние канала до диспетчера для выбора слоя видео
+	layerCheckInterval = time.Second
+	// канал перегружен, если RTT или доля потерь не меньше этих значений: переходим на слой хуже
+	congestedRTT  = 300 * time.Millisecond
+	congestedLoss = 0.05
+	// канал свободен, если RTT и доля потерь не больше этих значений
+	clearRTT  = 150 * time.Millisecond
+	clearLoss = 0.01
+	// clearChecksToUpgrade - сколько проверок подряд канал должен быть свободен, чтобы перейти на слой лучше
+	clearChecksToUpgrade = 5
+)
+
 type DispatcherDelivery struct {
 	broadcastUsecase usecase.BroadcastUsecase
 	logger           *logrus.Logger
 	tlsConfig        *tls.Config
 	quicConfig       *quic.Config
+	links            *linkMonitor
 
 	wg     sync.WaitGroup
 	ctx    context.Context
@@ -34,11 +56,18 @@ func NewDispatcherDelivery(
 	quicConfig *quic.Config,
 ) *DispatcherDelivery {
 	ctx, cancel := context.WithCancel(context.Background())
+	// состояние канала до каждого диспетчера собирается трассировщиком QUIC и нужно для выбора слоя видео
+	links := &linkMonitor{}
+	quicConfig = quicConfig.Clone()
+	quicConfig.Tracer = links.tracer
+	// при переподключении диспетчер отправляет приветствие в 0-RTT
+	quicConfig.Allow0RTT = true
 	delivery := DispatcherDelivery{
 		broadcastUsecase: broadcastUsecase,
 		logger:           logger,
 		tlsConfig:        tlsConfig,
 		quicConfig:       quicConfig,
+		links:            links,
 
 		wg:     sync.WaitGroup{},
 		ctx:    ctx,
@@ -60,7 +89,7 @@ func NewDispatcherDelivery(
 }
 
 func (v *DispatcherDelivery) Start(addr string) error {
-	listener, err := quic.ListenAddr(addr, v.tlsConfig, v.quicConfig)
+	listener, err := quic.ListenAddrEarly(addr, v.tlsConfig, v.quicConfig)
 	if err != nil {
 		return err
 	}
@@ -90,88 +119,431 @@ func (v *DispatcherDelivery) Stop(timeout time.Duration) {
 }
 
 // HandleConnection обрабатывает входящие соединения для приёма с ТС
-func (v *DispatcherDelivery) handleConnection(conn quic.Connection) {
+func (v *DispatcherDelivery) handleConnection(conn quic.EarlyConnection) {
 	v.logger.Infoln("Новое соединение от диспетчера:", conn.RemoteAddr())
 	defer v.logger.Infoln("Соединение с диспетчером закрыто:", conn.RemoteAddr())
 	v.wg.Add(1)
 	defer v.wg.Done()
 
-	// Открываем поток для отправки информации о транспортном средстве диспетчеру
-	infoStream, err := conn.OpenStreamSync(context.Background())
+	// Диспетчер первым открывает управляющий поток и отправляет в нём приветствие
+	controlStream, hello, err := acceptHello(v.ctx, conn, protocol.RoleDispatcher)
 	if err != nil {
-		v.logger.Errorf("Ошибка при открытии потока с диспетчером %s: %s\n", conn.RemoteAddr(), err)
-		conn.CloseWithError(0, "Connection error")
+		v.logger.Errorf("Ошибка рукопожатия с диспетчером %s: %s\n", conn.RemoteAddr(), err)
 		return
 	}
-	defer infoStream.Close()
-	v.logger.Infof("Открыт infoStream с диспетчером %s\n", conn.RemoteAddr())
-
-	// Открываем поток для отправки видеотрансляции диспетчеру
-	videoStream, err := conn.OpenStreamSync(context.Background())
+	defer controlStream.Close()
+	// ID ТС, с которого диспетчер хочет получать данные
+	vehicleID := int(hello.VehicleID)
+	dispatcherID := int(hello.DispatcherID)
+	v.logger.Infof("Получены данные о диспетчере %d\n", dispatcherID)
+	if vehicleID == 0 && !hello.Capabilities.Has(protocol.CapabilitySubscriptions) {
+		rejectHello(conn, controlStream, protocol.CodeMalformed, "vehicle ID is required without subscriptions")
+		return
+	}
+	// Пароль или токен из приветствия проверяется один раз, дальше соединение использует выданный токен
+	session, err := v.broadcastUsecase.LoginDispatcher(dispatcherID, hello.Secret)
+	if err == nil {
+		err = v.broadcastUsecase.AuthDispatcher(vehicleID, dispatcherID, session.Token)
+	}
 	if err != nil {
-		v.logger.Errorf("Ошибка при открытии видеопотока с диспетчером %s: %s\n", conn.RemoteAddr(), err)
+		v.logger.Errorf("Диспетчер %d не прошёл аутентификацию: %s\n", dispatcherID, err)
+		rejectHello(conn, controlStream, rejectCode(err), "")
+		return
+	}
+	capabilities := hello.Capabilities & dispatcherCapabilities
+	if !conn.ConnectionState().SupportsDatagrams {
+		capabilities &^= protocol.CapabilityDatagramVideo
+	}
+	// Без ТС в приветствии диспетчер получает только потоки подписок, поэтому возможности ТС ему не нужны
+	var cameras []string
+	if vehicleID == 0 {
+		capabilities &^= protocol.CapabilityCommands | protocol.CapabilityLease | protocol.CapabilityEvents | protocol.CapabilityDatagramVideo
+	} else {
+		cameras = selectCameras(v.broadcastUsecase.GetVideoCameras(vehicleID), hello.Cameras)
+	}
+	if err = acceptHelloReply(controlStream, capabilities, hello.Encoding, cameras, session.Token); err != nil {
+		v.logger.Errorf("Ошибка при отправке ответа диспетчеру %s: %s\n", conn.RemoteAddr(), err)
 		conn.CloseWithError(0, "Connection error")
 		return
 	}
-	defer videoStream.Close()
-	v.logger.Infof("Открыт videoStream с диспетчером %s\n", conn.RemoteAddr())
 
-	data, err := conn.ReceiveDatagram(v.ctx)
+	// Открываем поток для отправки информации о транспортном средстве диспетчеру
+	var infoStream quic.Stream
+	if vehicleID != 0 {
+		infoStream, err = conn.OpenStreamSync(v.ctx)
+		if err != nil {
+			v.logger.Errorf("Ошибка при открытии потока с диспетчером %s: %s\n", conn.RemoteAddr(), err)
+			conn.CloseWithError(0, "Connection error")
+			return
+		}
+		defer infoStream.Close()
+		v.logger.Infof("Открыт infoStream с диспетчером %s\n", conn.RemoteAddr())
+	}
+
+	// Открываем потоки для отправки видеотрансляции диспетчеру, по одному на каждую камеру из ответа на приветствие.
+	// Если видео передаётся в datagram, то потоки не нужны
+	datagramVideo := capabilities.Has(protocol.CapabilityDatagramVideo)
+	videoStreams := make([]quic.Stream, len(cameras))
+	for i, camera := range cameras {
+		if datagramVideo {
+			continue
+		}
+		videoStreams[i], err = conn.OpenStreamSync(v.ctx)
+		if err != nil {
+			v.logger.Errorf("Ошибка при открытии видеопотока с диспетчером %s: %s\n", conn.RemoteAddr(), err)
+			conn.CloseWithError(0, "Connection error")
+			return
+		}
+		defer videoStreams[i].Close()
+		v.logger.Infof("Открыт videoStream камеры %s с диспетчером %s\n", camera, conn.RemoteAddr())
+	}
+
+	// Открываем поток аренды управления ТС: сервер сообщает в нём, кто управляет ТС, а диспетчер запрашивает управление
+	var leaseStream quic.Stream
+	if capabilities.Has(protocol.CapabilityLease) {
+		leaseStream, err = conn.OpenStreamSync(v.ctx)
+		if err != nil {
+			v.logger.Errorf("Ошибка при открытии потока аренды управления с диспетчером %s: %s\n", conn.RemoteAddr(), err)
+			conn.CloseWithError(0, "Connection error")
+			return
+		}
+		defer leaseStream.Close()
+		v.logger.Infof("Открыт leaseStream с диспетчером %s\n", conn.RemoteAddr())
+	}
+
+	// Открываем поток событий: сервер сообщает в нём, что из потоков ТС перестали приходить данные
+	var eventStream quic.Stream
+	if capabilities.Has(protocol.CapabilityEvents) {
+		eventStream, err = conn.OpenStreamSync(v.ctx)
+		if err != nil {
+			v.logger.Errorf("Ошибка при открытии потока событий с диспетчером %s: %s\n", conn.RemoteAddr(), err)
+			conn.CloseWithError(0, "Connection error")
+			return
+		}
+		defer eventStream.Close()
+		v.logger.Infof("Открыт eventStream с диспетчером %s\n", conn.RemoteAddr())
+	}
+
+	// Открываем поток обзора парка: сервер сообщает в нём, какие ТС подключены и кто с ними работает
+	var fleetStream quic.Stream
+	if capabilities.Has(protocol.CapabilityFleet) {
+		fleetStream, err = conn.OpenStreamSync(v.ctx)
+		if err != nil {
+			v.logger.Errorf("Ошибка при открытии потока обзора парка с диспетчером %s: %s\n", conn.RemoteAddr(), err)
+			conn.CloseWithError(0, "Connection error")
+			return
+		}
+		defer fleetStream.Close()
+		v.logger.Infof("Открыт fleetStream с диспетчером %s\n", conn.RemoteAddr())
+	}
+
+	// Принимаем поток синхронизации часов. Диспетчер открывает его раньше потока команд,
+	// поэтому поток команд по-прежнему принимается после него
+	var clockStream quic.Stream
+	if capabilities.Has(protocol.CapabilityClock) {
+		clockStream, err = conn.AcceptStream(v.ctx)
+		if err != nil {
+			v.logger.Errorf("Ошибка при открытии потока синхронизации часов с диспетчером %s: %s\n", conn.RemoteAddr(), err)
+			conn.CloseWithError(0, "Connection error")
+			return
+		}
+		defer clockStream.Close()
+		v.logger.Infof("Открыт clockStream с диспетчером %s\n", conn.RemoteAddr())
+	}
+
+	// контекст соединения отменяется при его закрытии, вместе с ним диспетчер отписывается от трансляции ТС
+	ctx := conn.Context()
+	infoChan := make(chan []byte, 100) // буферизированный канал для передачи информации о ТС
+	errChan := make(chan error)        // канал для передачи ошибок
+	cred := newCredential(dispatcherID, session)
+	go v.refreshCredential(ctx, cred)
+	go v.broadcastUsecase.DispatcherSession(ctx, vehicleID, dispatcherID, cred.String(), errChan)
+	if infoStream != nil {
+		v.logger.Infof("Отправка информации о ТС %d диспетчеру %d\n", vehicleID, dispatcherID)
+		go v.sendFrames(ctx, infoStream, infoChan)
+		go v.broadcastUsecase.GetInfoStream(ctx, vehicleID, dispatcherID, cred.String(), hello.Encoding, infoChan, errChan)
+	}
+	layers := newLayerSwitch() // выбор слоя качества видео всех камер, в том числе по подпискам
+	for i, camera := range cameras {
+		videoChan := make(chan []byte) // без буфера: кадры копятся и отбрасываются в очереди подписки на трансляцию
+		layerChan := layers.add(vehicleID, camera)
+		if datagramVideo {
+			go v.sendDatagrams(ctx, conn, uint8(i), videoChan)
+		} else {
+			go v.sendStream(ctx, videoStreams[i], videoChan, errChan)
+		}
+		go v.broadcastUsecase.GetVideoStream(ctx, vehicleID, dispatcherID, cred.String(), camera, layerChan, videoChan, errChan)
+	}
+	go v.selectVideoLayer(ctx, conn, dispatcherID, layers)
+	// Приветствие могло прийти в 0-RTT, а данные 0-RTT злоумышленник может повторить. Трансляция повтором
+	// ничего не даёт, а команды и аренду управления принимаем только после завершения рукопожатия
+	select {
+	case <-conn.HandshakeComplete():
+	case <-v.ctx.Done():
+		conn.CloseWithError(0, "Connection closed")
+		return
+	}
+	if capabilities.Has(protocol.CapabilitySubscriptions) {
+		go v.handleSubscriptions(ctx, conn, controlStream, dispatcherID, cred, hello.Encoding, layers, errChan)
+	}
+	if capabilities.Has(protocol.CapabilityCommands) {
+		go v.acceptCommandStream(ctx, conn, vehicleID, dispatcherID, cred, errChan)
+	}
+	if leaseStream != nil {
+		go v.handleLeaseStream(ctx, leaseStream, vehicleID, dispatcherID, cred.String(), errChan)
+	}
+	if eventStream != nil {
+		eventChan := make(chan entity.StreamEvent, 10)
+		go writeMessages(ctx, eventStream, eventChan, errChan)
+		go v.broadcastUsecase.GetEventStream(ctx, vehicleID, dispatcherID, cred.String(), eventChan, errChan)
+	}
+	if fleetStream != nil {
+		fleetChan := make(chan entity.FleetStatus, 1)
+		go writeMessages(ctx, fleetStream, fleetChan, errChan)
+		go v.broadcastUsecase.GetFleetStream(ctx, dispatcherID, cred.String(), fleetChan, errChan)
+	}
+	if clockStream != nil {
+		go serveClock(ctx, clockStream, errChan)
+	}
+	select {
+	case err := <-errChan:
+		if err != nil {
+			v.logger.Errorf("Ошибка при трансляции данных диспетчеру %d: %s\n", dispatcherID, err)
+		}
+		conn.CloseWithError(0, fmt.Sprintf("Connection error: %s", err))
+	case <-ctx.Done():
+		v.logger.Infof("Диспетчер %d отключился\n", dispatcherID)
+	case <-v.ctx.Done():
+		conn.CloseWithError(0, "Connection closed")
+	}
+}
+
+// selectCameras возвращает запрошенные камеры, с которых сейчас ведётся трансляция, или все, если диспетчер не запросил камеры
+func selectCameras(available, requested []string) []string {
+	if len(requested) == 0 {
+		return available
+	}
+	return slices.DeleteFunc(slices.Clone(requested), func(camera string) bool {
+		return !slices.Contains(available, camera)
+	})
+}
+
+// videoSource - камера ТС, видео с которой получает диспетчер
+type videoSource struct {
+	vehicleID int
+	camera    string
+}
+
+// layerSwitch передаёт выбранный слой качества видео всем видеопотокам соединения, в том числе открытым по подпискам
+type layerSwitch struct {
+	mu      sync.Mutex
+	layer   int
+	sources map[chan int]videoSource
+}
+
+func newLayerSwitch() *layerSwitch {
+	return &layerSwitch{sources: make(map[chan int]videoSource)}
+}
+
+// add возвращает канал, в который передаётся номер слоя для видео с камеры ТС. Если слой уже сменился,
+// то его номер сразу есть в канале
+func (s *layerSwitch) add(vehicleID int, camera string) chan int {
+	layerChan := make(chan int, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources[layerChan] = videoSource{vehicleID: vehicleID, camera: camera}
+	if s.layer != 0 {
+		layerChan <- s.layer
+	}
+	return layerChan
+}
+
+func (s *layerSwitch) remove(layerChan chan int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sources, layerChan)
+}
+
+// cameras возвращает камеры, видео с которых получает диспетчер
+func (s *layerSwitch) cameras() []videoSource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sources := make([]videoSource, 0, len(s.sources))
+	for _, source := range s.sources {
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// set передаёт номер слоя во все каналы. Получателю нужен только последний номер, поэтому непрочитанный заменяется
+func (s *layerSwitch) set(layer int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.layer = layer
+	for layerChan := range s.sources {
+		select {
+		case <-layerChan:
+		default:
+		}
+		layerChan <- layer
+	}
+}
+
+// selectVideoLayer выбирает слой качества видео по состоянию канала до диспетчера: при перегрузке сразу
+// переходит на слой хуже, а на слой лучше - только после нескольких проверок подряд без перегрузки.
+// Канал общий для всех камер всех ТС, поэтому слой меняется для всех камер сразу
+func (v *DispatcherDelivery) selectVideoLayer(ctx context.Context, conn quic.Connection, dispatcherID int, layers *layerSwitch) {
+	l := v.links.get(conn)
+	if l == nil {
+		// без статистики соединения диспетчер получает лучший слой
+		return
+	}
+	ticker := time.NewTicker(layerCheckInterval)
+	defer ticker.Stop()
+	layer, clearChecks := 0, 0
+	prev := l.snapshot()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		stats := l.snapshot()
+		loss := stats.lossRate(prev)
+		prev = stats
+		maxLayer := 0
+		for _, source := range layers.cameras() {
+			maxLayer = max(maxLayer, v.broadcastUsecase.GetVideoLayers(source.vehicleID, source.camera)-1)
+		}
+		next := min(layer, maxLayer)
+		switch {
+		case stats.smoothedRTT >= congestedRTT || loss >= congestedLoss:
+			clearChecks = 0
+			next = min(next+1, maxLayer)
+		case stats.smoothedRTT <= clearRTT && loss <= clearLoss:
+			if clearChecks++; clearChecks >= clearChecksToUpgrade && next > 0 {
+				clearChecks = 0
+				next--
+			}
+		default:
+			clearChecks = 0
+		}
+		if next == layer {
+			continue
+		}
+		v.logger.Infof("Диспетчер %d переключается на слой видео %d: RTT %s, потери %.1f%%, cwnd %d, в пути %d байт\n",
+			dispatcherID, next, stats.smoothedRTT, loss*100, stats.cwnd, stats.bytesInFlight)
+		layer = next
+		layers.set(layer)
+	}
+}
+
+// acceptCommandStream принимает поток команд телеуправления. Диспетчер открывает его при отправке первой команды,
+// поэтому ожидание потока не блокирует трансляцию
+func (v *DispatcherDelivery) acceptCommandStream(ctx context.Context, conn quic.Connection, vehicleID, dispatcherID int, cred *credential, errChan chan error) {
+	commandStream, err := conn.AcceptStream(ctx)
 	if err != nil {
-		v.logger.Errorf("Ошибка при получении данных от диспетчера %s: %s\n", conn.RemoteAddr(), err)
-		conn.CloseWithError(0, "Connection error")
+		if ctx.Err() == nil {
+			v.logger.Errorf("Ошибка при открытии потока команд с диспетчером %s: %s\n", conn.RemoteAddr(), err)
+		}
 		return
 	}
-	if len(data) < 8 {
-		v.logger.Errorf("Некорректные данные от диспетчера %s\n", conn.RemoteAddr())
-	}
-
-	// в первых четырех байтах содержится ID ТС, с которого хотим получать данные
-	vehicleID := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
-	// в последующих четырех байтах содержится ID диспетчера, который хочет получать данные
-	dispatcherID := int(data[4])<<24 | int(data[5])<<16 | int(data[6])<<8 | int(data[7])
-	// последующие до n - ключ доступа в UTF-8
-	secret := string(data[8:])
-	v.logger.Infof("Получены данные о диспетчере %d %s\n", dispatcherID, secret)
-
-	infoChan := make(chan []byte, 100)  // буферизированный канал для передачи информации о ТС
-	videoChan := make(chan []byte, 100) // буферизированный канал для передачи видеопотока
-	errChan := make(chan error)         // канал для передачи ошибок
-	v.logger.Infof("Отправка информации о ТС %d диспетчеру %d\n", vehicleID, dispatcherID)
-	go v.sendStream(infoStream, infoChan)
-	go v.sendStream(videoStream, videoChan)
-	go v.broadcastUsecase.GetInfoStream(vehicleID, dispatcherID, secret, infoChan, errChan)
-	go v.broadcastUsecase.GetVideoStream(vehicleID, dispatcherID, secret, videoChan, errChan)
+	v.logger.Infof("Открыт commandStream с диспетчером %s\n", conn.RemoteAddr())
+	v.handleCommandStream(ctx, commandStream, vehicleID, dispatcherID, cred.String(), errChan)
+}
+
+// handleCommandStream передает команды телеуправления от диспетчера, в ответ в тот же поток передаются подтверждения от ТС
+func (v *DispatcherDelivery) handleCommandStream(ctx context.Context, commandStream quic.Stream, vehicleID, dispatcherID int, secret string, errChan chan error) {
+	defer commandStream.Close()
+	commandChan := make(chan entity.Command, 100)
+	ackChan := make(chan entity.CommandAck, 100)
+	go readMessages(ctx, commandStream, commandChan, errChan)
+	go writeMessages(ctx, commandStream, ackChan, errChan)
+	v.broadcastUsecase.SendCommandStream(ctx, vehicleID, dispatcherID, secret, commandChan, ackChan, errChan)
+}
+
+// handleLeaseStream передает запросы диспетчера на управление ТС, в ответ в тот же поток передается состояние аренды
+func (v *DispatcherDelivery) handleLeaseStream(ctx context.Context, leaseStream quic.Stream, vehicleID, dispatcherID int, secret string, errChan chan error) {
+	requestChan := make(chan entity.LeaseRequest, 10)
+	stateChan := make(chan entity.LeaseState, 10)
+	go readMessages(ctx, leaseStream, requestChan, errChan)
+	go writeMessages(ctx, leaseStream, stateChan, errChan)
+	v.broadcastUsecase.LeaseStream(ctx, vehicleID, dispatcherID, secret, requestChan, stateChan, errChan)
+}
+
+// sendStream записывает видео из stream в поток QUIC. Ошибка записи передаётся в errChan и закрывает соединение:
+// иначе сервис трансляции ждал бы, пока кадр из stream кто-нибудь заберёт
+func (v *DispatcherDelivery) sendStream(ctx context.Context, quicStream quic.Stream, stream chan []byte, errChan chan error) {
 	for {
 		select {
-		case err := <-errChan:
+		case data := <-stream:
+			n, err := quicStream.Write(data)
 			if err != nil {
-				v.logger.Errorf("Ошибка при трансляции данных диспетчеру %d: %s\n", vehicleID, err)
+				if ctx.Err() == nil {
+					v.logger.Errorf("Ошибка при отправке данных в поток: %s\n", err)
+					select {
+					case errChan <- err:
+					case <-ctx.Done():
+					}
+				}
+				return
 			}
-			conn.CloseWithError(0, fmt.Sprintf("Connection error: %s", err))
-			close(infoChan)
-			close(videoChan)
+			v.logger.Debugf("Отправлено %d байт в %d\n", n, quicStream.StreamID())
+		case <-ctx.Done():
 			return
-		case <-v.ctx.Done():
-			conn.CloseWithError(0, "Connection closed")
-			close(infoChan)
-			close(videoChan)
+		}
+	}
+}
+
+// datagramErrorLogInterval - раз во сколько пропущенных кадров повторяется сообщение об ошибке отправки datagram
+const datagramErrorLogInterval = 100
+
+// sendDatagrams разбивает каждый кадр видео из stream на пакеты и отправляет их в datagram.
+// Потерянные пакеты не передаются повторно: диспетчер пропускает неполные кадры до следующего ключевого.
+// Ошибка отправки пакета, например, слишком большой datagram после уменьшения MTU, приводит к пропуску кадра,
+// а не к остановке трансляции. Трансляция заканчивается только вместе с соединением
+func (v *DispatcherDelivery) sendDatagrams(ctx context.Context, conn quic.Connection, camera uint8, stream chan []byte) {
+	packetizer := protocol.NewPacketizer(camera)
+	var skipped uint64
+	for {
+		select {
+		case frame := <-stream:
+			au := h264.AccessUnit{NALs: h264.Split(frame)}
+			var err error
+			for _, packet := range packetizer.Packetize(frame, protocol.VideoTimestamp(time.Now()), au.IsKeyframe()) {
+				// остальные пакеты кадра без этого пакета диспетчеру не нужны
+				if err = conn.SendDatagram(packet.Marshal()); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				skipped++
+				if skipped%datagramErrorLogInterval == 1 {
+					v.logger.Warnf("Кадр камеры %d не отправлен диспетчеру %s, всего пропущено %d: %s\n", camera, conn.RemoteAddr(), skipped, err)
+				}
+				continue
+			}
+			v.logger.Debugf("Отправлен кадр из %d байт в datagram камеры %d\n", len(frame), camera)
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (v *DispatcherDelivery) sendStream(quicStream quic.Stream, stream chan []byte) {
+// sendFrames отправляет каждое сообщение из stream отдельным кадром
+func (v *DispatcherDelivery) sendFrames(ctx context.Context, quicStream quic.Stream, stream chan []byte) {
 	for {
 		select {
 		case data := <-stream:
-			n, err := quicStream.Write(data)
-			if err != nil {
-				v.logger.Errorf("Ошибка при отправке данных в поток: %s\n", err)
+			if err := protocol.WriteFrame(quicStream, data); err != nil {
+				v.logger.Errorf("Ошибка при отправке кадра в поток: %s\n", err)
 				return
 			}
-			v.logger.Debugf("Отправлено %d байт в %d\n", n, quicStream.StreamID())
-		case <-v.ctx.Done():
+			v.logger.Debugf("Отправлен кадр из %d байт в %d\n", len(data), quicStream.StreamID())
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -0,0 +1,105 @@
+package http3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"time"
+)
+
+// helloTimeout - время, за которое клиент должен открыть управляющий поток и отправить приветствие
+const helloTimeout = 5 * time.Second
+
+// acceptHello принимает управляющий поток, который клиент открывает первым, и читает из него приветствие.
+// Если приветствие некорректно, то клиенту отправляется отказ, соединение закрывается и возвращается ошибка
+func acceptHello(ctx context.Context, conn quic.Connection, role protocol.Role) (quic.Stream, *protocol.Hello, error) {
+	acceptCtx, cancel := context.WithTimeout(ctx, helloTimeout)
+	defer cancel()
+	controlStream, err := conn.AcceptStream(acceptCtx)
+	if err != nil {
+		conn.CloseWithError(0, "Handshake timeout")
+		return nil, nil, err
+	}
+	if err = controlStream.SetReadDeadline(time.Now().Add(helloTimeout)); err != nil {
+		conn.CloseWithError(0, "Handshake error")
+		return nil, nil, err
+	}
+	hello, err := protocol.ReadHello(controlStream)
+	switch {
+	case err == nil:
+	case errors.Is(err, protocol.ErrUnsupportedVersion):
+		rejectHello(conn, controlStream, protocol.CodeUnsupportedVersion, fmt.Sprintf("server supports version %d", protocol.Version))
+		return nil, nil, err
+	case errors.Is(err, protocol.ErrMalformed), errors.Is(err, protocol.ErrBadMagic):
+		rejectHello(conn, controlStream, protocol.CodeMalformed, "")
+		return nil, nil, err
+	default:
+		conn.CloseWithError(0, "Handshake error")
+		return nil, nil, err
+	}
+	if hello.Role != role {
+		rejectHello(conn, controlStream, protocol.CodeWrongRole, "")
+		return nil, nil, errors.New("wrong role")
+	}
+	if role == protocol.RoleVehicle && len(hello.Cameras) == 0 {
+		rejectHello(conn, controlStream, protocol.CodeMalformed, "vehicle must announce at least one camera")
+		return nil, nil, errors.New("no cameras")
+	}
+	if role == protocol.RoleVehicle && (hello.Layers == 0 || hello.Layers > protocol.MaxLayers) {
+		rejectHello(conn, controlStream, protocol.CodeMalformed, fmt.Sprintf("vehicle must send from 1 to %d video layers", protocol.MaxLayers))
+		return nil, nil, fmt.Errorf("invalid number of video layers %d", hello.Layers)
+	}
+	if !hello.Encoding.IsValid() {
+		rejectHello(conn, controlStream, protocol.CodeUnsupportedEncoding, "")
+		return nil, nil, fmt.Errorf("unsupported encoding %d", hello.Encoding)
+	}
+	if err = controlStream.SetReadDeadline(time.Time{}); err != nil {
+		conn.CloseWithError(0, "Handshake error")
+		return nil, nil, err
+	}
+	return controlStream, hello, nil
+}
+
+// acceptHelloReply подтверждает подключение и сообщает клиенту согласованные возможности, формат телеметрии, камеры
+// и токен сессии для переподключения
+func acceptHelloReply(controlStream quic.Stream, capabilities protocol.Capability, encoding protocol.Encoding, cameras []string, token string) error {
+	return protocol.WriteHelloReply(controlStream, &protocol.HelloReply{
+		Version:      protocol.Version,
+		Code:         protocol.CodeOK,
+		Capabilities: capabilities,
+		Encoding:     encoding,
+		Cameras:      cameras,
+		Token:        token,
+	})
+}
+
+// rejectHello отклоняет подключение с кодом ошибки, который клиент может показать пользователю, и закрывает соединение.
+// Соединение закрывается после того, как клиент прочитает ответ, но не позже чем через helloTimeout
+func rejectHello(conn quic.Connection, controlStream quic.Stream, code protocol.ReplyCode, message string) {
+	_ = protocol.WriteHelloReply(controlStream, &protocol.HelloReply{
+		Version: protocol.Version,
+		Code:    code,
+		Message: message,
+	})
+	_ = controlStream.Close()
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(helloTimeout):
+	}
+	conn.CloseWithError(0, fmt.Sprintf("Handshake rejected: %s", code))
+}
+
+// rejectCode возвращает код отказа для ошибки аутентификации
+func rejectCode(err error) protocol.ReplyCode {
+	switch {
+	case errors.Is(err, usecase.ErrInternal):
+		return protocol.CodeInternal
+	case errors.Is(err, usecase.ErrVehicleInactive):
+		return protocol.CodeVehicleInactive
+	default:
+		return protocol.CodeAuthFailed
+	}
+}
@@ -0,0 +1,92 @@
+package http3
+
+import (
+	"context"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+	"sync"
+	"time"
+)
+
+// linkStats - состояние канала до клиента по данным контроля перегрузки QUIC
+type linkStats struct {
+	smoothedRTT   time.Duration
+	cwnd          logging.ByteCount
+	bytesInFlight logging.ByteCount
+	// sent и lost - число отправленных и потерянных пакетов за всё время соединения
+	sent uint64
+	lost uint64
+}
+
+// lossRate возвращает долю потерянных пакетов с момента prev
+func (s linkStats) lossRate(prev linkStats) float64 {
+	sent := s.sent - prev.sent
+	if sent == 0 {
+		return 0
+	}
+	return float64(s.lost-prev.lost) / float64(sent)
+}
+
+// link собирает linkStats одного соединения. Трассировщик вызывается из горутины соединения, поэтому нужна блокировка
+type link struct {
+	mu    sync.Mutex
+	stats linkStats
+}
+
+func (l *link) snapshot() linkStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// linkMonitor собирает состояние каналов всех соединений сервера через трассировщик QUIC,
+// так как quic.Connection не отдаёт статистику напрямую
+type linkMonitor struct {
+	// links хранит *link, ключ - quic.ConnectionTracingID
+	links sync.Map
+}
+
+// tracer подходит для quic.Config.Tracer
+func (m *linkMonitor) tracer(ctx context.Context, _ logging.Perspective, _ quic.ConnectionID) *logging.ConnectionTracer {
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	if !ok {
+		return nil
+	}
+	l := &link{}
+	m.links.Store(id, l)
+	return &logging.ConnectionTracer{
+		SentShortHeaderPacket: func(*logging.ShortHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			l.mu.Lock()
+			l.stats.sent++
+			l.mu.Unlock()
+		},
+		LostPacket: func(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+			l.mu.Lock()
+			l.stats.lost++
+			l.mu.Unlock()
+		},
+		UpdatedMetrics: func(rttStats *logging.RTTStats, cwnd, bytesInFlight logging.ByteCount, _ int) {
+			l.mu.Lock()
+			l.stats.smoothedRTT = rttStats.SmoothedRTT()
+			l.stats.cwnd = cwnd
+			l.stats.bytesInFlight = bytesInFlight
+			l.mu.Unlock()
+		},
+		Close: func() {
+			m.links.Delete(id)
+		},
+	}
+}
+
+// get возвращает канал соединения или nil, если трассировщик для него не вызывался
+func (m *linkMonitor) get(conn quic.Connection) *link {
+	id, ok := conn.Context().Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	if !ok {
+		return nil
+	}
+	l, ok := m.links.Load(id)
+	if !ok {
+		return nil
+	}
+	return l.(*link)
+}
@@ -0,0 +1,55 @@
+package http3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+)
+
+// Служебные потоки (команды, подтверждения и т.п.) передают последовательность JSON-сообщений
+
+// readMessages читает JSON-сообщения из потока и передает их в messages. По окончании потока канал закрывается
+func readMessages[T any](ctx context.Context, stream io.Reader, messages chan T, errChan chan error) {
+	defer close(messages)
+	decoder := json.NewDecoder(stream)
+	for {
+		var message T
+		if err := decoder.Decode(&message); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+				select {
+				case errChan <- err:
+				case <-ctx.Done():
+				}
+			}
+			return
+		}
+		select {
+		case messages <- message:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeMessages записывает сообщения из messages в поток в формате JSON
+func writeMessages[T any](ctx context.Context, stream io.Writer, messages chan T, errChan chan error) {
+	encoder := json.NewEncoder(stream)
+	for {
+		select {
+		case message := <-messages:
+			if err := encoder.Encode(message); err != nil {
+				if ctx.Err() == nil {
+					select {
+					case errChan <- err:
+					case <-ctx.Done():
+					}
+				}
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
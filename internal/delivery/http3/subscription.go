@@ -0,0 +1,171 @@
+package http3
+
+import (
+	"context"
+	"errors"
+	"github.com/quic-go/quic-go"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/protocol"
+)
+
+// maxSubscriptions ограничивает число ТС, на которые диспетчер может подписаться в одном соединении
+const maxSubscriptions = 32
+
+// subscription - подписка диспетчера на ТС через управляющий поток
+type subscription struct {
+	vehicleID int
+	cancel    context.CancelFunc
+	// subscribed - сервер открыл потоки подписки и сообщил об этом диспетчеру
+	subscribed bool
+	err        error
+}
+
+// handleSubscriptions обрабатывает запросы подписки на ТС из управляющего потока и сообщает в нём же состояние подписок.
+// Доступ к каждому ТС проверяется по Grants диспетчера при подписке. Подписки заканчиваются вместе с соединением
+func (v *DispatcherDelivery) handleSubscriptions(ctx context.Context, conn quic.Connection, controlStream quic.Stream, dispatcherID int, cred *credential, encoding protocol.Encoding, layers *layerSwitch, errChan chan error) {
+	requests := make(chan entity.SubscriptionRequest, 10)
+	states := make(chan entity.SubscriptionState, 10)
+	go readMessages(ctx, controlStream, requests, errChan)
+	go writeMessages(ctx, controlStream, states, errChan)
+	sendState := func(state entity.SubscriptionState) {
+		select {
+		case states <- state:
+		case <-ctx.Done():
+		}
+	}
+
+	subscriptions := make(map[int]*subscription)
+	ended := make(chan *subscription)
+	for {
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				// диспетчер больше не будет менять подписки, оформленные продолжают работать
+				requests = nil
+				continue
+			}
+			switch reason := rejectReason(subscriptions, request); {
+			case reason != "":
+				sendState(entity.SubscriptionState{VehicleID: request.VehicleID, Status: entity.SubscriptionRejected, Error: reason})
+			case request.Action == entity.UnsubscribeAction:
+				subscriptions[request.VehicleID].cancel()
+				delete(subscriptions, request.VehicleID)
+				v.logger.Infof("Диспетчер %d отписался от ТС %d\n", dispatcherID, request.VehicleID)
+				sendState(entity.SubscriptionState{VehicleID: request.VehicleID, Status: entity.Unsubscribed})
+			default:
+				subCtx, cancel := context.WithCancel(ctx)
+				sub := &subscription{vehicleID: request.VehicleID, cancel: cancel}
+				subscriptions[request.VehicleID] = sub
+				go func() {
+					sub.subscribed, sub.err = v.subscribe(subCtx, conn, request, dispatcherID, cred.String(), encoding, layers, sendState)
+					select {
+					case ended <- sub:
+					case <-ctx.Done():
+					}
+				}()
+			}
+		case sub := <-ended:
+			sub.cancel()
+			if subscriptions[sub.vehicleID] != sub {
+				// подписку отменил диспетчер
+				continue
+			}
+			delete(subscriptions, sub.vehicleID)
+			status := entity.SubscriptionClosed
+			if !sub.subscribed {
+				status = entity.SubscriptionRejected
+			}
+			state := entity.SubscriptionState{VehicleID: sub.vehicleID, Status: status}
+			if sub.err != nil {
+				state.Error = sub.err.Error()
+			}
+			v.logger.Infof("Подписка диспетчера %d на ТС %d закончилась: %s\n", dispatcherID, sub.vehicleID, state.Error)
+			sendState(state)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rejectReason возвращает причину отказа в запросе подписки при оформленных подписках subscriptions
+// или пустую строку, если запрос можно выполнить
+func rejectReason(subscriptions map[int]*subscription, request entity.SubscriptionRequest) string {
+	_, subscribed := subscriptions[request.VehicleID]
+	switch {
+	case !entity.IsSubscriptionActionValid(request.Action):
+		return "unknown action"
+	case request.Action == entity.UnsubscribeAction && !subscribed:
+		return "not subscribed"
+	case request.Action == entity.UnsubscribeAction:
+		return ""
+	case subscribed:
+		return "already subscribed"
+	case len(subscriptions) >= maxSubscriptions:
+		return "too many subscriptions"
+	default:
+		return ""
+	}
+}
+
+// subscribe открывает потоки подписки на ТС и передаёт в них телеметрию и видео, пока подписку не отменят
+// или не закончится трансляция ТС. subscribed сообщает, что потоки были открыты, а диспетчер получил Subscribed
+func (v *DispatcherDelivery) subscribe(ctx context.Context, conn quic.Connection, request entity.SubscriptionRequest, dispatcherID int, secret string, encoding protocol.Encoding, layers *layerSwitch, sendState func(entity.SubscriptionState)) (subscribed bool, err error) {
+	vehicleID := request.VehicleID
+	// ТС 0 означает соединение без ТС, подписаться на него нельзя
+	if vehicleID <= 0 || !protocol.IsCameraListValid(request.Cameras) {
+		return false, usecase.ErrBadRequest
+	}
+	if err = v.broadcastUsecase.AuthDispatcher(vehicleID, dispatcherID, secret); err != nil {
+		return false, err
+	}
+	cameras := selectCameras(v.broadcastUsecase.GetVideoCameras(vehicleID), request.Cameras)
+
+	infoStream, err := openSubscriptionStream(ctx, conn, &protocol.StreamHeader{Kind: protocol.StreamInfo, VehicleID: uint32(vehicleID)})
+	if err != nil {
+		return false, err
+	}
+	defer infoStream.Close()
+	videoStreams := make([]quic.Stream, len(cameras))
+	for i, camera := range cameras {
+		videoStreams[i], err = openSubscriptionStream(ctx, conn, &protocol.StreamHeader{Kind: protocol.StreamVideo, VehicleID: uint32(vehicleID), Camera: camera})
+		if err != nil {
+			return false, err
+		}
+		defer videoStreams[i].Close()
+	}
+	v.logger.Infof("Диспетчер %d подписался на ТС %d, камеры: %v\n", dispatcherID, vehicleID, cameras)
+	sendState(entity.SubscriptionState{VehicleID: vehicleID, Status: entity.Subscribed, Cameras: cameras})
+
+	subErrChan := make(chan error)
+	infoChan := make(chan []byte, 100)
+	go v.sendFrames(ctx, infoStream, infoChan)
+	go v.broadcastUsecase.GetInfoStream(ctx, vehicleID, dispatcherID, secret, encoding, infoChan, subErrChan)
+	for i, camera := range cameras {
+		videoChan := make(chan []byte)
+		layerChan := layers.add(vehicleID, camera)
+		defer layers.remove(layerChan)
+		go v.sendStream(ctx, videoStreams[i], videoChan, subErrChan)
+		go v.broadcastUsecase.GetVideoStream(ctx, vehicleID, dispatcherID, secret, camera, layerChan, videoChan, subErrChan)
+	}
+	select {
+	case err = <-subErrChan:
+		return true, err
+	case <-ctx.Done():
+		return true, nil
+	}
+}
+
+// openSubscriptionStream открывает поток подписки и отправляет в нём заголовок, по которому диспетчер узнает,
+// к какому ТС и камере относится поток
+func openSubscriptionStream(ctx context.Context, conn quic.Connection, header *protocol.StreamHeader) (quic.Stream, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = protocol.WriteStreamHeader(stream, header); err != nil {
+		stream.CancelWrite(0)
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return stream, nil
+}
@@ -0,0 +1,47 @@
+package http3
+
+import (
+	"testing"
+
+	"self-driving-car-dispatch-system/internal/entity"
+)
+
+func TestRejectReason(t *testing.T) {
+	// subscribed возвращает оформленные подписки на ТС с ID от 1 до n
+	subscribed := func(n int) map[int]*subscription {
+		subscriptions := make(map[int]*subscription, n)
+		for id := 1; id <= n; id++ {
+			subscriptions[id] = &subscription{vehicleID: id}
+		}
+		return subscriptions
+	}
+	subscribe := func(id int) entity.SubscriptionRequest {
+		return entity.SubscriptionRequest{VehicleID: id, Action: entity.SubscribeAction}
+	}
+	unsubscribe := func(id int) entity.SubscriptionRequest {
+		return entity.SubscriptionRequest{VehicleID: id, Action: entity.UnsubscribeAction}
+	}
+
+	tests := []struct {
+		name          string
+		subscriptions map[int]*subscription
+		request       entity.SubscriptionRequest
+		want          string
+	}{
+		{name: "first subscription", subscriptions: subscribed(0), request: subscribe(1), want: ""},
+		{name: "below limit", subscriptions: subscribed(maxSubscriptions - 1), request: subscribe(maxSubscriptions), want: ""},
+		{name: "at limit", subscriptions: subscribed(maxSubscriptions), request: subscribe(maxSubscriptions + 1), want: "too many subscriptions"},
+		{name: "already subscribed at limit", subscriptions: subscribed(maxSubscriptions), request: subscribe(1), want: "already subscribed"},
+		{name: "already subscribed", subscriptions: subscribed(1), request: subscribe(1), want: "already subscribed"},
+		{name: "unsubscribe at limit", subscriptions: subscribed(maxSubscriptions), request: unsubscribe(1), want: ""},
+		{name: "unsubscribe not subscribed", subscriptions: subscribed(1), request: unsubscribe(2), want: "not subscribed"},
+		{name: "unknown action", subscriptions: subscribed(0), request: entity.SubscriptionRequest{VehicleID: 1, Action: "pause"}, want: "unknown action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rejectReason(tt.subscriptions, tt.request); got != tt.want {
+				t.Errorf("rejectReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,11 +9,16 @@ import (
 	"github.com/sirupsen/logrus"
 	"io"
 	"net"
+	"self-driving-car-dispatch-system/internal/entity"
 	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/protocol"
 	"sync"
 	"time"
 )
 
+// vehicleCapabilities - возможности, которые сервер поддерживает для ТС
+const vehicleCapabilities = protocol.CapabilityCommands | protocol.CapabilityClock
+
 type VehicleDelivery struct {
 	broadcastUsecase usecase.BroadcastUsecase
 	logger           *logrus.Logger
@@ -99,69 +104,137 @@ func (v *VehicleDelivery) handleConnection(conn quic.Connection) {
 	v.wg.Add(1)
 	defer v.wg.Done()
 
-	// Открываем поток для получения информации о транспортном средстве
-	infoStream, err := conn.AcceptStream(context.Background())
+	// ТС первым открывает управляющий поток и отправляет в нём приветствие
+	controlStream, hello, err := acceptHello(v.ctx, conn, protocol.RoleVehicle)
 	if err != nil {
-		v.logger.Errorf("Ошибка при открытии потока с ТС %s: %s\n", conn.RemoteAddr(), err)
-		conn.CloseWithError(0, "Connection error")
+		v.logger.Errorf("Ошибка рукопожатия с ТС %s: %s\n", conn.RemoteAddr(), err)
 		return
 	}
-	defer infoStream.Close()
-	v.logger.Infof("Открыт infoStream с ТС %s\n", conn.RemoteAddr())
-
-	// Открываем поток для получения видеотрансляции
-	videoStream, err := conn.AcceptStream(context.Background())
+	defer controlStream.Close()
+	vehicleID := int(hello.VehicleID)
+	v.logger.Infof("Получены данные о ТС %d\n", vehicleID)
+	// Сертификат, пароль или токен из приветствия проверяется один раз, дальше потоки ТС используют выданный токен.
+	// Сертификат уже проверен по CA при рукопожатии TLS, а без сертификата ТС входит по паролю
+	var session *entity.SessionToken
+	if certificates := conn.ConnectionState().TLS.PeerCertificates; len(certificates) > 0 {
+		session, err = v.broadcastUsecase.LoginVehicleCertificate(vehicleID, certificates[0])
+	} else {
+		session, err = v.broadcastUsecase.LoginVehicle(vehicleID, hello.Secret)
+	}
 	if err != nil {
-		v.logger.Errorf("Ошибка при открытии видеопотока с ТС %s: %s\n", conn.RemoteAddr(), err)
+		v.logger.Errorf("ТС %d не прошло аутентификацию: %s\n", vehicleID, err)
+		rejectHello(conn, controlStream, rejectCode(err), "")
+		return
+	}
+	secret := session.Token
+	defer func() {
+		stats := v.broadcastUsecase.GetTelemetryStats(vehicleID)
+		v.logger.Infof("ТС %d: получено кадров телеметрии %d, из них отклонено %d, помечено %d\n",
+			vehicleID, stats.Frames, stats.Malformed, stats.Flagged)
+	}()
+	capabilities := hello.Capabilities & vehicleCapabilities
+	if err = acceptHelloReply(controlStream, capabilities, hello.Encoding, hello.Cameras, session.Token); err != nil {
+		v.logger.Errorf("Ошибка при отправке ответа ТС %s: %s\n", conn.RemoteAddr(), err)
 		conn.CloseWithError(0, "Connection error")
 		return
 	}
-	defer videoStream.Close()
-	v.logger.Infof("Открыт videoStream с ТС %s\n", conn.RemoteAddr())
 
-	data, err := conn.ReceiveDatagram(v.ctx)
+	// Открываем поток для получения информации о транспортном средстве
+	infoStream, err := conn.AcceptStream(v.ctx)
 	if err != nil {
-		v.logger.Errorf("Ошибка при получении данных от ТС %s: %s\n", conn.RemoteAddr(), err)
+		v.logger.Errorf("Ошибка при открытии потока с ТС %s: %s\n", conn.RemoteAddr(), err)
 		conn.CloseWithError(0, "Connection error")
 		return
 	}
-	if len(data) < 4 {
-		v.logger.Errorf("Неверный формат данных от ТС %s\n", conn.RemoteAddr())
-		conn.CloseWithError(0, "Connection error")
+	defer infoStream.Close()
+	v.logger.Infof("Открыт infoStream с ТС %s\n", conn.RemoteAddr())
+
+	// Открываем потоки для получения видеотрансляции: для каждой камеры по одному на каждый слой качества,
+	// начиная с лучшего
+	videoStreams := make([]quic.Stream, len(hello.Cameras)*int(hello.Layers))
+	for i := range videoStreams {
+		videoStreams[i], err = conn.AcceptStream(v.ctx)
+		if err != nil {
+			v.logger.Errorf("Ошибка при открытии видеопотока с ТС %s: %s\n", conn.RemoteAddr(), err)
+			conn.CloseWithError(0, "Connection error")
+			return
+		}
+		defer videoStreams[i].Close()
+		v.logger.Infof("Открыт videoStream камеры %s слоя %d с ТС %s\n",
+			hello.Cameras[i/int(hello.Layers)], i%int(hello.Layers), conn.RemoteAddr())
 	}
 
-	// в первых четырех байтах содержится ID ТС, последующие до конца - ключ доступа в UTF-8
-	vehicleID := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
-	secret := string(data[4:])
-	v.logger.Infof("Получены данные о ТС %d\n", vehicleID)
+	// Открываем поток синхронизации часов: по часам сервера ТС отмечает время съёмки телеметрии и видео
+	var clockStream quic.Stream
+	if capabilities.Has(protocol.CapabilityClock) {
+		clockStream, err = conn.AcceptStream(v.ctx)
+		if err != nil {
+			v.logger.Errorf("Ошибка при открытии потока синхронизации часов с ТС %s: %s\n", conn.RemoteAddr(), err)
+			conn.CloseWithError(0, "Connection error")
+			return
+		}
+		defer clockStream.Close()
+		v.logger.Infof("Открыт clockStream с ТС %s\n", conn.RemoteAddr())
+	}
 
-	infoChan := make(chan []byte, 100)  // буферизированный канал для передачи информации о ТС
-	videoChan := make(chan []byte, 100) // буферизированный канал для передачи видеопотока
-	errChan := make(chan error)         // канал для передачи ошибок
-	go v.getStream(infoStream, infoChan, errChan)
-	go v.getStream(videoStream, videoChan, errChan)
-	go v.broadcastUsecase.SendInfoStream(vehicleID, secret, infoChan, errChan)
-	go v.broadcastUsecase.SendVideoStream(vehicleID, secret, videoChan, errChan)
-	for {
+	ctx := conn.Context()
+	infoChan := make(chan []byte, 100) // буферизированный канал для передачи информации о ТС
+	errChan := make(chan error)        // канал для передачи ошибок
+	go v.getStream(ctx, infoStream, infoChan, errChan)
+	videoChans := make([]chan []byte, len(videoStreams))
+	for i, videoStream := range videoStreams {
+		videoChans[i] = make(chan []byte, 100) // буферизированный канал для передачи видеопотока
+		go v.getStream(ctx, videoStream, videoChans[i], errChan)
+	}
+	go v.broadcastUsecase.VehicleSession(ctx, vehicleID, secret, hello.Cameras, errChan)
+	go v.broadcastUsecase.SendInfoStream(ctx, vehicleID, secret, hello.Encoding, infoChan, errChan)
+	for i, camera := range hello.Cameras {
+		layers := videoChans[i*int(hello.Layers) : (i+1)*int(hello.Layers)]
+		go v.broadcastUsecase.SendVideoStream(ctx, vehicleID, secret, camera, layers, errChan)
+	}
+	if capabilities.Has(protocol.CapabilityCommands) {
+		go v.handleCommandStream(ctx, conn, vehicleID, secret, errChan)
+	}
+	if clockStream != nil {
+		go serveClock(ctx, clockStream, errChan)
+	}
+	select {
+	case err := <-errChan:
+		if err != nil {
+			v.logger.Errorf("Ошибка при трансляции данных от ТС %d: %s\n", vehicleID, err)
+		}
+		conn.CloseWithError(0, fmt.Sprintf("Connection error: %s", err))
+	case <-ctx.Done():
+		v.logger.Infof("ТС %d отключилось\n", vehicleID)
+	case <-v.ctx.Done():
+		conn.CloseWithError(0, "Connection closed")
+	}
+}
+
+// handleCommandStream открывает поток, по которому сервер передает ТС команды диспетчеров и получает подтверждения
+func (v *VehicleDelivery) handleCommandStream(ctx context.Context, conn quic.Connection, vehicleID int, secret string, errChan chan error) {
+	commandStream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		v.logger.Errorf("Ошибка при открытии потока команд с ТС %s: %s\n", conn.RemoteAddr(), err)
 		select {
-		case err := <-errChan:
-			if err != nil {
-				v.logger.Errorf("Ошибка при трансляции данных от ТС %d: %s\n", vehicleID, err)
-			}
-			conn.CloseWithError(0, fmt.Sprintf("Connection error: %s", err))
-			close(infoChan)
-			close(videoChan)
-			return
-		case <-v.ctx.Done():
-			conn.CloseWithError(0, "Connection closed")
-			close(infoChan)
-			close(videoChan)
-			return
+		case errChan <- err:
+		case <-ctx.Done():
 		}
+		return
 	}
+	defer commandStream.Close()
+	v.logger.Infof("Открыт commandStream с ТС %s\n", conn.RemoteAddr())
+
+	commandChan := make(chan entity.Command, 100)
+	ackChan := make(chan entity.CommandAck, 100)
+	go writeMessages(ctx, commandStream, commandChan, errChan)
+	go readMessages(ctx, commandStream, ackChan, errChan)
+	v.broadcastUsecase.GetCommandStream(ctx, vehicleID, secret, commandChan, ackChan, errChan)
 }
 
-func (v *VehicleDelivery) getStream(quicStream quic.Stream, stream chan []byte, errChan chan error) {
+func (v *VehicleDelivery) getStream(ctx context.Context, quicStream quic.Stream, stream chan []byte, errChan chan error) {
+	// getStream - единственный писатель в stream, поэтому по окончании потока закрывает канал
+	defer close(stream)
 	for {
 		data := v.bufferPool.Get().([]byte)
 		n, err := quicStream.Read(data)
@@ -169,13 +242,20 @@ func (v *VehicleDelivery) getStream(quicStream quic.Stream, stream chan []byte,
 			v.bufferPool.Put(data)
 			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
 				v.logger.Errorf("Ошибка при чтении данных с потока: %s\n", err)
-				errChan <- err
+				select {
+				case errChan <- err:
+				case <-ctx.Done():
+				}
 			}
 			return
 		}
 		if n != 0 {
 			v.logger.Debugf("Получено %d байт от %d\n", n, quicStream.StreamID())
-			stream <- data[:n]
+			select {
+			case stream <- data[:n]:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
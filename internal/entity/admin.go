@@ -0,0 +1,82 @@
+package entity
+
+import (
+	"golang.org/x/exp/slices"
+	"time"
+)
+
+// AdminRole - роль учётной записи администратора, от неё зависит, какие методы администратору доступны
+type AdminRole string
+
+const (
+	// SuperAdminRole - полный доступ, в том числе управление учётными записями администраторов
+	SuperAdminRole = AdminRole("super-admin")
+	// FleetManagerRole - управление ТС, диспетчерами и сертификатами ТС
+	FleetManagerRole = AdminRole("fleet-manager")
+	// AuditorRole - только чтение
+	AuditorRole = AdminRole("auditor")
+)
+
+// AdminPermission - действие, на которое у роли администратора должно быть разрешение
+type AdminPermission string
+
+const (
+	// ReadPermission - просмотр ТС, диспетчеров, состояния потоков и сертификатов
+	ReadPermission = AdminPermission("read")
+	// ManageFleetPermission - добавление, изменение и удаление ТС и диспетчеров, выпуск и отзыв сертификатов
+	ManageFleetPermission = AdminPermission("manage-fleet")
+	// ManageAdminsPermission - управление учётными записями администраторов
+	ManageAdminsPermission = AdminPermission("manage-admins")
+)
+
+var adminRolePermissions = map[AdminRole][]AdminPermission{
+	SuperAdminRole:   {ReadPermission, ManageFleetPermission, ManageAdminsPermission},
+	FleetManagerRole: {ReadPermission, ManageFleetPermission},
+	AuditorRole:      {ReadPermission},
+}
+
+func IsAdminRoleValid(role AdminRole) bool {
+	_, ok := adminRolePermissions[role]
+	return ok
+}
+
+// Can сообщает, есть ли у роли разрешение permission
+func (r AdminRole) Can(permission AdminPermission) bool {
+	return slices.Contains(adminRolePermissions[r], permission)
+}
+
+// Admin - учётная запись администратора
+type Admin struct {
+	Login        string
+	Role         AdminRole
+	PasswordHash string
+}
+
+type AdminLoginRequest struct {
+	Login    string `json:"login"    binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// AdminLoginResponse - токен, который администратор передаёт в заголовке Authorization: Bearer <token>
+type AdminLoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type GetAdminResponse struct {
+	Login string    `json:"login"`
+	Role  AdminRole `json:"role"`
+}
+
+type AddAdminRequest struct {
+	Login    string    `json:"login"    binding:"required"`
+	Password string    `json:"password" binding:"required"`
+	Role     AdminRole `json:"role"     binding:"required"`
+}
+
+type EditAdminRequest struct {
+	Login string    `json:"login" binding:"required"`
+	Role  AdminRole `json:"role"  binding:"required"`
+	// Password - новый пароль, пустой - пароль не меняется
+	Password string `json:"password" binding:"omitempty"`
+}
@@ -0,0 +1,77 @@
+package entity
+
+import (
+	"fmt"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	// Действия администраторов
+	AdminLoginAudit       = AuditAction("admin.login")
+	AdminLoginFailedAudit = AuditAction("admin.login_failed")
+	AdminAddAudit         = AuditAction("admin.add")
+	AdminEditAudit        = AuditAction("admin.edit")
+	AdminDeleteAudit      = AuditAction("admin.delete")
+	DispatcherAddAudit    = AuditAction("dispatcher.add")
+	DispatcherEditAudit   = AuditAction("dispatcher.edit")
+	DispatcherDeleteAudit = AuditAction("dispatcher.delete")
+	VehicleAddAudit       = AuditAction("vehicle.add")
+	VehicleEditAudit      = AuditAction("vehicle.edit")
+	VehicleDeleteAudit    = AuditAction("vehicle.delete")
+	// VehicleRotateAudit и DispatcherRotateAudit - смена пароля ТС или диспетчера
+	VehicleRotateAudit    = AuditAction("vehicle.rotate_credential")
+	DispatcherRotateAudit = AuditAction("dispatcher.rotate_credential")
+	CertificateIssueAudit = AuditAction("certificate.issue")
+	// CertificateRevokeAudit - отзыв сертификата ТС, цель записи - ТС, серийный номер указан в подробностях
+	CertificateRevokeAudit = AuditAction("certificate.revoke")
+
+	// События сервера ретрансляции
+	VehicleConnectAudit       = AuditAction("vehicle.connect")
+	VehicleDisconnectAudit    = AuditAction("vehicle.disconnect")
+	DispatcherConnectAudit    = AuditAction("dispatcher.connect")
+	DispatcherDisconnectAudit = AuditAction("dispatcher.disconnect")
+	// ControlAcquireAudit - диспетчер получил управление ТС, в подробностях указано как: acquire, handover или takeover
+	ControlAcquireAudit = AuditAction("control.acquire")
+	// ControlReleaseAudit - аренда управления закончилась, в подробностях указана причина
+	ControlReleaseAudit = AuditAction("control.release")
+	// ControlCommandAudit - диспетчер отправил ТС команду. Уставки ручного управления не записываются:
+	// их слишком много, а кто управлял ТС, видно по записям аренды
+	ControlCommandAudit = AuditAction("control.command")
+)
+
+// AdminActor, VehicleActor и DispatcherActor возвращают того, кто выполнил действие, в записи журнала аудита.
+// Те же значения используются как цель записи
+func AdminActor(login string) string {
+	return fmt.Sprintf("admin:%s", login)
+}
+
+func VehicleActor(vehicleID int) string {
+	return fmt.Sprintf("vehicle:%d", vehicleID)
+}
+
+func DispatcherActor(dispatcherID int) string {
+	return fmt.Sprintf("dispatcher:%d", dispatcherID)
+}
+
+// AuditRecord - запись журнала аудита. Записи только добавляются и не изменяются
+type AuditRecord struct {
+	ID      string      `json:"id"`
+	Time    time.Time   `json:"time"`
+	Actor   string      `json:"actor"`
+	Action  AuditAction `json:"action"`
+	Target  string      `json:"target,omitempty"`
+	Details string      `json:"details,omitempty"`
+}
+
+// AuditFilter отбирает записи журнала аудита. Пустые поля не ограничивают выборку, время - в формате RFC 3339
+type AuditFilter struct {
+	Actor  string      `form:"actor"  binding:"omitempty"`
+	Target string      `form:"target" binding:"omitempty"`
+	Action AuditAction `form:"action" binding:"omitempty"`
+	From   time.Time   `form:"from"   binding:"omitempty"`
+	To     time.Time   `form:"to"     binding:"omitempty"`
+	// Limit - сколько последних записей вернуть
+	Limit int `form:"limit" binding:"omitempty,min=0"`
+}
@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vehicleCommonNamePrefix - начало имени (CN) в сертификате ТС, за ним следует ID ТС
+const vehicleCommonNamePrefix = "vehicle-"
+
+// VehicleCommonName возвращает имя (CN) для сертификата ТС
+func VehicleCommonName(vehicleID int) string {
+	return fmt.Sprintf("%s%d", vehicleCommonNamePrefix, vehicleID)
+}
+
+// ParseVehicleCommonName возвращает ID ТС по имени (CN) из его сертификата
+func ParseVehicleCommonName(commonName string) (int, bool) {
+	id, ok := strings.CutPrefix(commonName, vehicleCommonNamePrefix)
+	if !ok {
+		return 0, false
+	}
+	vehicleID, err := strconv.Atoi(id)
+	if err != nil || vehicleID <= 0 || VehicleCommonName(vehicleID) != commonName {
+		return 0, false
+	}
+	return vehicleID, true
+}
+
+// VehicleCertificate - клиентский сертификат, выпущенный для ТС
+type VehicleCertificate struct {
+	Serial    string    `json:"serial"`
+	VehicleID int       `json:"vehicle_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	NotAfter  time.Time `json:"not_after"`
+	// RevokedAt - время отзыва, пустое, если сертификат не отозван
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+}
+
+type IssueCertificateRequest struct {
+	// ValidFor - срок действия сертификата, например "720h". По умолчанию берётся из конфигурации
+	ValidFor string `json:"valid_for" binding:"omitempty"`
+}
+
+// IssueCertificateResponse - выпущенный сертификат ТС. Закрытый ключ сервер не хранит, он передаётся только в этом ответе
+type IssueCertificateResponse struct {
+	VehicleCertificate
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
+}
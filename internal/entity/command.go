@@ -0,0 +1,53 @@
+package entity
+
+type CommandType string
+
+const (
+	// SetpointCommand задаёт уставки руления, газа и тормоза при ручном управлении ТС
+	SetpointCommand = CommandType("setpoint")
+	// EmergencyStopCommand требует немедленной экстренной остановки ТС
+	EmergencyStopCommand = CommandType("emergency_stop")
+	// PullOverCommand требует безопасно остановиться у обочины
+	PullOverCommand = CommandType("pull_over")
+	// ResumeAutonomyCommand возвращает управление автопилоту ТС
+	ResumeAutonomyCommand = CommandType("resume_autonomy")
+)
+
+type CommandStatus string
+
+const (
+	// CommandApplied означает, что ТС применило команду
+	CommandApplied = CommandStatus("applied")
+	// CommandRejected означает, что команда не была применена
+	CommandRejected = CommandStatus("rejected")
+)
+
+// Command - команда телеуправления от диспетчера к ТС.
+// Seq - порядковый номер команды, по нему диспетчер сопоставляет команду и подтверждение
+type Command struct {
+	Seq      uint64      `json:"seq"`
+	Type     CommandType `json:"type"`
+	Steering float64     `json:"steering,omitempty"` // руль от -1 (влево) до 1 (вправо)
+	Throttle float64     `json:"throttle,omitempty"` // газ от 0 до 1
+	Brake    float64     `json:"brake,omitempty"`    // тормоз от 0 до 1
+}
+
+// CommandAck - подтверждение команды от ТС
+type CommandAck struct {
+	Seq    uint64        `json:"seq"`
+	Status CommandStatus `json:"status"`
+	Error  string        `json:"error,omitempty"`
+}
+
+func IsCommandValid(command *Command) bool {
+	switch command.Type {
+	case SetpointCommand:
+		return command.Steering >= -1 && command.Steering <= 1 &&
+			command.Throttle >= 0 && command.Throttle <= 1 &&
+			command.Brake >= 0 && command.Brake <= 1
+	case EmergencyStopCommand, PullOverCommand, ResumeAutonomyCommand:
+		return true
+	default:
+		return false
+	}
+}
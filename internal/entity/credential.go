@@ -0,0 +1,89 @@
+package entity
+
+import "time"
+
+// PreviousCredential - прежний пароль после смены. Он остаётся действительным до ValidUntil,
+// чтобы ТС или диспетчер успели получить новый пароль
+type PreviousCredential struct {
+	PasswordHash string
+	ValidUntil   time.Time
+}
+
+// IsValid сообщает, действует ли прежний пароль в момент now
+func (c PreviousCredential) IsValid(now time.Time) bool {
+	return c.PasswordHash != "" && now.Before(c.ValidUntil)
+}
+
+// IsCredentialVersionValid сообщает, действует ли пароль версии version, если текущая версия пароля - current.
+// Версия увеличивается при каждой смене пароля, поэтому действует только текущий пароль и прежний до конца перекрытия
+func IsCredentialVersionValid(version, current int, previous PreviousCredential, now time.Time) bool {
+	return version == current || (version == current-1 && previous.IsValid(now))
+}
+
+// RevocationKind - что отзывает CredentialRevocation
+type RevocationKind string
+
+const (
+	// PasswordRevocation отзывает пароли старше MinVersion после смены пароля
+	PasswordRevocation RevocationKind = ""
+	// CertificateRevocation отзывает сертификат ТС с серийным номером Serial
+	CertificateRevocation RevocationKind = "certificate"
+	// AccessRevocation сообщает, что ТС или диспетчера изменили или удалили: сервер ретрансляции перечитывает
+	// их из хранилища и отключает сессии, которые потеряли доступ
+	AccessRevocation RevocationKind = "access"
+)
+
+// CredentialRevocation - отзыв паролей ТС или диспетчера после смены пароля на версию MinVersion: пароли старше
+// прежнего отзываются сразу, прежний пароль версии MinVersion-1 - в момент PreviousValidUntil. Отзыв хранится
+// вместе с паролем, поэтому его видит и сервер ретрансляции, запущенный после смены пароля.
+// Отзыв сертификата ТС и изменение доступа передаются только сообщением: отозванный сертификат и так отмечен
+// в хранилище сертификатов, а доступ сервер ретрансляции перечитывает из хранилища ТС и диспетчеров
+type CredentialRevocation struct {
+	Kind               RevocationKind `json:"kind,omitempty"`
+	Role               TokenRole      `json:"role"`
+	ID                 int            `json:"id"`
+	MinVersion         int            `json:"min_version"`
+	PreviousValidUntil time.Time      `json:"previous_valid_until"`
+	Serial             string         `json:"serial,omitempty"`
+}
+
+// NewCredentialRevocation создаёт отзыв паролей старше version, если текущий пароль - version, а прежний - previous
+func NewCredentialRevocation(role TokenRole, id, version int, previous PreviousCredential) CredentialRevocation {
+	revocation := CredentialRevocation{Role: role, ID: id, MinVersion: version}
+	if previous.PasswordHash != "" {
+		revocation.PreviousValidUntil = previous.ValidUntil
+	}
+	return revocation
+}
+
+// NewCertificateRevocation создаёт отзыв сертификата ТС с серийным номером serial
+func NewCertificateRevocation(vehicleID int, serial string) CredentialRevocation {
+	return CredentialRevocation{Kind: CertificateRevocation, Role: VehicleToken, ID: vehicleID, Serial: serial}
+}
+
+// NewAccessRevocation создаёт сообщение об изменении доступа ТС или диспетчера
+func NewAccessRevocation(role TokenRole, id int) CredentialRevocation {
+	return CredentialRevocation{Kind: AccessRevocation, Role: role, ID: id}
+}
+
+// IsRevoked сообщает, отозван ли в момент now пароль версии version
+func (r CredentialRevocation) IsRevoked(version int, now time.Time) bool {
+	return version < r.MinVersion && !(version == r.MinVersion-1 && now.Before(r.PreviousValidUntil))
+}
+
+type RotateCredentialRequest struct {
+	// Overlap - сколько прежний пароль остаётся действительным, например "10m". По умолчанию он отзывается сразу
+	Overlap string `json:"overlap" binding:"omitempty"`
+}
+
+// RotateCredentialResponse - новый пароль. Сервер хранит только его хэш, поэтому пароль передаётся только в этом ответе
+type RotateCredentialResponse struct {
+	ID                int    `json:"id"`
+	Secret            string `json:"secret"`
+	CredentialVersion int    `json:"credential_version"`
+	// PreviousValidUntil - до какого момента действует прежний пароль, пустое - прежний пароль уже отозван
+	PreviousValidUntil *time.Time `json:"previous_valid_until,omitempty"`
+	// SessionsRevoked - удалось ли сообщить серверу ретрансляции об отзыве прежнего пароля. Если нет,
+	// то сессии с отозванным паролем отключаются при следующей сверке с хранилищем, а новые сессии с ним не принимаются
+	SessionsRevoked bool `json:"sessions_revoked"`
+}
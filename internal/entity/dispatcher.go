@@ -1,10 +1,20 @@
 package entity
 
+import "golang.org/x/exp/slices"
+
 type Dispatcher struct {
 	ID           int
 	GrantsType   GrantsType
 	Grants       []int
 	PasswordHash string
+	// CredentialVersion увеличивается при каждой смене пароля
+	CredentialVersion  int
+	PreviousCredential PreviousCredential
+}
+
+// CanAccess проверяет, что у диспетчера есть доступ ко всем ТС или к ТС vehicleID
+func (d *Dispatcher) CanAccess(vehicleID int) bool {
+	return d.GrantsType == AllGrants || (d.GrantsType == ListGrants && slices.Contains(d.Grants, vehicleID))
 }
 
 type GetDispatcherResponse struct {
@@ -28,3 +38,27 @@ type EditDispatcherRequest struct {
 func IsGrantsTypeValid(grantsType GrantsType) bool {
 	return grantsType == ListGrants || grantsType == AllGrants
 }
+
+// DispatcherFilter отбирает диспетчеров для списка. Пустые поля не ограничивают выборку.
+// Список упорядочен по ID и разбит на страницы: следующая страница начинается после диспетчера с ID After
+type DispatcherFilter struct {
+	GrantsType GrantsType `form:"grants_type" binding:"omitempty"`
+	// VehicleID отбирает диспетчеров с доступом к ТС: со всеми правами или с этим ТС в списке
+	VehicleID int `form:"vehicle_id" binding:"omitempty,min=0"`
+	After     int `form:"after"      binding:"omitempty,min=0"`
+	Limit     int `form:"limit"      binding:"omitempty,min=0"`
+}
+
+// Match проверяет, подходит ли диспетчер под фильтр. Страница фильтром не проверяется
+func (f *DispatcherFilter) Match(dispatcher *Dispatcher) bool {
+	if f.GrantsType != "" && dispatcher.GrantsType != f.GrantsType {
+		return false
+	}
+	return f.VehicleID == 0 || dispatcher.CanAccess(f.VehicleID)
+}
+
+type ListDispatchersResponse struct {
+	Dispatchers []GetDispatcherResponse `json:"dispatchers"`
+	// Next - значение after для следующей страницы, 0 - страница последняя
+	Next int `json:"next,omitempty"`
+}
@@ -0,0 +1,45 @@
+package entity
+
+import "time"
+
+type ConnectionState string
+
+const (
+	// VehicleOnline - соединение с ТС открыто
+	VehicleOnline = ConnectionState("online")
+	// VehicleReconnecting - соединение с ТС потеряно, сервер ждёт переподключения до конца грейс-периода
+	VehicleReconnecting = ConnectionState("reconnecting")
+	// VehicleOffline - ТС не подключено
+	VehicleOffline = ConnectionState("offline")
+)
+
+// TelemetrySummary - последняя телеметрия ТС, которую видит диспетчер в обзоре парка
+type TelemetrySummary struct {
+	Seq      uint64       `json:"seq"`
+	Speed    float64      `json:"speed"`
+	Position *Position    `json:"position,omitempty"`
+	Battery  *float64     `json:"battery,omitempty"`
+	Mode     AutonomyMode `json:"mode,omitempty"`
+	Faults   []string     `json:"faults,omitempty"`
+	// ReceivedAt - время получения телеметрии по часам сервера
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// VehicleOverview - состояние одного ТС в обзоре парка
+type VehicleOverview struct {
+	VehicleID int             `json:"vehicle_id"`
+	State     ConnectionState `json:"state"`
+	// Cameras - камеры, с которых ТС ведёт трансляцию
+	Cameras   []string          `json:"cameras,omitempty"`
+	Telemetry *TelemetrySummary `json:"telemetry,omitempty"`
+	// Watchers - диспетчеры, которые получают трансляции ТС
+	Watchers []int `json:"watchers,omitempty"`
+	// ControllerID - диспетчер, который арендовал управление ТС, 0 - ТС никто не управляет
+	ControllerID int `json:"controller_id,omitempty"`
+}
+
+// FleetStatus - обзор ТС, к которым у диспетчера есть доступ
+type FleetStatus struct {
+	Vehicles []VehicleOverview `json:"vehicles"`
+	At       time.Time         `json:"at"`
+}
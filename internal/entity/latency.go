@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+// LatencyStamps - метки времени прохождения кадра телеметрии или видео. Все метки по часам сервера:
+// клиенты переводят свои часы в часы сервера с помощью ClockSync
+type LatencyStamps struct {
+	Capture time.Time `json:"capture"` // ТС получило кадр с датчика или кодировщика
+	Ingress time.Time `json:"ingress"` // сервер получил кадр от ТС
+	Egress  time.Time `json:"egress"`  // сервер отправил кадр диспетчеру
+}
+
+// ClockSync - сообщение синхронизации часов по схеме NTP. Клиент заполняет ClientSent,
+// сервер возвращает сообщение, заполнив ServerReceived и ServerSent
+type ClockSync struct {
+	ClientSent     time.Time `json:"client_sent"`
+	ServerReceived time.Time `json:"server_received"`
+	ServerSent     time.Time `json:"server_sent"`
+}
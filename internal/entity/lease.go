@@ -0,0 +1,67 @@
+package entity
+
+import "time"
+
+type LeaseAction string
+
+const (
+	// AcquireLease запрашивает управление ТС, если им никто не владеет
+	AcquireLease = LeaseAction("acquire")
+	// RenewLease продлевает аренду управления (heartbeat)
+	RenewLease = LeaseAction("renew")
+	// ReleaseLease добровольно освобождает управление ТС
+	ReleaseLease = LeaseAction("release")
+	// HandoverLease передает управление другому диспетчеру, наблюдающему за ТС
+	HandoverLease = LeaseAction("handover")
+	// TakeoverLease принудительно забирает управление у текущего владельца
+	TakeoverLease = LeaseAction("takeover")
+	// LeaseHistory запрашивает историю владения управлением ТС
+	LeaseHistory = LeaseAction("history")
+)
+
+type LeaseRole string
+
+const (
+	// ControlRole - диспетчер управляет ТС и может отправлять ему команды
+	ControlRole = LeaseRole("control")
+	// ViewRole - диспетчер только наблюдает за ТС
+	ViewRole = LeaseRole("view")
+)
+
+// LeaseRequest - запрос диспетчера на изменение аренды управления ТС
+type LeaseRequest struct {
+	Action LeaseAction `json:"action"`
+	// TargetDispatcherID - диспетчер, которому передается управление при HandoverLease
+	TargetDispatcherID int `json:"target_dispatcher_id,omitempty"`
+}
+
+// LeaseState - состояние аренды управления ТС с точки зрения получателя
+type LeaseState struct {
+	VehicleID int       `json:"vehicle_id"`
+	HolderID  int       `json:"holder_id,omitempty"` // 0, если ТС никто не управляет
+	Role      LeaseRole `json:"role"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// Error содержит причину отказа, если запрос диспетчера не был выполнен
+	Error   string        `json:"error,omitempty"`
+	History []LeaseRecord `json:"history,omitempty"`
+}
+
+// LeaseRecord - запись о том, какой диспетчер и когда управлял ТС
+type LeaseRecord struct {
+	VehicleID    int         `json:"vehicle_id"`
+	DispatcherID int         `json:"dispatcher_id"`
+	Action       LeaseAction `json:"action"` // как диспетчер получил управление
+	AcquiredAt   time.Time   `json:"acquired_at"`
+	ReleasedAt   time.Time   `json:"released_at,omitempty"`
+	// ReleaseReason - причина окончания аренды: release, handover, takeover, expired или disconnected
+	ReleaseReason string `json:"release_reason,omitempty"`
+}
+
+func IsLeaseActionValid(action LeaseAction) bool {
+	switch action {
+	case AcquireLease, RenewLease, ReleaseLease, HandoverLease, TakeoverLease, LeaseHistory:
+		return true
+	default:
+		return false
+	}
+}
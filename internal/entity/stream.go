@@ -0,0 +1,75 @@
+package entity
+
+import "time"
+
+type StreamState string
+
+const (
+	// StreamActive - данные приходят
+	StreamActive = StreamState("active")
+	// StreamStale - соединение с ТС открыто, но данные не приходят несколько секунд: картинка у диспетчера застыла
+	StreamStale = StreamState("stale")
+	// StreamLost - данные не приходят так долго, что на поток нельзя полагаться
+	StreamLost = StreamState("lost")
+)
+
+// Состояния сессии ТС. В событиях о сессии поле Stream пустое
+const (
+	// SessionDisconnected - соединение с ТС потеряно, сервер ждёт переподключения до конца грейс-периода
+	SessionDisconnected = StreamState("disconnected")
+	// SessionReconnected - ТС переподключилось, диспетчеры переключены на его новые трансляции
+	SessionReconnected = StreamState("reconnected")
+	// SessionClosed - ТС не переподключилось за грейс-период, трансляции завершены
+	SessionClosed = StreamState("closed")
+)
+
+// InfoStreamName - название потока телеметрии ТС
+const InfoStreamName = "info"
+
+// VideoStreamName возвращает название потока видео с камеры ТС
+func VideoStreamName(camera string) string {
+	return "video:" + camera
+}
+
+// StreamStatus - состояние одного потока ТС
+type StreamStatus struct {
+	Stream     string      `json:"stream"`
+	State      StreamState `json:"state"`
+	LastPacket time.Time   `json:"last_packet"` // время получения последних данных по часам сервера
+}
+
+// StreamEvent - событие об изменении состояния потока или сессии ТС, которое получают подписанные на ТС диспетчеры
+type StreamEvent struct {
+	VehicleID int `json:"vehicle_id"`
+	StreamStatus
+	At time.Time `json:"at"`
+}
+
+// StreamHealth - состояние всех потоков ТС. Состояние ТС - худшее из состояний его потоков,
+// LastPacket - время последних данных по любому потоку. Если ТС не подключено, то потоков нет
+type StreamHealth struct {
+	VehicleID  int            `json:"vehicle_id"`
+	State      StreamState    `json:"state,omitempty"`
+	LastPacket time.Time      `json:"last_packet,omitempty"`
+	Streams    []StreamStatus `json:"streams"`
+}
+
+// streamStateSeverity упорядочивает состояния от лучшего к худшему
+var streamStateSeverity = map[StreamState]int{StreamActive: 0, StreamStale: 1, StreamLost: 2}
+
+// NewStreamHealth сводит состояния потоков ТС в состояние ТС
+func NewStreamHealth(vehicleID int, streams []StreamStatus) *StreamHealth {
+	health := &StreamHealth{VehicleID: vehicleID, Streams: streams}
+	if health.Streams == nil {
+		health.Streams = make([]StreamStatus, 0)
+	}
+	for _, stream := range streams {
+		if health.State == "" || streamStateSeverity[stream.State] > streamStateSeverity[health.State] {
+			health.State = stream.State
+		}
+		if stream.LastPacket.After(health.LastPacket) {
+			health.LastPacket = stream.LastPacket
+		}
+	}
+	return health
+}
@@ -0,0 +1,50 @@
+package entity
+
+type SubscriptionAction string
+
+const (
+	// SubscribeAction подписывает диспетчера на ТС
+	SubscribeAction = SubscriptionAction("subscribe")
+	// UnsubscribeAction отменяет подписку на ТС
+	UnsubscribeAction = SubscriptionAction("unsubscribe")
+)
+
+type SubscriptionStatus string
+
+const (
+	// Subscribed - сервер открыл потоки подписки
+	Subscribed = SubscriptionStatus("subscribed")
+	// Unsubscribed - подписка отменена по запросу диспетчера
+	Unsubscribed = SubscriptionStatus("unsubscribed")
+	// SubscriptionRejected - подписка не оформлена, например, у диспетчера нет доступа к ТС
+	SubscriptionRejected = SubscriptionStatus("rejected")
+	// SubscriptionClosed - подписка закончилась, например, ТС не переподключилось за грейс-период
+	SubscriptionClosed = SubscriptionStatus("closed")
+)
+
+// SubscriptionRequest - запрос диспетчера на подписку на ТС или её отмену, который передаётся в управляющем потоке
+type SubscriptionRequest struct {
+	Action    SubscriptionAction `json:"action"`
+	VehicleID int                `json:"vehicle_id"`
+	// Cameras - камеры, видео с которых нужно получать, пустой список означает все камеры
+	Cameras []string `json:"cameras,omitempty"`
+}
+
+// SubscriptionState - состояние подписки диспетчера на ТС
+type SubscriptionState struct {
+	VehicleID int                `json:"vehicle_id"`
+	Status    SubscriptionStatus `json:"status"`
+	// Cameras - камеры, по видеопотоку на каждую, если подписка оформлена
+	Cameras []string `json:"cameras,omitempty"`
+	// Error содержит причину, если подписка отклонена или закончилась
+	Error string `json:"error,omitempty"`
+}
+
+func IsSubscriptionActionValid(action SubscriptionAction) bool {
+	switch action {
+	case SubscribeAction, UnsubscribeAction:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,115 @@
+package entity
+
+import (
+	"math"
+	"time"
+)
+
+type AutonomyMode string
+
+const (
+	// AutonomousMode - ТС управляет автопилот
+	AutonomousMode = AutonomyMode("autonomous")
+	// TeleoperatedMode - ТС управляет диспетчер
+	TeleoperatedMode = AutonomyMode("teleoperated")
+	// ManualMode - ТС управляет водитель-испытатель
+	ManualMode = AutonomyMode("manual")
+	// SafeStopMode - ТС выполняет безопасную остановку или уже остановилось
+	SafeStopMode = AutonomyMode("safe_stop")
+)
+
+// MaxSpeed - максимальная правдоподобная скорость ТС в км/ч
+const MaxSpeed = 300
+
+// Position - координаты ТС в WGS 84
+type Position struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Telemetry - сообщение телеметрии от ТС.
+// Необязательные поля передаются указателями, чтобы отличать отсутствие значения от нуля
+type Telemetry struct {
+	Seq       uint64       `json:"seq"`
+	Timestamp time.Time    `json:"timestamp"`
+	Steering  float64      `json:"steering"` // руль от -1 (влево) до 1 (вправо)
+	Throttle  float64      `json:"throttle"` // газ от 0 до 1
+	Brake     float64      `json:"brake"`    // тормоз от 0 до 1
+	Speed     float64      `json:"speed"`    // скорость в км/ч
+	Position  *Position    `json:"position,omitempty"`
+	Heading   *float64     `json:"heading,omitempty"` // курс в градусах от 0 до 360
+	Battery   *float64     `json:"battery,omitempty"` // заряд батареи в процентах
+	Mode      AutonomyMode `json:"mode,omitempty"`
+	Faults    []string     `json:"faults,omitempty"` // коды неисправностей ТС
+	// Flags заполняет сервер: это названия полей, значения которых вне допустимого диапазона
+	Flags []string `json:"flags,omitempty"`
+	// Latency - метки времени для измерения задержки: ТС заполняет Capture, сервер - Ingress и Egress
+	Latency *LatencyStamps `json:"latency,omitempty"`
+}
+
+// TelemetryStats - счётчики кадров потока телеметрии одного ТС
+type TelemetryStats struct {
+	Frames    uint64 `json:"frames"`    // всего получено кадров
+	Malformed uint64 `json:"malformed"` // из них отклонено: слишком больших, не декодируемых или не прошедших проверку
+	Flagged   uint64 `json:"flagged"`   // принято, но со значениями вне допустимого диапазона
+}
+
+func IsAutonomyModeValid(mode AutonomyMode) bool {
+	switch mode {
+	case "", AutonomousMode, TeleoperatedMode, ManualMode, SafeStopMode:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsTelemetryValid проверяет, что сообщение телеметрии можно передать диспетчерам:
+// указано время, все числа конечны, режим известен
+func IsTelemetryValid(telemetry *Telemetry) bool {
+	if telemetry.Timestamp.IsZero() || !IsAutonomyModeValid(telemetry.Mode) {
+		return false
+	}
+	values := []float64{telemetry.Steering, telemetry.Throttle, telemetry.Brake, telemetry.Speed}
+	if telemetry.Position != nil {
+		values = append(values, telemetry.Position.Latitude, telemetry.Position.Longitude)
+	}
+	if telemetry.Heading != nil {
+		values = append(values, *telemetry.Heading)
+	}
+	if telemetry.Battery != nil {
+		values = append(values, *telemetry.Battery)
+	}
+	for _, value := range values {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// TelemetryFlags возвращает названия полей, значения которых вне допустимого диапазона
+func TelemetryFlags(telemetry *Telemetry) []string {
+	var flags []string
+	if telemetry.Steering < -1 || telemetry.Steering > 1 {
+		flags = append(flags, "steering")
+	}
+	if telemetry.Throttle < 0 || telemetry.Throttle > 1 {
+		flags = append(flags, "throttle")
+	}
+	if telemetry.Brake < 0 || telemetry.Brake > 1 {
+		flags = append(flags, "brake")
+	}
+	if telemetry.Speed < 0 || telemetry.Speed > MaxSpeed {
+		flags = append(flags, "speed")
+	}
+	if p := telemetry.Position; p != nil && (p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180) {
+		flags = append(flags, "position")
+	}
+	if h := telemetry.Heading; h != nil && (*h < 0 || *h >= 360) {
+		flags = append(flags, "heading")
+	}
+	if b := telemetry.Battery; b != nil && (*b < 0 || *b > 100) {
+		flags = append(flags, "battery")
+	}
+	return flags
+}
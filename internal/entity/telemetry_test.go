@@ -0,0 +1,98 @@
+package entity
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestIsTelemetryValid(t *testing.T) {
+	valid := func(change func(telemetry *Telemetry)) *Telemetry {
+		telemetry := &Telemetry{Timestamp: time.Unix(1700000000, 0), Mode: AutonomousMode}
+		change(telemetry)
+		return telemetry
+	}
+	tests := []struct {
+		name      string
+		telemetry *Telemetry
+		want      bool
+	}{
+		{name: "minimal", telemetry: valid(func(*Telemetry) {}), want: true},
+		{name: "empty mode", telemetry: valid(func(t *Telemetry) { t.Mode = "" }), want: true},
+		// значения вне диапазона допустимы: они только помечаются флагами
+		{name: "out of range", telemetry: valid(func(t *Telemetry) { t.Speed = 1000; t.Heading = float(-1) }), want: true},
+		{name: "no timestamp", telemetry: valid(func(t *Telemetry) { t.Timestamp = time.Time{} })},
+		{name: "unknown mode", telemetry: valid(func(t *Telemetry) { t.Mode = "parked" })},
+		{name: "NaN steering", telemetry: valid(func(t *Telemetry) { t.Steering = math.NaN() })},
+		{name: "Inf throttle", telemetry: valid(func(t *Telemetry) { t.Throttle = math.Inf(1) })},
+		{name: "-Inf brake", telemetry: valid(func(t *Telemetry) { t.Brake = math.Inf(-1) })},
+		{name: "NaN speed", telemetry: valid(func(t *Telemetry) { t.Speed = math.NaN() })},
+		{name: "NaN latitude", telemetry: valid(func(t *Telemetry) { t.Position = &Position{Latitude: math.NaN()} })},
+		{name: "Inf longitude", telemetry: valid(func(t *Telemetry) { t.Position = &Position{Longitude: math.Inf(1)} })},
+		{name: "NaN heading", telemetry: valid(func(t *Telemetry) { t.Heading = float(math.NaN()) })},
+		{name: "Inf battery", telemetry: valid(func(t *Telemetry) { t.Battery = float(math.Inf(-1)) })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTelemetryValid(tt.telemetry); got != tt.want {
+				t.Errorf("IsTelemetryValid = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTelemetryFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		telemetry Telemetry
+		want      string
+	}{
+		{name: "zero", telemetry: Telemetry{}},
+		{
+			// границы диапазонов допустимы, кроме курса 360: он равен курсу 0
+			name: "upper bounds",
+			telemetry: Telemetry{
+				Steering: 1, Throttle: 1, Brake: 1, Speed: MaxSpeed,
+				Position: &Position{Latitude: 90, Longitude: 180},
+				Heading:  float(359.999), Battery: float(100),
+			},
+		},
+		{
+			name: "lower bounds",
+			telemetry: Telemetry{
+				Steering: -1, Position: &Position{Latitude: -90, Longitude: -180},
+				Heading: float(0), Battery: float(0),
+			},
+		},
+		{
+			name: "above bounds",
+			telemetry: Telemetry{
+				Steering: 1.001, Throttle: 1.001, Brake: 1.001, Speed: MaxSpeed + 0.1,
+				Position: &Position{Latitude: 90.001}, Heading: float(360), Battery: float(100.1),
+			},
+			want: "steering,throttle,brake,speed,position,heading,battery",
+		},
+		{
+			name: "below bounds",
+			telemetry: Telemetry{
+				Steering: -1.001, Throttle: -0.001, Brake: -0.001, Speed: -0.1,
+				Position: &Position{Longitude: -180.001}, Heading: float(-0.001), Battery: float(-0.1),
+			},
+			want: "steering,throttle,brake,speed,position,heading,battery",
+		},
+		{name: "absent optional fields", telemetry: Telemetry{Speed: -1}, want: "speed"},
+		{name: "Inf speed", telemetry: Telemetry{Speed: math.Inf(1)}, want: "speed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(TelemetryFlags(&tt.telemetry), ","); got != tt.want {
+				t.Errorf("TelemetryFlags = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
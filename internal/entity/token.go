@@ -0,0 +1,37 @@
+package entity
+
+import "time"
+
+type TokenRole string
+
+const (
+	// VehicleToken - токен ТС
+	VehicleToken = TokenRole("vehicle")
+	// DispatcherToken - токен диспетчера
+	DispatcherToken = TokenRole("dispatcher")
+	// AdminToken - токен администратора
+	AdminToken = TokenRole("admin")
+)
+
+// TokenClaims - содержимое токена сессии. Токен передаётся в сообщениях рукопожатия ограниченного размера,
+// поэтому права диспетчера в него не записываются, сервер хранит их сам по ID диспетчера и версии пароля.
+// Роль администратора в токен не записывается и читается из хранилища при каждом запросе
+type TokenClaims struct {
+	Role TokenRole `json:"role"`
+	ID   int       `json:"id"`
+	// Login - логин администратора, у администраторов нет числового ID
+	Login string `json:"login,omitempty"`
+	// CredentialVersion - версия пароля, по которому выдан первый токен сессии. После смены пароля
+	// токены прежних версий не продлеваются
+	CredentialVersion int `json:"cv,omitempty"`
+	// Certificate - серийный номер сертификата, по которому ТС вошло без пароля
+	Certificate string    `json:"cert,omitempty"`
+	IssuedAt    time.Time `json:"iat"`
+	ExpiresAt   time.Time `json:"exp"`
+}
+
+// SessionToken - выданный токен сессии
+type SessionToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
@@ -1,14 +1,84 @@
 package entity
 
+import "golang.org/x/exp/slices"
+
+type VehicleStatus string
+
+const (
+	// ActiveVehicle - ТС в эксплуатации, только такие ТС могут подключаться к серверу ретрансляции
+	ActiveVehicle = VehicleStatus("active")
+	// MaintenanceVehicle - ТС на обслуживании
+	MaintenanceVehicle = VehicleStatus("maintenance")
+	// DecommissionedVehicle - ТС выведено из эксплуатации
+	DecommissionedVehicle = VehicleStatus("decommissioned")
+)
+
+func IsVehicleStatusValid(status VehicleStatus) bool {
+	return status == ActiveVehicle || status == MaintenanceVehicle || status == DecommissionedVehicle
+}
+
+// VehicleInfo - описание ТС, которое ведёт администратор
+type VehicleInfo struct {
+	Make  string `json:"make,omitempty"`
+	Model string `json:"model,omitempty"`
+	VIN   string `json:"vin,omitempty"`
+	Plate string `json:"plate,omitempty"`
+	// Cameras - камеры, установленные на ТС
+	Cameras []string `json:"cameras,omitempty"`
+	// Depot - парк, к которому приписано ТС
+	Depot string   `json:"depot,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
 type Vehicle struct {
 	ID           int
 	PasswordHash string
+	// CredentialVersion увеличивается при каждой смене пароля
+	CredentialVersion  int
+	PreviousCredential PreviousCredential
+	VehicleInfo
+	Status VehicleStatus
 }
 
 type GetVehicleResponse struct {
 	ID int `json:"id"`
+	VehicleInfo
+	Status VehicleStatus `json:"status"`
 }
 
 type AddVehicleRequest struct {
 	Password string `json:"password"    binding:"required"`
+	VehicleInfo
+	// Status - статус нового ТС, по умолчанию active
+	Status VehicleStatus `json:"status" binding:"omitempty"`
+}
+
+// EditVehicleRequest заменяет описание и статус ТС. Пароль ТС здесь не меняется
+type EditVehicleRequest struct {
+	ID int `json:"id"     binding:"required"`
+	VehicleInfo
+	Status VehicleStatus `json:"status" binding:"required"`
+}
+
+// VehicleFilter отбирает ТС для списка. Пустые поля не ограничивают выборку.
+// Список упорядочен по ID и разбит на страницы: следующая страница начинается после ТС с ID After
+type VehicleFilter struct {
+	Status VehicleStatus `form:"status" binding:"omitempty"`
+	Depot  string        `form:"depot"  binding:"omitempty"`
+	Tag    string        `form:"tag"    binding:"omitempty"`
+	After  int           `form:"after"  binding:"omitempty,min=0"`
+	Limit  int           `form:"limit"  binding:"omitempty,min=0"`
+}
+
+// Match проверяет, подходит ли ТС под фильтр. Страница фильтром не проверяется
+func (f *VehicleFilter) Match(vehicle *Vehicle) bool {
+	return (f.Status == "" || vehicle.Status == f.Status) &&
+		(f.Depot == "" || vehicle.Depot == f.Depot) &&
+		(f.Tag == "" || slices.Contains(vehicle.Tags, f.Tag))
+}
+
+type ListVehiclesResponse struct {
+	Vehicles []GetVehicleResponse `json:"vehicles"`
+	// Next - значение after для следующей страницы, 0 - страница последняя
+	Next int `json:"next,omitempty"`
 }
@@ -0,0 +1,14 @@
+package repo
+
+import (
+	"self-driving-car-dispatch-system/internal/entity"
+)
+
+// AdminRepo хранит учётные записи администраторов, ключ учётной записи - логин.
+// Запись журнала аудита сохраняется в той же транзакции, что и изменение, как в VehicleRepo
+type AdminRepo interface {
+	GetAdmin(login string) (*entity.Admin, error)
+	AddAdmin(admin *entity.Admin, audit *entity.AuditRecord) error
+	EditAdmin(admin *entity.Admin, audit *entity.AuditRecord) error
+	DeleteAdmin(login string, audit *entity.AuditRecord) error
+}
@@ -0,0 +1,13 @@
+package repo
+
+import (
+	"self-driving-car-dispatch-system/internal/entity"
+)
+
+// AuditRepo - журнал аудита, в который записи только добавляются
+type AuditRepo interface {
+	// AddRecord добавляет запись и заполняет её ID
+	AddRecord(record *entity.AuditRecord) error
+	// GetRecords возвращает не больше filter.Limit записей, подходящих под фильтр, от новых к старым
+	GetRecords(filter *entity.AuditFilter) ([]entity.AuditRecord, error)
+}
@@ -0,0 +1,16 @@
+package repo
+
+import (
+	"self-driving-car-dispatch-system/internal/entity"
+)
+
+// CertificateRepo хранит клиентские сертификаты, выпущенные для ТС. Отозванные сертификаты остаются в хранилище,
+// чтобы сервер ретрансляции отклонял их до окончания срока действия. Запись журнала аудита сохраняется в той же
+// транзакции, что и изменение, как в VehicleRepo
+type CertificateRepo interface {
+	AddCertificate(certificate *entity.VehicleCertificate, audit *entity.AuditRecord) error
+	GetCertificates(vehicleID int) ([]entity.VehicleCertificate, error)
+	// RevokeCertificate не изменяет уже отозванный сертификат и не записывает audit
+	RevokeCertificate(vehicleID int, serial string, audit *entity.AuditRecord) error
+	IsCertificateRevoked(serial string) (bool, error)
+}
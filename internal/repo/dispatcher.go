@@ -4,9 +4,18 @@ import (
 	"self-driving-car-dispatch-system/internal/entity"
 )
 
+// DispatcherRepo хранит диспетчеров. Запись журнала аудита сохраняется в той же транзакции, что и изменение,
+// как в VehicleRepo
 type DispatcherRepo interface {
 	GetDispatcher(id int) (*entity.Dispatcher, error)
-	AddDispatcher(dispatcher *entity.Dispatcher) error
-	EditDispatcher(dispatcher *entity.Dispatcher) error
-	DeleteDispatcher(id int) error
+	// AddDispatcher заполняет ID диспетчера и Target записи журнала аудита
+	AddDispatcher(dispatcher *entity.Dispatcher, audit *entity.AuditRecord) error
+	// EditDispatcher не меняет пароль: он меняется только RotateDispatcherCredential
+	EditDispatcher(dispatcher *entity.Dispatcher, audit *entity.AuditRecord) error
+	// RotateDispatcherCredential меняет пароль диспетчера так же, как VehicleRepo.RotateVehicleCredential
+	RotateDispatcherCredential(id int, rotate func(dispatcher *entity.Dispatcher) (*entity.AuditRecord, error)) error
+	DeleteDispatcher(id int, audit *entity.AuditRecord) error
+	// ListDispatchers возвращает не больше filter.Limit диспетчеров с ID больше filter.After, подходящих
+	// под фильтр и упорядоченных по ID
+	ListDispatchers(filter *entity.DispatcherFilter) ([]entity.Dispatcher, error)
 }
@@ -8,4 +8,7 @@ var (
 	ErrInternal                = errors.New("db error")
 	ErrVehicleNotFound         = errors.New("vehicle not found")
 	ErrVehicleAlreadyExists    = errors.New("vehicle already exists")
+	ErrCertificateNotFound     = errors.New("certificate not found")
+	ErrAdminNotFound           = errors.New("admin not found")
+	ErrAdminAlreadyExists      = errors.New("admin already exists")
 )
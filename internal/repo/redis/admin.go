@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"time"
+)
+
+type AdminRepo struct {
+	redisClient *redis.Client
+}
+
+func NewAdminRepo(client *redis.Client) repo.AdminRepo {
+	return &AdminRepo{
+		redisClient: client,
+	}
+}
+
+func adminKey(login string) string {
+	return fmt.Sprintf("admin:%s", login)
+}
+
+func encodeAdmin(admin *entity.Admin) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(*admin); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (a AdminRepo) GetAdmin(login string) (*entity.Admin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	data, err := a.redisClient.Get(ctx, adminKey(login)).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return nil, repo.ErrAdminNotFound
+	case err != nil:
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+	var admin entity.Admin
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&admin); err != nil {
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+	return &admin, nil
+}
+
+// changeAdmin выполняет change в одной транзакции с добавлением записи журнала аудита, если учётная запись login
+// существует (exists = true) или ещё не существует (exists = false). Иначе возвращается repo.ErrAdminNotFound
+// или repo.ErrAdminAlreadyExists
+func (a AdminRepo) changeAdmin(ctx context.Context, login string, exists bool, change func(pipe redis.Pipeliner) error, audit *entity.AuditRecord) error {
+	key := adminKey(login)
+	var auditCmd *redis.StringCmd
+	err := a.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, key).Result()
+		switch {
+		case err != nil:
+			return err
+		case exists && n == 0:
+			return repo.ErrAdminNotFound
+		case !exists && n > 0:
+			return repo.ErrAdminAlreadyExists
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := change(pipe); err != nil {
+				return err
+			}
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
+		})
+		return err
+	}, key)
+	switch {
+	case err == nil:
+		setAuditRecordID(audit, auditCmd)
+		return nil
+	case errors.Is(err, repo.ErrAdminNotFound), errors.Is(err, repo.ErrAdminAlreadyExists):
+		return err
+	case !exists && errors.Is(err, redis.TxFailedErr):
+		// учётную запись с тем же логином одновременно создал другой запрос
+		return repo.ErrAdminAlreadyExists
+	default:
+		return errors.Join(repo.ErrInternal, err)
+	}
+}
+
+func (a AdminRepo) AddAdmin(admin *entity.Admin, audit *entity.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	data, err := encodeAdmin(admin)
+	if err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	// логин уникален, поэтому учётная запись создаётся, только если ключа ещё нет
+	return a.changeAdmin(ctx, admin.Login, false, func(pipe redis.Pipeliner) error {
+		return pipe.Set(ctx, adminKey(admin.Login), data, 0).Err()
+	}, audit)
+}
+
+func (a AdminRepo) EditAdmin(admin *entity.Admin, audit *entity.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	data, err := encodeAdmin(admin)
+	if err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	// учётная запись обновляется, только если она существует
+	return a.changeAdmin(ctx, admin.Login, true, func(pipe redis.Pipeliner) error {
+		return pipe.Set(ctx, adminKey(admin.Login), data, redis.KeepTTL).Err()
+	}, audit)
+}
+
+func (a AdminRepo) DeleteAdmin(login string, audit *entity.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return a.changeAdmin(ctx, login, true, func(pipe redis.Pipeliner) error {
+		return pipe.Del(ctx, adminKey(login)).Err()
+	}, audit)
+}
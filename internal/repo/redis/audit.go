@@ -0,0 +1,125 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"time"
+)
+
+const (
+	// auditKey - поток (Redis stream) журнала аудита. Поток не обрезается, записи из него не удаляются
+	auditKey = "audit"
+	// auditBatchSize - сколько записей читается из потока за один запрос при поиске по фильтру
+	auditBatchSize = 500
+)
+
+type AuditRepo struct {
+	redisClient *redis.Client
+}
+
+func NewAuditRepo(client *redis.Client) repo.AuditRepo {
+	return &AuditRepo{
+		redisClient: client,
+	}
+}
+
+// addAuditRecord добавляет запись в журнал аудита командой client, например, в транзакции вместе с изменением,
+// которое эта запись описывает. Если record nil, то ничего не добавляется и возвращается nil.
+// ID записи известен только после выполнения команды, его заполняет setAuditRecordID
+func addAuditRecord(ctx context.Context, client redis.Cmdable, record *entity.AuditRecord) *redis.StringCmd {
+	if record == nil {
+		return nil
+	}
+	return client.XAdd(ctx, &redis.XAddArgs{
+		Stream: auditKey,
+		Values: map[string]interface{}{
+			"time":    record.Time.Format(time.RFC3339Nano),
+			"actor":   record.Actor,
+			"action":  string(record.Action),
+			"target":  record.Target,
+			"details": record.Details,
+		},
+	})
+}
+
+// setAuditRecordID заполняет ID записи, добавленной addAuditRecord в выполненной транзакции
+func setAuditRecordID(record *entity.AuditRecord, cmd *redis.StringCmd) {
+	if record != nil && cmd != nil {
+		record.ID = cmd.Val()
+	}
+}
+
+func (a AuditRepo) AddRecord(record *entity.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	id, err := addAuditRecord(ctx, a.redisClient, record).Result()
+	if err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	record.ID = id
+	return nil
+}
+
+func decodeAuditRecord(message redis.XMessage) entity.AuditRecord {
+	value := func(field string) string {
+		s, _ := message.Values[field].(string)
+		return s
+	}
+	recordTime, _ := time.Parse(time.RFC3339Nano, value("time"))
+	return entity.AuditRecord{
+		ID:      message.ID,
+		Time:    recordTime,
+		Actor:   value("actor"),
+		Action:  entity.AuditAction(value("action")),
+		Target:  value("target"),
+		Details: value("details"),
+	}
+}
+
+func matchAuditRecord(record *entity.AuditRecord, filter *entity.AuditFilter) bool {
+	return (filter.Actor == "" || record.Actor == filter.Actor) &&
+		(filter.Target == "" || record.Target == filter.Target) &&
+		(filter.Action == "" || record.Action == filter.Action)
+}
+
+func (a AuditRepo) GetRecords(filter *entity.AuditFilter) ([]entity.AuditRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// ID записи в потоке начинается с времени добавления в миллисекундах, поэтому диапазон времени
+	// ограничивается диапазоном ID, а остальные условия фильтра проверяются при чтении
+	end, start := "+", "-"
+	if !filter.To.IsZero() {
+		end = fmt.Sprint(filter.To.UnixMilli())
+	}
+	if !filter.From.IsZero() {
+		start = fmt.Sprint(filter.From.UnixMilli())
+	}
+	records := make([]entity.AuditRecord, 0)
+	for len(records) < filter.Limit {
+		messages, err := a.redisClient.XRevRangeN(ctx, auditKey, end, start, auditBatchSize).Result()
+		if err != nil {
+			return nil, errors.Join(repo.ErrInternal, err)
+		}
+		for _, message := range messages {
+			record := decodeAuditRecord(message)
+			if matchAuditRecord(&record, filter) {
+				records = append(records, record)
+				if len(records) == filter.Limit {
+					break
+				}
+			}
+		}
+		if len(messages) < auditBatchSize {
+			break
+		}
+		// следующая порция начинается сразу после последней прочитанной записи
+		end = "(" + messages[len(messages)-1].ID
+	}
+	return records, nil
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/exp/slices"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"time"
+)
+
+type CertificateRepo struct {
+	redisClient *redis.Client
+}
+
+func NewCertificateRepo(client *redis.Client) repo.CertificateRepo {
+	return &CertificateRepo{
+		redisClient: client,
+	}
+}
+
+// Сертификаты ТС хранятся в хэше: поле - серийный номер, значение - сериализованный entity.VehicleCertificate
+func certificatesKey(vehicleID int) string {
+	return fmt.Sprintf("vehicle:%d:certificates", vehicleID)
+}
+
+// Отозванный сертификат отмечается отдельным ключом, который хранится до окончания срока действия сертификата:
+// после него сертификат отклоняется при рукопожатии TLS
+func revokedCertificateKey(serial string) string {
+	return fmt.Sprintf("certificate:revoked:%s", serial)
+}
+
+func (c CertificateRepo) setCertificate(ctx context.Context, client redis.Cmdable, certificate *entity.VehicleCertificate) error {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(*certificate); err != nil {
+		return err
+	}
+	return client.HSet(ctx, certificatesKey(certificate.VehicleID), certificate.Serial, buffer.Bytes()).Err()
+}
+
+func (c CertificateRepo) AddCertificate(certificate *entity.VehicleCertificate, audit *entity.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var auditCmd *redis.StringCmd
+	_, err := c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := c.setCertificate(ctx, pipe, certificate); err != nil {
+			return err
+		}
+		auditCmd = addAuditRecord(ctx, pipe, audit)
+		return nil
+	})
+	if err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	setAuditRecordID(audit, auditCmd)
+	return nil
+}
+
+func (c CertificateRepo) GetCertificates(vehicleID int) ([]entity.VehicleCertificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	fields, err := c.redisClient.HGetAll(ctx, certificatesKey(vehicleID)).Result()
+	if err != nil {
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+	certificates := make([]entity.VehicleCertificate, 0, len(fields))
+	for _, data := range fields {
+		var certificate entity.VehicleCertificate
+		if err = gob.NewDecoder(bytes.NewReader([]byte(data))).Decode(&certificate); err != nil {
+			return nil, errors.Join(repo.ErrInternal, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	slices.SortFunc(certificates, func(a, b entity.VehicleCertificate) int {
+		return a.IssuedAt.Compare(b.IssuedAt)
+	})
+	return certificates, nil
+}
+
+func (c CertificateRepo) RevokeCertificate(vehicleID int, serial string, audit *entity.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key := certificatesKey(vehicleID)
+	var auditCmd *redis.StringCmd
+	err := c.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.HGet(ctx, key, serial).Bytes()
+		switch {
+		case errors.Is(err, redis.Nil):
+			return repo.ErrCertificateNotFound
+		case err != nil:
+			return err
+		}
+		var certificate entity.VehicleCertificate
+		if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&certificate); err != nil {
+			return err
+		}
+		if !certificate.RevokedAt.IsZero() {
+			// сертификат уже отозван
+			return nil
+		}
+		certificate.RevokedAt = time.Now()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := c.setCertificate(ctx, pipe, &certificate); err != nil {
+				return err
+			}
+			// просроченный сертификат отклоняется и без отметки
+			if ttl := time.Until(certificate.NotAfter); ttl > 0 {
+				if err := pipe.Set(ctx, revokedCertificateKey(serial), certificate.VehicleID, ttl).Err(); err != nil {
+					return err
+				}
+			}
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
+		})
+		return err
+	}, key)
+
+	switch {
+	case err == nil:
+		setAuditRecordID(audit, auditCmd)
+		return nil
+	case errors.Is(err, repo.ErrCertificateNotFound):
+		return err
+	default:
+		return errors.Join(repo.ErrInternal, err)
+	}
+}
+
+func (c CertificateRepo) IsCertificateRevoked(serial string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := c.redisClient.Exists(ctx, revokedCertificateKey(serial)).Result()
+	if err != nil {
+		return false, errors.Join(repo.ErrInternal, err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,40 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"self-driving-car-dispatch-system/internal/entity"
+)
+
+// rotationAttempts - сколько раз повторяется смена пароля, если запись изменили во время смены
+const rotationAttempts = 3
+
+// revocationKey возвращает ключ, в котором хранится отзыв паролей ТС или диспетчера
+func revocationKey(role entity.TokenRole, id int) string {
+	return fmt.Sprintf("credential:revoked:%s:%d", role, id)
+}
+
+// setRevocation сохраняет отзыв паролей командой client, в транзакции вместе с новым паролем
+func setRevocation(ctx context.Context, client redis.Cmdable, revocation *entity.CredentialRevocation) error {
+	data, err := json.Marshal(revocation)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, revocationKey(revocation.Role, revocation.ID), data, 0).Err()
+}
+
+// watchRotation выполняет смену пароля записи key в транзакции: rotate читает запись в tx и сохраняет её с новым
+// паролем. Если запись изменили во время смены, то смена повторяется с чтения записи, не больше rotationAttempts раз,
+// поэтому две одновременные смены не получат одну и ту же версию пароля
+func watchRotation(ctx context.Context, client *redis.Client, key string, rotate func(tx *redis.Tx) error) error {
+	var err error
+	for i := 0; i < rotationAttempts; i++ {
+		if err = client.Watch(ctx, rotate, key); !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
@@ -50,29 +50,45 @@ func (d DispatcherRepo) GetDispatcher(id int) (*entity.Dispatcher, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
+	dispatcher, err := d.getDispatcher(ctx, d.redisClient, id)
+	switch {
+	case err == nil:
+		return dispatcher, nil
+	case errors.Is(err, repo.ErrDispatcherNotFound):
+		return nil, err
+	default:
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+}
+
+// getDispatcher читает диспетчера командой client, например, в транзакции перед его изменением
+func (d DispatcherRepo) getDispatcher(ctx context.Context, client redis.Cmdable, id int) (*entity.Dispatcher, error) {
 	// получаем сериализованный объект из Redis
-	data, err := d.redisClient.Get(ctx, fmt.Sprintf("dispatcher:%d", id)).Bytes()
+	data, err := client.Get(ctx, fmt.Sprintf("dispatcher:%d", id)).Bytes()
 	switch {
 	case errors.Is(err, redis.Nil):
 		return nil, repo.ErrDispatcherNotFound
 	case err != nil:
-		return nil, errors.Join(repo.ErrInternal, err)
+		return nil, err
 	}
 
 	// десериализуем объект
+	return decodeDispatcher(data)
+}
+
+func decodeDispatcher(data []byte) (*entity.Dispatcher, error) {
 	var dispatcher entity.Dispatcher
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err = decoder.Decode(&dispatcher)
-	if err != nil {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&dispatcher); err != nil {
 		return nil, err
 	}
 	return &dispatcher, nil
 }
 
-func (d DispatcherRepo) AddDispatcher(dispatcher *entity.Dispatcher) error {
+func (d DispatcherRepo) AddDispatcher(dispatcher *entity.Dispatcher, audit *entity.AuditRecord) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
+	var auditCmd *redis.StringCmd
 	err := d.redisClient.Watch(ctx, func(tx *redis.Tx) error {
 		// получаем следующий ID для диспетчера
 		id, err := d.redisClient.Incr(ctx, "dispatcher:id").Result()
@@ -91,10 +107,18 @@ func (d DispatcherRepo) AddDispatcher(dispatcher *entity.Dispatcher) error {
 			return repo.ErrDispatcherAlreadyExists
 		}
 
+		if audit != nil {
+			audit.Target = entity.DispatcherActor(dispatcher.ID)
+		}
+
 		// начинаем транзакцию
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-			// используем вспомогательную функцию для сохранения диспетчера
-			return d.setDispatcher(ctx, pipe, dispatcher)
+			if err := d.setDispatcher(ctx, pipe, dispatcher); err != nil {
+				return err
+			}
+			addToIndex(ctx, pipe, "dispatcher", dispatcher.ID)
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
 		})
 		return err
 	}, fmt.Sprintf("dispatcher:%d", dispatcher.ID))
@@ -102,42 +126,143 @@ func (d DispatcherRepo) AddDispatcher(dispatcher *entity.Dispatcher) error {
 	if err != nil {
 		return errors.Join(repo.ErrInternal, err)
 	}
+	setAuditRecordID(audit, auditCmd)
 	return nil
 }
 
-func (d DispatcherRepo) EditDispatcher(dispatcher *entity.Dispatcher) error {
+func (d DispatcherRepo) EditDispatcher(dispatcher *entity.Dispatcher, audit *entity.AuditRecord) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
+	var auditCmd *redis.StringCmd
 	err := d.redisClient.Watch(ctx, func(tx *redis.Tx) error {
 		// проверяем, существует ли диспетчер
-		exists, err := d.isDispatcherExists(ctx, tx, dispatcher.ID)
+		stored, err := d.getDispatcher(ctx, tx, dispatcher.ID)
 		if err != nil {
 			return err
 		}
-		if !exists {
-			return repo.ErrDispatcherNotFound
-		}
+		// пароль сохраняется из хранилища: его могли сменить после того, как диспетчер был прочитан для изменения
+		dispatcher.PasswordHash = stored.PasswordHash
+		dispatcher.CredentialVersion = stored.CredentialVersion
+		dispatcher.PreviousCredential = stored.PreviousCredential
 
 		// начинаем транзакцию
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-			return d.setDispatcher(ctx, pipe, dispatcher)
+			if err := d.setDispatcher(ctx, pipe, dispatcher); err != nil {
+				return err
+			}
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
 		})
 		return err
 	}, fmt.Sprintf("dispatcher:%d", dispatcher.ID))
 
-	if err != nil {
+	switch {
+	case err == nil:
+		setAuditRecordID(audit, auditCmd)
+		return nil
+	case errors.Is(err, repo.ErrDispatcherNotFound):
+		return err
+	default:
+		return errors.Join(repo.ErrInternal, err)
+	}
+}
+
+func (d DispatcherRepo) RotateDispatcherCredential(id int, rotate func(dispatcher *entity.Dispatcher) (*entity.AuditRecord, error)) error {
+	// новый пароль хэшируется внутри транзакции, поэтому на смену даётся больше времени, чем на другие изменения
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var audit *entity.AuditRecord
+	var auditCmd *redis.StringCmd
+	err := watchRotation(ctx, d.redisClient, fmt.Sprintf("dispatcher:%d", id), func(tx *redis.Tx) error {
+		dispatcher, err := d.getDispatcher(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if audit, err = rotate(dispatcher); err != nil {
+			return err
+		}
+		revocation := entity.NewCredentialRevocation(entity.DispatcherToken, id, dispatcher.CredentialVersion, dispatcher.PreviousCredential)
+
+		// начинаем транзакцию
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := d.setDispatcher(ctx, pipe, dispatcher); err != nil {
+				return err
+			}
+			if err := setRevocation(ctx, pipe, &revocation); err != nil {
+				return err
+			}
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
+		})
+		return err
+	})
+
+	switch {
+	case err == nil:
+		setAuditRecordID(audit, auditCmd)
+		return nil
+	case errors.Is(err, repo.ErrDispatcherNotFound):
+		return err
+	default:
 		return errors.Join(repo.ErrInternal, err)
 	}
-	return nil
 }
 
-func (d DispatcherRepo) DeleteDispatcher(id int) error {
+func (d DispatcherRepo) DeleteDispatcher(id int, audit *entity.AuditRecord) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	_, err := d.redisClient.Del(ctx, fmt.Sprintf("dispatcher:%d", id)).Result()
-	if err != nil {
+
+	var auditCmd *redis.StringCmd
+	err := d.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := d.isDispatcherExists(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return repo.ErrDispatcherNotFound
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := pipe.Del(ctx, fmt.Sprintf("dispatcher:%d", id)).Err(); err != nil {
+				return err
+			}
+			removeFromIndex(ctx, pipe, "dispatcher", id)
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
+		})
+		return err
+	}, fmt.Sprintf("dispatcher:%d", id))
+
+	switch {
+	case err == nil:
+		setAuditRecordID(audit, auditCmd)
+		return nil
+	case errors.Is(err, repo.ErrDispatcherNotFound):
+		return err
+	default:
 		return errors.Join(repo.ErrInternal, err)
 	}
-	return nil
+}
+
+func (d DispatcherRepo) ListDispatchers(filter *entity.DispatcherFilter) ([]entity.Dispatcher, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dispatchers := make([]entity.Dispatcher, 0, filter.Limit)
+	err := listRecords(ctx, d.redisClient, "dispatcher", filter.After, func(data []byte) (bool, error) {
+		dispatcher, err := decodeDispatcher(data)
+		if err != nil {
+			return false, err
+		}
+		if !filter.Match(dispatcher) {
+			return true, nil
+		}
+		dispatchers = append(dispatchers, *dispatcher)
+		return len(dispatchers) < filter.Limit, nil
+	})
+	if err != nil {
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+	return dispatchers, nil
 }
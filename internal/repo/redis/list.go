@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"self-driving-car-dispatch-system/internal/repo"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// scanBatchSize - сколько ключей Redis просматривает за одну итерацию SCAN при построении индекса
+	scanBatchSize = 1000
+	// listBatchSize - сколько записей читается одним MGET при составлении списка
+	listBatchSize = 100
+)
+
+// indexKey возвращает ключ сортированного множества с ID записей <prefix>:<ID>, вес элемента равен ID
+func indexKey(prefix string) string {
+	return prefix + ":index"
+}
+
+// addToIndex добавляет ID записи в индекс. Вызывается в той же транзакции, что и сохранение новой записи
+func addToIndex(ctx context.Context, client redis.Cmdable, prefix string, id int) *redis.IntCmd {
+	return client.ZAdd(ctx, indexKey(prefix), redis.Z{Score: float64(id), Member: id})
+}
+
+// removeFromIndex удаляет ID записи из индекса. Вызывается в той же транзакции, что и удаление записи
+func removeFromIndex(ctx context.Context, client redis.Cmdable, prefix string, id int) *redis.IntCmd {
+	return client.ZRem(ctx, indexKey(prefix), id)
+}
+
+// BuildIndexes строит индексы ТС и диспетчеров по уже сохранённым записям. Записи, созданные до появления индексов,
+// в них не попадали, поэтому при запуске индекс, которого ещё нет, заполняется одним проходом SCAN.
+// Повторный вызов ничего не меняет
+func BuildIndexes(client *redis.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, prefix := range []string{"vehicle", "dispatcher"} {
+		if err := buildIndex(ctx, client, prefix); err != nil {
+			return errors.Join(repo.ErrInternal, err)
+		}
+	}
+	return nil
+}
+
+// buildIndex заполняет индекс записей <prefix>:<ID>, если его ещё нет. Ключи с тем же префиксом,
+// но другого вида (счётчик ID, сертификаты ТС, сам индекс) пропускаются
+func buildIndex(ctx context.Context, client *redis.Client, prefix string) error {
+	n, err := client.Exists(ctx, indexKey(prefix)).Result()
+	if err != nil || n > 0 {
+		return err
+	}
+	members := make([]redis.Z, 0)
+	iter := client.Scan(ctx, 0, prefix+":*", scanBatchSize).Iterator()
+	for iter.Next(ctx) {
+		id, err := strconv.Atoi(strings.TrimPrefix(iter.Val(), prefix+":"))
+		if err != nil || id <= 0 {
+			continue
+		}
+		// SCAN может вернуть один ключ несколько раз, ZADD это допускает
+		members = append(members, redis.Z{Score: float64(id), Member: id})
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return client.ZAdd(ctx, indexKey(prefix), members...).Err()
+}
+
+// listRecords читает записи <prefix>:<ID> с ID больше after по порядку ID и передаёт их в add,
+// пока add возвращает true. ID берутся из индекса страницами по listBatchSize, записи, удалённые между
+// чтением индекса и MGET, пропускаются
+func listRecords(ctx context.Context, client *redis.Client, prefix string, after int, add func(data []byte) (bool, error)) error {
+	for {
+		ids, err := client.ZRangeByScore(ctx, indexKey(prefix), &redis.ZRangeBy{
+			Min:   "(" + strconv.Itoa(after),
+			Max:   "+inf",
+			Count: listBatchSize,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = prefix + ":" + id
+		}
+		values, err := client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			data, ok := value.(string)
+			if !ok {
+				continue
+			}
+			more, err := add([]byte(data))
+			if err != nil {
+				return err
+			}
+			if !more {
+				return nil
+			}
+		}
+		if after, err = strconv.Atoi(ids[len(ids)-1]); err != nil {
+			return err
+		}
+	}
+}
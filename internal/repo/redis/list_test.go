@@ -0,0 +1,128 @@
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/exp/slices"
+	"self-driving-car-dispatch-system/internal/entity"
+	"testing"
+)
+
+// newTestVehicleRepo создаёт хранилище ТС поверх miniredis с vehicles ТС, ID которых идут с 1 по порядку
+func newTestVehicleRepo(t *testing.T, vehicles int) (*VehicleRepo, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	r := &VehicleRepo{redisClient: client}
+	for i := 0; i < vehicles; i++ {
+		vehicle := entity.Vehicle{Status: entity.ActiveVehicle}
+		if i%2 == 1 {
+			vehicle.Status = entity.MaintenanceVehicle
+		}
+		if err := r.AddVehicle(&vehicle, nil); err != nil {
+			t.Fatalf("AddVehicle: %v", err)
+		}
+	}
+	return r, server
+}
+
+// vehicleIDs возвращает ID ТС страницы по порядку
+func vehicleIDs(t *testing.T, r *VehicleRepo, filter entity.VehicleFilter) []int {
+	t.Helper()
+	vehicles, err := r.ListVehicles(&filter)
+	if err != nil {
+		t.Fatalf("ListVehicles: %v", err)
+	}
+	ids := make([]int, 0, len(vehicles))
+	for _, vehicle := range vehicles {
+		ids = append(ids, vehicle.ID)
+	}
+	return ids
+}
+
+func TestListVehiclesPages(t *testing.T) {
+	r, _ := newTestVehicleRepo(t, 5)
+
+	tests := []struct {
+		name   string
+		filter entity.VehicleFilter
+		want   []int
+	}{
+		{name: "first page", filter: entity.VehicleFilter{Limit: 2}, want: []int{1, 2}},
+		{name: "middle page", filter: entity.VehicleFilter{After: 2, Limit: 2}, want: []int{3, 4}},
+		{name: "last page", filter: entity.VehicleFilter{After: 4, Limit: 2}, want: []int{5}},
+		// страница заполнена ровно, и после неё ничего не остаётся
+		{name: "after last", filter: entity.VehicleFilter{After: 5, Limit: 2}, want: []int{}},
+		{name: "after unknown ID", filter: entity.VehicleFilter{After: 100, Limit: 2}, want: []int{}},
+		{name: "filtered", filter: entity.VehicleFilter{Status: entity.MaintenanceVehicle, After: 1, Limit: 5}, want: []int{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vehicleIDs(t, r, tt.filter); !slices.Equal(got, tt.want) {
+				t.Errorf("ListVehicles(after=%d) = %v, want %v", tt.filter.After, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListVehiclesEmptyIndex(t *testing.T) {
+	r, _ := newTestVehicleRepo(t, 0)
+	if got := vehicleIDs(t, r, entity.VehicleFilter{Limit: 10}); len(got) != 0 {
+		t.Errorf("ListVehicles = %v, want empty", got)
+	}
+}
+
+// TestListVehiclesDeletedBetweenPages проверяет, что удаление ТС между страницами не сдвигает и не обрывает список,
+// в том числе когда удалено ТС, после которого начинается следующая страница
+func TestListVehiclesDeletedBetweenPages(t *testing.T) {
+	r, _ := newTestVehicleRepo(t, 5)
+	if got := vehicleIDs(t, r, entity.VehicleFilter{Limit: 2}); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("first page = %v, want [1 2]", got)
+	}
+	for _, id := range []int{2, 3} {
+		if err := r.DeleteVehicle(id, nil); err != nil {
+			t.Fatalf("DeleteVehicle(%d): %v", id, err)
+		}
+	}
+	if got := vehicleIDs(t, r, entity.VehicleFilter{After: 2, Limit: 2}); !slices.Equal(got, []int{4, 5}) {
+		t.Errorf("second page = %v, want [4 5]", got)
+	}
+}
+
+// TestListVehiclesMissingRecord проверяет, что ID, оставшийся в индексе без записи, пропускается, как ТС,
+// удалённое между чтением индекса и MGET
+func TestListVehiclesMissingRecord(t *testing.T) {
+	r, server := newTestVehicleRepo(t, 3)
+	server.Del("vehicle:2")
+	if got := vehicleIDs(t, r, entity.VehicleFilter{Limit: 2}); !slices.Equal(got, []int{1, 3}) {
+		t.Errorf("ListVehicles = %v, want [1 3]", got)
+	}
+}
+
+// TestListVehiclesBatches проверяет, что список продолжается в следующей порции индекса, если фильтр отбросил
+// всю первую порцию
+func TestListVehiclesBatches(t *testing.T) {
+	r, _ := newTestVehicleRepo(t, 2*listBatchSize+1)
+	vehicle := entity.Vehicle{ID: 2*listBatchSize + 1, Status: entity.ActiveVehicle, VehicleInfo: entity.VehicleInfo{Depot: "north"}}
+	if err := r.EditVehicle(&vehicle, nil); err != nil {
+		t.Fatalf("EditVehicle: %v", err)
+	}
+	got := vehicleIDs(t, r, entity.VehicleFilter{Depot: "north", Limit: 10})
+	if !slices.Equal(got, []int{vehicle.ID}) {
+		t.Errorf("ListVehicles(depot) = %v, want [%d]", got, vehicle.ID)
+	}
+}
+
+func TestBuildIndexes(t *testing.T) {
+	r, server := newTestVehicleRepo(t, 3)
+	// записи, сохранённые до появления индекса
+	server.Del(indexKey("vehicle"))
+	if err := BuildIndexes(r.redisClient); err != nil {
+		t.Fatalf("BuildIndexes: %v", err)
+	}
+	if got := vehicleIDs(t, r, entity.VehicleFilter{Limit: 10}); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("ListVehicles after BuildIndexes = %v, want [1 2 3]", got)
+	}
+}
@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"time"
+)
+
+// revocationChannel - канал Redis Pub/Sub для сообщений об отзыве паролей
+const revocationChannel = "credentials:revoked"
+
+type RevocationRepo struct {
+	redisClient *redis.Client
+}
+
+func NewRevocationRepo(client *redis.Client) repo.RevocationRepo {
+	return &RevocationRepo{
+		redisClient: client,
+	}
+}
+
+func (r RevocationRepo) PublishRevocation(revocation *entity.CredentialRevocation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	data, err := json.Marshal(revocation)
+	if err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	if err = r.redisClient.Publish(ctx, revocationChannel, data).Err(); err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	return nil
+}
+
+func (r RevocationRepo) GetRevocation(role entity.TokenRole, id int) (*entity.CredentialRevocation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	revocation := entity.CredentialRevocation{Role: role, ID: id}
+	data, err := r.redisClient.Get(ctx, revocationKey(role, id)).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+		// пароль ни разу не меняли
+		return &revocation, nil
+	case err != nil:
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+	if err = json.Unmarshal(data, &revocation); err != nil {
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+	return &revocation, nil
+}
+
+func (r RevocationRepo) SubscribeRevocations(ctx context.Context) <-chan entity.CredentialRevocation {
+	revocations := make(chan entity.CredentialRevocation)
+	// после разрыва соединения с Redis подписка восстанавливается сама
+	pubsub := r.redisClient.Subscribe(ctx, revocationChannel)
+	go func() {
+		defer close(revocations)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var revocation entity.CredentialRevocation
+				if err := json.Unmarshal([]byte(message.Payload), &revocation); err != nil {
+					continue
+				}
+				select {
+				case revocations <- revocation:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return revocations
+}
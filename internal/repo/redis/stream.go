@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/exp/slices"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"strings"
+	"time"
+)
+
+// streamHealthTTL - через сколько состояние потоков ТС удаляется, если сервер ретрансляции перестал его обновлять
+const streamHealthTTL = 30 * time.Second
+
+type StreamHealthRepo struct {
+	redisClient *redis.Client
+}
+
+func NewStreamHealthRepo(client *redis.Client) repo.StreamHealthRepo {
+	return &StreamHealthRepo{
+		redisClient: client,
+	}
+}
+
+// Состояние потоков ТС хранится в хэше: поле - название потока, значение - сериализованный entity.StreamStatus
+func streamHealthKey(vehicleID int) string {
+	return fmt.Sprintf("vehicle:%d:streams", vehicleID)
+}
+
+func (s StreamHealthRepo) SetStreamStatus(vehicleID int, status *entity.StreamStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(*status); err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	key := streamHealthKey(vehicleID)
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, status.Stream, buffer.Bytes())
+		pipe.Expire(ctx, key, streamHealthTTL)
+		return nil
+	})
+	if err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	return nil
+}
+
+func (s StreamHealthRepo) DeleteStreamStatus(vehicleID int, stream string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.redisClient.HDel(ctx, streamHealthKey(vehicleID), stream).Err(); err != nil {
+		return errors.Join(repo.ErrInternal, err)
+	}
+	return nil
+}
+
+func (s StreamHealthRepo) GetStreamStatuses(vehicleID int) ([]entity.StreamStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	fields, err := s.redisClient.HGetAll(ctx, streamHealthKey(vehicleID)).Result()
+	if err != nil {
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+	statuses := make([]entity.StreamStatus, 0, len(fields))
+	for _, data := range fields {
+		var status entity.StreamStatus
+		if err = gob.NewDecoder(bytes.NewReader([]byte(data))).Decode(&status); err != nil {
+			return nil, errors.Join(repo.ErrInternal, err)
+		}
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b entity.StreamStatus) int {
+		return strings.Compare(a.Stream, b.Stream)
+	})
+	return statuses, nil
+}
@@ -50,29 +50,49 @@ func (d VehicleRepo) GetVehicle(id int) (*entity.Vehicle, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
+	vehicle, err := d.getVehicle(ctx, d.redisClient, id)
+	switch {
+	case err == nil:
+		return vehicle, nil
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return nil, err
+	default:
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+}
+
+// getVehicle читает ТС командой client, например, в транзакции перед его изменением
+func (d VehicleRepo) getVehicle(ctx context.Context, client redis.Cmdable, id int) (*entity.Vehicle, error) {
 	// получаем сериализованный объект из Redis
-	data, err := d.redisClient.Get(ctx, fmt.Sprintf("vehicle:%d", id)).Bytes()
+	data, err := client.Get(ctx, fmt.Sprintf("vehicle:%d", id)).Bytes()
 	switch {
 	case errors.Is(err, redis.Nil):
 		return nil, repo.ErrVehicleNotFound
 	case err != nil:
-		return nil, errors.Join(repo.ErrInternal, err)
+		return nil, err
 	}
 
 	// десериализуем объект
+	return decodeVehicle(data)
+}
+
+// decodeVehicle десериализует ТС. ТС, сохранённые до появления статусов, считаются действующими
+func decodeVehicle(data []byte) (*entity.Vehicle, error) {
 	var vehicle entity.Vehicle
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err = decoder.Decode(&vehicle)
-	if err != nil {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&vehicle); err != nil {
 		return nil, err
 	}
+	if vehicle.Status == "" {
+		vehicle.Status = entity.ActiveVehicle
+	}
 	return &vehicle, nil
 }
 
-func (d VehicleRepo) AddVehicle(vehicle *entity.Vehicle) error {
+func (d VehicleRepo) AddVehicle(vehicle *entity.Vehicle, audit *entity.AuditRecord) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
+	var auditCmd *redis.StringCmd
 	err := d.redisClient.Watch(ctx, func(tx *redis.Tx) error {
 		// получаем следующий ID для ТС
 		id, err := d.redisClient.Incr(ctx, "vehicle:id").Result()
@@ -91,10 +111,18 @@ func (d VehicleRepo) AddVehicle(vehicle *entity.Vehicle) error {
 			return repo.ErrVehicleAlreadyExists
 		}
 
+		if audit != nil {
+			audit.Target = entity.VehicleActor(vehicle.ID)
+		}
+
 		// начинаем транзакцию
 		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-			// используем вспомогательную функцию для сохранения диспетчера
-			return d.setVehicle(ctx, pipe, vehicle)
+			if err := d.setVehicle(ctx, pipe, vehicle); err != nil {
+				return err
+			}
+			addToIndex(ctx, pipe, "vehicle", vehicle.ID)
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
 		})
 		return err
 	}, fmt.Sprintf("vehicle:%d", vehicle.ID))
@@ -102,15 +130,143 @@ func (d VehicleRepo) AddVehicle(vehicle *entity.Vehicle) error {
 	if err != nil {
 		return errors.Join(repo.ErrInternal, err)
 	}
+	setAuditRecordID(audit, auditCmd)
 	return nil
 }
 
-func (d VehicleRepo) DeleteVehicle(id int) error {
+func (d VehicleRepo) EditVehicle(vehicle *entity.Vehicle, audit *entity.AuditRecord) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	_, err := d.redisClient.Del(ctx, fmt.Sprintf("vehicle:%d", id)).Result()
-	if err != nil {
+
+	var auditCmd *redis.StringCmd
+	err := d.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		// проверяем, существует ли ТС
+		stored, err := d.getVehicle(ctx, tx, vehicle.ID)
+		if err != nil {
+			return err
+		}
+		// пароль сохраняется из хранилища: его могли сменить после того, как ТС было прочитано для изменения
+		vehicle.PasswordHash = stored.PasswordHash
+		vehicle.CredentialVersion = stored.CredentialVersion
+		vehicle.PreviousCredential = stored.PreviousCredential
+
+		// начинаем транзакцию
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := d.setVehicle(ctx, pipe, vehicle); err != nil {
+				return err
+			}
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
+		})
+		return err
+	}, fmt.Sprintf("vehicle:%d", vehicle.ID))
+
+	switch {
+	case err == nil:
+		setAuditRecordID(audit, auditCmd)
+		return nil
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return err
+	default:
 		return errors.Join(repo.ErrInternal, err)
 	}
-	return nil
+}
+
+func (d VehicleRepo) RotateVehicleCredential(id int, rotate func(vehicle *entity.Vehicle) (*entity.AuditRecord, error)) error {
+	// новый пароль хэшируется внутри транзакции, поэтому на смену даётся больше времени, чем на другие изменения
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var audit *entity.AuditRecord
+	var auditCmd *redis.StringCmd
+	err := watchRotation(ctx, d.redisClient, fmt.Sprintf("vehicle:%d", id), func(tx *redis.Tx) error {
+		vehicle, err := d.getVehicle(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if audit, err = rotate(vehicle); err != nil {
+			return err
+		}
+		revocation := entity.NewCredentialRevocation(entity.VehicleToken, id, vehicle.CredentialVersion, vehicle.PreviousCredential)
+
+		// начинаем транзакцию
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := d.setVehicle(ctx, pipe, vehicle); err != nil {
+				return err
+			}
+			if err := setRevocation(ctx, pipe, &revocation); err != nil {
+				return err
+			}
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
+		})
+		return err
+	})
+
+	switch {
+	case err == nil:
+		setAuditRecordID(audit, auditCmd)
+		return nil
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return err
+	default:
+		return errors.Join(repo.ErrInternal, err)
+	}
+}
+
+func (d VehicleRepo) DeleteVehicle(id int, audit *entity.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var auditCmd *redis.StringCmd
+	err := d.redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := d.isVehicleExists(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return repo.ErrVehicleNotFound
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := pipe.Del(ctx, fmt.Sprintf("vehicle:%d", id)).Err(); err != nil {
+				return err
+			}
+			removeFromIndex(ctx, pipe, "vehicle", id)
+			auditCmd = addAuditRecord(ctx, pipe, audit)
+			return nil
+		})
+		return err
+	}, fmt.Sprintf("vehicle:%d", id))
+
+	switch {
+	case err == nil:
+		setAuditRecordID(audit, auditCmd)
+		return nil
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return err
+	default:
+		return errors.Join(repo.ErrInternal, err)
+	}
+}
+
+func (d VehicleRepo) ListVehicles(filter *entity.VehicleFilter) ([]entity.Vehicle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	vehicles := make([]entity.Vehicle, 0, filter.Limit)
+	err := listRecords(ctx, d.redisClient, "vehicle", filter.After, func(data []byte) (bool, error) {
+		vehicle, err := decodeVehicle(data)
+		if err != nil {
+			return false, err
+		}
+		if !filter.Match(vehicle) {
+			return true, nil
+		}
+		vehicles = append(vehicles, *vehicle)
+		return len(vehicles) < filter.Limit, nil
+	})
+	if err != nil {
+		return nil, errors.Join(repo.ErrInternal, err)
+	}
+	return vehicles, nil
 }
@@ -0,0 +1,18 @@
+package repo
+
+import (
+	"context"
+	"self-driving-car-dispatch-system/internal/entity"
+)
+
+// RevocationRepo хранит отзывы паролей и передаёт серверу ретрансляции сообщения о них, об отзыве сертификатов ТС
+// и об изменении ТС и диспетчеров, чтобы он сразу отключил сессии, которые вошли с отозванным паролем
+// или сертификатом или потеряли доступ. Отзыв сохраняется вместе с новым паролем в VehicleRepo и DispatcherRepo,
+// а сообщения не хранятся: сервер получает только те, что отправлены, пока он подписан
+type RevocationRepo interface {
+	// GetRevocation возвращает отзыв паролей ТС или диспетчера. Если пароль не меняли, то отзыв пустой
+	GetRevocation(role entity.TokenRole, id int) (*entity.CredentialRevocation, error)
+	PublishRevocation(revocation *entity.CredentialRevocation) error
+	// SubscribeRevocations передаёт сообщения об отзыве в канал, пока не отменён ctx
+	SubscribeRevocations(ctx context.Context) <-chan entity.CredentialRevocation
+}
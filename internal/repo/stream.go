@@ -0,0 +1,13 @@
+package repo
+
+import (
+	"self-driving-car-dispatch-system/internal/entity"
+)
+
+// StreamHealthRepo хранит состояние потоков подключённых ТС, чтобы его видел сервер администратора.
+// Записи обновляет сервер ретрансляции; если он перестал их обновлять, то они устаревают и удаляются
+type StreamHealthRepo interface {
+	SetStreamStatus(vehicleID int, status *entity.StreamStatus) error
+	DeleteStreamStatus(vehicleID int, stream string) error
+	GetStreamStatuses(vehicleID int) ([]entity.StreamStatus, error)
+}
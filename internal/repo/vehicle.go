@@ -4,8 +4,20 @@ import (
 	"self-driving-car-dispatch-system/internal/entity"
 )
 
+// VehicleRepo хранит ТС. Изменяющие методы принимают запись журнала аудита, которая сохраняется в той же
+// транзакции, что и изменение, поэтому изменение без записи в журнале невозможно. Если запись nil, то
+// изменение в журнал не попадает
 type VehicleRepo interface {
 	GetVehicle(id int) (*entity.Vehicle, error)
-	AddVehicle(vehicle *entity.Vehicle) error
-	DeleteVehicle(id int) error
+	// AddVehicle заполняет ID ТС и Target записи журнала аудита
+	AddVehicle(vehicle *entity.Vehicle, audit *entity.AuditRecord) error
+	// EditVehicle не меняет пароль: он меняется только RotateVehicleCredential
+	EditVehicle(vehicle *entity.Vehicle, audit *entity.AuditRecord) error
+	// RotateVehicleCredential меняет пароль в одной транзакции с чтением записи: rotate меняет пароль в прочитанной записи
+	// и возвращает запись журнала аудита. Вместе с паролем сохраняется отзыв прежних паролей для RevocationRepo.
+	// Если запись изменили во время смены, то rotate вызывается снова с новым содержимым записи
+	RotateVehicleCredential(id int, rotate func(vehicle *entity.Vehicle) (*entity.AuditRecord, error)) error
+	DeleteVehicle(id int, audit *entity.AuditRecord) error
+	// ListVehicles возвращает не больше filter.Limit ТС с ID больше filter.After, упорядоченных по ID
+	ListVehicles(filter *entity.VehicleFilter) ([]entity.Vehicle, error)
 }
@@ -4,13 +4,45 @@ import (
 	"self-driving-car-dispatch-system/internal/entity"
 )
 
+// AdminUsecase - методы администратора. Каждый метод, кроме Login, принимает токен администратора
+// и проверяет, что роль администратора разрешает действие: иначе возвращается ErrUnauthorized или ErrAccessDenied
 type AdminUsecase interface {
-	GetDispatcher(secret string, id int) (*entity.GetDispatcherResponse, error)
-	AddDispatcher(secret string, dispatcher *entity.AddDispatcherRequest) (*entity.Dispatcher, error)
-	EditDispatcher(secret string, dispatcher *entity.EditDispatcherRequest) error
-	DeleteDispatcher(secret string, id int) error
+	// Login проверяет логин и пароль администратора и выдаёт токен
+	Login(request *entity.AdminLoginRequest) (*entity.AdminLoginResponse, error)
+	GetAdmin(token string, login string) (*entity.GetAdminResponse, error)
+	AddAdmin(token string, admin *entity.AddAdminRequest) (*entity.Admin, error)
+	EditAdmin(token string, admin *entity.EditAdminRequest) error
+	// DeleteAdmin удаляет учётную запись администратора. Свою учётную запись удалить нельзя
+	DeleteAdmin(token string, login string) error
 
-	GetVehicle(secret string, id int) (*entity.GetVehicleResponse, error)
-	AddVehicle(secret string, dispatcher *entity.AddVehicleRequest) (*entity.Vehicle, error)
-	DeleteVehicle(secret string, id int) error
+	GetDispatcher(token string, id int) (*entity.GetDispatcherResponse, error)
+	AddDispatcher(token string, dispatcher *entity.AddDispatcherRequest) (*entity.Dispatcher, error)
+	EditDispatcher(token string, dispatcher *entity.EditDispatcherRequest) error
+	DeleteDispatcher(token string, id int) error
+	// ListDispatchers возвращает страницу списка диспетчеров, подходящих под фильтр
+	ListDispatchers(token string, filter *entity.DispatcherFilter) (*entity.ListDispatchersResponse, error)
+
+	GetVehicle(token string, id int) (*entity.GetVehicleResponse, error)
+	AddVehicle(token string, dispatcher *entity.AddVehicleRequest) (*entity.Vehicle, error)
+	// EditVehicle заменяет описание и статус ТС. ТС не в статусе active не может подключиться к серверу ретрансляции
+	EditVehicle(token string, vehicle *entity.EditVehicleRequest) error
+	DeleteVehicle(token string, id int) error
+	// ListVehicles возвращает страницу списка ТС
+	ListVehicles(token string, filter *entity.VehicleFilter) (*entity.ListVehiclesResponse, error)
+	// GetVehicleStreams возвращает состояние потоков ТС по данным сервера ретрансляции
+	GetVehicleStreams(token string, id int) (*entity.StreamHealth, error)
+	// IssueVehicleCertificate выпускает клиентский сертификат ТС, с которым оно подключается к серверу ретрансляции
+	// без пароля. Закрытый ключ возвращается только здесь и не хранится
+	IssueVehicleCertificate(token string, id int, request *entity.IssueCertificateRequest) (*entity.IssueCertificateResponse, error)
+	// GetVehicleCertificates возвращает сертификаты, выпущенные для ТС, в том числе отозванные
+	GetVehicleCertificates(token string, id int) ([]entity.VehicleCertificate, error)
+	// RevokeVehicleCertificate отзывает сертификат ТС: сервер ретрансляции больше не принимает подключения с ним
+	RevokeVehicleCertificate(token string, id int, serial string) error
+	// RotateVehicleCredential и RotateDispatcherCredential заменяют пароль новым, созданным сервером. Новый пароль
+	// возвращается только здесь. Сессии с прежним паролем отключаются сразу или после перекрытия из request
+	RotateVehicleCredential(token string, id int, request *entity.RotateCredentialRequest) (*entity.RotateCredentialResponse, error)
+	RotateDispatcherCredential(token string, id int, request *entity.RotateCredentialRequest) (*entity.RotateCredentialResponse, error)
+
+	// GetAudit возвращает записи журнала аудита, подходящие под фильтр, от новых к старым
+	GetAudit(token string, filter *entity.AuditFilter) ([]entity.AuditRecord, error)
 }
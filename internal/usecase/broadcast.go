@@ -1,12 +1,71 @@
 package usecase
 
+import (
+	"context"
+	"crypto/x509"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/pkg/protocol"
+)
+
+// BroadcastUsecase ретранслирует потоки ТС диспетчерам. Все методы работают до тех пор, пока не будет отменён ctx
+// (обычно это контекст QUIC-соединения) или пока не произойдёт ошибка, которая передаётся в errChan.
+// Вместо пароля ТС или диспетчера методы принимают токен сессии, выданный LoginVehicle или LoginDispatcher:
+// токен проверяется без обращения к хранилищу и без bcrypt
 type BroadcastUsecase interface {
-	// GetVideoStream передает видеопоток с камеры ТС в поток передачи диспетчеру
-	GetVideoStream(vehicleID, dispatcherID int, dispatcherPassword string, stream chan []byte, errChan chan error)
-	// GetInfoStream передает информацию из потока ТС в поток передачи диспетчеру
-	GetInfoStream(vehicleID, dispatcherID int, dispatcherPassword string, stream chan []byte, errChan chan error)
-	// SendVideoStream отправляет видеопоток с камеры ТС в канал
-	SendVideoStream(vehicleID int, vehiclePassword string, stream chan []byte, errChan chan error)
-	// SendInfoStream отправляет информационный поток с ТС в канал
-	SendInfoStream(vehicleID int, vehiclePassword string, stream chan []byte, errChan chan error)
+	// LoginVehicle проверяет пароль или действующий токен ТС и выдаёт новый токен сессии.
+	// Передав действующий токен, ТС продлевает его без пароля
+	LoginVehicle(vehicleID int, vehicleCredential string) (*entity.SessionToken, error)
+	// LoginVehicleCertificate выдаёт токен сессии ТС, которое предъявило клиентский сертификат при рукопожатии TLS.
+	// Сертификат должен быть выпущен для этого ТС и не отозван
+	LoginVehicleCertificate(vehicleID int, certificate *x509.Certificate) (*entity.SessionToken, error)
+	// LoginDispatcher проверяет пароль или действующий токен диспетчера и выдаёт новый токен сессии.
+	// Права диспетчера хранятся на сервере и перечитываются из хранилища при каждом продлении
+	LoginDispatcher(dispatcherID int, dispatcherCredential string) (*entity.SessionToken, error)
+	// AuthDispatcher проверяет учётные данные диспетчера и его доступ к ТС при подключении или подписке на ТС.
+	// Если vehicleID = 0, то проверяются только учётные данные: диспетчер подключается без ТС
+	AuthDispatcher(vehicleID, dispatcherID int, dispatcherPassword string) error
+	// VehicleSession держит сессию ТС, пока открыто его соединение. Если ТС переподключится в течение
+	// грейс-периода, то диспетчеры продолжат получать его трансляции, cameras - камеры, с которых ТС ведёт трансляцию
+	VehicleSession(ctx context.Context, vehicleID int, vehiclePassword string, cameras []string, errChan chan error)
+	// DispatcherSession отмечает в журнале аудита подключение диспетчера и его отключение, когда закрывается соединение.
+	// vehicleID - ТС из приветствия диспетчера, 0 - диспетчер подключился без ТС
+	DispatcherSession(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, errChan chan error)
+	// GetVideoStream передает видеопоток с камеры ТС в поток передачи диспетчеру. Номер слоя качества
+	// из layerChan применяется со следующего ключевого кадра, до первого номера передаётся лучший слой.
+	// Когда ТС переподключается, диспетчер переходит на новую трансляцию, а после конца сессии ТС
+	// в errChan передаётся usecase.ErrStreamClosed
+	GetVideoStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, camera string, layerChan chan int, stream chan []byte, errChan chan error)
+	// GetInfoStream передает информацию из потока ТС в поток передачи диспетчеру. Телеметрия передается
+	// в формате encoding независимо от того, в каком формате её отправляет ТС. Переподключения ТС
+	// обрабатываются так же, как в GetVideoStream
+	GetInfoStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, encoding protocol.Encoding, stream chan []byte, errChan chan error)
+	// GetVideoCameras возвращает камеры ТС, с которых сейчас ведётся трансляция или, пока ТС переподключается,
+	// велась в его последнем соединении
+	GetVideoCameras(vehicleID int) []string
+	// GetVideoLayers возвращает число слоёв качества видео камеры ТС или 0, если с неё не ведётся трансляция
+	GetVideoLayers(vehicleID int, camera string) int
+	// SendVideoStream отправляет видеопоток с камеры ТС в канал, по каналу на каждый слой качества начиная с лучшего
+	SendVideoStream(ctx context.Context, vehicleID int, vehiclePassword string, camera string, streams []chan []byte, errChan chan error)
+	// SendInfoStream отправляет информационный поток с ТС в канал. Поток состоит из кадров с сообщениями
+	// entity.Telemetry в формате encoding, stream передает фрагменты потока в том виде, в котором они были прочитаны.
+	// Некорректные сообщения отбрасываются, значения вне допустимого диапазона помечаются в Telemetry.Flags
+	SendInfoStream(ctx context.Context, vehicleID int, vehiclePassword string, encoding protocol.Encoding, stream chan []byte, errChan chan error)
+	// GetTelemetryStats возвращает счётчики кадров телеметрии ТС
+	GetTelemetryStats(vehicleID int) entity.TelemetryStats
+	// SendCommandStream передает команды телеуправления от диспетчера на ТС, подтверждения от ТС приходят в acks
+	SendCommandStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, commands chan entity.Command, acks chan entity.CommandAck, errChan chan error)
+	// GetCommandStream передает команды диспетчеров в поток ТС и возвращает диспетчерам подтверждения из acks
+	GetCommandStream(ctx context.Context, vehicleID int, vehiclePassword string, commands chan entity.Command, acks chan entity.CommandAck, errChan chan error)
+	// GetEventStream передает диспетчеру события о потоках ТС: данные перестали приходить (entity.StreamStale),
+	// не приходят так долго, что поток считается потерянным (entity.StreamLost), или снова приходят.
+	// Также передаются события сессии ТС: соединение потеряно, ТС переподключилось или сессия закрыта
+	GetEventStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, events chan entity.StreamEvent, errChan chan error)
+	// LeaseStream обрабатывает запросы диспетчера на управление ТС и сообщает ему текущее состояние аренды.
+	// Управление арендует соединение: отправлять команды (кроме экстренной остановки) может только SendCommandStream
+	// с тем же ctx, что и у LeaseStream, в котором арендовано управление
+	LeaseStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, requests chan entity.LeaseRequest, states chan entity.LeaseState, errChan chan error)
+	// GetFleetStream передает диспетчеру обзор ТС, к которым у него есть доступ: состояние подключения, последнюю
+	// телеметрию, диспетчеров, которые получают трансляции ТС, и того, кто им управляет. Обзор передаётся при
+	// изменении трансляций, сессий ТС и аренды управления, а также периодически, чтобы обновлялась телеметрия
+	GetFleetStream(ctx context.Context, dispatcherID int, dispatcherPassword string, states chan entity.FleetStatus, errChan chan error)
 }
@@ -11,4 +11,15 @@ var (
 	ErrInternal                = errors.New("internal error")
 	ErrBadRequest              = errors.New("bad request")
 	ErrNotFound                = errors.New("not found")
+	ErrStreamClosed            = errors.New("stream closed")
+	ErrLeaseHeld               = errors.New("vehicle is controlled by another dispatcher")
+	ErrNotLeaseHolder          = errors.New("dispatcher does not control the vehicle")
+	ErrCertificateNotFound     = errors.New("certificate not found")
+	ErrNotConfigured           = errors.New("feature is not configured")
+	ErrUnauthorized            = errors.New("unauthorized")
+	ErrAdminNotFound           = errors.New("admin not found")
+	ErrAdminAlreadyExists      = errors.New("admin already exists")
+	ErrVehicleInactive         = errors.New("vehicle is not in active status")
+	ErrCredentialRevoked       = errors.New("credential revoked")
+	ErrAckOverflow             = errors.New("dispatcher does not read command acknowledgements")
 )
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/password"
+)
+
+// BootstrapAdmin создаёт учётную запись суперадминистратора login, если её ещё нет.
+// Без неё в пустом хранилище некому создавать остальных администраторов
+func BootstrapAdmin(adminRepo repo.AdminRepo, login, adminPassword string) (bool, error) {
+	_, err := adminRepo.GetAdmin(login)
+	switch {
+	case err == nil:
+		return false, nil
+	case !errors.Is(err, repo.ErrAdminNotFound):
+		return false, err
+	}
+	passwordHash, err := password.HashPassword(adminPassword)
+	if err != nil {
+		return false, err
+	}
+	err = adminRepo.AddAdmin(&entity.Admin{Login: login, Role: entity.SuperAdminRole, PasswordHash: passwordHash}, nil)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, repo.ErrAdminAlreadyExists):
+		// учётную запись одновременно создал другой экземпляр сервиса
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// authorize возвращает администратора, которому выдан token, если его роль разрешает permission.
+// Роль читается из хранилища, поэтому изменение роли или удаление администратора действует сразу
+func (a AdminService) authorize(token string, permission entity.AdminPermission) (*entity.Admin, error) {
+	claims, ok := a.tokens.parse(token, entity.AdminToken)
+	if !ok {
+		return nil, usecase.ErrUnauthorized
+	}
+	admin, err := a.adminRepo.GetAdmin(claims.Login)
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrAdminNotFound):
+		return nil, usecase.ErrUnauthorized
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	if !admin.Role.Can(permission) {
+		return nil, usecase.ErrAccessDenied
+	}
+	return admin, nil
+}
+
+// dummyPasswordHash - хеш bcrypt с той же сложностью, что и у паролей администраторов. С ним сравнивается пароль
+// при входе с несуществующим логином, чтобы по времени ответа нельзя было узнать, какие логины существуют
+const dummyPasswordHash = "JDJhJDEwJFY2SXJocTFycktuN0Zvck00VUtrdmVKVEU3Wk8xNTgzUHpCSkJZcmxBTnNhTFZ2cmpNZHZL"
+
+func (a AdminService) Login(request *entity.AdminLoginRequest) (*entity.AdminLoginResponse, error) {
+	admin, err := a.adminRepo.GetAdmin(request.Login)
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrAdminNotFound):
+		_ = password.CheckPassword(request.Password, dummyPasswordHash)
+		return nil, usecase.ErrUnauthorized
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	if !password.CheckPassword(request.Password, admin.PasswordHash) {
+		// неудачный вход только отмечается в журнале: в ответ в любом случае отказ
+		_ = a.audit.record(entity.AdminActor(admin.Login), entity.AdminLoginFailedAudit, "", "")
+		return nil, usecase.ErrUnauthorized
+	}
+	session, err := a.tokens.issue(entity.TokenClaims{Role: entity.AdminToken, Login: admin.Login})
+	if err != nil {
+		return nil, err
+	}
+	if err = a.auditAdmin(admin, entity.AdminLoginAudit, "", ""); err != nil {
+		return nil, err
+	}
+	return &entity.AdminLoginResponse{Token: session.Token, ExpiresAt: session.ExpiresAt}, nil
+}
+
+func (a AdminService) GetAdmin(token string, login string) (*entity.GetAdminResponse, error) {
+	if _, err := a.authorize(token, entity.ManageAdminsPermission); err != nil {
+		return nil, err
+	}
+	admin, err := a.adminRepo.GetAdmin(login)
+	switch {
+	case err == nil:
+		return &entity.GetAdminResponse{
+			Login: admin.Login,
+			Role:  admin.Role,
+		}, nil
+	case errors.Is(err, repo.ErrAdminNotFound):
+		return nil, usecase.ErrAdminNotFound
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+}
+
+func (a AdminService) AddAdmin(token string, adminRequest *entity.AddAdminRequest) (*entity.Admin, error) {
+	caller, err := a.authorize(token, entity.ManageAdminsPermission)
+	if err != nil {
+		return nil, err
+	}
+	if !entity.IsAdminRoleValid(adminRequest.Role) {
+		return nil, errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid admin role"))
+	}
+	passwordHash, err := password.HashPassword(adminRequest.Password)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrBadRequest, fmt.Errorf("failed to hash password: %w", err))
+	}
+	admin := &entity.Admin{
+		Login:        adminRequest.Login,
+		Role:         adminRequest.Role,
+		PasswordHash: passwordHash,
+	}
+	err = a.adminRepo.AddAdmin(admin, adminRecord(caller, entity.AdminAddAudit, entity.AdminActor(admin.Login), "role="+string(admin.Role)))
+	switch {
+	case err == nil:
+		return admin, nil
+	case errors.Is(err, repo.ErrAdminAlreadyExists):
+		return nil, usecase.ErrAdminAlreadyExists
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+}
+
+func (a AdminService) EditAdmin(token string, adminRequest *entity.EditAdminRequest) error {
+	caller, err := a.authorize(token, entity.ManageAdminsPermission)
+	if err != nil {
+		return err
+	}
+	if !entity.IsAdminRoleValid(adminRequest.Role) {
+		return errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid admin role"))
+	}
+	// суперадминистратор не может понизить сам себя, иначе в системе может не остаться суперадминистраторов
+	if adminRequest.Login == caller.Login && adminRequest.Role != caller.Role {
+		return errors.Join(usecase.ErrBadRequest, fmt.Errorf("admin cannot change own role"))
+	}
+	admin, err := a.adminRepo.GetAdmin(adminRequest.Login)
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrAdminNotFound):
+		return usecase.ErrAdminNotFound
+	default:
+		return errors.Join(usecase.ErrInternal, err)
+	}
+	details := fmt.Sprintf("role=%s -> role=%s", admin.Role, adminRequest.Role)
+	admin.Role = adminRequest.Role
+	if adminRequest.Password != "" {
+		details += " password changed"
+		if admin.PasswordHash, err = password.HashPassword(adminRequest.Password); err != nil {
+			return errors.Join(usecase.ErrBadRequest, fmt.Errorf("failed to hash password: %w", err))
+		}
+	}
+	err = a.adminRepo.EditAdmin(admin, adminRecord(caller, entity.AdminEditAudit, entity.AdminActor(admin.Login), details))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, repo.ErrAdminNotFound):
+		return usecase.ErrAdminNotFound
+	default:
+		return errors.Join(usecase.ErrInternal, err)
+	}
+}
+
+func (a AdminService) DeleteAdmin(token string, login string) error {
+	caller, err := a.authorize(token, entity.ManageAdminsPermission)
+	if err != nil {
+		return err
+	}
+	if login == caller.Login {
+		return errors.Join(usecase.ErrBadRequest, fmt.Errorf("admin cannot delete own account"))
+	}
+	err = a.adminRepo.DeleteAdmin(login, adminRecord(caller, entity.AdminDeleteAudit, entity.AdminActor(login), ""))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, repo.ErrAdminNotFound):
+		return usecase.ErrAdminNotFound
+	default:
+		return errors.Join(usecase.ErrInternal, err)
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"encoding/base64"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+// TestDummyPasswordHash проверяет, что проверка пароля несуществующего администратора занимает столько же,
+// сколько и существующего: хеш должен быть настоящим хешем bcrypt со сложностью как у password.HashPassword
+func TestDummyPasswordHash(t *testing.T) {
+	hash, err := base64.StdEncoding.DecodeString(dummyPasswordHash)
+	if err != nil {
+		t.Fatalf("dummyPasswordHash is not base64: %v", err)
+	}
+	cost, err := bcrypt.Cost(hash)
+	if err != nil {
+		t.Fatalf("dummyPasswordHash is not bcrypt hash: %v", err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("dummyPasswordHash cost = %d, want %d", cost, bcrypt.DefaultCost)
+	}
+}
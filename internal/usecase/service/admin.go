@@ -7,25 +7,72 @@ import (
 	"self-driving-car-dispatch-system/internal/repo"
 	"self-driving-car-dispatch-system/internal/usecase"
 	"self-driving-car-dispatch-system/pkg/password"
+	"self-driving-car-dispatch-system/pkg/pki"
+	"time"
+)
+
+const (
+	// defaultPageLimit и maxPageLimit - размер страницы списка по умолчанию и наибольший
+	defaultPageLimit = 50
+	maxPageLimit     = 500
 )
 
 type AdminService struct {
-	vehicleRepo    repo.VehicleRepo
-	dispatcherRepo repo.DispatcherRepo
-	secretKey      string
+	vehicleRepo      repo.VehicleRepo
+	dispatcherRepo   repo.DispatcherRepo
+	streamHealthRepo repo.StreamHealthRepo
+	certificateRepo  repo.CertificateRepo
+	adminRepo        repo.AdminRepo
+	revocationRepo   repo.RevocationRepo
+	audit            *auditTrail
+	// ca выпускает сертификаты ТС, nil - сертификаты не настроены
+	ca             *pki.CA
+	certificateTTL time.Duration
+	tokens         *tokenIssuer
 }
 
-func NewAdminService(vehicleRepo repo.VehicleRepo, dispatcherRepo repo.DispatcherRepo, secret string) usecase.AdminUsecase {
+// NewAdminService создаёт сервис администратора. Если ca не nil, то администратор может выпускать для ТС
+// клиентские сертификаты, по умолчанию действующие certificateTTL. Токены администраторов подписываются
+// ключом tokenKey и действуют tokenTTL. После смены пароля ТС или диспетчера, отзыва сертификата ТС и изменения
+// доступа сервер ретрансляции получает отзыв через revocationRepo
+func NewAdminService(vehicleRepo repo.VehicleRepo, dispatcherRepo repo.DispatcherRepo, streamHealthRepo repo.StreamHealthRepo, certificateRepo repo.CertificateRepo, adminRepo repo.AdminRepo, auditRepo repo.AuditRepo, revocationRepo repo.RevocationRepo, ca *pki.CA, certificateTTL time.Duration, tokenKey []byte, tokenTTL time.Duration) usecase.AdminUsecase {
 	return &AdminService{
-		vehicleRepo:    vehicleRepo,
-		dispatcherRepo: dispatcherRepo,
-		secretKey:      secret,
+		vehicleRepo:      vehicleRepo,
+		dispatcherRepo:   dispatcherRepo,
+		streamHealthRepo: streamHealthRepo,
+		certificateRepo:  certificateRepo,
+		adminRepo:        adminRepo,
+		revocationRepo:   revocationRepo,
+		audit:            newAuditTrail(auditRepo),
+		ca:               ca,
+		certificateTTL:   certificateTTL,
+		tokens:           newTokenIssuer(tokenKey, tokenTTL, nil, nil),
 	}
 }
 
-func (a AdminService) GetDispatcher(secret string, id int) (*entity.GetDispatcherResponse, error) {
-	if secret != a.secretKey {
-		return nil, usecase.ErrAccessDenied
+// revokeAccess сообщает серверу ретрансляции, что ТС или диспетчера изменили или удалили, чтобы он отключил
+// сессии, которые потеряли доступ. Изменение уже сохранено, поэтому если сообщение не дойдёт, то сессии
+// отключатся при следующей сверке с хранилищем
+func (a AdminService) revokeAccess(role entity.TokenRole, id int) {
+	revocation := entity.NewAccessRevocation(role, id)
+	_ = a.revocationRepo.PublishRevocation(&revocation)
+}
+
+// pageLimit возвращает размер страницы списка по запрошенному limit
+func pageLimit(limit int) int {
+	switch {
+	case limit == 0:
+		return defaultPageLimit
+	case limit > maxPageLimit:
+		return maxPageLimit
+	default:
+		return limit
+	}
+}
+
+func (a AdminService) GetDispatcher(token string, id int) (*entity.GetDispatcherResponse, error) {
+	if _, err := a.authorize(token, entity.ReadPermission); err != nil {
+		return nil, err
 	}
 	dispatcher, err := a.dispatcherRepo.GetDispatcher(id)
 	switch {
@@ -42,9 +89,10 @@ func (a AdminService) GetDispatcher(secret string, id int) (*entity.GetDispatche
 	}
 }
 
-func (a AdminService) AddDispatcher(secret string, dispatcherRequest *entity.AddDispatcherRequest) (*entity.Dispatcher, error) {
-	if secret != a.secretKey {
-		return nil, usecase.ErrAccessDenied
+func (a AdminService) AddDispatcher(token string, dispatcherRequest *entity.AddDispatcherRequest) (*entity.Dispatcher, error) {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return nil, err
 	}
 	passwordHash, err := password.HashPassword(dispatcherRequest.Password)
 	if err != nil {
@@ -66,7 +114,9 @@ func (a AdminService) AddDispatcher(secret string, dispatcherRequest *entity.Add
 	default:
 		return nil, errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid dispatcher grants"))
 	}
-	err = a.dispatcherRepo.AddDispatcher(dispatcher)
+	// ID диспетчера ещё не известен, его запишет в журнал хранилище
+	details := fmt.Sprintf("grants_type=%s grants=%v", dispatcher.GrantsType, dispatcher.Grants)
+	err = a.dispatcherRepo.AddDispatcher(dispatcher, adminRecord(caller, entity.DispatcherAddAudit, "", details))
 	switch {
 	case err == nil:
 		return dispatcher, nil
@@ -77,9 +127,10 @@ func (a AdminService) AddDispatcher(secret string, dispatcherRequest *entity.Add
 	}
 }
 
-func (a AdminService) EditDispatcher(secret string, dispatcherRequest *entity.EditDispatcherRequest) error {
-	if secret != a.secretKey {
-		return usecase.ErrAccessDenied
+func (a AdminService) EditDispatcher(token string, dispatcherRequest *entity.EditDispatcherRequest) error {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return err
 	}
 	if !entity.IsGrantsTypeValid(dispatcherRequest.GrantsType) {
 		return errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid dispatcher grants type"))
@@ -93,7 +144,8 @@ func (a AdminService) EditDispatcher(secret string, dispatcherRequest *entity.Ed
 	default:
 		return errors.Join(usecase.ErrInternal, err)
 	}
-	// обновляем объект диспетчера
+	// обновляем объект диспетчера, прежние права попадут в журнал аудита
+	details := fmt.Sprintf("grants_type=%s grants=%v", dispatcher.GrantsType, dispatcher.Grants)
 	dispatcher.GrantsType = dispatcherRequest.GrantsType
 	if dispatcherRequest.GrantsType == entity.AllGrants {
 		// если тип разрешений - все, то список разрешений не нужен
@@ -103,9 +155,11 @@ func (a AdminService) EditDispatcher(secret string, dispatcherRequest *entity.Ed
 	} else {
 		return errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid dispatcher grants"))
 	}
-	err = a.dispatcherRepo.EditDispatcher(dispatcher)
+	details += fmt.Sprintf(" -> grants_type=%s grants=%v", dispatcher.GrantsType, dispatcher.Grants)
+	err = a.dispatcherRepo.EditDispatcher(dispatcher, adminRecord(caller, entity.DispatcherEditAudit, entity.DispatcherActor(dispatcher.ID), details))
 	switch {
 	case err == nil:
+		a.revokeAccess(entity.DispatcherToken, dispatcher.ID)
 		return nil
 	case errors.Is(err, repo.ErrDispatcherNotFound):
 		return usecase.ErrDispatcherNotFound
@@ -114,13 +168,15 @@ func (a AdminService) EditDispatcher(secret string, dispatcherRequest *entity.Ed
 	}
 }
 
-func (a AdminService) DeleteDispatcher(secret string, id int) error {
-	if secret != a.secretKey {
-		return usecase.ErrAccessDenied
+func (a AdminService) DeleteDispatcher(token string, id int) error {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return err
 	}
-	err := a.dispatcherRepo.DeleteDispatcher(id)
+	err = a.dispatcherRepo.DeleteDispatcher(id, adminRecord(caller, entity.DispatcherDeleteAudit, entity.DispatcherActor(id), ""))
 	switch {
 	case err == nil:
+		a.revokeAccess(entity.DispatcherToken, id)
 		return nil
 	case errors.Is(err, repo.ErrDispatcherNotFound):
 		return usecase.ErrDispatcherNotFound
@@ -129,15 +185,43 @@ func (a AdminService) DeleteDispatcher(secret string, id int) error {
 	}
 }
 
-func (a AdminService) GetVehicle(secret string, id int) (*entity.GetVehicleResponse, error) {
-	if secret != a.secretKey {
-		return nil, usecase.ErrAccessDenied
+func (a AdminService) ListDispatchers(token string, filter *entity.DispatcherFilter) (*entity.ListDispatchersResponse, error) {
+	if _, err := a.authorize(token, entity.ReadPermission); err != nil {
+		return nil, err
+	}
+	if filter.GrantsType != "" && !entity.IsGrantsTypeValid(filter.GrantsType) {
+		return nil, errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid dispatcher grants type"))
+	}
+	filter.Limit = pageLimit(filter.Limit)
+	dispatchers, err := a.dispatcherRepo.ListDispatchers(filter)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	response := &entity.ListDispatchersResponse{Dispatchers: make([]entity.GetDispatcherResponse, 0, len(dispatchers))}
+	for _, dispatcher := range dispatchers {
+		response.Dispatchers = append(response.Dispatchers, entity.GetDispatcherResponse{
+			ID:         dispatcher.ID,
+			GrantsType: dispatcher.GrantsType,
+			Grants:     dispatcher.Grants,
+		})
+	}
+	if len(dispatchers) == filter.Limit {
+		response.Next = dispatchers[len(dispatchers)-1].ID
+	}
+	return response, nil
+}
+
+func (a AdminService) GetVehicle(token string, id int) (*entity.GetVehicleResponse, error) {
+	if _, err := a.authorize(token, entity.ReadPermission); err != nil {
+		return nil, err
 	}
 	vehicle, err := a.vehicleRepo.GetVehicle(id)
 	switch {
 	case err == nil:
 		return &entity.GetVehicleResponse{
-			ID: vehicle.ID,
+			ID:          vehicle.ID,
+			VehicleInfo: vehicle.VehicleInfo,
+			Status:      vehicle.Status,
 		}, nil
 	case errors.Is(err, repo.ErrVehicleNotFound):
 		return nil, usecase.ErrVehicleNotFound
@@ -146,9 +230,17 @@ func (a AdminService) GetVehicle(secret string, id int) (*entity.GetVehicleRespo
 	}
 }
 
-func (a AdminService) AddVehicle(secret string, vehicleRequest *entity.AddVehicleRequest) (*entity.Vehicle, error) {
-	if secret != a.secretKey {
-		return nil, usecase.ErrAccessDenied
+func (a AdminService) AddVehicle(token string, vehicleRequest *entity.AddVehicleRequest) (*entity.Vehicle, error) {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return nil, err
+	}
+	status := vehicleRequest.Status
+	if status == "" {
+		status = entity.ActiveVehicle
+	}
+	if !entity.IsVehicleStatusValid(status) {
+		return nil, errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid vehicle status"))
 	}
 	passwordHash, err := password.HashPassword(vehicleRequest.Password)
 	if err != nil {
@@ -156,8 +248,11 @@ func (a AdminService) AddVehicle(secret string, vehicleRequest *entity.AddVehicl
 	}
 	vehicle := &entity.Vehicle{
 		PasswordHash: passwordHash,
+		VehicleInfo:  vehicleRequest.VehicleInfo,
+		Status:       status,
 	}
-	err = a.vehicleRepo.AddVehicle(vehicle)
+	// ID ТС ещё не известен, его запишет в журнал хранилище
+	err = a.vehicleRepo.AddVehicle(vehicle, adminRecord(caller, entity.VehicleAddAudit, "", "status="+string(status)))
 	switch {
 	case err == nil:
 		return vehicle, nil
@@ -168,13 +263,49 @@ func (a AdminService) AddVehicle(secret string, vehicleRequest *entity.AddVehicl
 	}
 }
 
-func (a AdminService) DeleteVehicle(secret string, id int) error {
-	if secret != a.secretKey {
-		return usecase.ErrAccessDenied
+func (a AdminService) EditVehicle(token string, vehicleRequest *entity.EditVehicleRequest) error {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return err
 	}
-	err := a.vehicleRepo.DeleteVehicle(id)
+	if !entity.IsVehicleStatusValid(vehicleRequest.Status) {
+		return errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid vehicle status"))
+	}
+	// получаем текущий объект ТС, чтобы сохранить пароль
+	vehicle, err := a.vehicleRepo.GetVehicle(vehicleRequest.ID)
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return usecase.ErrVehicleNotFound
+	default:
+		return errors.Join(usecase.ErrInternal, err)
+	}
+	details := fmt.Sprintf("status=%s -> status=%s", vehicle.Status, vehicleRequest.Status)
+	vehicle.VehicleInfo = vehicleRequest.VehicleInfo
+	vehicle.Status = vehicleRequest.Status
+	err = a.vehicleRepo.EditVehicle(vehicle, adminRecord(caller, entity.VehicleEditAudit, entity.VehicleActor(vehicle.ID), details))
+	switch {
+	case err == nil:
+		if vehicle.Status != entity.ActiveVehicle {
+			a.revokeAccess(entity.VehicleToken, vehicle.ID)
+		}
+		return nil
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return usecase.ErrVehicleNotFound
+	default:
+		return errors.Join(usecase.ErrInternal, err)
+	}
+}
+
+func (a AdminService) DeleteVehicle(token string, id int) error {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return err
+	}
+	err = a.vehicleRepo.DeleteVehicle(id, adminRecord(caller, entity.VehicleDeleteAudit, entity.VehicleActor(id), ""))
 	switch {
 	case err == nil:
+		a.revokeAccess(entity.VehicleToken, id)
 		return nil
 	case errors.Is(err, repo.ErrVehicleNotFound):
 		return usecase.ErrVehicleNotFound
@@ -182,3 +313,131 @@ func (a AdminService) DeleteVehicle(secret string, id int) error {
 		return errors.Join(usecase.ErrInternal, err)
 	}
 }
+
+func (a AdminService) ListVehicles(token string, filter *entity.VehicleFilter) (*entity.ListVehiclesResponse, error) {
+	if _, err := a.authorize(token, entity.ReadPermission); err != nil {
+		return nil, err
+	}
+	if filter.Status != "" && !entity.IsVehicleStatusValid(filter.Status) {
+		return nil, errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid vehicle status"))
+	}
+	filter.Limit = pageLimit(filter.Limit)
+	vehicles, err := a.vehicleRepo.ListVehicles(filter)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	response := &entity.ListVehiclesResponse{Vehicles: make([]entity.GetVehicleResponse, 0, len(vehicles))}
+	for _, vehicle := range vehicles {
+		response.Vehicles = append(response.Vehicles, entity.GetVehicleResponse{
+			ID:          vehicle.ID,
+			VehicleInfo: vehicle.VehicleInfo,
+			Status:      vehicle.Status,
+		})
+	}
+	if len(vehicles) == filter.Limit {
+		response.Next = vehicles[len(vehicles)-1].ID
+	}
+	return response, nil
+}
+
+func (a AdminService) GetVehicleStreams(token string, id int) (*entity.StreamHealth, error) {
+	if _, err := a.authorize(token, entity.ReadPermission); err != nil {
+		return nil, err
+	}
+	_, err := a.vehicleRepo.GetVehicle(id)
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return nil, usecase.ErrVehicleNotFound
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	streams, err := a.streamHealthRepo.GetStreamStatuses(id)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return entity.NewStreamHealth(id, streams), nil
+}
+
+func (a AdminService) IssueVehicleCertificate(token string, id int, request *entity.IssueCertificateRequest) (*entity.IssueCertificateResponse, error) {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return nil, err
+	}
+	if a.ca == nil {
+		return nil, usecase.ErrNotConfigured
+	}
+	ttl := a.certificateTTL
+	if request.ValidFor != "" {
+		if ttl, err = time.ParseDuration(request.ValidFor); err != nil || ttl <= 0 {
+			return nil, errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid certificate validity period"))
+		}
+	}
+	_, err = a.vehicleRepo.GetVehicle(id)
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return nil, usecase.ErrVehicleNotFound
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	issued, err := a.ca.Issue(entity.VehicleCommonName(id), ttl)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	certificate := entity.VehicleCertificate{
+		Serial:    pki.Serial(issued.Cert),
+		VehicleID: id,
+		IssuedAt:  issued.Cert.NotBefore,
+		NotAfter:  issued.Cert.NotAfter,
+	}
+	// без записи в хранилище сертификат нельзя будет отозвать, поэтому он не выдаётся
+	details := fmt.Sprintf("serial=%s not_after=%s", certificate.Serial, certificate.NotAfter.Format(time.RFC3339))
+	if err = a.certificateRepo.AddCertificate(&certificate, adminRecord(caller, entity.CertificateIssueAudit, entity.VehicleActor(id), details)); err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return &entity.IssueCertificateResponse{
+		VehicleCertificate: certificate,
+		Certificate:        string(issued.CertPEM),
+		PrivateKey:         string(issued.KeyPEM),
+	}, nil
+}
+
+func (a AdminService) GetVehicleCertificates(token string, id int) ([]entity.VehicleCertificate, error) {
+	if _, err := a.authorize(token, entity.ReadPermission); err != nil {
+		return nil, err
+	}
+	_, err := a.vehicleRepo.GetVehicle(id)
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return nil, usecase.ErrVehicleNotFound
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	certificates, err := a.certificateRepo.GetCertificates(id)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return certificates, nil
+}
+
+func (a AdminService) RevokeVehicleCertificate(token string, id int, serial string) error {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return err
+	}
+	err = a.certificateRepo.RevokeCertificate(id, serial, adminRecord(caller, entity.CertificateRevokeAudit, entity.VehicleActor(id), "serial="+serial))
+	switch {
+	case err == nil:
+		// сертификат уже отмечен отозванным в хранилище: если сообщение не дойдёт до сервера ретрансляции,
+		// то сессии ТС с этим сертификатом отключатся при следующей сверке с хранилищем
+		revocation := entity.NewCertificateRevocation(id, serial)
+		_ = a.revocationRepo.PublishRevocation(&revocation)
+		return nil
+	case errors.Is(err, repo.ErrCertificateNotFound):
+		return usecase.ErrCertificateNotFound
+	default:
+		return errors.Join(usecase.ErrInternal, err)
+	}
+}
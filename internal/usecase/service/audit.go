@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"time"
+)
+
+const (
+	// auditQueueSize - сколько событий сервера ретрансляции может ждать записи в журнал аудита
+	auditQueueSize = 1024
+	// defaultAuditLimit и maxAuditLimit - сколько записей журнала аудита возвращается по умолчанию и не больше
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditTrail записывает действия администраторов и события сервера ретрансляции в журнал аудита
+type auditTrail struct {
+	repo  repo.AuditRepo
+	queue chan entity.AuditRecord
+}
+
+func newAuditTrail(auditRepo repo.AuditRepo) *auditTrail {
+	t := &auditTrail{
+		repo:  auditRepo,
+		queue: make(chan entity.AuditRecord, auditQueueSize),
+	}
+	go t.run()
+	return t
+}
+
+// record сразу записывает событие в журнал и возвращает ошибку хранилища вызывающему
+func (t *auditTrail) record(actor string, action entity.AuditAction, target, details string) error {
+	return t.repo.AddRecord(&entity.AuditRecord{
+		Time:    time.Now(),
+		Actor:   actor,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	})
+}
+
+// post ставит событие в очередь на запись и не ждёт хранилища: журнал не должен задерживать ретрансляцию.
+// Если очередь переполнена, то событие отбрасывается
+func (t *auditTrail) post(actor string, action entity.AuditAction, target, details string) {
+	select {
+	case t.queue <- entity.AuditRecord{Time: time.Now(), Actor: actor, Action: action, Target: target, Details: details}:
+	default:
+	}
+}
+
+// run записывает события из очереди. Ведение журнала не обязательно для ретрансляции, поэтому ошибки хранилища
+// её не прерывают
+func (t *auditTrail) run() {
+	for record := range t.queue {
+		_ = t.repo.AddRecord(&record)
+	}
+}
+
+// adminRecord создаёт запись журнала о действии администратора caller. Изменение без записи в журнале
+// не должно сохраниться, поэтому запись передаётся в хранилище вместе с изменением и сохраняется в той же транзакции
+func adminRecord(caller *entity.Admin, action entity.AuditAction, target, details string) *entity.AuditRecord {
+	return &entity.AuditRecord{
+		Time:    time.Now(),
+		Actor:   entity.AdminActor(caller.Login),
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+}
+
+// auditAdmin записывает в журнал действие администратора caller, которое не изменяет хранилище, например, вход
+func (a AdminService) auditAdmin(caller *entity.Admin, action entity.AuditAction, target, details string) error {
+	if err := a.audit.record(entity.AdminActor(caller.Login), action, target, details); err != nil {
+		return errors.Join(usecase.ErrInternal, err)
+	}
+	return nil
+}
+
+func (a AdminService) GetAudit(token string, filter *entity.AuditFilter) ([]entity.AuditRecord, error) {
+	if _, err := a.authorize(token, entity.ReadPermission); err != nil {
+		return nil, err
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return nil, errors.Join(usecase.ErrBadRequest, errors.New("invalid time range"))
+	}
+	switch {
+	case filter.Limit == 0:
+		filter.Limit = defaultAuditLimit
+	case filter.Limit > maxAuditLimit:
+		filter.Limit = maxAuditLimit
+	}
+	records, err := a.audit.repo.GetRecords(filter)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return records, nil
+}
@@ -1,184 +1,395 @@
 package service
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"golang.org/x/exp/slices"
+	"self-driving-car-dispatch-system/internal/codec"
 	"self-driving-car-dispatch-system/internal/entity"
 	"self-driving-car-dispatch-system/internal/repo"
 	"self-driving-car-dispatch-system/internal/usecase"
-	"self-driving-car-dispatch-system/pkg/password"
+	"self-driving-car-dispatch-system/pkg/protocol"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// MaxJsonSize ограничивает размер JSON в приложении в 8 КБ
-const MaxJsonSize = 1 << 13
-
 type BroadcastService struct {
-	vehicleRepo    repo.VehicleRepo
-	dispatcherRepo repo.DispatcherRepo
-	videoStreams   sync.Map
-	infoStreams    sync.Map
-	pool           sync.Pool
+	vehicleRepo     repo.VehicleRepo
+	dispatcherRepo  repo.DispatcherRepo
+	certificateRepo repo.CertificateRepo
+	// videoStreams хранит *videoLayers для каждой камеры ТС, ключ - videoKey
+	videoStreams sync.Map
+	// infoStreams хранит *telemetryHubs для каждого ТС, ключ - ID ТС
+	infoStreams sync.Map
+	// commandRoutes хранит *commandRoute для каждого подключённого ТС
+	commandRoutes sync.Map
+	leases        *leaseManager
+	// telemetryStats хранит *telemetryCounters для каждого ТС
+	telemetryStats sync.Map
+	watchdog       *streamWatchdog
+	sessions       *sessionManager
+	fleet          *fleetMonitor
+	tokens         *tokenIssuer
+	// grants хранит права диспетчеров, вошедших по токену
+	grants      *grantsCache
+	audit       *auditTrail
+	credentials *credentialSessions
+}
+
+// telemetryCounters - счётчики кадров телеметрии ТС, накапливаются за всё время работы сервера
+type telemetryCounters struct {
+	frames    atomic.Uint64
+	malformed atomic.Uint64
+	flagged   atomic.Uint64
 }
 
-func NewBroadcastService(vehicleRepo repo.VehicleRepo, dispatcherRepo repo.DispatcherRepo) usecase.BroadcastUsecase {
+// NewBroadcastService создаёт сервис ретрансляции. Если ТС переподключится в течение gracePeriod,
+// то диспетчеры продолжат получать его трансляции без переподключения. Токены сессий подписываются ключом
+// tokenKey и действуют tokenTTL. Токены и сессии с паролем, отозванным в revocationRepo, отклоняются и отключаются
+func NewBroadcastService(vehicleRepo repo.VehicleRepo, dispatcherRepo repo.DispatcherRepo, streamHealthRepo repo.StreamHealthRepo, certificateRepo repo.CertificateRepo, auditRepo repo.AuditRepo, revocationRepo repo.RevocationRepo, gracePeriod time.Duration, tokenKey []byte, tokenTTL time.Duration) usecase.BroadcastUsecase {
+	watchdog := newStreamWatchdog(streamHealthRepo)
+	fleet := newFleetMonitor()
+	audit := newAuditTrail(auditRepo)
 	service := &BroadcastService{
-		vehicleRepo:    vehicleRepo,
-		dispatcherRepo: dispatcherRepo,
-		videoStreams:   sync.Map{},
-		infoStreams:    sync.Map{},
-		pool: sync.Pool{
-			New: func() interface{} {
-				return make([]byte, 4096)
-			},
-		},
+		vehicleRepo:     vehicleRepo,
+		dispatcherRepo:  dispatcherRepo,
+		certificateRepo: certificateRepo,
+		videoStreams:    sync.Map{},
+		infoStreams:     sync.Map{},
+		leases:          newLeaseManager(leaseTTL, fleet, audit),
+		watchdog:        watchdog,
+		sessions:        newSessionManager(gracePeriod, watchdog, fleet),
+		fleet:           fleet,
+		tokens:          newTokenIssuer(tokenKey, tokenTTL, revocationRepo, certificateRepo),
+		grants:          newGrantsCache(tokenTTL),
+		audit:           audit,
+		credentials:     newCredentialSessions(),
 	}
+	go service.watchRevocations(revocationRepo)
 	return service
 }
 
-func (b *BroadcastService) GetVideoStream(vehicleID, dispatcherID int, dispatcherPassword string, stream chan []byte, errChan chan error) {
-	dispatcher, err := b.dispatcherRepo.GetDispatcher(dispatcherID)
-	switch {
-	case errors.Is(err, repo.ErrDispatcherNotFound):
-		errChan <- usecase.ErrDispatcherNotFound
-		return
-	case errors.Is(err, repo.ErrInternal):
-		errChan <- usecase.ErrInternal
-		return
+// sendErr передает ошибку в errChan, если получатель ещё ждёт её
+func sendErr(ctx context.Context, errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	case <-ctx.Done():
 	}
-	if !password.CheckPassword(dispatcherPassword, dispatcher.PasswordHash) {
-		errChan <- errors.Join(usecase.ErrAccessDenied, fmt.Errorf("неверный пароль"))
-		return
+}
+
+// authDispatcher проверяет пароль диспетчера и его доступ к ТС
+func (b *BroadcastService) authDispatcher(vehicleID, dispatcherID int, dispatcherPassword string) (*entity.Dispatcher, error) {
+	dispatcher, err := b.checkDispatcher(dispatcherID, dispatcherPassword)
+	if err != nil {
+		return nil, err
 	}
 	// проверяем, что у диспетчера есть доступ ко всем ТС или к данному ТС
-	if !(dispatcher.GrantsType == entity.AllGrants || (dispatcher.GrantsType == entity.ListGrants && slices.Contains(dispatcher.Grants, vehicleID))) {
-		fmt.Println(dispatcher)
-		errChan <- usecase.ErrAccessDenied
-		return
+	if !dispatcher.CanAccess(vehicleID) {
+		return nil, usecase.ErrAccessDenied
+	}
+	return dispatcher, nil
+}
+
+// checkDispatcher проверяет токен или пароль диспетчера. По токену диспетчер читается из хранилища,
+// только если его прав нет в grants
+func (b *BroadcastService) checkDispatcher(dispatcherID int, dispatcherPassword string) (*entity.Dispatcher, error) {
+	if claims, ok, err := b.tokens.verify(dispatcherPassword, entity.DispatcherToken, dispatcherID); ok {
+		if err != nil {
+			return nil, err
+		}
+		return b.currentGrants(dispatcherID, claims.CredentialVersion)
+	}
+	dispatcher, err := b.getDispatcher(dispatcherID)
+	if err != nil {
+		return nil, err
 	}
-	ch, ok := b.videoStreams.Load(vehicleID)
+	revocation, err := b.tokens.revocation(entity.DispatcherToken, dispatcherID)
+	if err != nil {
+		return nil, err
+	}
+	version, ok := checkPassword(dispatcherPassword, dispatcher.PasswordHash, dispatcher.CredentialVersion, dispatcher.PreviousCredential, revocation)
 	if !ok {
-		errChan <- usecase.ErrNotFound
-		return
+		return nil, errors.Join(usecase.ErrAccessDenied, fmt.Errorf("неверный пароль"))
+	}
+	dispatcher.CredentialVersion = version
+	b.grants.put(grantsKey{dispatcherID: dispatcherID, credentialVersion: version}, dispatcher)
+	return dispatcher, nil
+}
+
+// currentGrants возвращает права диспетчера, вошедшего с паролем версии version: из кэша или, если их там нет,
+// например, после изменения прав, из хранилища
+func (b *BroadcastService) currentGrants(dispatcherID, version int) (*entity.Dispatcher, error) {
+	key := grantsKey{dispatcherID: dispatcherID, credentialVersion: version}
+	if dispatcher, ok := b.grants.get(key); ok {
+		return dispatcher, nil
 	}
-	c := ch.(chan []byte)
-	// передаём видеопоток ch в поток stream и если канал ch закрывается, то выходим
-	for d := range c {
-		stream <- d
+	dispatcher, err := b.getDispatcher(dispatcherID)
+	if err != nil {
+		return nil, err
 	}
+	b.grants.put(key, dispatcher)
+	dispatcher.CredentialVersion = version
+	return dispatcher, nil
 }
 
-func (b *BroadcastService) GetInfoStream(vehicleID, dispatcherID int, dispatcherPassword string, stream chan []byte, errChan chan error) {
+func (b *BroadcastService) getDispatcher(dispatcherID int) (*entity.Dispatcher, error) {
 	dispatcher, err := b.dispatcherRepo.GetDispatcher(dispatcherID)
 	switch {
+	case err == nil:
+		return dispatcher, nil
 	case errors.Is(err, repo.ErrDispatcherNotFound):
-		errChan <- usecase.ErrDispatcherNotFound
-		return
-	case errors.Is(err, repo.ErrInternal):
-		errChan <- usecase.ErrInternal
-		return
+		return nil, usecase.ErrDispatcherNotFound
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
 	}
-	if !password.CheckPassword(dispatcherPassword, dispatcher.PasswordHash) {
-		errChan <- errors.Join(usecase.ErrAccessDenied, fmt.Errorf("неверный пароль"))
-		return
+}
+
+// authVehicle проверяет токен или пароль ТС и что ТС в статусе active: по токену пароль не проверяется,
+// но ТС, выведенное из эксплуатации после выдачи токена, не подключается и по нему.
+// CredentialVersion возвращённого ТС - версия пароля, с которым ТС вошло
+func (b *BroadcastService) authVehicle(vehicleID int, vehiclePassword string) (*entity.Vehicle, error) {
+	vehicle, err := b.activeVehicle(vehicleID)
+	if err != nil {
+		return nil, err
 	}
-	// проверяем, что у диспетчера есть доступ ко всем ТС или к данному ТС
-	if !(dispatcher.GrantsType == entity.AllGrants || (dispatcher.GrantsType == entity.ListGrants && slices.Contains(dispatcher.Grants, vehicleID))) {
-		fmt.Println(dispatcher)
-		errChan <- usecase.ErrAccessDenied
-		return
+	if claims, ok, err := b.tokens.verify(vehiclePassword, entity.VehicleToken, vehicleID); ok {
+		if err != nil {
+			return nil, err
+		}
+		vehicle.CredentialVersion = claims.CredentialVersion
+		return vehicle, nil
+	}
+	if err != nil {
+		return nil, err
 	}
-	ch, ok := b.infoStreams.Load(vehicleID)
+	revocation, err := b.tokens.revocation(entity.VehicleToken, vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	version, ok := checkPassword(vehiclePassword, vehicle.PasswordHash, vehicle.CredentialVersion, vehicle.PreviousCredential, revocation)
 	if !ok {
-		errChan <- usecase.ErrNotFound
-		return
+		return nil, errors.Join(usecase.ErrBadRequest, fmt.Errorf("неверный пароль"))
 	}
-	c := ch.(chan []byte)
-	for d := range c {
-		stream <- d
+	vehicle.CredentialVersion = version
+	return vehicle, nil
+}
+
+// activeVehicle возвращает ТС, если оно в статусе active: ТС на обслуживании или выведенное из эксплуатации
+// не может подключиться к серверу ретрансляции
+func (b *BroadcastService) activeVehicle(vehicleID int) (*entity.Vehicle, error) {
+	vehicle, err := b.getVehicle(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle.Status != entity.ActiveVehicle {
+		return nil, errors.Join(usecase.ErrVehicleInactive, fmt.Errorf("статус ТС %d: %s", vehicleID, vehicle.Status))
 	}
+	return vehicle, nil
 }
 
-func (b *BroadcastService) SendVideoStream(vehicleID int, vehiclePassword string, stream chan []byte, errChan chan error) {
+func (b *BroadcastService) getVehicle(vehicleID int) (*entity.Vehicle, error) {
 	vehicle, err := b.vehicleRepo.GetVehicle(vehicleID)
 	switch {
+	case err == nil:
+		return vehicle, nil
 	case errors.Is(err, repo.ErrVehicleNotFound):
-		errChan <- usecase.ErrVehicleNotFound
-		return
-	case errors.Is(err, repo.ErrInternal):
-		errChan <- usecase.ErrInternal
-		return
+		return nil, usecase.ErrVehicleNotFound
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
 	}
-	if !password.CheckPassword(vehiclePassword, vehicle.PasswordHash) {
-		errChan <- errors.Join(usecase.ErrBadRequest, fmt.Errorf("неверный пароль"))
-		return
+}
+
+func (b *BroadcastService) AuthDispatcher(vehicleID, dispatcherID int, dispatcherPassword string) error {
+	if vehicleID == 0 {
+		_, err := b.checkDispatcher(dispatcherID, dispatcherPassword)
+		return err
 	}
-	// если трансляция уже ведется, то удаляем её и начинаем новую
-	// если трансляции нет, то создаем новую
-	ch, loaded := b.videoStreams.LoadOrStore(vehicleID, make(chan []byte, 100))
-	if loaded {
-		ch = make(chan []byte, 100)
-		b.videoStreams.Store(vehicleID, ch)
+	_, err := b.authDispatcher(vehicleID, dispatcherID, dispatcherPassword)
+	return err
+}
+
+// subscribe подписывает диспетчера на трансляцию потока name ТС, которую возвращает lookup, и передаёт данные
+// в stream, пока не закончится сессия ТС или не будет отменён ctx. Когда ТС переподключается, диспетчер
+// переходит на новую трансляцию
+func (b *BroadcastService) subscribe(ctx context.Context, vehicleID int, name string, lookup func() (*hub, bool), size int, policy dropPolicy, stream chan []byte, errChan chan error) {
+	var h *hub
+	for {
+		next, err := awaitSource(ctx, b.sessions, vehicleID, name, h, lookup)
+		if err != nil {
+			if ctx.Err() == nil {
+				sendErr(ctx, errChan, err)
+			}
+			return
+		}
+		h = next
+		if !relay(ctx, h, size, policy, stream) {
+			return
+		}
 	}
+}
 
-	for d := range stream {
+// relay передаёт данные трансляции h в stream. Возвращает true, если трансляция закончилась, и false,
+// если отменён ctx
+func relay(ctx context.Context, h *hub, size int, policy dropPolicy, stream chan []byte) bool {
+	sub := h.subscribe(size, policy, true)
+	defer h.unsubscribe(sub)
+	for {
 		select {
-		case ch.(chan []byte) <- d:
-		default:
-			<-ch.(chan []byte)
-			ch.(chan []byte) <- d
+		case p, ok := <-sub.queue:
+			if !ok {
+				return true
+			}
+			select {
+			case stream <- p.bytes(time.Now()):
+			case <-ctx.Done():
+				return false
+			}
+		case <-ctx.Done():
+			return false
 		}
 	}
-	close(ch.(chan []byte))
-	b.videoStreams.Delete(vehicleID)
 }
 
-func (b *BroadcastService) SendInfoStream(vehicleID int, vehiclePassword string, stream chan []byte, errChan chan error) {
-	vehicle, err := b.vehicleRepo.GetVehicle(vehicleID)
-	switch {
-	case errors.Is(err, repo.ErrVehicleNotFound):
-		errChan <- usecase.ErrVehicleNotFound
-		return
-	case errors.Is(err, repo.ErrInternal):
-		errChan <- usecase.ErrInternal
+// telemetryHubs - трансляции телеметрии одного ТС, по одной на каждый формат. Телеметрия перекодируется
+// при получении, а при отправке каждому диспетчеру кодируется заново только для отметки времени отправки
+type telemetryHubs struct {
+	hubs map[protocol.Encoding]*hub
+}
+
+func newTelemetryHubs() *telemetryHubs {
+	t := &telemetryHubs{hubs: make(map[protocol.Encoding]*hub, len(protocol.Encodings))}
+	for _, encoding := range protocol.Encodings {
+		t.hubs[encoding] = newHub()
+	}
+	return t
+}
+
+func (t *telemetryHubs) close() {
+	for _, h := range t.hubs {
+		h.close()
+	}
+}
+
+func (b *BroadcastService) GetInfoStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, encoding protocol.Encoding, stream chan []byte, errChan chan error) {
+	if _, err := b.authDispatcher(vehicleID, dispatcherID, dispatcherPassword); err != nil {
+		sendErr(ctx, errChan, err)
 		return
 	}
-	if !password.CheckPassword(vehiclePassword, vehicle.PasswordHash) {
-		errChan <- errors.Join(usecase.ErrBadRequest, fmt.Errorf("неверный пароль"))
+	if !slices.Contains(protocol.Encodings, encoding) {
+		sendErr(ctx, errChan, usecase.ErrBadRequest)
 		return
 	}
+	b.fleet.watch(vehicleID, dispatcherID)
+	defer b.fleet.unwatch(vehicleID, dispatcherID)
+	lookup := func() (*hub, bool) {
+		hubs, ok := b.infoStreams.Load(vehicleID)
+		if !ok {
+			return nil, false
+		}
+		return hubs.(*telemetryHubs).hubs[encoding], true
+	}
+	b.holdGrant(ctx, vehicleID, dispatcherID, errChan, func(ctx context.Context) {
+		b.subscribe(ctx, vehicleID, entity.InfoStreamName, lookup, subscriberQueueSize, dropOldest, stream, errChan)
+	})
+}
 
-	// если трансляция уже ведется, то удаляем её и начинаем новую
-	// если трансляции нет, то создаем новую
-	ch, loaded := b.infoStreams.LoadOrStore(vehicleID, make(chan []byte, 100))
-	if loaded {
-		ch = make(chan []byte, 100)
-		b.infoStreams.Store(vehicleID, ch)
-	}
-	defer close(ch.(chan []byte))
-	defer b.infoStreams.Delete(vehicleID)
-	// Читаем информацию батчами, пока не сможем распарсить JSON. Полученный JSON отправляем в stream
-	buffer := b.pool.Get().([]byte)[:0]
-	defer b.pool.Put(buffer[:cap(buffer)])
-	for d := range stream {
-		buffer = append(buffer, d...)
-		var jsonData map[string]interface{}
-		if err = json.Unmarshal(buffer, &jsonData); err == nil {
-			select {
-			case ch.(chan []byte) <- buffer:
-				// пакет успешно передан
-			default:
-				// канал заполнен, удаляем старые данные и записываем новые (закольцовываем буфер)
-				<-ch.(chan []byte)
-				ch.(chan []byte) <- buffer
+func (b *BroadcastService) SendInfoStream(ctx context.Context, vehicleID int, vehiclePassword string, encoding protocol.Encoding, stream chan []byte, errChan chan error) {
+	if _, err := b.authVehicle(vehicleID, vehiclePassword); err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	// если ТС переподключилось, то новая трансляция заменяет старую, а её диспетчеры переходят на новую
+	hubs := newTelemetryHubs()
+	if old, loaded := b.infoStreams.Swap(vehicleID, hubs); loaded {
+		old.(*telemetryHubs).close()
+	}
+	b.sessions.changed(vehicleID)
+	b.fleet.notify()
+	defer b.fleet.notify()
+	defer hubs.close()
+	defer b.infoStreams.CompareAndDelete(vehicleID, hubs)
+	watched := b.watchdog.track(vehicleID, entity.InfoStreamName)
+	defer b.watchdog.untrack(watched)
+	counters, _ := b.telemetryStats.LoadOrStore(vehicleID, &telemetryCounters{})
+	stats := counters.(*telemetryCounters)
+	// Собираем кадры из прочитанных фрагментов потока. Корректную телеметрию отправляем подписчикам
+	decoder := protocol.NewFrameDecoder(protocol.MaxFrameSize)
+	var lastSeq uint64
+	for {
+		select {
+		case d, ok := <-stream:
+			if !ok {
+				return
 			}
-		}
-		if len(buffer) > MaxJsonSize {
-			errChan <- usecase.ErrBadRequest
+			watched.touch()
+			_, _ = decoder.Write(d)
+		case <-ctx.Done():
 			return
 		}
-		buffer = buffer[:0]
+		ingress := time.Now()
+		for {
+			frame, err := decoder.Next()
+			if frame == nil && err == nil {
+				break
+			}
+			stats.frames.Add(1)
+			if err != nil {
+				stats.malformed.Add(1)
+				continue
+			}
+			var telemetry entity.Telemetry
+			if err = codec.UnmarshalTelemetry(encoding, frame, &telemetry); err != nil || !entity.IsTelemetryValid(&telemetry) {
+				stats.malformed.Add(1)
+				continue
+			}
+			// значения вне допустимого диапазона не отбрасываем, а помечаем, чтобы диспетчер видел проблему
+			telemetry.Flags = entity.TelemetryFlags(&telemetry)
+			if telemetry.Seq <= lastSeq {
+				telemetry.Flags = append(telemetry.Flags, "seq")
+			} else {
+				lastSeq = telemetry.Seq
+			}
+			if len(telemetry.Flags) > 0 {
+				stats.flagged.Add(1)
+			}
+			b.fleet.report(vehicleID, &telemetry, ingress)
+			// время съёмки ТС передаёт по часам сервера, сервер добавляет время получения и отправки
+			if telemetry.Latency == nil {
+				telemetry.Latency = &entity.LatencyStamps{}
+			}
+			telemetry.Latency.Ingress = ingress
+			for hubEncoding, h := range hubs.hubs {
+				data, err := codec.MarshalTelemetry(hubEncoding, &telemetry)
+				if err != nil {
+					continue
+				}
+				p := packet{data: data, egress: func(egress time.Time) []byte {
+					stamped, latency := telemetry, *telemetry.Latency
+					latency.Egress = egress
+					stamped.Latency = &latency
+					stampedData, err := codec.MarshalTelemetry(hubEncoding, &stamped)
+					if err != nil {
+						return data
+					}
+					return stampedData
+				}}
+				// новый диспетчер сразу получит последнее сообщение телеметрии
+				h.publish(p, []packet{p})
+			}
+		}
+	}
+}
+
+func (b *BroadcastService) GetTelemetryStats(vehicleID int) entity.TelemetryStats {
+	counters, ok := b.telemetryStats.Load(vehicleID)
+	if !ok {
+		return entity.TelemetryStats{}
+	}
+	return entity.TelemetryStats{
+		Frames:    counters.(*telemetryCounters).frames.Load(),
+		Malformed: counters.(*telemetryCounters).malformed.Load(),
+		Flagged:   counters.(*telemetryCounters).flagged.Load(),
 	}
 }
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"sync"
+)
+
+// ackQueueSize - сколько подтверждений может ждать отправки одному диспетчеру
+const ackQueueSize = 100
+
+// errCommandAnswered - команда не доставлена на ТС, но отклонение уже отправлено диспетчеру при закрытии маршрута
+var errCommandAnswered = errors.New("command already answered")
+
+// ackInbox - очередь подтверждений одного диспетчера. Маршрут кладёт в неё подтверждения, не блокируясь,
+// поэтому диспетчер, который не читает подтверждения, не задерживает их для других диспетчеров ТС.
+// Если очередь переполнена, то закрывается overflow и диспетчер отключается
+type ackInbox struct {
+	acks     chan entity.CommandAck
+	overflow chan struct{}
+	once     sync.Once
+}
+
+func newAckInbox() *ackInbox {
+	return &ackInbox{
+		acks:     make(chan entity.CommandAck, ackQueueSize),
+		overflow: make(chan struct{}),
+	}
+}
+
+// push кладёт подтверждение в очередь, не блокируясь
+func (i *ackInbox) push(ack entity.CommandAck) {
+	select {
+	case i.acks <- ack:
+	default:
+		i.once.Do(func() { close(i.overflow) })
+	}
+}
+
+// pendingCommand - команда, отправленная на ТС и ожидающая подтверждения
+type pendingCommand struct {
+	seq   uint64 // порядковый номер команды у диспетчера
+	inbox *ackInbox
+}
+
+// commandRoute доставляет команды диспетчеров на одно ТС и возвращает подтверждения отправителям.
+// Диспетчеры нумеруют команды независимо друг от друга, поэтому на ТС команда уходит под собственным номером маршрута
+type commandRoute struct {
+	commands chan entity.Command
+	done     chan struct{}
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]pendingCommand
+	closed  bool
+}
+
+func newCommandRoute() *commandRoute {
+	return &commandRoute{
+		commands: make(chan entity.Command, 100),
+		done:     make(chan struct{}),
+		pending:  make(map[uint64]pendingCommand),
+	}
+}
+
+// send отправляет команду на ТС. Подтверждение придёт в inbox
+func (r *commandRoute) send(ctx context.Context, command entity.Command, inbox *ackInbox) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return usecase.ErrStreamClosed
+	}
+	r.seq++
+	seq := r.seq
+	r.pending[seq] = pendingCommand{seq: command.Seq, inbox: inbox}
+	r.mu.Unlock()
+
+	command.Seq = seq
+	select {
+	case r.commands <- command:
+		return nil
+	case <-r.done:
+		// close мог уже забрать команду и отклонить её, тогда второе отклонение не нужно
+		if !r.forget(seq) {
+			return errCommandAnswered
+		}
+		return usecase.ErrStreamClosed
+	case <-ctx.Done():
+		r.forget(seq)
+		return ctx.Err()
+	}
+}
+
+// forget удаляет команду, которая не дошла до ТС. Возвращает false, если команду уже забрал ack или close
+func (r *commandRoute) forget(seq uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.pending[seq]
+	delete(r.pending, seq)
+	return ok
+}
+
+// ack передает подтверждение от ТС диспетчеру, отправившему команду
+func (r *commandRoute) ack(ack entity.CommandAck) {
+	r.mu.Lock()
+	p, ok := r.pending[ack.Seq]
+	delete(r.pending, ack.Seq)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	ack.Seq = p.seq
+	p.inbox.push(ack)
+}
+
+// close закрывает маршрут, все неподтверждённые команды считаются отклонёнными
+func (r *commandRoute) close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.done)
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	for _, p := range pending {
+		p.inbox.push(entity.CommandAck{Seq: p.seq, Status: entity.CommandRejected, Error: "vehicle disconnected"})
+	}
+}
+
+func rejectCommand(ctx context.Context, acks chan entity.CommandAck, seq uint64, err error) {
+	select {
+	case acks <- entity.CommandAck{Seq: seq, Status: entity.CommandRejected, Error: err.Error()}:
+	case <-ctx.Done():
+	}
+}
+
+func (b *BroadcastService) SendCommandStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, commands chan entity.Command, acks chan entity.CommandAck, errChan chan error) {
+	if _, err := b.authDispatcher(vehicleID, dispatcherID, dispatcherPassword); err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	inbox := newAckInbox()
+	for {
+		var command entity.Command
+		var ok bool
+		select {
+		case command, ok = <-commands:
+			if !ok {
+				return
+			}
+		case ack := <-inbox.acks:
+			select {
+			case acks <- ack:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		case <-inbox.overflow:
+			sendErr(ctx, errChan, usecase.ErrAckOverflow)
+			return
+		case <-ctx.Done():
+			return
+		}
+		if !entity.IsCommandValid(&command) {
+			rejectCommand(ctx, acks, command.Seq, usecase.ErrBadRequest)
+			continue
+		}
+		// экстренную остановку может отправить любой диспетчер с доступом к ТС, остальные команды - только соединение,
+		// которое арендовало управление
+		if command.Type != entity.EmergencyStopCommand && !b.leases.isHolder(vehicleID, ctx) {
+			rejectCommand(ctx, acks, command.Seq, usecase.ErrNotLeaseHolder)
+			continue
+		}
+		route, ok := b.commandRoutes.Load(vehicleID)
+		if !ok {
+			rejectCommand(ctx, acks, command.Seq, usecase.ErrNotFound)
+			continue
+		}
+		if err := route.(*commandRoute).send(ctx, command, inbox); err != nil {
+			if errors.Is(err, ctx.Err()) {
+				return
+			}
+			if errors.Is(err, errCommandAnswered) {
+				continue
+			}
+			rejectCommand(ctx, acks, command.Seq, err)
+			continue
+		}
+		if command.Type != entity.SetpointCommand {
+			b.audit.post(entity.DispatcherActor(dispatcherID), entity.ControlCommandAudit, entity.VehicleActor(vehicleID), string(command.Type))
+		}
+	}
+}
+
+func (b *BroadcastService) GetCommandStream(ctx context.Context, vehicleID int, vehiclePassword string, commands chan entity.Command, acks chan entity.CommandAck, errChan chan error) {
+	if _, err := b.authVehicle(vehicleID, vehiclePassword); err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	// если ТС переподключилось, то новые команды идут по новому маршруту
+	route := newCommandRoute()
+	b.commandRoutes.Store(vehicleID, route)
+	defer route.close()
+	defer b.commandRoutes.CompareAndDelete(vehicleID, route)
+	for {
+		select {
+		case command := <-route.commands:
+			select {
+			case commands <- command:
+			case <-ctx.Done():
+				return
+			}
+		case ack, ok := <-acks:
+			if !ok {
+				return
+			}
+			route.ack(ack)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
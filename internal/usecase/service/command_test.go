@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"testing"
+)
+
+func TestCommandRouteAck(t *testing.T) {
+	r := newCommandRoute()
+	inbox := newAckInbox()
+	if err := r.send(context.Background(), entity.Command{Seq: 7}, inbox); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	command := <-r.commands
+	r.ack(entity.CommandAck{Seq: command.Seq, Status: entity.CommandApplied})
+	// повторное подтверждение того же номера не доходит до диспетчера
+	r.ack(entity.CommandAck{Seq: command.Seq, Status: entity.CommandApplied})
+	r.close()
+
+	if got := len(inbox.acks); got != 1 {
+		t.Fatalf("acks = %d, want 1", got)
+	}
+	if ack := <-inbox.acks; ack.Seq != 7 || ack.Status != entity.CommandApplied {
+		t.Errorf("ack = %+v, want seq 7 applied", ack)
+	}
+}
+
+func TestCommandRouteClosedWhileSending(t *testing.T) {
+	r := newCommandRoute()
+	inbox := newAckInbox()
+	// заполняем очередь команд ТС, чтобы send ждал
+	for i := 0; i < cap(r.commands); i++ {
+		r.commands <- entity.Command{}
+	}
+	result := make(chan error)
+	go func() { result <- r.send(context.Background(), entity.Command{Seq: 1}, inbox) }()
+	for {
+		r.mu.Lock()
+		n := len(r.pending)
+		r.mu.Unlock()
+		if n == 1 {
+			break
+		}
+	}
+	r.close()
+	err := <-result
+
+	// диспетчер получает ровно одно отклонение: либо от close, либо от вызывающего по ErrStreamClosed
+	rejects := len(inbox.acks)
+	if errors.Is(err, usecase.ErrStreamClosed) {
+		rejects++
+	} else if !errors.Is(err, errCommandAnswered) {
+		t.Fatalf("send = %v, want ErrStreamClosed or errCommandAnswered", err)
+	}
+	if rejects != 1 {
+		t.Errorf("rejects = %d, want 1", rejects)
+	}
+}
+
+func TestCommandRouteSlowDispatcher(t *testing.T) {
+	r := newCommandRoute()
+	slow, fast := newAckInbox(), newAckInbox()
+	send := func(inbox *ackInbox) uint64 {
+		if err := r.send(context.Background(), entity.Command{}, inbox); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		return (<-r.commands).Seq
+	}
+	// медленный диспетчер не читает подтверждения: его очередь переполняется, но ack не блокируется
+	for i := 0; i <= ackQueueSize; i++ {
+		r.ack(entity.CommandAck{Seq: send(slow), Status: entity.CommandApplied})
+	}
+	r.ack(entity.CommandAck{Seq: send(fast), Status: entity.CommandApplied})
+
+	select {
+	case <-slow.overflow:
+	default:
+		t.Error("slow dispatcher overflow is not signalled")
+	}
+	if got := len(fast.acks); got != 1 {
+		t.Errorf("fast dispatcher acks = %d, want 1", got)
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/password"
+	"time"
+)
+
+// credentialRotation - новый пароль ТС или диспетчера, который ещё не сохранён
+type credentialRotation struct {
+	secret       string
+	passwordHash string
+	version      int
+	previous     entity.PreviousCredential
+}
+
+// rotateCredential создаёт новый пароль взамен пароля passwordHash версии version. Если overlap больше нуля,
+// то прежний пароль действует ещё overlap, иначе он отзывается сразу
+func rotateCredential(passwordHash string, version int, overlap time.Duration) (*credentialRotation, error) {
+	secret, err := password.GenerateSecret()
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	newHash, err := password.HashPassword(secret)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	rotation := &credentialRotation{secret: secret, passwordHash: newHash, version: version + 1}
+	if overlap > 0 {
+		rotation.previous = entity.PreviousCredential{PasswordHash: passwordHash, ValidUntil: time.Now().Add(overlap)}
+	}
+	return rotation, nil
+}
+
+// parseOverlap возвращает, сколько прежний пароль действует после смены
+func parseOverlap(request *entity.RotateCredentialRequest) (time.Duration, error) {
+	if request.Overlap == "" {
+		return 0, nil
+	}
+	overlap, err := time.ParseDuration(request.Overlap)
+	if err != nil || overlap < 0 {
+		return 0, errors.Join(usecase.ErrBadRequest, fmt.Errorf("invalid credential overlap"))
+	}
+	return overlap, nil
+}
+
+// revokeSessions сообщает серверу ретрансляции, что сессии с паролем старше rotation.version нужно отключить:
+// с паролями до прежнего - сразу, с прежним - когда закончится перекрытие. Отзыв уже сохранён вместе с паролем,
+// поэтому ошибка не отменяет смену, а только возвращается как false
+func (a AdminService) revokeSessions(role entity.TokenRole, id int, rotation *credentialRotation) bool {
+	revocation := entity.NewCredentialRevocation(role, id, rotation.version, rotation.previous)
+	return a.revocationRepo.PublishRevocation(&revocation) == nil
+}
+
+// rotationRecord создаёт запись журнала аудита о смене пароля, которая сохраняется вместе с новым паролем
+func rotationRecord(caller *entity.Admin, action entity.AuditAction, target string, rotation *credentialRotation) *entity.AuditRecord {
+	details := fmt.Sprintf("credential_version=%d", rotation.version)
+	if rotation.previous.PasswordHash != "" {
+		details += " previous_valid_until=" + rotation.previous.ValidUntil.Format(time.RFC3339)
+	}
+	return adminRecord(caller, action, target, details)
+}
+
+// rotationResponse возвращает новый пароль администратору
+func rotationResponse(id int, rotation *credentialRotation, revoked bool) *entity.RotateCredentialResponse {
+	response := &entity.RotateCredentialResponse{
+		ID:                id,
+		Secret:            rotation.secret,
+		CredentialVersion: rotation.version,
+		SessionsRevoked:   revoked,
+	}
+	if rotation.previous.PasswordHash != "" {
+		response.PreviousValidUntil = &rotation.previous.ValidUntil
+	}
+	return response
+}
+
+func (a AdminService) RotateVehicleCredential(token string, id int, request *entity.RotateCredentialRequest) (*entity.RotateCredentialResponse, error) {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return nil, err
+	}
+	overlap, err := parseOverlap(request)
+	if err != nil {
+		return nil, err
+	}
+	var rotation *credentialRotation
+	err = a.vehicleRepo.RotateVehicleCredential(id, func(vehicle *entity.Vehicle) (*entity.AuditRecord, error) {
+		var err error
+		if rotation, err = rotateCredential(vehicle.PasswordHash, vehicle.CredentialVersion, overlap); err != nil {
+			return nil, err
+		}
+		vehicle.PasswordHash = rotation.passwordHash
+		vehicle.CredentialVersion = rotation.version
+		vehicle.PreviousCredential = rotation.previous
+		return rotationRecord(caller, entity.VehicleRotateAudit, entity.VehicleActor(id), rotation), nil
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrVehicleNotFound):
+		return nil, usecase.ErrVehicleNotFound
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return rotationResponse(id, rotation, a.revokeSessions(entity.VehicleToken, id, rotation)), nil
+}
+
+func (a AdminService) RotateDispatcherCredential(token string, id int, request *entity.RotateCredentialRequest) (*entity.RotateCredentialResponse, error) {
+	caller, err := a.authorize(token, entity.ManageFleetPermission)
+	if err != nil {
+		return nil, err
+	}
+	overlap, err := parseOverlap(request)
+	if err != nil {
+		return nil, err
+	}
+	var rotation *credentialRotation
+	err = a.dispatcherRepo.RotateDispatcherCredential(id, func(dispatcher *entity.Dispatcher) (*entity.AuditRecord, error) {
+		var err error
+		if rotation, err = rotateCredential(dispatcher.PasswordHash, dispatcher.CredentialVersion, overlap); err != nil {
+			return nil, err
+		}
+		dispatcher.PasswordHash = rotation.passwordHash
+		dispatcher.CredentialVersion = rotation.version
+		dispatcher.PreviousCredential = rotation.previous
+		return rotationRecord(caller, entity.DispatcherRotateAudit, entity.DispatcherActor(id), rotation), nil
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, repo.ErrDispatcherNotFound):
+		return nil, usecase.ErrDispatcherNotFound
+	default:
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return rotationResponse(id, rotation, a.revokeSessions(entity.DispatcherToken, id, rotation)), nil
+}
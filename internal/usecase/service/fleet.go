@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"sync"
+	"time"
+)
+
+const (
+	// fleetRefreshInterval - как часто диспетчер получает обзор парка, даже если подключения ТС не менялись:
+	// за это время обновляется телеметрия
+	fleetRefreshInterval = time.Second
+	// fleetSettleDelay - сколько обзор ждёт после изменения: ТС открывает несколько потоков подряд,
+	// и диспетчер получает один обзор вместо нескольких
+	fleetSettleDelay = 100 * time.Millisecond
+)
+
+// fleetMonitor собирает то, что нужно для обзора парка и не хранится в трансляциях: последнюю телеметрию
+// и диспетчеров каждого ТС. Об изменениях трансляций, сессий и аренды он узнаёт через notify
+type fleetMonitor struct {
+	mu sync.Mutex
+	// changed закрывается и заменяется при каждом изменении, как в vehicleSession
+	changed chan struct{}
+	// watchers - число трансляций ТС, которые получает каждый диспетчер
+	watchers map[int]map[int]int
+	// telemetry хранит *entity.TelemetrySummary для каждого ТС. Обновляется с каждым кадром телеметрии,
+	// поэтому без общей блокировки. Запись удаляется, когда сессия ТС закрывается или ТС удаляют
+	telemetry sync.Map
+}
+
+func newFleetMonitor() *fleetMonitor {
+	return &fleetMonitor{
+		changed:  make(chan struct{}),
+		watchers: make(map[int]map[int]int),
+	}
+}
+
+// notify сообщает диспетчерам, что обзор парка изменился
+func (f *fleetMonitor) notify() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// updated возвращает канал, который закроется при следующем изменении
+func (f *fleetMonitor) updated() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.changed
+}
+
+// watch отмечает, что диспетчер начал получать трансляцию ТС, до вызова unwatch
+func (f *fleetMonitor) watch(vehicleID, dispatcherID int) {
+	f.mu.Lock()
+	dispatchers, ok := f.watchers[vehicleID]
+	if !ok {
+		dispatchers = make(map[int]int)
+		f.watchers[vehicleID] = dispatchers
+	}
+	dispatchers[dispatcherID]++
+	f.mu.Unlock()
+	f.notify()
+}
+
+func (f *fleetMonitor) unwatch(vehicleID, dispatcherID int) {
+	f.mu.Lock()
+	dispatchers := f.watchers[vehicleID]
+	if dispatchers[dispatcherID]--; dispatchers[dispatcherID] <= 0 {
+		delete(dispatchers, dispatcherID)
+	}
+	if len(dispatchers) == 0 {
+		delete(f.watchers, vehicleID)
+	}
+	f.mu.Unlock()
+	f.notify()
+}
+
+// dispatchers возвращает диспетчеров, которые получают трансляции ТС, по возрастанию ID
+func (f *fleetMonitor) dispatchers(vehicleID int) []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dispatchers := maps.Keys(f.watchers[vehicleID])
+	slices.Sort(dispatchers)
+	return dispatchers
+}
+
+// vehicles возвращает ТС, у которых есть диспетчеры или известна телеметрия
+func (f *fleetMonitor) vehicles() []int {
+	f.mu.Lock()
+	vehicles := maps.Keys(f.watchers)
+	f.mu.Unlock()
+	f.telemetry.Range(func(key, _ any) bool {
+		vehicles = append(vehicles, key.(int))
+		return true
+	})
+	return vehicles
+}
+
+// report запоминает последнюю телеметрию ТС
+func (f *fleetMonitor) report(vehicleID int, telemetry *entity.Telemetry, received time.Time) {
+	f.telemetry.Store(vehicleID, &entity.TelemetrySummary{
+		Seq:        telemetry.Seq,
+		Speed:      telemetry.Speed,
+		Position:   telemetry.Position,
+		Battery:    telemetry.Battery,
+		Mode:       telemetry.Mode,
+		Faults:     telemetry.Faults,
+		ReceivedAt: received,
+	})
+}
+
+// forget удаляет последнюю телеметрию ТС
+func (f *fleetMonitor) forget(vehicleID int) {
+	f.telemetry.Delete(vehicleID)
+}
+
+func (f *fleetMonitor) lastTelemetry(vehicleID int) *entity.TelemetrySummary {
+	summary, ok := f.telemetry.Load(vehicleID)
+	if !ok {
+		return nil
+	}
+	return summary.(*entity.TelemetrySummary)
+}
+
+// fleetStatus собирает обзор ТС, к которым у диспетчера есть доступ. Диспетчер со всеми правами
+// видит ТС, о которых знает сервер: подключённые, переподключающиеся и те, трансляции которых получают диспетчеры
+func (b *BroadcastService) fleetStatus(dispatcher *entity.Dispatcher) entity.FleetStatus {
+	var vehicles []int
+	if dispatcher.GrantsType == entity.AllGrants {
+		vehicles = append(b.sessions.vehicles(), b.fleet.vehicles()...)
+	} else {
+		vehicles = slices.Clone(dispatcher.Grants)
+	}
+	slices.Sort(vehicles)
+	vehicles = slices.Compact(vehicles)
+
+	status := entity.FleetStatus{Vehicles: make([]entity.VehicleOverview, 0, len(vehicles)), At: time.Now()}
+	for _, vehicleID := range vehicles {
+		status.Vehicles = append(status.Vehicles, entity.VehicleOverview{
+			VehicleID:    vehicleID,
+			State:        b.sessions.state(vehicleID),
+			Cameras:      b.GetVideoCameras(vehicleID),
+			Telemetry:    b.fleet.lastTelemetry(vehicleID),
+			Watchers:     b.fleet.dispatchers(vehicleID),
+			ControllerID: b.leases.holderID(vehicleID),
+		})
+	}
+	return status
+}
+
+func (b *BroadcastService) GetFleetStream(ctx context.Context, dispatcherID int, dispatcherPassword string, states chan entity.FleetStatus, errChan chan error) {
+	dispatcher, err := b.checkDispatcher(dispatcherID, dispatcherPassword)
+	if err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	ticker := time.NewTicker(fleetRefreshInterval)
+	defer ticker.Stop()
+	for {
+		// канал берётся до сбора обзора, чтобы не пропустить изменение во время сбора
+		updated := b.fleet.updated()
+		// права могли изменить с прошлого обзора. Если хранилище недоступно, то обзор собирается по прежним правам
+		current, err := b.currentGrants(dispatcherID, dispatcher.CredentialVersion)
+		switch {
+		case err == nil:
+			dispatcher = current
+		case errors.Is(err, usecase.ErrDispatcherNotFound):
+			sendErr(ctx, errChan, err)
+			return
+		}
+		select {
+		case states <- b.fleetStatus(dispatcher):
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-updated:
+			select {
+			case <-time.After(fleetSettleDelay):
+			case <-ctx.Done():
+				return
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"self-driving-car-dispatch-system/internal/entity"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFleetService создаёт BroadcastService, как newTestDispatcherService, с тем, что нужно для обзора парка
+func newTestFleetService(t *testing.T, gracePeriod time.Duration, dispatchers ...entity.Dispatcher) *BroadcastService {
+	t.Helper()
+	b, _ := newTestDispatcherService(t, dispatchers...)
+	b.watchdog = newStreamWatchdog(newTestHealthRepo())
+	b.sessions = newSessionManager(gracePeriod, b.watchdog, b.fleet)
+	b.leases = newLeaseManager(leaseTTL, b.fleet, &auditTrail{queue: make(chan entity.AuditRecord, auditQueueSize)})
+	return b
+}
+
+// overview возвращает ТС обзора парка в виде "ID:состояние", через запятую. Если известна телеметрия,
+// то после состояния добавляется её номер, если есть диспетчеры - их ID
+func overview(status entity.FleetStatus) string {
+	vehicles := make([]string, 0, len(status.Vehicles))
+	for _, vehicle := range status.Vehicles {
+		s := fmt.Sprintf("%d:%s", vehicle.VehicleID, vehicle.State)
+		if vehicle.Telemetry != nil {
+			s += fmt.Sprintf(" seq=%d", vehicle.Telemetry.Seq)
+		}
+		if len(vehicle.Watchers) != 0 {
+			s += fmt.Sprintf(" watchers=%v", vehicle.Watchers)
+		}
+		vehicles = append(vehicles, s)
+	}
+	return strings.Join(vehicles, ",")
+}
+
+func TestFleetStatusGrants(t *testing.T) {
+	b := newTestFleetService(t, time.Hour)
+	b.sessions.connect(1, []string{"front"})
+	b.fleet.report(1, &entity.Telemetry{Seq: 5}, time.Now())
+	b.sessions.connect(2, nil)
+	b.sessions.disconnect(2)
+	// диспетчер ждёт трансляцию ТС, которое ещё не подключалось
+	b.fleet.watch(4, 8)
+
+	tests := []struct {
+		name       string
+		dispatcher entity.Dispatcher
+		want       string
+	}{
+		{
+			name:       "all grants",
+			dispatcher: entity.Dispatcher{ID: 7, GrantsType: entity.AllGrants},
+			want:       "1:online seq=5,2:reconnecting,4:offline watchers=[8]",
+		},
+		{
+			name:       "list grants",
+			dispatcher: entity.Dispatcher{ID: 8, GrantsType: entity.ListGrants, Grants: []int{4, 3, 1}},
+			want:       "1:online seq=5,3:offline,4:offline watchers=[8]",
+		},
+		{
+			name:       "no grants",
+			dispatcher: entity.Dispatcher{ID: 9, GrantsType: entity.ListGrants, Grants: []int{}},
+			want:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overview(b.fleetStatus(&tt.dispatcher)); got != tt.want {
+				t.Errorf("fleetStatus = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFleetTelemetryForget проверяет, что телеметрия ТС не копится в обзоре парка после закрытия сессии ТС
+// и удаления ТС
+func TestFleetTelemetryForget(t *testing.T) {
+	all := &entity.Dispatcher{ID: 7, GrantsType: entity.AllGrants}
+
+	// без грейс-периода сессия закрывается при отключении
+	b := newTestFleetService(t, 0)
+	b.sessions.connect(1, nil)
+	b.fleet.report(1, &entity.Telemetry{Seq: 1}, time.Now())
+	b.sessions.disconnect(1)
+	if got := overview(b.fleetStatus(all)); got != "" {
+		t.Errorf("fleetStatus after session closed = %q, want empty", got)
+	}
+
+	// переподключающееся ТС сохраняет телеметрию, пока его не удалят
+	b = newTestFleetService(t, time.Hour)
+	b.sessions.connect(2, nil)
+	b.fleet.report(2, &entity.Telemetry{Seq: 1}, time.Now())
+	b.sessions.disconnect(2)
+	if got := overview(b.fleetStatus(all)); got != "2:reconnecting seq=1" {
+		t.Errorf("fleetStatus of reconnecting vehicle = %q, want telemetry", got)
+	}
+	// ТС нет в хранилище, как после удаления
+	b.applyRevocation(entity.NewAccessRevocation(entity.VehicleToken, 2))
+	if got := overview(b.fleetStatus(all)); got != "2:reconnecting" {
+		t.Errorf("fleetStatus after vehicle deleted = %q, want no telemetry", got)
+	}
+}
+
+// nextStatus возвращает следующий обзор парка, если он пришёл в течение timeout
+func nextStatus(t *testing.T, states chan entity.FleetStatus, timeout time.Duration) entity.FleetStatus {
+	t.Helper()
+	select {
+	case status := <-states:
+		return status
+	case <-time.After(timeout):
+		t.Fatalf("no fleet status in %s", timeout)
+		return entity.FleetStatus{}
+	}
+}
+
+// TestGetFleetStreamUpdates проверяет, что диспетчер получает обзор сразу после изменений, не дожидаясь
+// периодического обновления, и что изменения подряд приходят одним обзором
+func TestGetFleetStreamUpdates(t *testing.T) {
+	b := newTestFleetService(t, time.Hour, entity.Dispatcher{ID: 7, GrantsType: entity.AllGrants})
+	// по токену пароль не проверяется, и первый обзор приходит сразу
+	session, err := b.LoginDispatcher(7, testDispatcherPassword)
+	if err != nil {
+		t.Fatalf("LoginDispatcher: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := make(chan entity.FleetStatus)
+	errChan := make(chan error, 1)
+	go b.GetFleetStream(ctx, 7, session.Token, states, errChan)
+
+	if got := overview(nextStatus(t, states, time.Second)); got != "" {
+		t.Fatalf("first fleet status = %q, want empty", got)
+	}
+	// обзор ещё собирается, пока ТС открывает потоки
+	b.sessions.connect(1, nil)
+	b.fleet.report(1, &entity.Telemetry{Seq: 1}, time.Now())
+	b.fleet.notify()
+	b.sessions.connect(2, nil)
+	if got := overview(nextStatus(t, states, fleetRefreshInterval/2)); got != "1:online seq=1,2:online" {
+		t.Errorf("fleet status after connect = %q, want both vehicles", got)
+	}
+
+	b.sessions.disconnect(2)
+	if got := overview(nextStatus(t, states, fleetRefreshInterval/2)); got != "1:online seq=1,2:reconnecting" {
+		t.Errorf("fleet status after disconnect = %q, want vehicle 2 reconnecting", got)
+	}
+	select {
+	case err := <-errChan:
+		t.Errorf("GetFleetStream: %v", err)
+	default:
+	}
+}
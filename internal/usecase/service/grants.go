@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"sync"
+	"time"
+)
+
+// grantsKey - диспетчер и версия пароля, с которой он вошёл
+type grantsKey struct {
+	dispatcherID      int
+	credentialVersion int
+}
+
+// dispatcherGrants - права диспетчера, прочитанные из хранилища в loadedAt
+type dispatcherGrants struct {
+	grantsType entity.GrantsType
+	grants     []int
+	loadedAt   time.Time
+}
+
+// grantWatch - трансляция ТС vehicleID, которую получает диспетчер. cancel отменяет её, когда диспетчер
+// теряет доступ к ТС
+type grantWatch struct {
+	vehicleID int
+	cancel    context.CancelCauseFunc
+}
+
+// grantsCache хранит права диспетчеров, вошедших по токену. Права не записываются в токен: у диспетчера
+// с доступом к сотням ТС токен не поместился бы в сообщение рукопожатия. Права читаются из хранилища при входе
+// по паролю, при продлении токена и, если их нет в кэше, при первом обращении по токену, например, после
+// перезапуска сервера. Запись действует ttl, как и токен, а при изменении прав сервис администратора сообщает
+// об этом через RevocationRepo: запись удаляется, а трансляции ТС, к которым у диспетчера больше нет доступа,
+// отменяются
+type grantsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[grantsKey]dispatcherGrants
+	// watches - трансляции, которые получает каждый диспетчер
+	watches map[int]map[*grantWatch]struct{}
+}
+
+func newGrantsCache(ttl time.Duration) *grantsCache {
+	return &grantsCache{
+		ttl:     ttl,
+		entries: make(map[grantsKey]dispatcherGrants),
+		watches: make(map[int]map[*grantWatch]struct{}),
+	}
+}
+
+// get возвращает диспетчера с правами из кэша, если они прочитаны не раньше ttl назад
+func (c *grantsCache) get(key grantsKey) (*entity.Dispatcher, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.loadedAt) >= c.ttl {
+		return nil, false
+	}
+	return &entity.Dispatcher{
+		ID:                key.dispatcherID,
+		GrantsType:        entry.grantsType,
+		Grants:            entry.grants,
+		CredentialVersion: key.credentialVersion,
+	}, true
+}
+
+// put сохраняет права диспетчера и удаляет устаревшие записи
+func (c *grantsCache) put(key grantsKey, dispatcher *entity.Dispatcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.Sub(entry.loadedAt) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[key] = dispatcherGrants{
+		grantsType: dispatcher.GrantsType,
+		grants:     dispatcher.Grants,
+		loadedAt:   now,
+	}
+}
+
+// watch отмечает, что диспетчер начал получать трансляцию ТС, и возвращает её контекст: он отменяется
+// с usecase.ErrAccessDenied, если диспетчер потеряет доступ к ТС. release вызывается по окончании трансляции
+func (c *grantsCache) watch(ctx context.Context, dispatcherID, vehicleID int) (watchCtx context.Context, release func()) {
+	watchCtx, cancel := context.WithCancelCause(ctx)
+	w := &grantWatch{vehicleID: vehicleID, cancel: cancel}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watches[dispatcherID] == nil {
+		c.watches[dispatcherID] = make(map[*grantWatch]struct{})
+	}
+	c.watches[dispatcherID][w] = struct{}{}
+	return watchCtx, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.watches[dispatcherID], w)
+		if len(c.watches[dispatcherID]) == 0 {
+			delete(c.watches, dispatcherID)
+		}
+		cancel(nil)
+	}
+}
+
+// revoke удаляет права диспетчера из кэша и отменяет трансляции ТС, к которым у него больше нет доступа
+// по правам dispatcher, прочитанным из хранилища. dispatcher nil - диспетчер удалён, отменяются все его трансляции
+func (c *grantsCache) revoke(dispatcherID int, dispatcher *entity.Dispatcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.dispatcherID == dispatcherID {
+			delete(c.entries, key)
+		}
+	}
+	for w := range c.watches[dispatcherID] {
+		if dispatcher == nil || !dispatcher.CanAccess(w.vehicleID) {
+			w.cancel(errors.Join(usecase.ErrAccessDenied, fmt.Errorf("права диспетчера изменены")))
+		}
+	}
+}
+
+// holdGrant передаёт трансляцию ТС диспетчеру функцией stream, пока у него есть доступ к ТС. Если права диспетчера
+// изменили и доступа больше нет, то stream отменяется, а в errChan передаётся usecase.ErrAccessDenied
+func (b *BroadcastService) holdGrant(ctx context.Context, vehicleID, dispatcherID int, errChan chan error, stream func(ctx context.Context)) {
+	watchCtx, release := b.grants.watch(ctx, dispatcherID, vehicleID)
+	defer release()
+	stream(watchCtx)
+	if err := context.Cause(watchCtx); ctx.Err() == nil && errors.Is(err, usecase.ErrAccessDenied) {
+		sendErr(ctx, errChan, err)
+	}
+}
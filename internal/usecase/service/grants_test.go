@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"testing"
+	"time"
+)
+
+func TestGrantsCacheRevoke(t *testing.T) {
+	c := newGrantsCache(time.Hour)
+	c.put(grantsKey{dispatcherID: 7, credentialVersion: 0}, &entity.Dispatcher{ID: 7, GrantsType: entity.ListGrants, Grants: []int{1, 2}})
+	c.put(grantsKey{dispatcherID: 7, credentialVersion: 1}, &entity.Dispatcher{ID: 7, GrantsType: entity.ListGrants, Grants: []int{1, 2}})
+	c.put(grantsKey{dispatcherID: 8, credentialVersion: 0}, &entity.Dispatcher{ID: 8, GrantsType: entity.AllGrants})
+	ctx := context.Background()
+	lost, releaseLost := c.watch(ctx, 7, 1)
+	defer releaseLost()
+	kept, releaseKept := c.watch(ctx, 7, 2)
+	defer releaseKept()
+	other, releaseOther := c.watch(ctx, 8, 1)
+	defer releaseOther()
+
+	c.revoke(7, &entity.Dispatcher{ID: 7, GrantsType: entity.ListGrants, Grants: []int{2}})
+	for _, version := range []int{0, 1} {
+		if _, ok := c.get(grantsKey{dispatcherID: 7, credentialVersion: version}); ok {
+			t.Errorf("grants of version %d are cached after revoke", version)
+		}
+	}
+	if _, ok := c.get(grantsKey{dispatcherID: 8}); !ok {
+		t.Error("grants of other dispatcher are evicted")
+	}
+	if err := context.Cause(lost); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("stream of revoked vehicle: cause = %v, want ErrAccessDenied", err)
+	}
+	if kept.Err() != nil || other.Err() != nil {
+		t.Fatal("stream of granted vehicle is cancelled")
+	}
+
+	// завершённая трансляция не отменяется, удалённый диспетчер теряет все трансляции
+	releaseKept()
+	c.revoke(8, nil)
+	if !errors.Is(context.Cause(kept), context.Canceled) {
+		t.Errorf("released stream: cause = %v, want context.Canceled", context.Cause(kept))
+	}
+	if err := context.Cause(other); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("stream of deleted dispatcher: cause = %v, want ErrAccessDenied", err)
+	}
+	if len(c.watches[7]) != 1 {
+		t.Errorf("watches of dispatcher 7 = %d, want 1", len(c.watches[7]))
+	}
+}
+
+// eventStream запускает GetEventStream и возвращает канал, в который он передаст ошибку
+func eventStream(ctx context.Context, b *BroadcastService, vehicleID, dispatcherID int, credential string) chan error {
+	errChan := make(chan error, 1)
+	go b.GetEventStream(ctx, vehicleID, dispatcherID, credential, make(chan entity.StreamEvent), errChan)
+	return errChan
+}
+
+// waitWatches ждёт, пока у диспетчера будет n трансляций
+func waitWatches(t *testing.T, c *grantsCache, dispatcherID, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		got := len(c.watches[dispatcherID])
+		c.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("watches = %d, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestEditDispatcherGrants проверяет, что изменение прав диспетчера действует сразу, не дожидаясь конца срока
+// записи в кэше: токен не даёт доступа к отнятому ТС, трансляции этого ТС отменяются, а обзор парка сужается
+func TestEditDispatcherGrants(t *testing.T) {
+	b := newTestFleetService(t, time.Hour, entity.Dispatcher{ID: 7, GrantsType: entity.ListGrants, Grants: []int{1, 2}})
+	a, adminToken := newTestAdminService(t, b)
+	revocationRepo := b.tokens.revocationRepo.(*testRevocationRepo)
+	session, err := b.LoginDispatcher(7, testDispatcherPassword)
+	if err != nil {
+		t.Fatalf("LoginDispatcher: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lostErr := eventStream(ctx, b, 1, 7, session.Token)
+	keptErr := eventStream(ctx, b, 2, 7, session.Token)
+	waitWatches(t, b.grants, 7, 2)
+	states := make(chan entity.FleetStatus)
+	fleetErr := make(chan error, 1)
+	go b.GetFleetStream(ctx, 7, session.Token, states, fleetErr)
+	if got := overview(nextStatus(t, states, time.Second)); got != "1:offline,2:offline" {
+		t.Fatalf("fleet status = %q, want both vehicles", got)
+	}
+
+	if err = a.EditDispatcher(adminToken, &entity.EditDispatcherRequest{ID: 7, GrantsType: entity.ListGrants, Grants: []int{2}}); err != nil {
+		t.Fatalf("EditDispatcher: %v", err)
+	}
+	if len(revocationRepo.published) != 1 || revocationRepo.published[0] != entity.NewAccessRevocation(entity.DispatcherToken, 7) {
+		t.Fatalf("published = %+v, want access revocation of dispatcher 7", revocationRepo.published)
+	}
+	b.applyRevocation(revocationRepo.published[0])
+	expectClosed(t, lostErr, usecase.ErrAccessDenied)
+	expectOpen(t, keptErr)
+	if _, err = b.authDispatcher(1, 7, session.Token); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("authDispatcher to revoked vehicle: err = %v, want ErrAccessDenied", err)
+	}
+	if got := overview(nextStatus(t, states, fleetRefreshInterval/2)); got != "2:offline" {
+		t.Errorf("fleet status after edit = %q, want only vehicle 2", got)
+	}
+
+	// удалённый диспетчер теряет все трансляции и обзор парка
+	if err = a.DeleteDispatcher(adminToken, 7); err != nil {
+		t.Fatalf("DeleteDispatcher: %v", err)
+	}
+	b.applyRevocation(revocationRepo.published[1])
+	expectClosed(t, keptErr, usecase.ErrAccessDenied)
+	expectClosed(t, fleetErr, usecase.ErrDispatcherNotFound)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// subscriberQueueSize - размер очереди каждого подписчика по умолчанию
+const subscriberQueueSize = 100
+
+// videoQueueSize - размер очереди подписчика на видео в кадрах, около секунды видео.
+// Чем больше очередь, тем дольше задержка у диспетчера до того, как начнут отбрасываться кадры
+const videoQueueSize = 30
+
+// dropPolicy определяет, какие данные отбрасываются при переполнении очереди подписчика
+type dropPolicy int
+
+const (
+	// dropOldest отбрасывает самый старый пакет в очереди, чтобы освободить место для нового
+	dropOldest dropPolicy = iota
+	// dropNewest отбрасывает новый пакет, оставляя очередь без изменений
+	dropNewest
+	// dropFrames отбрасывает видео целыми кадрами: при заполнении очереди наполовину отбрасываются неопорные
+	// кадры, а при полном заполнении очередь очищается и подписчик ждёт следующий ключевой кадр.
+	// Так декодер диспетчера никогда не получает кадр, который ссылается на отброшенный
+	dropFrames
+)
+
+// packet - единица данных трансляции: сообщение телеметрии или кадр видео
+type packet struct {
+	data []byte
+	// keyframe - кадр, с которого можно начать декодирование видео
+	keyframe bool
+	// reference - на кадр ссылаются следующие кадры, поэтому его нельзя отбросить отдельно
+	reference bool
+	// egress, если задан, возвращает данные с отметкой времени отправки диспетчеру вместо data
+	egress func(time.Time) []byte
+}
+
+// bytes возвращает данные пакета для отправки диспетчеру в момент now
+func (p packet) bytes(now time.Time) []byte {
+	if p.egress != nil {
+		return p.egress(now)
+	}
+	return p.data
+}
+
+// subscriber - подписчик на поток данных ТС. У каждого подписчика своя ограниченная очередь,
+// поэтому медленный диспетчер не влияет ни на других диспетчеров, ни на ТС
+type subscriber struct {
+	queue   chan packet
+	policy  dropPolicy
+	dropped atomic.Uint64
+	// waitKeyframe - очередь была очищена, и до ключевого кадра видео отбрасывается
+	waitKeyframe bool
+}
+
+// push кладёт пакет в очередь подписчика, не блокируясь
+func (s *subscriber) push(p packet) {
+	if s.policy == dropFrames {
+		s.pushFrame(p)
+		return
+	}
+	for {
+		select {
+		case s.queue <- p:
+			return
+		default:
+		}
+		if s.policy == dropNewest {
+			s.dropped.Add(1)
+			return
+		}
+		// очередь заполнена, удаляем самый старый пакет и пробуем снова
+		select {
+		case <-s.queue:
+			s.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// pushFrame кладёт в очередь кадр видео по правилам dropFrames
+func (s *subscriber) pushFrame(p packet) {
+	if p.keyframe {
+		s.waitKeyframe = false
+	}
+	switch {
+	case s.waitKeyframe:
+		s.dropped.Add(1)
+		return
+	case !p.reference && len(s.queue) >= cap(s.queue)/2:
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.queue <- p:
+		return
+	default:
+	}
+	// очередь заполнена: все кадры в ней устарели, отбрасываем их и ждём ключевой кадр
+	s.drain()
+	if !p.keyframe {
+		s.waitKeyframe = true
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.queue <- p:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// drain удаляет все пакеты из очереди
+func (s *subscriber) drain() {
+	for {
+		select {
+		case <-s.queue:
+			s.dropped.Add(1)
+		default:
+			return
+		}
+	}
+}
+
+// hub раздаёт поток данных одного ТС всем подписанным диспетчерам
+type hub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	// snapshot - последнее состояние потока, которое новый подписчик получает перед живыми данными
+	snapshot []packet
+	closed   bool
+}
+
+func newHub() *hub {
+	return &hub{
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// publish рассылает пакет всем подписчикам и заменяет snapshot, в котором уже должен быть учтён этот пакет.
+// Метод никогда не блокируется, поэтому не замедляет чтение с ТС
+func (h *hub) publish(p packet, snapshot []packet) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.snapshot = snapshot
+	for s := range h.subscribers {
+		s.push(p)
+	}
+}
+
+// subscribe создаёт нового подписчика. Если replay, то в его очередь сразу кладётся snapshot, а очередь
+// увеличивается на размер snapshot, чтобы он не вытеснил живые данные. Иначе подписчик получает только живые данные.
+// Видео без snapshot подписчик получает начиная со следующего ключевого кадра. Если hub уже закрыт,
+// то очередь подписчика сразу закрыта
+func (h *hub) subscribe(size int, policy dropPolicy, replay bool) *subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var snapshot []packet
+	if replay {
+		snapshot = h.snapshot
+	}
+	s := &subscriber{
+		queue:        make(chan packet, size+len(snapshot)),
+		policy:       policy,
+		waitKeyframe: len(snapshot) == 0 && policy == dropFrames,
+	}
+	if h.closed {
+		close(s.queue)
+		return s
+	}
+	// snapshot и publish защищены одной блокировкой, поэтому подписчик не пропустит и не получит дважды ни один пакет
+	for _, p := range snapshot {
+		s.queue <- p
+	}
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// unsubscribe отписывает подписчика от потока
+func (h *hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, s)
+}
+
+// isClosed сообщает, закончилась ли трансляция
+func (h *hub) isClosed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closed
+}
+
+// close завершает трансляцию: очереди всех подписчиков закрываются, новые пакеты игнорируются
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for s := range h.subscribers {
+		close(s.queue)
+	}
+	h.subscribers = nil
+	h.snapshot = nil
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"golang.org/x/exp/slices"
+	"testing"
+)
+
+// drainQueue возвращает данные всех пакетов, которые сейчас лежат в очереди подписчика
+func drainQueue(s *subscriber) []string {
+	var got []string
+	for {
+		select {
+		case p, ok := <-s.queue:
+			if !ok {
+				return got
+			}
+			got = append(got, string(p.data))
+		default:
+			return got
+		}
+	}
+}
+
+func TestSubscriberPushOverflow(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      dropPolicy
+		push        []string
+		want        []string
+		wantDropped uint64
+	}{
+		{name: "oldest fits", policy: dropOldest, push: []string{"a", "b"}, want: []string{"a", "b"}},
+		{name: "oldest overflow", policy: dropOldest, push: []string{"a", "b", "c", "d", "e"}, want: []string{"c", "d", "e"}, wantDropped: 2},
+		{name: "newest fits", policy: dropNewest, push: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "newest overflow", policy: dropNewest, push: []string{"a", "b", "c", "d", "e"}, want: []string{"a", "b", "c"}, wantDropped: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &subscriber{queue: make(chan packet, 3), policy: tt.policy}
+			for _, d := range tt.push {
+				s.push(packet{data: []byte(d)})
+			}
+			if got := drainQueue(s); !slices.Equal(got, tt.want) {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+			if got := s.dropped.Load(); got != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+// frame описывает кадр видео для проверки dropFrames: K - ключевой, P - опорный, B - неопорный
+func frame(name string) packet {
+	return packet{data: []byte(name), keyframe: name[0] == 'K', reference: name[0] != 'B'}
+}
+
+func TestSubscriberPushFrames(t *testing.T) {
+	tests := []struct {
+		name         string
+		waitKeyframe bool
+		push         []string
+		want         []string
+		wantDropped  uint64
+		wantWait     bool
+	}{
+		{
+			name: "fits",
+			push: []string{"K1", "B2", "P3"},
+			want: []string{"K1", "B2", "P3"},
+		},
+		{
+			name:        "non-reference dropped at half",
+			push:        []string{"K1", "P2", "B3", "P4"},
+			want:        []string{"K1", "P2", "P4"},
+			wantDropped: 1,
+		},
+		{
+			// очередь на 4 кадра заполнена, пятый кадр очищает её и ждёт ключевого кадра
+			name:        "full queue waits for keyframe",
+			push:        []string{"K1", "P2", "P3", "P4", "P5", "P6"},
+			want:        nil,
+			wantDropped: 6,
+			wantWait:    true,
+		},
+		{
+			name:        "keyframe after drain",
+			push:        []string{"K1", "P2", "P3", "P4", "P5", "P6", "K7", "P8"},
+			want:        []string{"K7", "P8"},
+			wantDropped: 6,
+		},
+		{
+			name:        "full queue keyframe replaces queue",
+			push:        []string{"K1", "P2", "P3", "P4", "K5"},
+			want:        []string{"K5"},
+			wantDropped: 4,
+		},
+		{
+			name:         "new subscriber waits for keyframe",
+			waitKeyframe: true,
+			push:         []string{"P1", "B2", "K3", "P4"},
+			want:         []string{"K3", "P4"},
+			wantDropped:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &subscriber{queue: make(chan packet, 4), policy: dropFrames, waitKeyframe: tt.waitKeyframe}
+			for _, name := range tt.push {
+				s.push(frame(name))
+			}
+			if got := drainQueue(s); !slices.Equal(got, tt.want) {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+			if got := s.dropped.Load(); got != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", got, tt.wantDropped)
+			}
+			if s.waitKeyframe != tt.wantWait {
+				t.Errorf("waitKeyframe = %t, want %t", s.waitKeyframe, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestHubSubscribeReplay(t *testing.T) {
+	h := newHub()
+	first := frame("K1")
+	h.publish(first, []packet{first})
+	second := frame("P2")
+	h.publish(second, []packet{first, second})
+
+	tests := []struct {
+		name   string
+		policy dropPolicy
+		replay bool
+		want   []string
+	}{
+		{name: "replay", policy: dropFrames, replay: true, want: []string{"K1", "P2", "P3"}},
+		// без snapshot видео начинается со следующего ключевого кадра
+		{name: "no replay video", policy: dropFrames, replay: false, want: nil},
+		{name: "no replay telemetry", policy: dropOldest, replay: false, want: []string{"P3"}},
+	}
+	subscribers := make([]*subscriber, len(tests))
+	for i, tt := range tests {
+		subscribers[i] = h.subscribe(2, tt.policy, tt.replay)
+	}
+	h.publish(frame("P3"), nil)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := drainQueue(subscribers[i]); !slices.Equal(got, tt.want) {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := newHub()
+	s := h.subscribe(2, dropOldest, false)
+	h.publish(packet{data: []byte("a")}, nil)
+	h.close()
+	h.publish(packet{data: []byte("b")}, nil)
+
+	if got := drainQueue(s); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("queue = %v, want [a]", got)
+	}
+	if _, ok := <-s.queue; ok {
+		t.Error("queue of closed hub is open")
+	}
+	late := h.subscribe(2, dropOldest, true)
+	if _, ok := <-late.queue; ok {
+		t.Error("subscriber of closed hub got open queue")
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	h := newHub()
+	s := h.subscribe(2, dropOldest, false)
+	h.unsubscribe(s)
+	h.publish(packet{data: []byte("a")}, nil)
+	if got := drainQueue(s); len(got) != 0 {
+		t.Errorf("unsubscribed queue = %v, want empty", got)
+	}
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"sync"
+	"time"
+)
+
+const (
+	// leaseTTL - время, на которое выдаётся аренда управления. Владелец должен продлевать её чаще
+	leaseTTL = 5 * time.Second
+	// leaseHistorySize ограничивает количество записей истории владения для одного ТС
+	leaseHistorySize = 100
+)
+
+// leaseWatcher - соединение диспетчера, подключённое к потоку аренды управления ТС. Управление принадлежит
+// соединению, а не диспетчеру: другие соединения того же диспетчера только наблюдают за ТС
+type leaseWatcher struct {
+	dispatcherID int
+	states       chan entity.LeaseState
+	// conn - контекст соединения, по нему поток команд того же соединения проверяет право управления
+	conn context.Context
+	// seq - порядковый номер подключения, более новое соединение диспетчера имеет больший номер
+	seq uint64
+}
+
+// vehicleLease - аренда управления одним ТС
+type vehicleLease struct {
+	holder *entity.LeaseRecord // текущий владелец, nil - ТС никто не управляет
+	// holding - соединение владельца
+	holding   *leaseWatcher
+	expiresAt time.Time
+	timer     *time.Timer
+	watchers  map[*leaseWatcher]struct{}
+	history   []entity.LeaseRecord
+}
+
+// leaseManager выдаёт диспетчерам эксклюзивное право управления ТС. Остальные диспетчеры могут только наблюдать
+type leaseManager struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	leases map[int]*vehicleLease
+	fleet  *fleetMonitor
+	audit  *auditTrail
+	seq    uint64
+}
+
+func newLeaseManager(ttl time.Duration, fleet *fleetMonitor, audit *auditTrail) *leaseManager {
+	return &leaseManager{
+		ttl:    ttl,
+		leases: make(map[int]*vehicleLease),
+		fleet:  fleet,
+		audit:  audit,
+	}
+}
+
+// pushState передает состояние аренды, не блокируясь. Устаревшие состояния отбрасываются
+func pushState(states chan entity.LeaseState, state entity.LeaseState) {
+	for {
+		select {
+		case states <- state:
+			return
+		default:
+		}
+		select {
+		case <-states:
+		default:
+		}
+	}
+}
+
+func (m *leaseManager) lease(vehicleID int) *vehicleLease {
+	l, ok := m.leases[vehicleID]
+	if !ok {
+		l = &vehicleLease{watchers: make(map[*leaseWatcher]struct{})}
+		m.leases[vehicleID] = l
+	}
+	return l
+}
+
+// state возвращает состояние аренды для соединения w
+func (m *leaseManager) state(vehicleID int, l *vehicleLease, w *leaseWatcher) entity.LeaseState {
+	state := entity.LeaseState{VehicleID: vehicleID, Role: entity.ViewRole}
+	if l.holder != nil {
+		state.HolderID = l.holder.DispatcherID
+		state.ExpiresAt = l.expiresAt
+		if l.holding == w {
+			state.Role = entity.ControlRole
+		}
+	}
+	return state
+}
+
+// notify рассылает новое состояние аренды всем наблюдателям ТС
+func (m *leaseManager) notify(vehicleID int, l *vehicleLease) {
+	for w := range l.watchers {
+		pushState(w.states, m.state(vehicleID, l, w))
+	}
+	m.fleet.notify()
+}
+
+// end завершает текущую аренду и записывает её в историю
+func (m *leaseManager) end(l *vehicleLease, reason string) {
+	if l.holder == nil {
+		return
+	}
+	l.timer.Stop()
+	l.holder.ReleasedAt = time.Now()
+	l.holder.ReleaseReason = reason
+	m.audit.post(entity.DispatcherActor(l.holder.DispatcherID), entity.ControlReleaseAudit, entity.VehicleActor(l.holder.VehicleID), reason)
+	l.history = append(l.history, *l.holder)
+	if len(l.history) > leaseHistorySize {
+		l.history = l.history[len(l.history)-leaseHistorySize:]
+	}
+	l.holder = nil
+	l.holding = nil
+}
+
+// grant передает управление ТС соединению w
+func (m *leaseManager) grant(vehicleID int, l *vehicleLease, w *leaseWatcher, action entity.LeaseAction) {
+	m.end(l, string(action))
+	dispatcherID := w.dispatcherID
+	holder := &entity.LeaseRecord{
+		VehicleID:    vehicleID,
+		DispatcherID: dispatcherID,
+		Action:       action,
+		AcquiredAt:   time.Now(),
+	}
+	l.holder = holder
+	l.holding = w
+	l.expiresAt = holder.AcquiredAt.Add(m.ttl)
+	m.audit.post(entity.DispatcherActor(dispatcherID), entity.ControlAcquireAudit, entity.VehicleActor(vehicleID), string(action))
+	l.timer = time.AfterFunc(m.ttl, func() { m.expire(vehicleID, holder) })
+}
+
+// expire освобождает управление, если владелец не продлил аренду вовремя
+func (m *leaseManager) expire(vehicleID int, holder *entity.LeaseRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lease(vehicleID)
+	if l.holder != holder {
+		return
+	}
+	if remaining := time.Until(l.expiresAt); remaining > 0 {
+		// аренду продлили после запуска таймера
+		l.timer = time.AfterFunc(remaining, func() { m.expire(vehicleID, holder) })
+		return
+	}
+	m.end(l, "expired")
+	m.notify(vehicleID, l)
+}
+
+// watch подключает соединение диспетчера к аренде управления ТС и сразу сообщает ему текущее состояние
+func (m *leaseManager) watch(conn context.Context, vehicleID, dispatcherID int, states chan entity.LeaseState) *leaseWatcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	w := &leaseWatcher{dispatcherID: dispatcherID, states: states, conn: conn, seq: m.seq}
+	l := m.lease(vehicleID)
+	l.watchers[w] = struct{}{}
+	pushState(states, m.state(vehicleID, l, w))
+	return w
+}
+
+// unwatch отключает соединение диспетчера. Если оно управляло ТС, то управление освобождается
+func (m *leaseManager) unwatch(vehicleID int, w *leaseWatcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lease(vehicleID)
+	delete(l.watchers, w)
+	if l.holder != nil && l.holding == w {
+		m.end(l, "disconnected")
+		m.notify(vehicleID, l)
+	}
+}
+
+// handle выполняет запрос диспетчера на изменение аренды
+func (m *leaseManager) handle(vehicleID int, w *leaseWatcher, request entity.LeaseRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.lease(vehicleID)
+	isHolder := l.holder != nil && l.holding == w
+	switch request.Action {
+	case entity.AcquireLease:
+		switch {
+		case isHolder:
+			l.expiresAt = time.Now().Add(m.ttl)
+			return nil
+		case l.holder == nil:
+			m.grant(vehicleID, l, w, entity.AcquireLease)
+		case l.holder.DispatcherID == w.dispatcherID:
+			// диспетчер переподключился: управление переходит новому соединению, аренда продолжается,
+			// а старое соединение больше не может управлять ТС и при закрытии не освобождает управление
+			l.holding = w
+			l.expiresAt = time.Now().Add(m.ttl)
+		default:
+			return usecase.ErrLeaseHeld
+		}
+	case entity.RenewLease:
+		if !isHolder {
+			return usecase.ErrNotLeaseHolder
+		}
+		// продление не меняет владельца, поэтому наблюдателей не уведомляем
+		l.expiresAt = time.Now().Add(m.ttl)
+		return nil
+	case entity.ReleaseLease:
+		if !isHolder {
+			return usecase.ErrNotLeaseHolder
+		}
+		m.end(l, string(entity.ReleaseLease))
+	case entity.HandoverLease:
+		if !isHolder {
+			return usecase.ErrNotLeaseHolder
+		}
+		// управление можно передать только диспетчеру, который сейчас наблюдает за ТС. Если у него несколько
+		// соединений, то управление получает самое новое из них
+		var target *leaseWatcher
+		for watcher := range l.watchers {
+			if watcher.dispatcherID == request.TargetDispatcherID && (target == nil || watcher.seq > target.seq) {
+				target = watcher
+			}
+		}
+		if target == nil || request.TargetDispatcherID == w.dispatcherID {
+			return usecase.ErrBadRequest
+		}
+		m.grant(vehicleID, l, target, entity.HandoverLease)
+	case entity.TakeoverLease:
+		if !isHolder {
+			m.grant(vehicleID, l, w, entity.TakeoverLease)
+		}
+	default:
+		return usecase.ErrBadRequest
+	}
+	m.notify(vehicleID, l)
+	return nil
+}
+
+// isHolder проверяет, управляет ли ТС соединение с контекстом conn
+func (m *leaseManager) isHolder(vehicleID int, conn context.Context) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.leases[vehicleID]
+	return ok && l.holding != nil && l.holding.conn == conn
+}
+
+// holderID возвращает диспетчера, который управляет ТС, или 0, если ТС никто не управляет
+func (m *leaseManager) holderID(vehicleID int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.leases[vehicleID]
+	if !ok || l.holder == nil {
+		return 0
+	}
+	return l.holder.DispatcherID
+}
+
+// current возвращает текущее состояние аренды для соединения w
+func (m *leaseManager) current(vehicleID int, w *leaseWatcher) entity.LeaseState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state(vehicleID, m.lease(vehicleID), w)
+}
+
+// history возвращает историю владения управлением ТС, включая текущую аренду
+func (m *leaseManager) history(vehicleID int) []entity.LeaseRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.leases[vehicleID]
+	if !ok {
+		return nil
+	}
+	history := make([]entity.LeaseRecord, len(l.history), len(l.history)+1)
+	copy(history, l.history)
+	if l.holder != nil {
+		history = append(history, *l.holder)
+	}
+	return history
+}
+
+func (b *BroadcastService) LeaseStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, requests chan entity.LeaseRequest, states chan entity.LeaseState, errChan chan error) {
+	if _, err := b.authDispatcher(vehicleID, dispatcherID, dispatcherPassword); err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	w := b.leases.watch(ctx, vehicleID, dispatcherID, states)
+	defer b.leases.unwatch(vehicleID, w)
+	for {
+		var request entity.LeaseRequest
+		var ok bool
+		select {
+		case request, ok = <-requests:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		if !entity.IsLeaseActionValid(request.Action) {
+			state := b.leases.current(vehicleID, w)
+			state.Error = usecase.ErrBadRequest.Error()
+			pushState(states, state)
+			continue
+		}
+		if request.Action == entity.LeaseHistory {
+			state := b.leases.current(vehicleID, w)
+			state.History = b.leases.history(vehicleID)
+			pushState(states, state)
+			continue
+		}
+		if err := b.leases.handle(vehicleID, w, request); err != nil {
+			state := b.leases.current(vehicleID, w)
+			state.Error = err.Error()
+			pushState(states, state)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"testing"
+	"time"
+)
+
+const testVehicleID = 1
+
+// newTestLeaseManager создаёт leaseManager без хранилища журнала аудита: события остаются в очереди
+func newTestLeaseManager(ttl time.Duration) *leaseManager {
+	return newLeaseManager(ttl, newFleetMonitor(), &auditTrail{queue: make(chan entity.AuditRecord, auditQueueSize)})
+}
+
+// testConn - соединение диспетчера с потоком аренды
+type testConn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	w      *leaseWatcher
+}
+
+func connect(m *leaseManager, dispatcherID int) *testConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := m.watch(ctx, testVehicleID, dispatcherID, make(chan entity.LeaseState, 1))
+	return &testConn{ctx: ctx, cancel: cancel, w: w}
+}
+
+func (c *testConn) request(m *leaseManager, action entity.LeaseAction, target int) error {
+	return m.handle(testVehicleID, c.w, entity.LeaseRequest{Action: action, TargetDispatcherID: target})
+}
+
+func (c *testConn) disconnect(m *leaseManager) {
+	c.cancel()
+	m.unwatch(testVehicleID, c.w)
+}
+
+func TestLeaseRequests(t *testing.T) {
+	type step struct {
+		conn    int
+		action  entity.LeaseAction
+		target  int
+		wantErr error
+	}
+	// соединения 0 и 1 - диспетчеры 10 и 20, соединение 2 - второе соединение диспетчера 20
+	dispatchers := []int{10, 20, 20}
+	tests := []struct {
+		name       string
+		steps      []step
+		wantHolder int // номер соединения-владельца, -1 - ТС никто не управляет
+		wantReason string
+	}{
+		{
+			name:       "acquire",
+			steps:      []step{{conn: 0, action: entity.AcquireLease}},
+			wantHolder: 0,
+		},
+		{
+			name:       "acquire held",
+			steps:      []step{{conn: 0, action: entity.AcquireLease}, {conn: 1, action: entity.AcquireLease, wantErr: usecase.ErrLeaseHeld}},
+			wantHolder: 0,
+		},
+		{
+			name:       "renew by viewer",
+			steps:      []step{{conn: 0, action: entity.AcquireLease}, {conn: 1, action: entity.RenewLease, wantErr: usecase.ErrNotLeaseHolder}},
+			wantHolder: 0,
+		},
+		{
+			name:       "release",
+			steps:      []step{{conn: 0, action: entity.AcquireLease}, {conn: 0, action: entity.ReleaseLease}},
+			wantHolder: -1,
+			wantReason: string(entity.ReleaseLease),
+		},
+		{
+			// передача диспетчеру с двумя соединениями достаётся более новому соединению
+			name:       "handover",
+			steps:      []step{{conn: 0, action: entity.AcquireLease}, {conn: 0, action: entity.HandoverLease, target: 20}},
+			wantHolder: 2,
+			wantReason: string(entity.HandoverLease),
+		},
+		{
+			name:       "handover to absent dispatcher",
+			steps:      []step{{conn: 0, action: entity.AcquireLease}, {conn: 0, action: entity.HandoverLease, target: 30, wantErr: usecase.ErrBadRequest}},
+			wantHolder: 0,
+		},
+		{
+			name:       "takeover",
+			steps:      []step{{conn: 0, action: entity.AcquireLease}, {conn: 1, action: entity.TakeoverLease}},
+			wantHolder: 1,
+			wantReason: string(entity.TakeoverLease),
+		},
+		{
+			// переподключившийся диспетчер забирает управление у своего старого соединения без новой аренды
+			name:       "acquire from new connection",
+			steps:      []step{{conn: 1, action: entity.AcquireLease}, {conn: 2, action: entity.AcquireLease}},
+			wantHolder: 2,
+		},
+		{
+			name:       "renew from other connection",
+			steps:      []step{{conn: 1, action: entity.AcquireLease}, {conn: 2, action: entity.RenewLease, wantErr: usecase.ErrNotLeaseHolder}},
+			wantHolder: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestLeaseManager(time.Minute)
+			conns := make([]*testConn, len(dispatchers))
+			for i, dispatcherID := range dispatchers {
+				conns[i] = connect(m, dispatcherID)
+			}
+			for i, st := range tt.steps {
+				err := conns[st.conn].request(m, st.action, st.target)
+				if !errors.Is(err, st.wantErr) {
+					t.Fatalf("step %d %s: err = %v, want %v", i, st.action, err, st.wantErr)
+				}
+			}
+			for i, c := range conns {
+				want := i == tt.wantHolder
+				if got := m.isHolder(testVehicleID, c.ctx); got != want {
+					t.Errorf("isHolder(conn %d) = %t, want %t", i, got, want)
+				}
+				if got := m.current(testVehicleID, c.w).Role == entity.ControlRole; got != want {
+					t.Errorf("conn %d has control role = %t, want %t", i, got, want)
+				}
+			}
+			history := m.history(testVehicleID)
+			if tt.wantReason != "" && (len(history) == 0 || history[0].ReleaseReason != tt.wantReason) {
+				t.Errorf("history = %+v, want first lease released with %q", history, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestLeaseReconnect(t *testing.T) {
+	m := newTestLeaseManager(time.Minute)
+	old := connect(m, 10)
+	if err := old.request(m, entity.AcquireLease, 0); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	fresh := connect(m, 10)
+	if err := fresh.request(m, entity.AcquireLease, 0); err != nil {
+		t.Fatalf("acquire from new connection: %v", err)
+	}
+	// старое соединение закрывается по таймауту уже после переподключения и не должно освободить управление
+	old.disconnect(m)
+	if !m.isHolder(testVehicleID, fresh.ctx) {
+		t.Fatal("new connection lost control when old connection closed")
+	}
+	if got := m.holderID(testVehicleID); got != 10 {
+		t.Errorf("holderID = %d, want 10", got)
+	}
+	if history := m.history(testVehicleID); len(history) != 1 || history[0].ReleaseReason != "" {
+		t.Errorf("history = %+v, want one active lease", history)
+	}
+
+	fresh.disconnect(m)
+	if got := m.holderID(testVehicleID); got != 0 {
+		t.Errorf("holderID after disconnect = %d, want 0", got)
+	}
+	if history := m.history(testVehicleID); len(history) != 1 || history[0].ReleaseReason != "disconnected" {
+		t.Errorf("history = %+v, want lease released as disconnected", history)
+	}
+}
+
+func TestLeaseExpire(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	m := newTestLeaseManager(ttl)
+	c := connect(m, 10)
+	if err := c.request(m, entity.AcquireLease, 0); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	// продление переносит окончание аренды
+	time.Sleep(ttl / 2)
+	if err := c.request(m, entity.RenewLease, 0); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	time.Sleep(ttl * 3 / 4)
+	if !m.isHolder(testVehicleID, c.ctx) {
+		t.Fatal("renewed lease expired early")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for m.isHolder(testVehicleID, c.ctx) {
+		if time.Now().After(deadline) {
+			t.Fatal("lease did not expire")
+		}
+		time.Sleep(ttl / 5)
+	}
+	if history := m.history(testVehicleID); len(history) != 1 || history[0].ReleaseReason != "expired" {
+		t.Errorf("history = %+v, want lease released as expired", history)
+	}
+	if err := c.request(m, entity.RenewLease, 0); !errors.Is(err, usecase.ErrNotLeaseHolder) {
+		t.Errorf("renew after expiry: err = %v, want ErrNotLeaseHolder", err)
+	}
+}
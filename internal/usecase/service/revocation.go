@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"sync"
+	"time"
+)
+
+// credentialKey - ТС или диспетчер, чьи сессии отключаются при отзыве пароля, и сертификат, по которому вошла
+// сессия ТС. Сессии, вошедшие по сертификату, отключаются только при отзыве этого сертификата
+type credentialKey struct {
+	role        entity.TokenRole
+	id          int
+	certificate string
+}
+
+// revocationCheckInterval - как часто открытая сессия сверяется с отзывами в хранилище. Сообщение об отзыве
+// отключает сессии сразу, но сервер ретрансляции может его не получить, например, если был отключён от Redis
+const revocationCheckInterval = time.Minute
+
+// credentialSession - соединение ТС или диспетчера, которое вошло с паролем версии version или по сертификату
+type credentialSession struct {
+	version int
+	// revoked закрывается при отключении сессии, err - причина отключения
+	revoked chan struct{}
+	err     error
+	once    sync.Once
+	// expiry отключает сессию, когда перестанет действовать прежний пароль, с которым она вошла
+	expiry *time.Timer
+}
+
+// revoke отключает сессию с ошибкой err, если она ещё не отключена
+func (s *credentialSession) revoke(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.revoked)
+	})
+}
+
+// apply отключает сессию, если её пароль или сертификат отозван, а если это прежний пароль, который ещё действует, -
+// откладывает отключение до конца перекрытия. Вызывается под credentialSessions.mu
+func (s *credentialSession) apply(revocation entity.CredentialRevocation) {
+	now := time.Now()
+	switch {
+	case revocation.Kind == entity.CertificateRevocation:
+		s.revoke(usecase.ErrCredentialRevoked)
+	case revocation.IsRevoked(s.version, now):
+		s.revoke(usecase.ErrCredentialRevoked)
+	case s.version < revocation.MinVersion && s.expiry == nil:
+		s.expiry = time.AfterFunc(revocation.PreviousValidUntil.Sub(now), func() { s.revoke(usecase.ErrCredentialRevoked) })
+	}
+}
+
+// credentialSessions отслеживает открытые соединения по версии пароля или сертификату, с которыми они вошли,
+// чтобы после смены пароля или отзыва сертификата отключить соединения с отозванным паролем или сертификатом
+type credentialSessions struct {
+	mu       sync.Mutex
+	sessions map[credentialKey]map[*credentialSession]struct{}
+}
+
+func newCredentialSessions() *credentialSessions {
+	return &credentialSessions{
+		sessions: make(map[credentialKey]map[*credentialSession]struct{}),
+	}
+}
+
+func (c *credentialSessions) register(key credentialKey, version int) *credentialSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := &credentialSession{version: version, revoked: make(chan struct{})}
+	if c.sessions[key] == nil {
+		c.sessions[key] = make(map[*credentialSession]struct{})
+	}
+	c.sessions[key][s] = struct{}{}
+	return s
+}
+
+func (c *credentialSessions) unregister(key credentialKey, s *credentialSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	delete(c.sessions[key], s)
+	if len(c.sessions[key]) == 0 {
+		delete(c.sessions, key)
+	}
+}
+
+// check применяет к сессии s отзыв, прочитанный из хранилища
+func (c *credentialSessions) check(s *credentialSession, revocation entity.CredentialRevocation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.apply(revocation)
+}
+
+// revoke применяет отзыв ко всем сессиям ТС или диспетчера, чей пароль отозван, или ко всем сессиям ТС
+// с отозванным сертификатом
+func (c *credentialSessions) revoke(revocation entity.CredentialRevocation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for s := range c.sessions[credentialKey{role: revocation.Role, id: revocation.ID, certificate: revocation.Serial}] {
+		s.apply(revocation)
+	}
+}
+
+// close отключает с ошибкой err все сессии ТС или диспетчера, с чем бы они ни вошли
+func (c *credentialSessions) close(role entity.TokenRole, id int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, sessions := range c.sessions {
+		if key.role != role || key.id != id {
+			continue
+		}
+		for s := range sessions {
+			s.revoke(err)
+		}
+	}
+}
+
+// watchRevocations получает сообщения об отзыве паролей и сертификатов и об изменении доступа от сервиса администратора
+// и сразу применяет их к открытым сессиям
+func (b *BroadcastService) watchRevocations(revocationRepo repo.RevocationRepo) {
+	for revocation := range revocationRepo.SubscribeRevocations(context.Background()) {
+		b.applyRevocation(revocation)
+	}
+}
+
+// applyRevocation применяет сообщение об отзыве к открытым сессиям
+func (b *BroadcastService) applyRevocation(revocation entity.CredentialRevocation) {
+	if revocation.Kind != entity.AccessRevocation {
+		b.credentials.revoke(revocation)
+		return
+	}
+	err := b.checkAccess(revocation.Role, revocation.ID)
+	if err != nil {
+		b.credentials.close(revocation.Role, revocation.ID, err)
+	}
+	if errors.Is(err, usecase.ErrVehicleNotFound) {
+		// телеметрия удалённого ТС пропадает из обзора парка, даже если его сессия ещё в грейс-периоде
+		b.fleet.forget(revocation.ID)
+	}
+	// обзор парка собирается заново: у ТС мог измениться статус, у диспетчера - права
+	b.fleet.notify()
+}
+
+// checkAccess перечитывает ТС или диспетчера из хранилища и возвращает ошибку, если их сессии потеряли доступ:
+// ТС удалено или выведено из статуса active, диспетчер удалён. Права диспетчера удаляются из кэша, а его трансляции
+// ТС, к которым у него больше нет доступа, отменяются. Если хранилище недоступно, то доступ не меняется
+// до следующей сверки
+func (b *BroadcastService) checkAccess(role entity.TokenRole, id int) error {
+	switch role {
+	case entity.VehicleToken:
+		_, err := b.activeVehicle(id)
+		if errors.Is(err, usecase.ErrVehicleInactive) || errors.Is(err, usecase.ErrVehicleNotFound) {
+			return err
+		}
+	case entity.DispatcherToken:
+		dispatcher, err := b.getDispatcher(id)
+		switch {
+		case err == nil:
+			b.grants.revoke(id, dispatcher)
+		case errors.Is(err, usecase.ErrDispatcherNotFound):
+			b.grants.revoke(id, nil)
+			return err
+		}
+	}
+	return nil
+}
+
+// holdCredential ждёт закрытия соединения, которое вошло с токеном или паролем версии version. Если этот пароль
+// или сертификат, по которому выдан токен, отозван, то в errChan передаётся ErrCredentialRevoked и соединение
+// закрывается, а если ТС удалили или вывели из статуса active - ErrVehicleNotFound или ErrVehicleInactive,
+// если удалили диспетчера - ErrDispatcherNotFound.
+// Кроме сообщений об отзыве сессия раз в revocationCheckInterval сверяется с хранилищем, поэтому отзыв,
+// сообщение о котором сервер не получил, тоже отключает её
+func (b *BroadcastService) holdCredential(ctx context.Context, role entity.TokenRole, id int, credential string, version int, errChan chan error) {
+	key := credentialKey{role: role, id: id}
+	if claims, ok := b.tokens.parse(credential, role); ok {
+		key.certificate = claims.Certificate
+	}
+	s := b.credentials.register(key, version)
+	defer b.credentials.unregister(key, s)
+	ticker := time.NewTicker(revocationCheckInterval)
+	defer ticker.Stop()
+	for {
+		// пароль или сертификат могли отозвать между входом и регистрацией сессии, поэтому первая сверка
+		// выполняется сразу
+		b.checkSession(key, s)
+		select {
+		case <-s.revoked:
+			sendErr(ctx, errChan, s.err)
+			return
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkSession сверяет сессию s с отзывом пароля или сертификата и с доступом в хранилище. Если хранилище недоступно,
+// то сессия сверяется в следующий раз
+func (b *BroadcastService) checkSession(key credentialKey, s *credentialSession) {
+	if err := b.checkAccess(key.role, key.id); err != nil {
+		s.revoke(err)
+		return
+	}
+	if key.certificate != "" {
+		if revoked, err := b.certificateRepo.IsCertificateRevoked(key.certificate); err == nil && revoked {
+			b.credentials.check(s, entity.NewCertificateRevocation(key.id, key.certificate))
+		}
+		return
+	}
+	if revocation, err := b.tokens.revocation(key.role, key.id); err == nil {
+		b.credentials.check(s, *revocation)
+	}
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/pki"
+	"testing"
+	"time"
+)
+
+// isClosed сообщает, отключена ли сессия
+func isClosed(s *credentialSession) bool {
+	select {
+	case <-s.revoked:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestCredentialSessionsRevoke(t *testing.T) {
+	const overlap = 50 * time.Millisecond
+	c := newCredentialSessions()
+	key := credentialKey{role: entity.DispatcherToken, id: 7}
+	other := c.register(credentialKey{role: entity.VehicleToken, id: 7}, 0)
+	old := c.register(key, 0)
+	previous := c.register(key, 1)
+	current := c.register(key, 2)
+
+	c.revoke(entity.CredentialRevocation{Role: entity.DispatcherToken, ID: 7, MinVersion: 2, PreviousValidUntil: time.Now().Add(overlap)})
+	if !isClosed(old) {
+		t.Error("session with revoked credential is not closed")
+	}
+	if isClosed(previous) || isClosed(current) || isClosed(other) {
+		t.Fatal("session with valid credential is closed")
+	}
+
+	// сессия с прежним паролем отключается в конце перекрытия
+	time.Sleep(2 * overlap)
+	if !isClosed(previous) {
+		t.Error("session with previous credential is not closed after overlap")
+	}
+	if isClosed(current) || isClosed(other) {
+		t.Error("session with current credential is closed")
+	}
+}
+
+// TestCredentialSessionsCheck проверяет, что сессия отключается по отзыву из хранилища, если сообщение об отзыве
+// не пришло, и что отключение не срабатывает после закрытия сессии
+func TestCredentialSessionsCheck(t *testing.T) {
+	const overlap = 50 * time.Millisecond
+	c := newCredentialSessions()
+	key := credentialKey{role: entity.VehicleToken, id: 3}
+	revoked := c.register(key, 0)
+	c.check(revoked, entity.CredentialRevocation{Role: entity.VehicleToken, ID: 3, MinVersion: 1})
+	if !isClosed(revoked) {
+		t.Error("session with revoked credential is not closed")
+	}
+
+	previous := c.register(key, 0)
+	c.check(previous, entity.CredentialRevocation{Role: entity.VehicleToken, ID: 3, MinVersion: 1, PreviousValidUntil: time.Now().Add(overlap)})
+	if isClosed(previous) {
+		t.Fatal("session with previous credential is closed during overlap")
+	}
+	c.unregister(key, previous)
+	time.Sleep(2 * overlap)
+	if isClosed(previous) {
+		t.Error("unregistered session is closed after overlap")
+	}
+}
+
+// waitSessions ждёт, пока зарегистрируется n сессий
+func waitSessions(t *testing.T, c *credentialSessions, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		got := 0
+		for _, sessions := range c.sessions {
+			got += len(sessions)
+		}
+		c.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sessions = %d, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// holdSession держит сессию, как VehicleSession и DispatcherSession, и возвращает канал, в который она передаст ошибку
+func holdSession(ctx context.Context, b *BroadcastService, role entity.TokenRole, id int, credential string, version int) chan error {
+	errChan := make(chan error, 1)
+	go b.holdCredential(ctx, role, id, credential, version, errChan)
+	return errChan
+}
+
+// expectClosed проверяет, что сессия отключилась с ошибкой want
+func expectClosed(t *testing.T, errChan chan error, want error) {
+	t.Helper()
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, want) {
+			t.Errorf("session closed with %v, want %v", err, want)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("session is not closed, want %v", want)
+	}
+}
+
+// expectOpen проверяет, что сессия не отключилась
+func expectOpen(t *testing.T, errChan chan error) {
+	t.Helper()
+	select {
+	case err := <-errChan:
+		t.Errorf("session closed with %v", err)
+	default:
+	}
+}
+
+// testCertificate возвращает сертификат ТС, как его видит сервер после проверки TLS
+func testCertificate(vehicleID int, serial int64) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: entity.VehicleCommonName(vehicleID)},
+	}
+}
+
+// TestVehicleCertificateRevocation проверяет, что отзыв сертификата сразу отключает сессии ТС, вошедшие по нему,
+// и не даёт ТС переподключиться ни по сертификату, ни по токену, выданному до отзыва
+func TestVehicleCertificateRevocation(t *testing.T) {
+	b, _ := newTestVehicleService(t, 3)
+	a, adminToken := newTestAdminService(t, b)
+	revoked, kept := testCertificate(3, 0xa1), testCertificate(3, 0xb2)
+	for _, certificate := range []*x509.Certificate{revoked, kept} {
+		if err := b.certificateRepo.AddCertificate(&entity.VehicleCertificate{Serial: pki.Serial(certificate), VehicleID: 3}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// выпуск: ТС входит по каждому сертификату и держит сессию с выданным токеном
+	session, err := b.LoginVehicleCertificate(3, revoked)
+	if err != nil {
+		t.Fatalf("LoginVehicleCertificate: %v", err)
+	}
+	keptSession, err := b.LoginVehicleCertificate(3, kept)
+	if err != nil {
+		t.Fatalf("LoginVehicleCertificate with other certificate: %v", err)
+	}
+	if _, err = b.authVehicle(3, session.Token); err != nil {
+		t.Fatalf("authVehicle with certificate token: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	revokedErr := holdSession(ctx, b, entity.VehicleToken, 3, session.Token, 0)
+	keptErr := holdSession(ctx, b, entity.VehicleToken, 3, keptSession.Token, 0)
+	waitSessions(t, b.credentials, 2)
+
+	// отзыв: сервер получает сообщение от сервиса администратора и отключает только сессию с отозванным сертификатом
+	if err = a.RevokeVehicleCertificate(adminToken, 3, pki.Serial(revoked)); err != nil {
+		t.Fatalf("RevokeVehicleCertificate: %v", err)
+	}
+	published := b.tokens.revocationRepo.(*testRevocationRepo).published
+	if len(published) != 1 || published[0].Kind != entity.CertificateRevocation || published[0].Serial != pki.Serial(revoked) {
+		t.Fatalf("published = %+v, want revocation of certificate %s", published, pki.Serial(revoked))
+	}
+	b.credentials.revoke(published[0])
+	expectClosed(t, revokedErr, usecase.ErrCredentialRevoked)
+	expectOpen(t, keptErr)
+
+	// переподключение: ни токен, выданный до отзыва, ни сам сертификат больше не принимаются
+	if _, err = b.authVehicle(3, session.Token); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("authVehicle with revoked certificate token: err = %v, want ErrAccessDenied", err)
+	}
+	if _, err = b.LoginVehicle(3, session.Token); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("LoginVehicle with revoked certificate token: err = %v, want ErrAccessDenied", err)
+	}
+	if _, err = b.LoginVehicleCertificate(3, revoked); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("LoginVehicleCertificate with revoked certificate: err = %v, want ErrAccessDenied", err)
+	}
+	if _, err = b.authVehicle(3, keptSession.Token); err != nil {
+		t.Errorf("authVehicle with other certificate token: %v", err)
+	}
+
+	// сервер, который не получил сообщение, отключает сессию при сверке с хранилищем
+	b.credentials = newCredentialSessions()
+	expectClosed(t, holdSession(ctx, b, entity.VehicleToken, 3, session.Token, 0), usecase.ErrCredentialRevoked)
+}
+
+// TestCredentialSessionsCertificate проверяет, что смена пароля не отключает сессии, вошедшие по сертификату,
+// а отзыв сертификата - сессии с паролем и с другими сертификатами
+func TestCredentialSessionsCertificate(t *testing.T) {
+	c := newCredentialSessions()
+	byPassword := c.register(credentialKey{role: entity.VehicleToken, id: 3}, 0)
+	revoked := c.register(credentialKey{role: entity.VehicleToken, id: 3, certificate: "a1"}, 0)
+	other := c.register(credentialKey{role: entity.VehicleToken, id: 3, certificate: "b2"}, 0)
+
+	c.revoke(entity.CredentialRevocation{Role: entity.VehicleToken, ID: 3, MinVersion: 1})
+	if !isClosed(byPassword) {
+		t.Error("session with revoked password is not closed")
+	}
+	if isClosed(revoked) || isClosed(other) {
+		t.Fatal("password rotation closed session with certificate")
+	}
+	c.revoke(entity.NewCertificateRevocation(3, "a1"))
+	if !isClosed(revoked) {
+		t.Error("session with revoked certificate is not closed")
+	}
+	if isClosed(other) {
+		t.Error("session with other certificate is closed")
+	}
+}
+
+// TestVehicleStatusRevocation проверяет, что ТС, выведенное из статуса active или удалённое, сразу отключается
+// и не подключается снова по токену, выданному до изменения
+func TestVehicleStatusRevocation(t *testing.T) {
+	b, _ := newTestVehicleService(t, 3, 4)
+	a, adminToken := newTestAdminService(t, b)
+	revocationRepo := b.tokens.revocationRepo.(*testRevocationRepo)
+	session, err := b.LoginVehicle(3, testVehiclePassword)
+	if err != nil {
+		t.Fatalf("LoginVehicle: %v", err)
+	}
+	other, err := b.LoginVehicle(4, testVehiclePassword)
+	if err != nil {
+		t.Fatalf("LoginVehicle: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sessionErr := holdSession(ctx, b, entity.VehicleToken, 3, session.Token, 0)
+	otherErr := holdSession(ctx, b, entity.VehicleToken, 4, other.Token, 0)
+	waitSessions(t, b.credentials, 2)
+
+	// изменение без выхода из статуса active не отключает ТС
+	if err = a.EditVehicle(adminToken, &entity.EditVehicleRequest{ID: 3, Status: entity.ActiveVehicle}); err != nil {
+		t.Fatalf("EditVehicle: %v", err)
+	}
+	if len(revocationRepo.published) != 0 {
+		t.Fatalf("published = %+v, want nothing for active vehicle", revocationRepo.published)
+	}
+
+	if err = a.EditVehicle(adminToken, &entity.EditVehicleRequest{ID: 3, Status: entity.MaintenanceVehicle}); err != nil {
+		t.Fatalf("EditVehicle: %v", err)
+	}
+	if len(revocationRepo.published) != 1 {
+		t.Fatalf("published = %+v, want access revocation", revocationRepo.published)
+	}
+	b.applyRevocation(revocationRepo.published[0])
+	expectClosed(t, sessionErr, usecase.ErrVehicleInactive)
+	expectOpen(t, otherErr)
+	if _, err = b.authVehicle(3, session.Token); !errors.Is(err, usecase.ErrVehicleInactive) {
+		t.Errorf("authVehicle with token issued before status change: err = %v, want ErrVehicleInactive", err)
+	}
+	if _, err = b.LoginVehicle(3, session.Token); !errors.Is(err, usecase.ErrVehicleInactive) {
+		t.Errorf("LoginVehicle with token issued before status change: err = %v, want ErrVehicleInactive", err)
+	}
+
+	// сервер, который не получил сообщение об удалении, отключает сессию при сверке с хранилищем
+	if err = a.DeleteVehicle(adminToken, 4); err != nil {
+		t.Fatalf("DeleteVehicle: %v", err)
+	}
+	if len(revocationRepo.published) != 2 || revocationRepo.published[1] != entity.NewAccessRevocation(entity.VehicleToken, 4) {
+		t.Fatalf("published = %+v, want access revocation of vehicle 4", revocationRepo.published)
+	}
+	b.credentials = newCredentialSessions()
+	expectClosed(t, holdSession(ctx, b, entity.VehicleToken, 4, other.Token, 0), usecase.ErrVehicleNotFound)
+	if _, err = b.authVehicle(4, other.Token); !errors.Is(err, usecase.ErrVehicleNotFound) {
+		t.Errorf("authVehicle of deleted vehicle: err = %v, want ErrVehicleNotFound", err)
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vehicleSession - сессия ТС. Она переживает переподключения: пока идёт грейс-период, диспетчеры остаются
+// подписаны на ТС и переходят на новые трансляции, как только ТС подключится снова
+type vehicleSession struct {
+	// connections - число открытых соединений ТС. Новое соединение может открыться раньше,
+	// чем сервер заметит, что старое оборвалось
+	connections int
+	// streams - потоки, которые ТС передаёт в последнем соединении
+	streams []string
+	// cameras - камеры, с которых ТС ведёт трансляцию в последнем соединении
+	cameras []string
+	// changed закрывается и заменяется при каждом изменении сессии или её трансляций,
+	// по нему ждущие подписчики узнают, что пора искать трансляцию снова
+	changed chan struct{}
+	// expire - таймер грейс-периода, запущен, пока у ТС нет соединений
+	expire *time.Timer
+}
+
+func (s *vehicleSession) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// sessionManager хранит сессии ТС и сообщает диспетчерам об их обрыве, восстановлении и закрытии
+type sessionManager struct {
+	mu          sync.Mutex
+	gracePeriod time.Duration
+	sessions    map[int]*vehicleSession
+	watchdog    *streamWatchdog
+	fleet       *fleetMonitor
+}
+
+func newSessionManager(gracePeriod time.Duration, watchdog *streamWatchdog, fleet *fleetMonitor) *sessionManager {
+	return &sessionManager{
+		gracePeriod: gracePeriod,
+		sessions:    make(map[int]*vehicleSession),
+		watchdog:    watchdog,
+		fleet:       fleet,
+	}
+}
+
+// event сообщает диспетчерам ТС об изменении состояния сессии
+func (m *sessionManager) event(vehicleID int, state entity.StreamState) {
+	m.watchdog.notify(entity.StreamEvent{VehicleID: vehicleID, StreamStatus: entity.StreamStatus{State: state}, At: time.Now()})
+	m.fleet.notify()
+}
+
+// connect отмечает новое соединение ТС. Если сессия уже есть, то ТС переподключилось
+func (m *sessionManager) connect(vehicleID int, cameras []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, reconnected := m.sessions[vehicleID]
+	if !reconnected {
+		s = &vehicleSession{changed: make(chan struct{})}
+		m.sessions[vehicleID] = s
+	}
+	if s.expire != nil {
+		s.expire.Stop()
+		s.expire = nil
+	}
+	s.connections++
+	s.cameras = cameras
+	s.streams = []string{entity.InfoStreamName}
+	for _, camera := range cameras {
+		s.streams = append(s.streams, entity.VideoStreamName(camera))
+	}
+	s.notify()
+	if reconnected {
+		m.event(vehicleID, entity.SessionReconnected)
+	} else {
+		m.fleet.notify()
+	}
+}
+
+// disconnect отмечает, что соединение ТС закрылось. Когда у ТС не остаётся соединений, начинается грейс-период
+func (m *sessionManager) disconnect(vehicleID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[vehicleID]
+	if !ok {
+		return
+	}
+	s.connections--
+	if s.connections > 0 {
+		return
+	}
+	if m.gracePeriod <= 0 {
+		m.close(vehicleID, s)
+		return
+	}
+	m.event(vehicleID, entity.SessionDisconnected)
+	var expire *time.Timer
+	expire = time.AfterFunc(m.gracePeriod, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		// таймер мог сработать одновременно с переподключением ТС
+		if m.sessions[vehicleID] == s && s.expire == expire {
+			m.close(vehicleID, s)
+		}
+	})
+	s.expire = expire
+	s.notify()
+}
+
+// close завершает сессию: подписчики, которые ждут новую трансляцию, получают usecase.ErrStreamClosed,
+// а последняя телеметрия ТС пропадает из обзора парка
+func (m *sessionManager) close(vehicleID int, s *vehicleSession) {
+	delete(m.sessions, vehicleID)
+	m.fleet.forget(vehicleID)
+	s.notify()
+	m.event(vehicleID, entity.SessionClosed)
+}
+
+// changed сообщает подписчикам, ждущим трансляцию ТС, что она могла появиться
+func (m *sessionManager) changed(vehicleID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[vehicleID]; ok {
+		s.notify()
+	}
+}
+
+// expects возвращает канал, который закроется при следующем изменении сессии ТС, и ждёт ли сессия поток stream:
+// ТС не подключено, но грейс-период не закончился, или поток есть в последнем соединении ТС
+func (m *sessionManager) expects(vehicleID int, stream string) (<-chan struct{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[vehicleID]
+	if !ok {
+		return nil, false
+	}
+	return s.changed, s.connections == 0 || slices.Contains(s.streams, stream)
+}
+
+// disconnected проверяет, что ТС сейчас переподключается
+func (m *sessionManager) disconnected(vehicleID int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[vehicleID]
+	return ok && s.connections == 0
+}
+
+// state возвращает состояние подключения ТС
+func (m *sessionManager) state(vehicleID int) entity.ConnectionState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[vehicleID]
+	switch {
+	case !ok:
+		return entity.VehicleOffline
+	case s.connections == 0:
+		return entity.VehicleReconnecting
+	default:
+		return entity.VehicleOnline
+	}
+}
+
+// vehicles возвращает ТС, у которых есть сессия
+func (m *sessionManager) vehicles() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Keys(m.sessions)
+}
+
+// cameras возвращает камеры последнего соединения ТС
+func (m *sessionManager) cameras(vehicleID int) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[vehicleID]; ok {
+		return slices.Clone(s.cameras)
+	}
+	return nil
+}
+
+// awaitSource ждёт трансляцию потока stream ТС, отличную от закончившейся old. Пока сессия ТС ждёт этот поток,
+// подписчик ждёт вместе с ней, иначе возвращается usecase.ErrStreamClosed или, если подписчик ещё не получал
+// трансляцию, usecase.ErrNotFound
+func awaitSource[T comparable](ctx context.Context, m *sessionManager, vehicleID int, stream string, old T, lookup func() (T, bool)) (T, error) {
+	var zero T
+	for {
+		// канал берётся до поиска трансляции, чтобы не пропустить её появление между поиском и ожиданием
+		changed, expected := m.expects(vehicleID, stream)
+		if source, ok := lookup(); ok && source != old {
+			return source, nil
+		}
+		if !expected {
+			if old == zero {
+				return zero, usecase.ErrNotFound
+			}
+			return zero, usecase.ErrStreamClosed
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+}
+
+func (b *BroadcastService) VehicleSession(ctx context.Context, vehicleID int, vehiclePassword string, cameras []string, errChan chan error) {
+	vehicle, err := b.authVehicle(vehicleID, vehiclePassword)
+	if err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	b.sessions.connect(vehicleID, cameras)
+	defer b.sessions.disconnect(vehicleID)
+	b.audit.post(entity.VehicleActor(vehicleID), entity.VehicleConnectAudit, "", "cameras="+strings.Join(cameras, ","))
+	defer b.audit.post(entity.VehicleActor(vehicleID), entity.VehicleDisconnectAudit, "", "")
+	b.holdCredential(ctx, entity.VehicleToken, vehicleID, vehiclePassword, vehicle.CredentialVersion, errChan)
+}
+
+func (b *BroadcastService) DispatcherSession(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, errChan chan error) {
+	dispatcher, err := b.checkDispatcher(dispatcherID, dispatcherPassword)
+	if err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	var target string
+	if vehicleID != 0 {
+		target = entity.VehicleActor(vehicleID)
+	}
+	b.audit.post(entity.DispatcherActor(dispatcherID), entity.DispatcherConnectAudit, target, "")
+	defer b.audit.post(entity.DispatcherActor(dispatcherID), entity.DispatcherDisconnectAudit, target, "")
+	b.holdCredential(ctx, entity.DispatcherToken, dispatcherID, dispatcherPassword, dispatcher.CredentialVersion, errChan)
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestSessionManager создаёт sessionManager и канал, в который приходят события сессии ТС testVehicleID
+func newTestSessionManager(gracePeriod time.Duration) (*sessionManager, chan entity.StreamEvent) {
+	watchdog := newStreamWatchdog(newTestHealthRepo())
+	events := make(chan entity.StreamEvent, eventQueueSize)
+	watchdog.watch(testVehicleID, events)
+	return newSessionManager(gracePeriod, watchdog, newFleetMonitor()), events
+}
+
+// sessionEvents возвращает состояния из накопленных событий сессии через запятую
+func sessionEvents(events chan entity.StreamEvent) string {
+	var states []string
+	for {
+		select {
+		case event := <-events:
+			states = append(states, string(event.State))
+		default:
+			return strings.Join(states, ",")
+		}
+	}
+}
+
+func TestSessionGracePeriod(t *testing.T) {
+	const grace = 50 * time.Millisecond
+	type step struct {
+		connect bool
+		wait    time.Duration
+	}
+	var (
+		connect    = step{connect: true}
+		disconnect = step{}
+	)
+	sleep := func(d time.Duration) step { return step{wait: d} }
+	tests := []struct {
+		name       string
+		grace      time.Duration
+		steps      []step
+		wantState  entity.ConnectionState
+		wantEvents string
+	}{
+		{
+			name:      "connected",
+			grace:     grace,
+			steps:     []step{connect},
+			wantState: entity.VehicleOnline,
+		},
+		{
+			name:       "reconnecting",
+			grace:      grace,
+			steps:      []step{connect, disconnect},
+			wantState:  entity.VehicleReconnecting,
+			wantEvents: "disconnected",
+		},
+		{
+			name:       "reconnect inside grace period",
+			grace:      grace,
+			steps:      []step{connect, disconnect, sleep(grace / 2), connect, sleep(grace * 2)},
+			wantState:  entity.VehicleOnline,
+			wantEvents: "disconnected,reconnected",
+		},
+		{
+			name:       "expired",
+			grace:      grace,
+			steps:      []step{connect, disconnect, sleep(grace * 2)},
+			wantState:  entity.VehicleOffline,
+			wantEvents: "disconnected,closed",
+		},
+		{
+			// таймер первого обрыва остановлен переподключением и не сокращает второй грейс-период
+			name:       "second grace period",
+			grace:      grace,
+			steps:      []step{connect, disconnect, sleep(grace * 3 / 4), connect, disconnect, sleep(grace * 3 / 4)},
+			wantState:  entity.VehicleReconnecting,
+			wantEvents: "disconnected,reconnected,disconnected",
+		},
+		{
+			// новое соединение открылось раньше, чем сервер заметил обрыв старого
+			name:       "overlapping connections",
+			grace:      grace,
+			steps:      []step{connect, connect, disconnect, sleep(grace * 2)},
+			wantState:  entity.VehicleOnline,
+			wantEvents: "reconnected",
+		},
+		{
+			name:       "no grace period",
+			steps:      []step{connect, disconnect},
+			wantState:  entity.VehicleOffline,
+			wantEvents: "closed",
+		},
+		{
+			name:      "disconnect without session",
+			grace:     grace,
+			steps:     []step{disconnect},
+			wantState: entity.VehicleOffline,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, events := newTestSessionManager(tt.grace)
+			for _, st := range tt.steps {
+				switch {
+				case st.wait > 0:
+					time.Sleep(st.wait)
+				case st.connect:
+					m.connect(testVehicleID, []string{"front"})
+				default:
+					m.disconnect(testVehicleID)
+				}
+			}
+			if got := m.state(testVehicleID); got != tt.wantState {
+				t.Errorf("state = %s, want %s", got, tt.wantState)
+			}
+			if got := sessionEvents(events); got != tt.wantEvents {
+				t.Errorf("events = %q, want %q", got, tt.wantEvents)
+			}
+		})
+	}
+}
+
+// TestSessionReconnectRacesExpiry проверяет, что таймер грейс-периода, сработавший одновременно
+// с переподключением, не закрывает новую сессию
+func TestSessionReconnectRacesExpiry(t *testing.T) {
+	m, _ := newTestSessionManager(time.Millisecond)
+	for i := 0; i < 200; i++ {
+		m.connect(testVehicleID, nil)
+		m.disconnect(testVehicleID)
+		time.Sleep(time.Duration(i%3) * time.Millisecond / 2)
+		m.connect(testVehicleID, nil)
+		time.Sleep(2 * time.Millisecond)
+		if got := m.state(testVehicleID); got != entity.VehicleOnline {
+			t.Fatalf("iteration %d: state = %s, want online", i, got)
+		}
+		m.disconnect(testVehicleID)
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// testSources - трансляции ТС, которые ищет awaitSource
+type testSources struct {
+	mu     sync.Mutex
+	source int
+}
+
+func (s *testSources) set(m *sessionManager, source int) {
+	s.mu.Lock()
+	s.source = source
+	s.mu.Unlock()
+	m.changed(testVehicleID)
+}
+
+func (s *testSources) lookup() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source, s.source != 0
+}
+
+// awaitResult - результат awaitSource, запущенного в отдельной горутине
+type awaitResult struct {
+	source int
+	err    error
+}
+
+func startAwait(m *sessionManager, sources *testSources, old int) chan awaitResult {
+	result := make(chan awaitResult, 1)
+	go func() {
+		source, err := awaitSource(context.Background(), m, testVehicleID, entity.VideoStreamName("front"), old, sources.lookup)
+		result <- awaitResult{source: source, err: err}
+	}()
+	return result
+}
+
+// TestAwaitSourceReconnect проверяет, что подписчик, ждущий трансляцию во время обрыва, переходит на трансляцию
+// переподключившегося ТС или получает ErrStreamClosed, если грейс-период закончился
+func TestAwaitSourceReconnect(t *testing.T) {
+	tests := []struct {
+		name       string
+		reconnect  bool
+		wantSource int
+		wantErr    error
+	}{
+		{name: "reconnect", reconnect: true, wantSource: 2},
+		{name: "expired", wantErr: usecase.ErrStreamClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const grace = 50 * time.Millisecond
+			m, _ := newTestSessionManager(grace)
+			sources := &testSources{}
+			m.connect(testVehicleID, []string{"front"})
+			sources.set(m, 1)
+			if got := <-startAwait(m, sources, 0); got.source != 1 || got.err != nil {
+				t.Fatalf("awaitSource = %d, %v, want first source", got.source, got.err)
+			}
+
+			// трансляция закончилась вместе с соединением, подписчик ждёт новую
+			sources.set(m, 0)
+			m.disconnect(testVehicleID)
+			result := startAwait(m, sources, 1)
+			time.Sleep(grace / 5)
+			select {
+			case got := <-result:
+				t.Fatalf("awaitSource returned %d, %v during grace period", got.source, got.err)
+			default:
+			}
+			if tt.reconnect {
+				m.connect(testVehicleID, []string{"front"})
+				// трансляция появляется позже соединения
+				time.Sleep(grace / 5)
+				sources.set(m, 2)
+			}
+
+			select {
+			case got := <-result:
+				if got.source != tt.wantSource || !errors.Is(got.err, tt.wantErr) {
+					t.Errorf("awaitSource = %d, %v, want %d, %v", got.source, got.err, tt.wantSource, tt.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("awaitSource did not return")
+			}
+		})
+	}
+}
+
+func TestAwaitSourceNotExpected(t *testing.T) {
+	m, _ := newTestSessionManager(time.Minute)
+	sources := &testSources{}
+	if got := <-startAwait(m, sources, 0); !errors.Is(got.err, usecase.ErrNotFound) {
+		t.Errorf("no session: err = %v, want ErrNotFound", got.err)
+	}
+	// ТС подключено без этой камеры
+	m.connect(testVehicleID, []string{"rear"})
+	if got := <-startAwait(m, sources, 1); !errors.Is(got.err, usecase.ErrStreamClosed) {
+		t.Errorf("stream not in session: err = %v, want ErrStreamClosed", got.err)
+	}
+}
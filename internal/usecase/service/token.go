@@ -0,0 +1,193 @@
+package service
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/password"
+	"self-driving-car-dispatch-system/pkg/pki"
+	"self-driving-car-dispatch-system/pkg/token"
+	"time"
+)
+
+// tokenIssuer выдаёт и проверяет токены сессий. С токеном пароль ТС или диспетчера проверяется bcrypt
+// только при входе, а не при каждом обращении к трансляциям
+type tokenIssuer struct {
+	signer *token.Signer
+	ttl    time.Duration
+	// revocationRepo хранит отзывы паролей, по которым отклоняются токены, выданные по отозванному паролю,
+	// а certificateRepo - отозванные сертификаты, по которым отклоняются токены, выданные по сертификату.
+	// nil - токены не сверяются с отзывами
+	revocationRepo  repo.RevocationRepo
+	certificateRepo repo.CertificateRepo
+}
+
+func newTokenIssuer(key []byte, ttl time.Duration, revocationRepo repo.RevocationRepo, certificateRepo repo.CertificateRepo) *tokenIssuer {
+	return &tokenIssuer{signer: token.NewSigner(key), ttl: ttl, revocationRepo: revocationRepo, certificateRepo: certificateRepo}
+}
+
+// issue подписывает токен с содержимым claims, действующий ttl с текущего момента
+func (t *tokenIssuer) issue(claims entity.TokenClaims) (*entity.SessionToken, error) {
+	claims.IssuedAt = time.Now()
+	claims.ExpiresAt = claims.IssuedAt.Add(t.ttl)
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return &entity.SessionToken{Token: t.signer.Sign(payload), ExpiresAt: claims.ExpiresAt}, nil
+}
+
+// parse возвращает содержимое credential, если это действующий токен с ролью role
+func (t *tokenIssuer) parse(credential string, role entity.TokenRole) (*entity.TokenClaims, bool) {
+	if !token.IsToken(credential) {
+		return nil, false
+	}
+	payload, err := t.signer.Verify(credential)
+	if err != nil {
+		return nil, false
+	}
+	var claims entity.TokenClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+	if claims.Role != role || !time.Now().Before(claims.ExpiresAt) {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// verify возвращает содержимое credential, если это действующий токен с ролью role и ID id. Если ok false,
+// то credential не токен и проверяется как пароль. Токен, выданный по отозванному паролю или сертификату,
+// отклоняется с ошибкой
+func (t *tokenIssuer) verify(credential string, role entity.TokenRole, id int) (claims *entity.TokenClaims, ok bool, err error) {
+	claims, ok = t.parse(credential, role)
+	if !ok || claims.ID != id {
+		return nil, false, nil
+	}
+	// токен, выданный по сертификату, от пароля не зависит
+	if claims.Certificate != "" && t.certificateRepo != nil {
+		if err = t.checkCertificate(claims.Certificate); err != nil {
+			return nil, true, err
+		}
+		return claims, true, nil
+	}
+	if claims.Certificate != "" || t.revocationRepo == nil {
+		return claims, true, nil
+	}
+	revocation, err := t.revocation(role, id)
+	if err != nil {
+		return nil, true, err
+	}
+	if revocation.IsRevoked(claims.CredentialVersion, time.Now()) {
+		return nil, true, errors.Join(usecase.ErrAccessDenied, fmt.Errorf("пароль сменён"))
+	}
+	return claims, true, nil
+}
+
+// revocation возвращает отзыв паролей ТС или диспетчера из хранилища
+func (t *tokenIssuer) revocation(role entity.TokenRole, id int) (*entity.CredentialRevocation, error) {
+	revocation, err := t.revocationRepo.GetRevocation(role, id)
+	if err != nil {
+		return nil, errors.Join(usecase.ErrInternal, err)
+	}
+	return revocation, nil
+}
+
+// checkCertificate проверяет, что сертификат с серийным номером serial не отозван
+func (t *tokenIssuer) checkCertificate(serial string) error {
+	revoked, err := t.certificateRepo.IsCertificateRevoked(serial)
+	if err != nil {
+		return errors.Join(usecase.ErrInternal, err)
+	}
+	if revoked {
+		return errors.Join(usecase.ErrAccessDenied, fmt.Errorf("сертификат отозван"))
+	}
+	return nil
+}
+
+// checkPassword проверяет secret по текущему паролю версии version и по прежнему паролю, пока он действует после смены.
+// Пароли, отозванные revocation, не принимаются, даже если запись с паролем прочитана до смены.
+// Возвращает версию пароля, с которым совпал secret
+func checkPassword(secret, passwordHash string, version int, previous entity.PreviousCredential, revocation *entity.CredentialRevocation) (int, bool) {
+	now := time.Now()
+	if !revocation.IsRevoked(version, now) && password.CheckPassword(secret, passwordHash) {
+		return version, true
+	}
+	if previous.IsValid(now) && !revocation.IsRevoked(version-1, now) && password.CheckPassword(secret, previous.PasswordHash) {
+		return version - 1, true
+	}
+	return 0, false
+}
+
+func (b *BroadcastService) LoginVehicle(vehicleID int, vehicleCredential string) (*entity.SessionToken, error) {
+	claims := entity.TokenClaims{Role: entity.VehicleToken, ID: vehicleID}
+	if previous, ok, err := b.tokens.verify(vehicleCredential, entity.VehicleToken, vehicleID); ok {
+		if err != nil {
+			return nil, err
+		}
+		// при продлении проверяем, что ТС не удалили, не вывели из эксплуатации и не сменили ему пароль.
+		// Отзыв сертификата уже проверил verify
+		vehicle, err := b.activeVehicle(vehicleID)
+		if err != nil {
+			return nil, err
+		}
+		if previous.Certificate == "" && !entity.IsCredentialVersionValid(previous.CredentialVersion, vehicle.CredentialVersion, vehicle.PreviousCredential, time.Now()) {
+			return nil, errors.Join(usecase.ErrAccessDenied, fmt.Errorf("пароль ТС сменён"))
+		}
+		claims.CredentialVersion = previous.CredentialVersion
+		claims.Certificate = previous.Certificate
+		return b.tokens.issue(claims)
+	}
+	vehicle, err := b.authVehicle(vehicleID, vehicleCredential)
+	if err != nil {
+		return nil, err
+	}
+	claims.CredentialVersion = vehicle.CredentialVersion
+	return b.tokens.issue(claims)
+}
+
+func (b *BroadcastService) LoginVehicleCertificate(vehicleID int, certificate *x509.Certificate) (*entity.SessionToken, error) {
+	// цепочку сертификата уже проверил TLS, остаётся сверить ID ТС и проверить, что сертификат не отозван
+	certificateVehicleID, ok := entity.ParseVehicleCommonName(certificate.Subject.CommonName)
+	if !ok || certificateVehicleID != vehicleID {
+		return nil, errors.Join(usecase.ErrAccessDenied, fmt.Errorf("сертификат выпущен не для ТС %d", vehicleID))
+	}
+	serial := pki.Serial(certificate)
+	if err := b.tokens.checkCertificate(serial); err != nil {
+		return nil, err
+	}
+	if _, err := b.activeVehicle(vehicleID); err != nil {
+		return nil, err
+	}
+	// токен, выданный по сертификату, не зависит от пароля ТС и не отзывается при его смене, а отзывается
+	// вместе с сертификатом
+	return b.tokens.issue(entity.TokenClaims{Role: entity.VehicleToken, ID: vehicleID, Certificate: serial})
+}
+
+func (b *BroadcastService) LoginDispatcher(dispatcherID int, dispatcherCredential string) (*entity.SessionToken, error) {
+	dispatcher, err := b.checkDispatcher(dispatcherID, dispatcherCredential)
+	if err != nil {
+		return nil, err
+	}
+	if token.IsToken(dispatcherCredential) {
+		// при продлении права перечитываются из хранилища: их могли изменить, а диспетчера - удалить или сменить ему пароль
+		version := dispatcher.CredentialVersion
+		if dispatcher, err = b.getDispatcher(dispatcherID); err != nil {
+			return nil, err
+		}
+		if !entity.IsCredentialVersionValid(version, dispatcher.CredentialVersion, dispatcher.PreviousCredential, time.Now()) {
+			return nil, errors.Join(usecase.ErrAccessDenied, fmt.Errorf("пароль диспетчера сменён"))
+		}
+		dispatcher.CredentialVersion = version
+		b.grants.put(grantsKey{dispatcherID: dispatcherID, credentialVersion: version}, dispatcher)
+	}
+	return b.tokens.issue(entity.TokenClaims{
+		Role:              entity.DispatcherToken,
+		ID:                dispatcherID,
+		CredentialVersion: dispatcher.CredentialVersion,
+	})
+}
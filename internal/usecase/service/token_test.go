@@ -0,0 +1,515 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/password"
+	"self-driving-car-dispatch-system/pkg/protocol"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDispatcherRepo - хранилище диспетчеров в памяти, считает чтения. Трансляции читают его в своих горутинах,
+// поэтому методы работают под mu
+type testDispatcherRepo struct {
+	mu          sync.Mutex
+	dispatchers map[int]entity.Dispatcher
+	revocations *testRevocationRepo
+	gets        int
+}
+
+// testRevocationRepo - хранилище отзывов паролей в памяти. Сообщения об отзыве не передаются серверу ретрансляции,
+// а копятся в published: тест сам решает, получил ли их сервер
+type testRevocationRepo struct {
+	revocations map[credentialKey]entity.CredentialRevocation
+	published   []entity.CredentialRevocation
+}
+
+// store сохраняет отзыв, как хранилище вместе с новым паролем
+func (r *testRevocationRepo) store(revocation *entity.CredentialRevocation) {
+	r.revocations[credentialKey{role: revocation.Role, id: revocation.ID}] = *revocation
+}
+
+func (r *testRevocationRepo) GetRevocation(role entity.TokenRole, id int) (*entity.CredentialRevocation, error) {
+	revocation, ok := r.revocations[credentialKey{role: role, id: id}]
+	if !ok {
+		revocation = entity.CredentialRevocation{Role: role, ID: id}
+	}
+	return &revocation, nil
+}
+
+func (r *testRevocationRepo) PublishRevocation(revocation *entity.CredentialRevocation) error {
+	r.published = append(r.published, *revocation)
+	return nil
+}
+
+func (r *testRevocationRepo) SubscribeRevocations(context.Context) <-chan entity.CredentialRevocation {
+	return nil
+}
+
+// testVehicleRepo - хранилище ТС в памяти. Сессии сверяются с ним в своих горутинах, поэтому доступ под mu
+type testVehicleRepo struct {
+	mu       sync.Mutex
+	vehicles map[int]entity.Vehicle
+}
+
+func (r *testVehicleRepo) GetVehicle(id int) (*entity.Vehicle, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	vehicle, ok := r.vehicles[id]
+	if !ok {
+		return nil, repo.ErrVehicleNotFound
+	}
+	return &vehicle, nil
+}
+
+func (r *testVehicleRepo) AddVehicle(vehicle *entity.Vehicle, _ *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.vehicles[vehicle.ID] = *vehicle
+	return nil
+}
+
+func (r *testVehicleRepo) EditVehicle(vehicle *entity.Vehicle, _ *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.vehicles[vehicle.ID]; !ok {
+		return repo.ErrVehicleNotFound
+	}
+	r.vehicles[vehicle.ID] = *vehicle
+	return nil
+}
+
+func (r *testVehicleRepo) RotateVehicleCredential(int, func(vehicle *entity.Vehicle) (*entity.AuditRecord, error)) error {
+	return nil
+}
+
+func (r *testVehicleRepo) DeleteVehicle(id int, _ *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.vehicles[id]; !ok {
+		return repo.ErrVehicleNotFound
+	}
+	delete(r.vehicles, id)
+	return nil
+}
+
+func (r *testVehicleRepo) ListVehicles(*entity.VehicleFilter) ([]entity.Vehicle, error) {
+	return nil, nil
+}
+
+// testCertificateRepo - хранилище сертификатов ТС в памяти, ключ - серийный номер. Сессии сверяются с ним
+// в своих горутинах, поэтому доступ под mu
+type testCertificateRepo struct {
+	mu           sync.Mutex
+	certificates map[string]entity.VehicleCertificate
+}
+
+func (r *testCertificateRepo) AddCertificate(certificate *entity.VehicleCertificate, _ *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certificates[certificate.Serial] = *certificate
+	return nil
+}
+
+func (r *testCertificateRepo) GetCertificates(int) ([]entity.VehicleCertificate, error) {
+	return nil, nil
+}
+
+func (r *testCertificateRepo) RevokeCertificate(vehicleID int, serial string, _ *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certificate, ok := r.certificates[serial]
+	if !ok || certificate.VehicleID != vehicleID {
+		return repo.ErrCertificateNotFound
+	}
+	if certificate.RevokedAt.IsZero() {
+		certificate.RevokedAt = time.Now()
+		r.certificates[serial] = certificate
+	}
+	return nil
+}
+
+func (r *testCertificateRepo) IsCertificateRevoked(serial string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.certificates[serial].RevokedAt.IsZero(), nil
+}
+
+// testAdminRepo - хранилище с единственным администратором testAdminLogin с ролью управляющего парком
+type testAdminRepo struct{}
+
+const testAdminLogin = "manager"
+
+func (testAdminRepo) GetAdmin(login string) (*entity.Admin, error) {
+	if login != testAdminLogin {
+		return nil, repo.ErrAdminNotFound
+	}
+	return &entity.Admin{Login: login, Role: entity.FleetManagerRole}, nil
+}
+
+func (testAdminRepo) AddAdmin(*entity.Admin, *entity.AuditRecord) error {
+	return nil
+}
+
+func (testAdminRepo) EditAdmin(*entity.Admin, *entity.AuditRecord) error {
+	return nil
+}
+
+func (testAdminRepo) DeleteAdmin(string, *entity.AuditRecord) error {
+	return nil
+}
+
+func (r *testDispatcherRepo) GetDispatcher(id int) (*entity.Dispatcher, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gets++
+	dispatcher, ok := r.dispatchers[id]
+	if !ok {
+		return nil, repo.ErrDispatcherNotFound
+	}
+	return &dispatcher, nil
+}
+
+func (r *testDispatcherRepo) AddDispatcher(dispatcher *entity.Dispatcher, _ *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dispatchers[dispatcher.ID] = *dispatcher
+	return nil
+}
+
+func (r *testDispatcherRepo) EditDispatcher(dispatcher *entity.Dispatcher, _ *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dispatchers[dispatcher.ID] = *dispatcher
+	return nil
+}
+
+// RotateDispatcherCredential сохраняет отзыв прежних паролей в revocations, как хранилище Redis
+func (r *testDispatcherRepo) RotateDispatcherCredential(id int, rotate func(dispatcher *entity.Dispatcher) (*entity.AuditRecord, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dispatcher, ok := r.dispatchers[id]
+	if !ok {
+		return repo.ErrDispatcherNotFound
+	}
+	if _, err := rotate(&dispatcher); err != nil {
+		return err
+	}
+	r.dispatchers[id] = dispatcher
+	revocation := entity.NewCredentialRevocation(entity.DispatcherToken, id, dispatcher.CredentialVersion, dispatcher.PreviousCredential)
+	r.revocations.store(&revocation)
+	return nil
+}
+
+func (r *testDispatcherRepo) DeleteDispatcher(id int, _ *entity.AuditRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.dispatchers, id)
+	return nil
+}
+
+func (r *testDispatcherRepo) ListDispatchers(*entity.DispatcherFilter) ([]entity.Dispatcher, error) {
+	return nil, nil
+}
+
+const (
+	testDispatcherPassword = "dispatcher-password"
+	testVehiclePassword    = "vehicle-password"
+)
+
+// newTestDispatcherService создаёт BroadcastService только с тем, что нужно для входа диспетчеров и ТС
+func newTestDispatcherService(t *testing.T, dispatchers ...entity.Dispatcher) (*BroadcastService, *testDispatcherRepo) {
+	t.Helper()
+	hash, err := password.HashPassword(testDispatcherPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcherRepo := &testDispatcherRepo{
+		dispatchers: make(map[int]entity.Dispatcher),
+		revocations: &testRevocationRepo{revocations: make(map[credentialKey]entity.CredentialRevocation)},
+	}
+	for _, dispatcher := range dispatchers {
+		dispatcher.PasswordHash = hash
+		dispatcherRepo.dispatchers[dispatcher.ID] = dispatcher
+	}
+	certificateRepo := &testCertificateRepo{certificates: make(map[string]entity.VehicleCertificate)}
+	return &BroadcastService{
+		vehicleRepo:     &testVehicleRepo{vehicles: make(map[int]entity.Vehicle)},
+		dispatcherRepo:  dispatcherRepo,
+		certificateRepo: certificateRepo,
+		tokens:          newTokenIssuer([]byte("test key"), time.Hour, dispatcherRepo.revocations, certificateRepo),
+		fleet:           newFleetMonitor(),
+		grants:          newGrantsCache(time.Hour),
+		credentials:     newCredentialSessions(),
+	}, dispatcherRepo
+}
+
+// newTestVehicleService создаёт BroadcastService, как newTestDispatcherService, с ТС vehicles в статусе active
+// и паролем testVehiclePassword
+func newTestVehicleService(t *testing.T, vehicles ...int) (*BroadcastService, *testVehicleRepo) {
+	t.Helper()
+	b, _ := newTestDispatcherService(t)
+	hash, err := password.HashPassword(testVehiclePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vehicleRepo := b.vehicleRepo.(*testVehicleRepo)
+	for _, id := range vehicles {
+		_ = vehicleRepo.AddVehicle(&entity.Vehicle{ID: id, PasswordHash: hash, Status: entity.ActiveVehicle}, nil)
+	}
+	return b, vehicleRepo
+}
+
+// newTestAdminService создаёт AdminService с теми же хранилищами, что у b, и токен администратора,
+// которому разрешено управлять парком
+func newTestAdminService(t *testing.T, b *BroadcastService) (*AdminService, string) {
+	t.Helper()
+	a := &AdminService{
+		vehicleRepo:     b.vehicleRepo,
+		dispatcherRepo:  b.dispatcherRepo,
+		certificateRepo: b.certificateRepo,
+		adminRepo:       testAdminRepo{},
+		revocationRepo:  b.tokens.revocationRepo,
+		tokens:          newTokenIssuer([]byte("admin key"), time.Hour, nil, nil),
+	}
+	session, err := a.tokens.issue(entity.TokenClaims{Role: entity.AdminToken, Login: testAdminLogin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, session.Token
+}
+
+// TestDispatcherManyGrantsHandshake проверяет, что токен диспетчера с доступом к тысяче ТС помещается
+// в сообщения рукопожатия, а права по нему берутся на сервере
+func TestDispatcherManyGrantsHandshake(t *testing.T) {
+	grants := make([]int, 1000)
+	for i := range grants {
+		grants[i] = 100000 + i
+	}
+	b, dispatcherRepo := newTestDispatcherService(t, entity.Dispatcher{ID: 7, GrantsType: entity.ListGrants, Grants: grants})
+
+	session, err := b.LoginDispatcher(7, testDispatcherPassword)
+	if err != nil {
+		t.Fatalf("LoginDispatcher: %v", err)
+	}
+	var buf bytes.Buffer
+	if err = protocol.WriteHelloReply(&buf, &protocol.HelloReply{Version: protocol.Version, Cameras: []string{}, Token: session.Token}); err != nil {
+		t.Fatalf("WriteHelloReply with token of %d bytes: %v", len(session.Token), err)
+	}
+	reply, err := protocol.ReadHelloReply(&buf)
+	if err != nil {
+		t.Fatalf("ReadHelloReply: %v", err)
+	}
+	hello := protocol.Hello{Version: protocol.Version, Role: protocol.RoleDispatcher, VehicleID: uint32(grants[999]), DispatcherID: 7, Cameras: []string{}, Secret: reply.Token}
+	if err = protocol.WriteHello(&buf, &hello); err != nil {
+		t.Fatalf("WriteHello with token of %d bytes: %v", len(reply.Token), err)
+	}
+	received, err := protocol.ReadHello(&buf)
+	if err != nil {
+		t.Fatalf("ReadHello: %v", err)
+	}
+
+	gets := dispatcherRepo.gets
+	if _, err = b.authDispatcher(int(received.VehicleID), 7, received.Secret); err != nil {
+		t.Errorf("authDispatcher to granted vehicle: %v", err)
+	}
+	if _, err = b.authDispatcher(1, 7, received.Secret); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("authDispatcher to other vehicle: err = %v, want ErrAccessDenied", err)
+	}
+	if dispatcherRepo.gets != gets {
+		t.Errorf("token check read dispatcher %d times, want cached grants", dispatcherRepo.gets-gets)
+	}
+}
+
+// TestDispatcherTokenGrants проверяет, откуда берутся права диспетчера, вошедшего по токену
+func TestDispatcherTokenGrants(t *testing.T) {
+	b, dispatcherRepo := newTestDispatcherService(t, entity.Dispatcher{ID: 7, GrantsType: entity.ListGrants, Grants: []int{1}})
+	session, err := b.LoginDispatcher(7, testDispatcherPassword)
+	if err != nil {
+		t.Fatalf("LoginDispatcher: %v", err)
+	}
+
+	// после перезапуска сервера кэш пуст, права читаются из хранилища
+	b.grants = newGrantsCache(time.Hour)
+	dispatcher := dispatcherRepo.dispatchers[7]
+	dispatcher.Grants = []int{2}
+	dispatcherRepo.dispatchers[7] = dispatcher
+	if _, err = b.authDispatcher(2, 7, session.Token); err != nil {
+		t.Errorf("authDispatcher after restart: %v", err)
+	}
+
+	// изменение прав вступает в силу при продлении токена
+	dispatcher.Grants = []int{3}
+	dispatcherRepo.dispatchers[7] = dispatcher
+	if _, err = b.authDispatcher(3, 7, session.Token); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("authDispatcher before renewal: err = %v, want ErrAccessDenied", err)
+	}
+	renewed, err := b.LoginDispatcher(7, session.Token)
+	if err != nil {
+		t.Fatalf("LoginDispatcher with token: %v", err)
+	}
+	if _, err = b.authDispatcher(3, 7, renewed.Token); err != nil {
+		t.Errorf("authDispatcher after renewal: %v", err)
+	}
+
+	// удалённый диспетчер не продлевает токен
+	delete(dispatcherRepo.dispatchers, 7)
+	if _, err = b.LoginDispatcher(7, renewed.Token); !errors.Is(err, usecase.ErrDispatcherNotFound) {
+		t.Errorf("LoginDispatcher of deleted dispatcher: err = %v, want ErrDispatcherNotFound", err)
+	}
+}
+
+// rotate меняет пароль диспетчера так же, как сервис администратора, и возвращает новый пароль
+func rotate(t *testing.T, dispatcherRepo *testDispatcherRepo, id int, overlap time.Duration) string {
+	t.Helper()
+	var secret string
+	err := dispatcherRepo.RotateDispatcherCredential(id, func(dispatcher *entity.Dispatcher) (*entity.AuditRecord, error) {
+		rotation, err := rotateCredential(dispatcher.PasswordHash, dispatcher.CredentialVersion, overlap)
+		if err != nil {
+			return nil, err
+		}
+		dispatcher.PasswordHash = rotation.passwordHash
+		dispatcher.CredentialVersion = rotation.version
+		dispatcher.PreviousCredential = rotation.previous
+		secret = rotation.secret
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("RotateDispatcherCredential: %v", err)
+	}
+	return secret
+}
+
+// TestDispatcherCredentialRotation проверяет, что отзыв пароля действует на сервере, который не получил сообщение
+// об отзыве, например, запущенном после смены пароля
+func TestDispatcherCredentialRotation(t *testing.T) {
+	b, dispatcherRepo := newTestDispatcherService(t, entity.Dispatcher{ID: 7, GrantsType: entity.AllGrants})
+	session, err := b.LoginDispatcher(7, testDispatcherPassword)
+	if err != nil {
+		t.Fatalf("LoginDispatcher: %v", err)
+	}
+
+	// с перекрытием прежний пароль и токены по нему действуют до его конца
+	secret := rotate(t, dispatcherRepo, 7, time.Hour)
+	if _, err = b.authDispatcher(1, 7, session.Token); err != nil {
+		t.Errorf("authDispatcher with token during overlap: %v", err)
+	}
+	if _, err = b.authDispatcher(1, 7, testDispatcherPassword); err != nil {
+		t.Errorf("authDispatcher with previous password during overlap: %v", err)
+	}
+	renewed, err := b.LoginDispatcher(7, secret)
+	if err != nil {
+		t.Fatalf("LoginDispatcher with new password: %v", err)
+	}
+
+	// без перекрытия прежний пароль и токены по нему отклоняются сразу, в том числе после перезапуска сервера
+	rotate(t, dispatcherRepo, 7, 0)
+	b.grants = newGrantsCache(time.Hour)
+	b.credentials = newCredentialSessions()
+	for name, credential := range map[string]string{"first token": session.Token, "renewed token": renewed.Token, "first password": testDispatcherPassword, "second password": secret} {
+		if _, err = b.authDispatcher(1, 7, credential); !errors.Is(err, usecase.ErrAccessDenied) {
+			t.Errorf("authDispatcher with %s: err = %v, want ErrAccessDenied", name, err)
+		}
+	}
+	if _, err = b.LoginDispatcher(7, renewed.Token); !errors.Is(err, usecase.ErrAccessDenied) {
+		t.Errorf("LoginDispatcher with revoked token: err = %v, want ErrAccessDenied", err)
+	}
+}
+
+// TestCheckPasswordRevoked проверяет, что пароль из записи, прочитанной до смены пароля, не принимается
+func TestCheckPasswordRevoked(t *testing.T) {
+	hash, err := password.HashPassword(testDispatcherPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHash, err := password.HashPassword("other-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	previous := entity.PreviousCredential{PasswordHash: hash, ValidUntil: now.Add(time.Hour)}
+	tests := []struct {
+		name        string
+		version     int
+		previous    entity.PreviousCredential
+		revocation  entity.CredentialRevocation
+		wantVersion int
+		wantOK      bool
+	}{
+		{name: "not rotated", version: 2, wantVersion: 2, wantOK: true},
+		{name: "current", version: 2, revocation: entity.CredentialRevocation{MinVersion: 2}, wantVersion: 2, wantOK: true},
+		{name: "stale record", version: 2, revocation: entity.CredentialRevocation{MinVersion: 3}},
+		{name: "stale record during overlap", version: 2, revocation: entity.CredentialRevocation{MinVersion: 3, PreviousValidUntil: now.Add(time.Hour)}, wantVersion: 2, wantOK: true},
+		{name: "previous", version: 3, previous: previous, revocation: entity.CredentialRevocation{MinVersion: 3, PreviousValidUntil: previous.ValidUntil}, wantVersion: 2, wantOK: true},
+		{name: "previous revoked by next rotation", version: 3, previous: previous, revocation: entity.CredentialRevocation{MinVersion: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passwordHash := hash
+			if tt.previous.PasswordHash != "" {
+				// текущий пароль другой, secret совпадает только с прежним
+				passwordHash = otherHash
+			}
+			version, ok := checkPassword(testDispatcherPassword, passwordHash, tt.version, tt.previous, &tt.revocation)
+			if ok != tt.wantOK || version != tt.wantVersion {
+				t.Errorf("checkPassword = %d, %t, want %d, %t", version, ok, tt.wantVersion, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTokenIssuerVerify(t *testing.T) {
+	revocationRepo := &testRevocationRepo{revocations: make(map[credentialKey]entity.CredentialRevocation)}
+	issuer := newTokenIssuer([]byte("test key"), time.Hour, revocationRepo, nil)
+	session, err := issuer.issue(entity.TokenClaims{Role: entity.DispatcherToken, ID: 7, CredentialVersion: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := issuer.issue(entity.TokenClaims{Role: entity.DispatcherToken, ID: 8, CredentialVersion: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocationRepo.store(&entity.CredentialRevocation{Role: entity.DispatcherToken, ID: 8, MinVersion: 4})
+	expiredIssuer := newTokenIssuer([]byte("test key"), -time.Second, nil, nil)
+	expired, err := expiredIssuer.issue(entity.TokenClaims{Role: entity.DispatcherToken, ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := newTokenIssuer([]byte("other key"), time.Hour, nil, nil).issue(entity.TokenClaims{Role: entity.DispatcherToken, ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		credential string
+		role       entity.TokenRole
+		id         int
+		wantOK     bool
+		wantErr    error
+	}{
+		{name: "valid", credential: session.Token, role: entity.DispatcherToken, id: 7, wantOK: true},
+		{name: "revoked", credential: revoked.Token, role: entity.DispatcherToken, id: 8, wantOK: true, wantErr: usecase.ErrAccessDenied},
+		{name: "other role", credential: session.Token, role: entity.VehicleToken, id: 7},
+		{name: "other id", credential: session.Token, role: entity.DispatcherToken, id: 8},
+		{name: "expired", credential: expired.Token, role: entity.DispatcherToken, id: 7},
+		{name: "other key", credential: otherKey.Token, role: entity.DispatcherToken, id: 7},
+		{name: "password", credential: testDispatcherPassword, role: entity.DispatcherToken, id: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, ok, err := issuer.verify(tt.credential, tt.role, tt.id)
+			if ok != tt.wantOK || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verify = %t, %v, want %t, %v", ok, err, tt.wantOK, tt.wantErr)
+			}
+			if claims != nil && claims.CredentialVersion != 3 {
+				t.Errorf("CredentialVersion = %d, want 3", claims.CredentialVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"golang.org/x/exp/slices"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/usecase"
+	"self-driving-car-dispatch-system/pkg/h264"
+	"sync"
+	"time"
+)
+
+// maxKeyframeGroupSize ограничивает размер сохраняемой группы кадров. Если между ключевыми кадрами
+// передаётся больше данных, то новый диспетчер получит начало группы и увидит артефакты до следующего IDR
+const maxKeyframeGroupSize = 8 << 20
+
+// keyframeCache хранит последние SPS и PPS и группу кадров, которая начинается с последнего ключевого кадра.
+// Эту группу получает диспетчер, подключившийся посреди трансляции, чтобы декодер сразу смог показать картинку
+type keyframeCache struct {
+	sps, pps []byte
+	group    []packet
+	size     int
+}
+
+// add превращает кадр, полученный сервером в ingress, в пакет для рассылки и учитывает его в группе.
+// В ключевой кадр добавляются SPS и PPS, если их в нём нет, чтобы с любого ключевого кадра можно было начать
+// декодирование. Время съёмки из SEI ТС заменяется на SEI со временем съёмки, получения и отправки диспетчеру
+func (c *keyframeCache) add(au *h264.AccessUnit, ingress time.Time) packet {
+	var capture time.Time
+	if timestamps, ok := au.TakeTimestampsSEI(); ok && len(timestamps) > 0 {
+		capture = timestamps[0]
+	}
+	for _, nal := range au.NALs {
+		switch h264.Type(nal) {
+		case h264.NALSPS:
+			c.sps = nal
+		case h264.NALPPS:
+			c.pps = nal
+		}
+	}
+	p := packet{
+		keyframe:  au.IsKeyframe() && c.sps != nil && c.pps != nil,
+		reference: au.IsReference(),
+	}
+	if p.keyframe && !au.Has(h264.NALSPS) {
+		// AUD должен оставаться первым NAL unit кадра
+		i := 0
+		if h264.Type(au.NALs[0]) == h264.NALAUD {
+			i = 1
+		}
+		au.NALs = slices.Insert(au.NALs, i, c.sps, c.pps)
+	}
+	data, offset := au.Bytes(), au.VCLOffset()
+	p.data = data
+	p.egress = func(egress time.Time) []byte {
+		sei := h264.NewTimestampsSEI(capture, ingress, egress)
+		stamped := make([]byte, 0, len(data)+len(sei))
+		stamped = append(stamped, data[:offset]...)
+		stamped = append(stamped, sei...)
+		return append(stamped, data[offset:]...)
+	}
+
+	if p.keyframe {
+		// новый срез, а не c.group[:0]: старая группа может читаться подписчиками
+		c.group = []packet{p}
+		c.size = len(p.data)
+	} else if c.group != nil && c.size+len(p.data) <= maxKeyframeGroupSize {
+		c.group = append(c.group, p)
+		c.size += len(p.data)
+	}
+	return p
+}
+
+// snapshot возвращает группу кадров для нового подписчика или nil, если ключевого кадра ещё не было
+func (c *keyframeCache) snapshot() []packet {
+	return c.group
+}
+
+// videoKey - ключ трансляции видео с одной камеры ТС
+type videoKey struct {
+	vehicleID int
+	camera    string
+}
+
+// videoLayers - трансляции слоёв качества видео одной камеры ТС, начиная с лучшего качества
+type videoLayers struct {
+	hubs []*hub
+}
+
+func newVideoLayers(count int) *videoLayers {
+	l := &videoLayers{hubs: make([]*hub, count)}
+	for i := range l.hubs {
+		l.hubs[i] = newHub()
+	}
+	return l
+}
+
+// layer возвращает номер и трансляцию слоя, ближайшего к запрошенному
+func (l *videoLayers) layer(i int) (int, *hub) {
+	i = min(max(i, 0), len(l.hubs)-1)
+	return i, l.hubs[i]
+}
+
+// live возвращает номер и трансляцию ближайшего к запрошенному слоя, который ещё не закончился. Из двух одинаково
+// близких слоёв выбирается слой хуже качеством. Если закончились все слои, то возвращается false
+func (l *videoLayers) live(i int) (int, *hub, bool) {
+	i = min(max(i, 0), len(l.hubs)-1)
+	for d := 0; d < len(l.hubs); d++ {
+		for _, j := range []int{i + d, i - d} {
+			if j >= 0 && j < len(l.hubs) && !l.hubs[j].isClosed() {
+				return j, l.hubs[j], true
+			}
+		}
+	}
+	return 0, nil, false
+}
+
+func (l *videoLayers) close() {
+	for _, h := range l.hubs {
+		h.close()
+	}
+}
+
+func (b *BroadcastService) GetVideoStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, camera string, layerChan chan int, stream chan []byte, errChan chan error) {
+	if _, err := b.authDispatcher(vehicleID, dispatcherID, dispatcherPassword); err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	b.fleet.watch(vehicleID, dispatcherID)
+	defer b.fleet.unwatch(vehicleID, dispatcherID)
+	b.holdGrant(ctx, vehicleID, dispatcherID, errChan, func(ctx context.Context) {
+		b.subscribeVideo(ctx, vehicleID, camera, layerChan, stream, errChan)
+	})
+}
+
+// GetVideoCameras возвращает камеры, с которых ведётся трансляция, а пока ТС переподключается - камеры
+// его последнего соединения
+func (b *BroadcastService) GetVideoCameras(vehicleID int) []string {
+	cameras := b.sessions.cameras(vehicleID)
+	b.videoStreams.Range(func(key, _ any) bool {
+		if key.(videoKey).vehicleID == vehicleID {
+			cameras = append(cameras, key.(videoKey).camera)
+		}
+		return true
+	})
+	slices.Sort(cameras)
+	return slices.Compact(cameras)
+}
+
+func (b *BroadcastService) GetVideoLayers(vehicleID int, camera string) int {
+	layers, ok := b.videoStreams.Load(videoKey{vehicleID: vehicleID, camera: camera})
+	if !ok {
+		return 0
+	}
+	return len(layers.(*videoLayers).hubs)
+}
+
+// subscribeVideo передаёт диспетчеру видео с камеры ТС, пока не закончится сессия ТС или не будет отменён ctx.
+// Когда ТС переподключается, диспетчер переходит на новую трансляцию с того же слоя
+func (b *BroadcastService) subscribeVideo(ctx context.Context, vehicleID int, camera string, layerChan chan int, stream chan []byte, errChan chan error) {
+	key := videoKey{vehicleID: vehicleID, camera: camera}
+	lookup := func() (*videoLayers, bool) {
+		layers, ok := b.videoStreams.Load(key)
+		if !ok {
+			return nil, false
+		}
+		return layers.(*videoLayers), true
+	}
+	var layers *videoLayers
+	wanted := 0
+	for {
+		next, err := awaitSource(ctx, b.sessions, vehicleID, entity.VideoStreamName(camera), layers, lookup)
+		if err != nil {
+			if ctx.Err() == nil {
+				sendErr(ctx, errChan, err)
+			}
+			return
+		}
+		layers = next
+		if !relayLayers(ctx, layers, &wanted, layerChan, stream) {
+			return
+		}
+	}
+}
+
+// relayLayers передаёт диспетчеру видео слоя, номер которого последним пришёл в layerChan, начиная со слоя wanted.
+// После смены слоя видео старого слоя передаётся, пока в новом слое не придёт ключевой кадр,
+// поэтому декодер диспетчера получает непрерывный поток. Если слой закончился раньше других, то диспетчер
+// переходит на ближайший живой слой. Возвращает true, если закончились все слои, и false, если отменён ctx
+func relayLayers(ctx context.Context, layers *videoLayers, wanted *int, layerChan chan int, stream chan []byte) bool {
+	current, h := layers.layer(*wanted)
+	sub := h.subscribe(videoQueueSize, dropFrames, true)
+	defer func() { h.unsubscribe(sub) }()
+	// next - подписка на слой, на который переключается диспетчер. В её очереди первым всегда идёт ключевой кадр
+	var (
+		next      *subscriber
+		nextHub   *hub
+		nextLayer int
+	)
+	defer func() {
+		if next != nil {
+			nextHub.unsubscribe(next)
+		}
+	}()
+	for {
+		var nextQueue chan packet
+		if next != nil {
+			nextQueue = next.queue
+		}
+		var p packet
+		var ok bool
+		select {
+		case layer, ok := <-layerChan:
+			if !ok {
+				layerChan = nil
+				continue
+			}
+			*wanted = layer
+			if next != nil {
+				nextHub.unsubscribe(next)
+				next = nil
+			}
+			if layer, lh := layers.layer(layer); layer != current {
+				nextLayer, nextHub = layer, lh
+				next = nextHub.subscribe(videoQueueSize, dropFrames, false)
+			}
+			continue
+		case p, ok = <-sub.queue:
+			if !ok {
+				// слой закончился: если диспетчер уже переключается, то ждём ключевой кадр нового слоя,
+				// иначе переходим на ближайший живой слой с его последней группы кадров
+				if next != nil {
+					current, h, sub = nextLayer, nextHub, next
+					next = nil
+					continue
+				}
+				layer, lh, live := layers.live(*wanted)
+				if !live {
+					return true
+				}
+				current, h = layer, lh
+				sub = h.subscribe(videoQueueSize, dropFrames, true)
+				continue
+			}
+		case p, ok = <-nextQueue:
+			if !ok {
+				// слой, на который переключался диспетчер, закончился, остаёмся на текущем
+				next = nil
+				continue
+			}
+			h.unsubscribe(sub)
+			current, h, sub = nextLayer, nextHub, next
+			next = nil
+		case <-ctx.Done():
+			return false
+		}
+		select {
+		case stream <- p.bytes(time.Now()):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// SendVideoStream принимает видео с камеры ТС, по потоку на каждый слой качества видео
+func (b *BroadcastService) SendVideoStream(ctx context.Context, vehicleID int, vehiclePassword string, camera string, streams []chan []byte, errChan chan error) {
+	if _, err := b.authVehicle(vehicleID, vehiclePassword); err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	if len(streams) == 0 {
+		sendErr(ctx, errChan, usecase.ErrBadRequest)
+		return
+	}
+	// если трансляция уже ведется, то новая трансляция заменяет её, а её диспетчеры переходят на новую
+	key := videoKey{vehicleID: vehicleID, camera: camera}
+	layers := newVideoLayers(len(streams))
+	if old, loaded := b.videoStreams.Swap(key, layers); loaded {
+		old.(*videoLayers).close()
+	}
+	b.sessions.changed(vehicleID)
+	b.fleet.notify()
+	defer b.fleet.notify()
+	defer layers.close()
+	defer b.videoStreams.CompareAndDelete(key, layers)
+	watched := b.watchdog.track(vehicleID, entity.VideoStreamName(camera))
+	defer b.watchdog.untrack(watched)
+	wg := sync.WaitGroup{}
+	for i, stream := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// когда слой заканчивается, его подписчики сразу узнают об этом
+			defer layers.hubs[i].close()
+			relayVideo(ctx, layers.hubs[i], stream, watched)
+		}()
+	}
+	wg.Wait()
+}
+
+// relayVideo разбирает поток Annex-B на кадры и рассылает видео только целыми кадрами, чтобы при отбрасывании
+// и при подключении нового диспетчера декодер никогда не получал разорванный поток. Данные любого слоя
+// отмечаются в watched: поток камеры считается живым, пока приходит хотя бы один слой
+func relayVideo(ctx context.Context, h *hub, stream chan []byte, watched *watchedStream) {
+	splitter := h264.NewSplitter()
+	assembler := h264.NewAssembler()
+	cache := &keyframeCache{}
+	publish := func(au *h264.AccessUnit) {
+		if au == nil {
+			return
+		}
+		p := cache.add(au, time.Now())
+		h.publish(p, cache.snapshot())
+	}
+	for {
+		select {
+		case d, ok := <-stream:
+			if !ok {
+				if nal := splitter.Flush(); nal != nil {
+					publish(assembler.Add(nal))
+				}
+				publish(assembler.Flush())
+				return
+			}
+			watched.touch()
+			_, _ = splitter.Write(d)
+		case <-ctx.Done():
+			return
+		}
+		for nal := splitter.Next(); nal != nil; nal = splitter.Next() {
+			publish(assembler.Add(nal))
+		}
+	}
+}
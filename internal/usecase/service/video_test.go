@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"self-driving-car-dispatch-system/pkg/h264"
+	"testing"
+	"time"
+)
+
+var (
+	testSPS   = []byte{0, 0, 0, 1, 0x67, 0x42, 0x00, 0x1f}
+	testPPS   = []byte{0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80}
+	testIDR   = []byte{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x21}
+	testSlice = []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x03}
+)
+
+func accessUnit(nals ...[]byte) *h264.AccessUnit {
+	return &h264.AccessUnit{NALs: nals}
+}
+
+func TestKeyframeCache(t *testing.T) {
+	aud := h264.NewAccessUnitDelimiter()
+	tests := []struct {
+		name string
+		aus  []*h264.AccessUnit
+		// wantGroup - кадры группы по номерам в aus
+		wantGroup []int
+		// wantKeyframes - какие пакеты рассылаются как ключевые
+		wantKeyframes []bool
+	}{
+		{
+			name:          "no keyframe",
+			aus:           []*h264.AccessUnit{accessUnit(testSlice), accessUnit(testSlice)},
+			wantKeyframes: []bool{false, false},
+		},
+		{
+			// IDR без SPS и PPS нельзя декодировать, это не начало группы
+			name:          "keyframe without parameter sets",
+			aus:           []*h264.AccessUnit{accessUnit(testIDR), accessUnit(testSlice)},
+			wantKeyframes: []bool{false, false},
+		},
+		{
+			name:          "group",
+			aus:           []*h264.AccessUnit{accessUnit(testSlice), accessUnit(testSPS, testPPS, testIDR), accessUnit(testSlice), accessUnit(testSlice)},
+			wantGroup:     []int{1, 2, 3},
+			wantKeyframes: []bool{false, true, false, false},
+		},
+		{
+			name:          "new keyframe restarts group",
+			aus:           []*h264.AccessUnit{accessUnit(testSPS, testPPS, testIDR), accessUnit(testSlice), accessUnit(aud, testIDR), accessUnit(testSlice)},
+			wantGroup:     []int{2, 3},
+			wantKeyframes: []bool{true, false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &keyframeCache{}
+			packets := make([]packet, len(tt.aus))
+			for i, au := range tt.aus {
+				packets[i] = c.add(au, time.Unix(1, 0))
+				if packets[i].keyframe != tt.wantKeyframes[i] {
+					t.Errorf("packet %d keyframe = %t, want %t", i, packets[i].keyframe, tt.wantKeyframes[i])
+				}
+			}
+			group := c.snapshot()
+			if len(group) != len(tt.wantGroup) {
+				t.Fatalf("group = %d packets, want %d", len(group), len(tt.wantGroup))
+			}
+			for i, n := range tt.wantGroup {
+				if !bytes.Equal(group[i].data, packets[n].data) {
+					t.Errorf("group packet %d = %x, want packet %d", i, group[i].data, n)
+				}
+			}
+		})
+	}
+}
+
+// TestKeyframeCacheParameterSets проверяет, что в ключевой кадр без SPS и PPS они добавляются после AUD
+func TestKeyframeCacheParameterSets(t *testing.T) {
+	c := &keyframeCache{}
+	aud := h264.NewAccessUnitDelimiter()
+	c.add(accessUnit(testSPS, testPPS, testIDR), time.Unix(1, 0))
+	p := c.add(accessUnit(aud, testIDR), time.Unix(2, 0))
+	if want := bytes.Join([][]byte{aud, testSPS, testPPS, testIDR}, nil); !bytes.Equal(p.data, want) {
+		t.Errorf("keyframe = %x, want %x", p.data, want)
+	}
+}
+
+// TestKeyframeCacheTimestamps проверяет замену SEI ТС на SEI со временем съёмки, получения и отправки
+func TestKeyframeCacheTimestamps(t *testing.T) {
+	capture, ingress, egress := time.Unix(10, 0), time.Unix(11, 0), time.Unix(12, 0)
+	c := &keyframeCache{}
+	p := c.add(accessUnit(h264.NewTimestampsSEI(capture), testSlice), ingress)
+	if h264.Type(p.data) != h264.NALSlice {
+		t.Fatalf("packet = %x, want vehicle SEI removed", p.data)
+	}
+	nals := h264.Split(p.egress(egress))
+	if len(nals) != 2 || !bytes.Equal(nals[1], testSlice) {
+		t.Fatalf("stamped packet = %x, want SEI and slice", nals)
+	}
+	timestamps, ok := h264.ParseTimestampsSEI(nals[0])
+	if !ok || len(timestamps) != 3 || !timestamps[0].Equal(capture) || !timestamps[1].Equal(ingress) || !timestamps[2].Equal(egress) {
+		t.Errorf("timestamps = %v, want capture, ingress and egress", timestamps)
+	}
+}
+
+// TestKeyframeCacheGroupLimit проверяет, что группа не растёт больше maxKeyframeGroupSize
+func TestKeyframeCacheGroupLimit(t *testing.T) {
+	c := &keyframeCache{}
+	c.add(accessUnit(testSPS, testPPS, testIDR), time.Unix(1, 0))
+	large := append([]byte{0, 0, 0, 1, 0x41, 0x9a}, make([]byte, maxKeyframeGroupSize/2)...)
+	for i := 0; i < 3; i++ {
+		c.add(accessUnit(large), time.Unix(1, 0))
+	}
+	if got := len(c.snapshot()); got != 2 {
+		t.Errorf("group = %d packets, want keyframe and one frame", got)
+	}
+}
+
+// relayRun - relayLayers, запущенный в отдельной горутине
+type relayRun struct {
+	stream    chan []byte
+	layerChan chan int
+	cancel    context.CancelFunc
+	done      chan bool
+}
+
+func startRelay(layers *videoLayers, wanted int) *relayRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &relayRun{stream: make(chan []byte), layerChan: make(chan int), cancel: cancel, done: make(chan bool, 1)}
+	go func() { r.done <- relayLayers(ctx, layers, &wanted, r.layerChan, r.stream) }()
+	return r
+}
+
+// expect проверяет, что диспетчер получил кадр want
+func (r *relayRun) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case data := <-r.stream:
+		if string(data) != want {
+			t.Fatalf("frame = %q, want %q", data, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("frame %q is not relayed", want)
+	}
+}
+
+// expectDone проверяет, что relayLayers закончился с результатом want
+func (r *relayRun) expectDone(t *testing.T, want bool) {
+	t.Helper()
+	select {
+	case got := <-r.done:
+		if got != want {
+			t.Fatalf("relayLayers = %t, want %t", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("relayLayers did not return")
+	}
+}
+
+// waitSubscribers ждёт, пока у h станет n подписчиков
+func waitSubscribers(t *testing.T, h *hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		h.mu.Lock()
+		got := len(h.subscribers)
+		h.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("subscribers = %d, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// publishFrame рассылает кадр слоя, ключевой кадр начинает новую группу snapshot
+func publishFrame(h *hub, name string) {
+	p := frame(name)
+	h.publish(p, []packet{p})
+}
+
+func TestRelayLayersSwitch(t *testing.T) {
+	layers := newVideoLayers(2)
+	publishFrame(layers.hubs[0], "K0")
+	r := startRelay(layers, 0)
+	defer r.cancel()
+	r.expect(t, "K0")
+
+	r.layerChan <- 1
+	waitSubscribers(t, layers.hubs[1], 1)
+	// до ключевого кадра нового слоя диспетчер получает старый слой
+	publishFrame(layers.hubs[1], "P1a")
+	publishFrame(layers.hubs[0], "P0a")
+	r.expect(t, "P0a")
+	publishFrame(layers.hubs[1], "K1")
+	r.expect(t, "K1")
+	waitSubscribers(t, layers.hubs[0], 0)
+	publishFrame(layers.hubs[0], "P0b")
+	publishFrame(layers.hubs[1], "P1b")
+	r.expect(t, "P1b")
+
+	r.cancel()
+	r.expectDone(t, false)
+}
+
+// TestRelayLayersFallback проверяет, что диспетчер не остаётся без видео, когда его слой закончился раньше других
+func TestRelayLayersFallback(t *testing.T) {
+	layers := newVideoLayers(3)
+	for i, name := range []string{"K0", "K1", "K2"} {
+		publishFrame(layers.hubs[i], name)
+	}
+	r := startRelay(layers, 1)
+	defer r.cancel()
+	r.expect(t, "K1")
+
+	// ближайшие живые слои 0 и 2, выбирается слой хуже качеством
+	layers.hubs[1].close()
+	r.expect(t, "K2")
+	publishFrame(layers.hubs[2], "P2")
+	r.expect(t, "P2")
+
+	layers.hubs[2].close()
+	r.expect(t, "K0")
+
+	layers.hubs[0].close()
+	r.expectDone(t, true)
+}
+
+// TestRelayLayersSwitchTargetClosed проверяет смену слоя, когда один из двух слоёв заканчивается во время смены
+func TestRelayLayersSwitchTargetClosed(t *testing.T) {
+	tests := []struct {
+		name   string
+		closed int
+		want   string
+	}{
+		// новый слой закончился до ключевого кадра: диспетчер остаётся на текущем
+		{name: "target closed", closed: 1, want: "P0"},
+		// текущий слой закончился: диспетчер ждёт ключевой кадр нового
+		{name: "current closed", closed: 0, want: "K1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers := newVideoLayers(2)
+			publishFrame(layers.hubs[0], "K0")
+			r := startRelay(layers, 0)
+			defer r.cancel()
+			r.expect(t, "K0")
+			r.layerChan <- 1
+			waitSubscribers(t, layers.hubs[1], 1)
+
+			layers.hubs[tt.closed].close()
+			// даём relayLayers обработать закрытие до следующих кадров
+			time.Sleep(10 * time.Millisecond)
+			publishFrame(layers.hubs[0], "P0")
+			publishFrame(layers.hubs[1], "K1")
+			r.expect(t, tt.want)
+		})
+	}
+}
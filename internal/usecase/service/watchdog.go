@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"self-driving-car-dispatch-system/internal/entity"
+	"self-driving-car-dispatch-system/internal/repo"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// staleTimeout - через сколько без данных поток считается зависшим
+	staleTimeout = 2 * time.Second
+	// lostTimeout - через сколько без данных поток считается потерянным
+	lostTimeout = 10 * time.Second
+	// watchdogInterval - как часто проверяется время последних данных потока
+	watchdogInterval = 250 * time.Millisecond
+	// healthRefreshInterval - как часто состояние потока обновляется в хранилище, даже если оно не изменилось
+	healthRefreshInterval = 5 * time.Second
+	// eventQueueSize - размер очереди событий каждого диспетчера
+	eventQueueSize = 16
+)
+
+// watchedStream - поток ТС, за которым следит сторож
+type watchedStream struct {
+	name string
+	// last - время последних данных в наносекундах Unix. Обновляется при каждом чтении из потока, поэтому без блокировки
+	last  atomic.Int64
+	state entity.StreamState
+	// done закрывается, когда поток заканчивается
+	done chan struct{}
+}
+
+// touch отмечает, что из потока пришли данные
+func (s *watchedStream) touch() {
+	s.last.Store(time.Now().UnixNano())
+}
+
+func (s *watchedStream) status() entity.StreamStatus {
+	return entity.StreamStatus{Stream: s.name, State: s.state, LastPacket: time.Unix(0, s.last.Load())}
+}
+
+// vehicleStreams - потоки одного ТС и диспетчеры, которые получают события о них
+type vehicleStreams struct {
+	streams  map[string]*watchedStream
+	watchers map[chan entity.StreamEvent]struct{}
+}
+
+// streamWatchdog следит за тем, что из потоков подключённых ТС приходят данные. Если соединение с ТС открыто,
+// но данные перестали приходить, то диспетчеры ТС получают событие, а администратор видит состояние через healthRepo
+type streamWatchdog struct {
+	mu         sync.Mutex
+	healthRepo repo.StreamHealthRepo
+	vehicles   map[int]*vehicleStreams
+}
+
+func newStreamWatchdog(healthRepo repo.StreamHealthRepo) *streamWatchdog {
+	return &streamWatchdog{
+		healthRepo: healthRepo,
+		vehicles:   make(map[int]*vehicleStreams),
+	}
+}
+
+// pushEvent передает событие, не блокируясь. Если диспетчер не успевает читать события, то старые отбрасываются
+func pushEvent(events chan entity.StreamEvent, event entity.StreamEvent) {
+	for {
+		select {
+		case events <- event:
+			return
+		default:
+		}
+		select {
+		case <-events:
+		default:
+		}
+	}
+}
+
+func (w *streamWatchdog) vehicle(vehicleID int) *vehicleStreams {
+	v, ok := w.vehicles[vehicleID]
+	if !ok {
+		v = &vehicleStreams{
+			streams:  make(map[string]*watchedStream),
+			watchers: make(map[chan entity.StreamEvent]struct{}),
+		}
+		w.vehicles[vehicleID] = v
+	}
+	return v
+}
+
+// release удаляет ТС, если у него не осталось ни потоков, ни диспетчеров
+func (w *streamWatchdog) release(vehicleID int, v *vehicleStreams) {
+	if len(v.streams) == 0 && len(v.watchers) == 0 {
+		delete(w.vehicles, vehicleID)
+	}
+}
+
+// track начинает следить за потоком ТС до вызова untrack. Поток с тем же названием, например,
+// после переподключения ТС, заменяет предыдущий
+func (w *streamWatchdog) track(vehicleID int, name string) *watchedStream {
+	s := &watchedStream{name: name, state: entity.StreamActive, done: make(chan struct{})}
+	s.touch()
+	w.mu.Lock()
+	w.vehicle(vehicleID).streams[name] = s
+	w.mu.Unlock()
+	go w.run(vehicleID, s)
+	return s
+}
+
+// untrack перестаёт следить за потоком, когда он закончился
+func (w *streamWatchdog) untrack(s *watchedStream) {
+	close(s.done)
+}
+
+// run проверяет время последних данных потока и сообщает об изменении его состояния. Состояние для администратора
+// необязательно для ретрансляции, поэтому ошибки хранилища её не прерывают
+func (w *streamWatchdog) run(vehicleID int, s *watchedStream) {
+	status := s.status()
+	_ = w.healthRepo.SetStreamStatus(vehicleID, &status)
+	refreshed := time.Now()
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			w.remove(vehicleID, s)
+			return
+		}
+		silence := time.Since(time.Unix(0, s.last.Load()))
+		state := entity.StreamActive
+		switch {
+		case silence >= lostTimeout:
+			state = entity.StreamLost
+		case silence >= staleTimeout:
+			state = entity.StreamStale
+		}
+		w.mu.Lock()
+		changed := state != s.state
+		s.state = state
+		status = s.status()
+		if changed {
+			for events := range w.vehicle(vehicleID).watchers {
+				pushEvent(events, entity.StreamEvent{VehicleID: vehicleID, StreamStatus: status, At: time.Now()})
+			}
+		}
+		w.mu.Unlock()
+		if changed || time.Since(refreshed) >= healthRefreshInterval {
+			refreshed = time.Now()
+			_ = w.healthRepo.SetStreamStatus(vehicleID, &status)
+		}
+	}
+}
+
+// remove удаляет закончившийся поток, если его ещё не заменил новый
+func (w *streamWatchdog) remove(vehicleID int, s *watchedStream) {
+	w.mu.Lock()
+	v := w.vehicle(vehicleID)
+	replaced := v.streams[s.name] != s
+	if !replaced {
+		delete(v.streams, s.name)
+	}
+	w.release(vehicleID, v)
+	w.mu.Unlock()
+	if !replaced {
+		_ = w.healthRepo.DeleteStreamStatus(vehicleID, s.name)
+	}
+}
+
+// watch подписывает диспетчера на события потоков ТС и сразу сообщает ему о потоках, из которых не приходят данные
+func (w *streamWatchdog) watch(vehicleID int, events chan entity.StreamEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v := w.vehicle(vehicleID)
+	v.watchers[events] = struct{}{}
+	for _, s := range v.streams {
+		if s.state != entity.StreamActive {
+			pushEvent(events, entity.StreamEvent{VehicleID: vehicleID, StreamStatus: s.status(), At: time.Now()})
+		}
+	}
+}
+
+// notify передает событие всем диспетчерам ТС
+func (w *streamWatchdog) notify(event entity.StreamEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if v, ok := w.vehicles[event.VehicleID]; ok {
+		for events := range v.watchers {
+			pushEvent(events, event)
+		}
+	}
+}
+
+func (w *streamWatchdog) unwatch(vehicleID int, events chan entity.StreamEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v := w.vehicle(vehicleID)
+	delete(v.watchers, events)
+	w.release(vehicleID, v)
+}
+
+func (b *BroadcastService) GetEventStream(ctx context.Context, vehicleID, dispatcherID int, dispatcherPassword string, events chan entity.StreamEvent, errChan chan error) {
+	if _, err := b.authDispatcher(vehicleID, dispatcherID, dispatcherPassword); err != nil {
+		sendErr(ctx, errChan, err)
+		return
+	}
+	// события копятся в своей очереди, чтобы сторож никогда не ждал медленного диспетчера
+	queue := make(chan entity.StreamEvent, eventQueueSize)
+	b.watchdog.watch(vehicleID, queue)
+	defer b.watchdog.unwatch(vehicleID, queue)
+	if b.sessions.disconnected(vehicleID) {
+		pushEvent(queue, entity.StreamEvent{VehicleID: vehicleID, StreamStatus: entity.StreamStatus{State: entity.SessionDisconnected}, At: time.Now()})
+	}
+	b.holdGrant(ctx, vehicleID, dispatcherID, errChan, func(ctx context.Context) {
+		for {
+			select {
+			case event := <-queue:
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
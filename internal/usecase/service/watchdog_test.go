@@ -0,0 +1,177 @@
+package service
+
+import (
+	"self-driving-car-dispatch-system/internal/entity"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHealthRepo - хранилище состояния потоков в памяти
+type testHealthRepo struct {
+	mu       sync.Mutex
+	statuses map[int]map[string]entity.StreamStatus
+}
+
+func newTestHealthRepo() *testHealthRepo {
+	return &testHealthRepo{statuses: make(map[int]map[string]entity.StreamStatus)}
+}
+
+func (r *testHealthRepo) SetStreamStatus(vehicleID int, status *entity.StreamStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.statuses[vehicleID] == nil {
+		r.statuses[vehicleID] = make(map[string]entity.StreamStatus)
+	}
+	r.statuses[vehicleID][status.Stream] = *status
+	return nil
+}
+
+func (r *testHealthRepo) DeleteStreamStatus(vehicleID int, stream string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.statuses[vehicleID], stream)
+	return nil
+}
+
+func (r *testHealthRepo) GetStreamStatuses(vehicleID int) ([]entity.StreamStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]entity.StreamStatus, 0, len(r.statuses[vehicleID]))
+	for _, status := range r.statuses[vehicleID] {
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// state возвращает сохранённое состояние потока, пустое - состояния нет
+func (r *testHealthRepo) state(vehicleID int, stream string) entity.StreamState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.statuses[vehicleID][stream].State
+}
+
+// silence сдвигает время последних данных потока в прошлое, как будто данные не приходили d
+func silence(s *watchedStream, d time.Duration) {
+	s.last.Store(time.Now().Add(-d).UnixNano())
+}
+
+// nextEvent ждёт следующее событие дольше, чем проходит одна проверка сторожа
+func nextEvent(t *testing.T, events chan entity.StreamEvent) entity.StreamEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(4 * watchdogInterval):
+		t.Fatal("no stream event")
+		return entity.StreamEvent{}
+	}
+}
+
+func TestStreamWatchdogTransitions(t *testing.T) {
+	healthRepo := newTestHealthRepo()
+	w := newStreamWatchdog(healthRepo)
+	events := make(chan entity.StreamEvent, eventQueueSize)
+	w.watch(testVehicleID, events)
+	defer w.unwatch(testVehicleID, events)
+	s := w.track(testVehicleID, "telemetry")
+
+	steps := []struct {
+		name    string
+		silence time.Duration
+		want    entity.StreamState
+	}{
+		{name: "stale", silence: staleTimeout, want: entity.StreamStale},
+		{name: "lost", silence: lostTimeout, want: entity.StreamLost},
+		{name: "recovered", silence: 0, want: entity.StreamActive},
+		// сразу из active в lost, минуя stale
+		{name: "lost at once", silence: lostTimeout + time.Second, want: entity.StreamLost},
+	}
+	for _, st := range steps {
+		silence(s, st.silence)
+		event := nextEvent(t, events)
+		if event.VehicleID != testVehicleID || event.Stream != "telemetry" || event.State != st.want {
+			t.Fatalf("%s: event = %+v, want telemetry %s", st.name, event, st.want)
+		}
+		if got := healthRepo.state(testVehicleID, "telemetry"); got != st.want {
+			t.Errorf("%s: stored state = %s, want %s", st.name, got, st.want)
+		}
+	}
+
+	// без изменения состояния события не повторяются
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	case <-time.After(3 * watchdogInterval):
+	}
+
+	w.untrack(s)
+	deadline := time.Now().Add(time.Second)
+	for healthRepo.state(testVehicleID, "telemetry") != "" {
+		if time.Now().After(deadline) {
+			t.Fatal("status of finished stream is not deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestStreamWatchdogFanOut проверяет, что событие получают все диспетчеры ТС, а подключившийся позже
+// сразу узнаёт о потоке без данных
+func TestStreamWatchdogFanOut(t *testing.T) {
+	w := newStreamWatchdog(newTestHealthRepo())
+	first := make(chan entity.StreamEvent, eventQueueSize)
+	second := make(chan entity.StreamEvent, eventQueueSize)
+	other := make(chan entity.StreamEvent, eventQueueSize)
+	w.watch(testVehicleID, first)
+	w.watch(testVehicleID, second)
+	w.watch(testVehicleID+1, other)
+	s := w.track(testVehicleID, "video:front")
+	defer w.untrack(s)
+
+	silence(s, staleTimeout)
+	for _, events := range []chan entity.StreamEvent{first, second} {
+		if event := nextEvent(t, events); event.State != entity.StreamStale {
+			t.Errorf("event = %+v, want stale", event)
+		}
+	}
+	if len(other) != 0 {
+		t.Errorf("dispatcher of other vehicle got %d events", len(other))
+	}
+
+	late := make(chan entity.StreamEvent, eventQueueSize)
+	w.watch(testVehicleID, late)
+	if len(late) != 1 || (<-late).State != entity.StreamStale {
+		t.Error("late dispatcher is not told about stale stream")
+	}
+
+	w.unwatch(testVehicleID, second)
+	w.notify(entity.StreamEvent{VehicleID: testVehicleID, StreamStatus: entity.StreamStatus{State: entity.SessionDisconnected}})
+	if len(first) != 1 || len(second) != 0 {
+		t.Errorf("notify: first got %d events, unwatched got %d, want 1 and 0", len(first), len(second))
+	}
+}
+
+// TestStreamWatchdogReplacedStream проверяет, что конец старого потока не удаляет состояние нового потока с тем же названием
+func TestStreamWatchdogReplacedStream(t *testing.T) {
+	healthRepo := newTestHealthRepo()
+	w := newStreamWatchdog(healthRepo)
+	old := w.track(testVehicleID, "telemetry")
+	fresh := w.track(testVehicleID, "telemetry")
+	defer w.untrack(fresh)
+	w.untrack(old)
+	time.Sleep(2 * watchdogInterval)
+	if got := healthRepo.state(testVehicleID, "telemetry"); got != entity.StreamActive {
+		t.Errorf("stored state = %q, want active", got)
+	}
+}
+
+func TestPushEventOverflow(t *testing.T) {
+	events := make(chan entity.StreamEvent, 2)
+	for i := 1; i <= 3; i++ {
+		pushEvent(events, entity.StreamEvent{VehicleID: i})
+	}
+	// медленный диспетчер теряет самое старое событие
+	if a, b := <-events, <-events; a.VehicleID != 2 || b.VehicleID != 3 {
+		t.Errorf("events = %d, %d, want 2, 3", a.VehicleID, b.VehicleID)
+	}
+}
@@ -0,0 +1,67 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSamples - сколько последних измерений учитывается при оценке смещения часов
+const maxSamples = 16
+
+type sample struct {
+	offset time.Duration
+	rtt    time.Duration
+}
+
+// Estimator оценивает смещение локальных часов относительно часов сервера по измерениям в стиле NTP.
+// Из последних измерений выбирается измерение с наименьшим RTT: у него меньше всего ошибка из-за
+// несимметричной задержки в сети
+type Estimator struct {
+	mu      sync.Mutex
+	samples []sample
+	best    sample
+	synced  bool
+}
+
+func NewEstimator() *Estimator {
+	return &Estimator{}
+}
+
+// Add учитывает измерение: клиент отправил запрос в clientSent, сервер получил его в serverReceived и ответил
+// в serverSent по своим часам, клиент получил ответ в clientReceived
+func (e *Estimator) Add(clientSent, serverReceived, serverSent, clientReceived time.Time) {
+	offset := (serverReceived.Sub(clientSent) + serverSent.Sub(clientReceived)) / 2
+	// время прохождения запроса и ответа без учёта обработки на сервере
+	rtt := clientReceived.Sub(clientSent) - serverSent.Sub(serverReceived)
+	if rtt < 0 {
+		// часы перевели во время измерения
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.samples = append(e.samples, sample{offset: offset, rtt: rtt})
+	if len(e.samples) > maxSamples {
+		e.samples = e.samples[1:]
+	}
+	e.best = e.samples[0]
+	for _, s := range e.samples[1:] {
+		if s.rtt < e.best.rtt {
+			e.best = s
+		}
+	}
+	e.synced = true
+}
+
+// Offset возвращает смещение часов сервера относительно локальных часов и RTT измерения, по которому оно получено.
+// Если измерений ещё не было, то возвращается false
+func (e *Estimator) Offset() (offset, rtt time.Duration, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.best.offset, e.best.rtt, e.synced
+}
+
+// Now возвращает текущее время по часам сервера. До первого измерения это локальное время
+func (e *Estimator) Now() time.Time {
+	offset, _, _ := e.Offset()
+	return time.Now().Add(offset)
+}
@@ -0,0 +1,123 @@
+package h264
+
+// AccessUnit - NAL units одного кадра вместе со стартовыми кодами
+type AccessUnit struct {
+	NALs [][]byte
+}
+
+// isVCL проверяет, что NAL unit содержит данные изображения
+func isVCL(nalType NALType) bool {
+	return nalType >= NALSlice && nalType <= NALIDR
+}
+
+// refIdc возвращает nal_ref_idc: 0 означает, что другие кадры не ссылаются на этот NAL unit
+func refIdc(nal []byte) uint8 {
+	payload := Payload(nal)
+	if len(payload) == 0 {
+		return 0
+	}
+	return payload[0] >> 5 & 0x3
+}
+
+// IsKeyframe проверяет, что кадр ключевой (IDR) и с него можно начать декодирование
+func (au *AccessUnit) IsKeyframe() bool {
+	return au.Has(NALIDR)
+}
+
+// IsReference проверяет, что на кадр могут ссылаться следующие кадры.
+// Неопорный кадр можно отбросить, не повредив декодирование остального потока
+func (au *AccessUnit) IsReference() bool {
+	for _, nal := range au.NALs {
+		if isVCL(Type(nal)) && refIdc(nal) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Has проверяет, что в кадре есть NAL unit указанного типа
+func (au *AccessUnit) Has(nalType NALType) bool {
+	for _, nal := range au.NALs {
+		if Type(nal) == nalType {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes возвращает кадр в формате Annex-B
+func (au *AccessUnit) Bytes() []byte {
+	size := 0
+	for _, nal := range au.NALs {
+		size += len(nal)
+	}
+	data := make([]byte, 0, size)
+	for _, nal := range au.NALs {
+		data = append(data, nal...)
+	}
+	return data
+}
+
+// Assembler собирает NAL units в кадры (access units)
+type Assembler struct {
+	current [][]byte
+	// hasVCL - в текущем кадре уже есть данные изображения
+	hasVCL bool
+}
+
+func NewAssembler() *Assembler {
+	return &Assembler{}
+}
+
+// startsAccessUnit проверяет, что nal начинает новый кадр (упрощённые правила из раздела 7.4.1.2.3 H.264)
+func (a *Assembler) startsAccessUnit(nal []byte) bool {
+	if !a.hasVCL {
+		return false
+	}
+	nalType := Type(nal)
+	switch {
+	case nalType == NALAUD || nalType == NALSEI || nalType == NALSPS || nalType == NALPPS:
+		return true
+	case nalType >= 14 && nalType <= 18:
+		return true
+	case isVCL(nalType):
+		// первый слайс кадра начинается с макроблока 0: first_mb_in_slice = ue(v) = 0 кодируется битом 1
+		payload := Payload(nal)
+		return len(payload) > 1 && payload[1]&0x80 != 0
+	default:
+		return false
+	}
+}
+
+// Add добавляет NAL unit. Если он начинает новый кадр, то возвращается предыдущий кадр, иначе nil
+func (a *Assembler) Add(nal []byte) *AccessUnit {
+	var au *AccessUnit
+	if a.startsAccessUnit(nal) {
+		au = &AccessUnit{NALs: a.current}
+		a.current = nil
+		a.hasVCL = false
+	}
+	a.current = append(a.current, nal)
+	if isVCL(Type(nal)) {
+		a.hasVCL = true
+	}
+	return au
+}
+
+// Flush возвращает недособранный кадр, например, когда поток закончился
+func (a *Assembler) Flush() *AccessUnit {
+	if len(a.current) == 0 {
+		return nil
+	}
+	au := &AccessUnit{NALs: a.current}
+	a.current = nil
+	a.hasVCL = false
+	return au
+}
+
+// NewAccessUnitDelimiter создаёт NAL unit AUD со стартовым кодом для кадра любого типа. AUD начинает новый кадр,
+// поэтому, если отправить его сразу после кадра, получатель соберёт кадр, не дожидаясь начала следующего
+func NewAccessUnitDelimiter() []byte {
+	// primary_pic_type = 7 и rbsp_trailing_bits
+	return []byte{0, 0, 0, 1, byte(NALAUD), 0xf0}
+}
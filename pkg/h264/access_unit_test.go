@@ -0,0 +1,95 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+// assemble собирает кадры из NAL units и дописывает недособранный кадр
+func assemble(nals ...[]byte) []*AccessUnit {
+	a := NewAssembler()
+	var aus []*AccessUnit
+	for _, nal := range nals {
+		if au := a.Add(nal); au != nil {
+			aus = append(aus, au)
+		}
+	}
+	if au := a.Flush(); au != nil {
+		aus = append(aus, au)
+	}
+	return aus
+}
+
+func TestAssembler(t *testing.T) {
+	tests := []struct {
+		name string
+		nals [][]byte
+		want [][][]byte
+	}{
+		{
+			name: "keyframe then slice",
+			nals: [][]byte{testSPS, testPPS, testIDR, testSlice},
+			want: [][][]byte{{testSPS, testPPS, testIDR}, {testSlice}},
+		},
+		{
+			name: "several slices per frame",
+			nals: [][]byte{testIDR, testSlice2, testSlice, testSlice2},
+			want: [][][]byte{{testIDR, testSlice2}, {testSlice, testSlice2}},
+		},
+		{
+			name: "aud starts frame",
+			nals: [][]byte{testAUD, testIDR, testAUD, testSlice},
+			want: [][][]byte{{testAUD, testIDR}, {testAUD, testSlice}},
+		},
+		{
+			// SEI перед слайсом относится к следующему кадру
+			name: "sei starts frame",
+			nals: [][]byte{testSlice, NewTimestampsSEI(), testSlice},
+			want: [][][]byte{{testSlice}, {NewTimestampsSEI(), testSlice}},
+		},
+		{
+			name: "parameter sets before first slice",
+			nals: [][]byte{testAUD, testSPS, testPPS, testIDR},
+			want: [][][]byte{{testAUD, testSPS, testPPS, testIDR}},
+		},
+		{name: "empty", nals: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := assemble(tt.nals...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("access units = %d, want %d", len(got), len(tt.want))
+			}
+			for i, au := range got {
+				if !bytes.Equal(au.Bytes(), join(tt.want[i]...)) {
+					t.Errorf("access unit %d = %x, want %x", i, au.NALs, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAccessUnitKinds(t *testing.T) {
+	tests := []struct {
+		name          string
+		au            AccessUnit
+		wantKeyframe  bool
+		wantReference bool
+	}{
+		{name: "keyframe", au: AccessUnit{NALs: [][]byte{testSPS, testPPS, testIDR}}, wantKeyframe: true, wantReference: true},
+		{name: "reference", au: AccessUnit{NALs: [][]byte{testAUD, testSlice}}, wantReference: true},
+		{name: "non-reference", au: AccessUnit{NALs: [][]byte{testAUD, testNonRef}}},
+		// nal_ref_idc у SPS не делает кадр опорным
+		{name: "no slices", au: AccessUnit{NALs: [][]byte{testSPS, testPPS}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.au.IsKeyframe(); got != tt.wantKeyframe {
+				t.Errorf("IsKeyframe = %t, want %t", got, tt.wantKeyframe)
+			}
+			if got := tt.au.IsReference(); got != tt.wantReference {
+				t.Errorf("IsReference = %t, want %t", got, tt.wantReference)
+			}
+		})
+	}
+}
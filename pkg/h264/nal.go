@@ -0,0 +1,149 @@
+package h264
+
+import "bytes"
+
+// MaxNALSize ограничивает размер одного NAL unit. Если стартовый код не найден за это число байт,
+// то накопленные данные отбрасываются и поиск начинается заново
+const MaxNALSize = 4 << 20
+
+// NALType - тип NAL unit (nal_unit_type)
+type NALType uint8
+
+const (
+	NALSlice NALType = 1 // слайс кадра, не являющегося ключевым
+	NALIDR   NALType = 5 // слайс ключевого кадра (IDR)
+	NALSEI   NALType = 6
+	NALSPS   NALType = 7
+	NALPPS   NALType = 8
+	NALAUD   NALType = 9 // разделитель кадров
+)
+
+// audSize - размер AUD без стартового кода
+const audSize = 2
+
+// startCode - стартовый код Annex-B. Перед ним может быть ещё один нулевой байт
+var startCode = []byte{0, 0, 1}
+
+// Payload возвращает NAL unit без стартового кода
+func Payload(nal []byte) []byte {
+	i := bytes.Index(nal, startCode)
+	if i < 0 {
+		return nal
+	}
+	return nal[i+len(startCode):]
+}
+
+// Type возвращает тип NAL unit. nal может начинаться со стартового кода
+func Type(nal []byte) NALType {
+	payload := Payload(nal)
+	if len(payload) == 0 {
+		return 0
+	}
+	return NALType(payload[0] & 0x1f)
+}
+
+// Splitter разбивает байтовый поток Annex-B, прочитанный фрагментами произвольного размера, на NAL unit.
+// NAL unit считается завершённым, когда получен стартовый код следующего, поэтому последний NAL unit
+// кадра отдаётся с задержкой до начала следующего кадра. Исключение - AUD: его размер известен заранее,
+// и он отдаётся сразу, поэтому AUD после кадра позволяет получателю собрать кадр без задержки
+type Splitter struct {
+	buffer []byte
+	// scanned - сколько байт буфера уже проверено на наличие стартового кода
+	scanned int
+}
+
+func NewSplitter() *Splitter {
+	return &Splitter{}
+}
+
+// Write добавляет очередной фрагмент потока
+func (s *Splitter) Write(p []byte) (int, error) {
+	s.buffer = append(s.buffer, p...)
+	return len(p), nil
+}
+
+// Next возвращает следующий NAL unit вместе со стартовым кодом или nil, если он ещё не завершён
+func (s *Splitter) Next() []byte {
+	for {
+		// данные до первого стартового кода не являются NAL unit, пропускаем их
+		start := bytes.Index(s.buffer, startCode)
+		if start < 0 {
+			// оставляем байты, которые могут оказаться началом четырёхбайтового стартового кода
+			s.discard(max(len(s.buffer)-len(startCode), 0))
+			return nil
+		}
+		if start > 0 && s.buffer[start-1] == 0 {
+			start--
+		}
+		s.discard(start)
+
+		// AUD состоит из заголовка и одного байта primary_pic_type
+		header := bytes.Index(s.buffer, startCode) + len(startCode)
+		if len(s.buffer) >= header+audSize && NALType(s.buffer[header]&0x1f) == NALAUD {
+			nal := make([]byte, header+audSize)
+			copy(nal, s.buffer)
+			s.discard(len(nal))
+			return nal
+		}
+
+		// ищем стартовый код следующего NAL unit
+		from := max(s.scanned, len(startCode)+1)
+		if from >= len(s.buffer) {
+			return nil
+		}
+		next := bytes.Index(s.buffer[from:], startCode)
+		if next < 0 {
+			s.scanned = max(len(s.buffer)-len(startCode)+1, 0)
+			if len(s.buffer) > MaxNALSize {
+				// стартовый код потерян, начинаем поиск с текущей позиции
+				s.discard(s.scanned)
+			}
+			return nil
+		}
+		end := from + next
+		if s.buffer[end-1] == 0 {
+			end--
+		}
+		nal := make([]byte, end)
+		copy(nal, s.buffer)
+		s.discard(end)
+		if len(Payload(nal)) == 0 {
+			// пустой NAL unit, например, лишние нули между стартовыми кодами
+			continue
+		}
+		return nal
+	}
+}
+
+// Flush возвращает недописанный NAL unit, например, когда поток закончился
+func (s *Splitter) Flush() []byte {
+	i := bytes.Index(s.buffer, startCode)
+	if i < 0 || len(s.buffer) == i+len(startCode) {
+		s.discard(len(s.buffer))
+		return nil
+	}
+	nal := make([]byte, len(s.buffer))
+	copy(nal, s.buffer)
+	s.discard(len(s.buffer))
+	return nal
+}
+
+// discard удаляет n байт из начала буфера
+func (s *Splitter) discard(n int) {
+	s.buffer = s.buffer[n:]
+	s.scanned = max(s.scanned-n, 0)
+}
+
+// Split разбивает целый буфер Annex-B, например, кадр, на NAL units
+func Split(data []byte) [][]byte {
+	s := NewSplitter()
+	_, _ = s.Write(data)
+	var nals [][]byte
+	for nal := s.Next(); nal != nil; nal = s.Next() {
+		nals = append(nals, nal)
+	}
+	if nal := s.Flush(); nal != nil {
+		nals = append(nals, nal)
+	}
+	return nals
+}
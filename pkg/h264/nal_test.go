@@ -0,0 +1,150 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+// nal собирает NAL unit с четырёхбайтовым стартовым кодом
+func nal(header byte, body ...byte) []byte {
+	return append([]byte{0, 0, 0, 1, header}, body...)
+}
+
+var (
+	testSPS   = nal(0x67, 0x42, 0x00, 0x1f)
+	testPPS   = nal(0x68, 0xce, 0x3c, 0x80)
+	testIDR   = nal(0x65, 0x88, 0x84, 0x21)
+	testSlice = nal(0x41, 0x9a, 0x02, 0x03)
+	// testSlice2 - второй слайс того же кадра: first_mb_in_slice != 0
+	testSlice2 = nal(0x41, 0x40, 0x02, 0x03)
+	// testNonRef - слайс неопорного кадра: nal_ref_idc = 0
+	testNonRef = nal(0x01, 0x9e, 0x02, 0x03)
+	testAUD    = NewAccessUnitDelimiter()
+)
+
+func join(nals ...[]byte) []byte {
+	return bytes.Join(nals, nil)
+}
+
+func TestTypeAndPayload(t *testing.T) {
+	tests := []struct {
+		nal         []byte
+		wantType    NALType
+		wantPayload []byte
+	}{
+		{nal: testIDR, wantType: NALIDR, wantPayload: testIDR[4:]},
+		{nal: testSPS[1:], wantType: NALSPS, wantPayload: testSPS[4:]},
+		{nal: testPPS[4:], wantType: NALPPS, wantPayload: testPPS[4:]},
+		{nal: []byte{0, 0, 1}, wantType: 0, wantPayload: []byte{}},
+		{nal: nil, wantType: 0, wantPayload: nil},
+	}
+	for _, tt := range tests {
+		if got := Type(tt.nal); got != tt.wantType {
+			t.Errorf("Type(%x) = %d, want %d", tt.nal, got, tt.wantType)
+		}
+		if got := Payload(tt.nal); !bytes.Equal(got, tt.wantPayload) {
+			t.Errorf("Payload(%x) = %x, want %x", tt.nal, got, tt.wantPayload)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	threeByte := []byte{0, 0, 1, 0x41, 0x9a, 0x02}
+	tests := []struct {
+		name string
+		data []byte
+		want [][]byte
+	}{
+		{name: "empty", data: nil, want: nil},
+		{name: "no start code", data: []byte{1, 2, 3, 4}, want: nil},
+		{name: "keyframe", data: join(testSPS, testPPS, testIDR), want: [][]byte{testSPS, testPPS, testIDR}},
+		{name: "three byte start code", data: join(threeByte, testIDR), want: [][]byte{threeByte, testIDR}},
+		{name: "garbage before start code", data: join([]byte{7, 7}, testSlice), want: [][]byte{testSlice}},
+		{name: "empty NAL units skipped", data: join([]byte{0, 0, 1}, testSlice, []byte{0, 0, 1}, testSlice), want: [][]byte{testSlice, testSlice}},
+		{name: "aud", data: join(testAUD, testSlice, testAUD), want: [][]byte{testAUD, testSlice, testAUD}},
+		{name: "start code only", data: []byte{0, 0, 0, 1}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Split(tt.data)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Split = %x, want %x", got, tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("NAL %d = %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// splitChunks передаёт data в Splitter фрагментами по chunk байт
+func splitChunks(data []byte, chunk int) (nals [][]byte, flushed []byte) {
+	s := NewSplitter()
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		_, _ = s.Write(data[:n])
+		data = data[n:]
+		for nal := s.Next(); nal != nil; nal = s.Next() {
+			nals = append(nals, nal)
+		}
+	}
+	return nals, s.Flush()
+}
+
+func TestSplitterChunks(t *testing.T) {
+	stream := join(testAUD, testSPS, testPPS, testIDR, testAUD, testSlice, testSlice2, testAUD)
+	want := [][]byte{testAUD, testSPS, testPPS, testIDR, testAUD, testSlice, testSlice2, testAUD}
+	// стартовый код и AUD могут оказаться на границе фрагментов
+	for _, chunk := range []int{1, 2, 3, 5, 7, len(stream)} {
+		got, flushed := splitChunks(stream, chunk)
+		if flushed != nil {
+			t.Errorf("chunk %d: Flush = %x, want nil", chunk, flushed)
+		}
+		if len(got) != len(want) {
+			t.Errorf("chunk %d: NALs = %x, want %x", chunk, got, want)
+			continue
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("chunk %d: NAL %d = %x, want %x", chunk, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+// TestSplitterDelay проверяет, что последний NAL unit отдаётся только с началом следующего или по Flush
+func TestSplitterDelay(t *testing.T) {
+	s := NewSplitter()
+	_, _ = s.Write(join(testSPS, testIDR))
+	if got := s.Next(); !bytes.Equal(got, testSPS) {
+		t.Fatalf("Next = %x, want SPS", got)
+	}
+	if got := s.Next(); got != nil {
+		t.Fatalf("Next = %x, want nil until next start code", got)
+	}
+	if got := s.Flush(); !bytes.Equal(got, testIDR) {
+		t.Errorf("Flush = %x, want IDR", got)
+	}
+	if got := s.Flush(); got != nil {
+		t.Errorf("second Flush = %x, want nil", got)
+	}
+}
+
+// TestSplitterLostStartCode проверяет, что слишком длинный NAL unit отбрасывается и поток восстанавливается
+func TestSplitterLostStartCode(t *testing.T) {
+	s := NewSplitter()
+	_, _ = s.Write(testSlice)
+	_, _ = s.Write(bytes.Repeat([]byte{0xff}, MaxNALSize))
+	if got := s.Next(); got != nil {
+		t.Fatalf("Next = %d bytes, want nil", len(got))
+	}
+	if len(s.buffer) > len(startCode) {
+		t.Fatalf("buffer = %d bytes after oversized NAL unit, want discarded", len(s.buffer))
+	}
+	_, _ = s.Write(join(testIDR, testAUD))
+	if got := s.Next(); !bytes.Equal(got, testIDR) {
+		t.Errorf("Next = %x, want IDR", got)
+	}
+}
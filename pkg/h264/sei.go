@@ -0,0 +1,147 @@
+package h264
+
+import (
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/exp/slices"
+	"time"
+)
+
+// seiUserDataUnregistered - тип сообщения SEI с произвольными данными, которые декодеры игнорируют
+const seiUserDataUnregistered = 5
+
+// timestampsUUID отличает сообщение SEI с метками времени от сообщений SEI кодировщика
+var timestampsUUID = [16]byte{0x5d, 0x2c, 0x8e, 0x41, 0x73, 0x0b, 0x4a, 0x6f, 0x9e, 0x15, 0xc4, 0x27, 0x3a, 0xd0, 0x61, 0x98}
+
+// escape вставляет emulation prevention bytes, чтобы внутри NAL unit не встретился стартовый код
+func escape(rbsp []byte) []byte {
+	escaped := make([]byte, 0, len(rbsp)+len(rbsp)/64)
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			escaped = append(escaped, 3)
+			zeros = 0
+		}
+		escaped = append(escaped, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return escaped
+}
+
+// unescape удаляет emulation prevention bytes
+func unescape(payload []byte) []byte {
+	rbsp := make([]byte, 0, len(payload))
+	zeros := 0
+	for _, b := range payload {
+		if zeros == 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		rbsp = append(rbsp, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return rbsp
+}
+
+// NewTimestampsSEI создаёт NAL unit SEI со стартовым кодом, в котором переданы метки времени.
+// Нулевое время передаётся как 0
+func NewTimestampsSEI(timestamps ...time.Time) []byte {
+	data := make([]byte, 0, len(timestampsUUID)+8*len(timestamps))
+	data = append(data, timestampsUUID[:]...)
+	for _, t := range timestamps {
+		var nanos int64
+		if !t.IsZero() {
+			nanos = t.UnixNano()
+		}
+		data = binary.BigEndian.AppendUint64(data, uint64(nanos))
+	}
+	rbsp := []byte{seiUserDataUnregistered}
+	size := len(data)
+	for ; size >= 255; size -= 255 {
+		rbsp = append(rbsp, 0xff)
+	}
+	rbsp = append(rbsp, byte(size))
+	rbsp = append(rbsp, data...)
+	// rbsp_trailing_bits
+	rbsp = append(rbsp, 0x80)
+	nal := []byte{0, 0, 0, 1, byte(NALSEI)}
+	return append(nal, escape(rbsp)...)
+}
+
+// ParseTimestampsSEI возвращает метки времени из NAL unit, созданного NewTimestampsSEI.
+// Если это другой NAL unit, то возвращается false
+func ParseTimestampsSEI(nal []byte) ([]time.Time, bool) {
+	if Type(nal) != NALSEI {
+		return nil, false
+	}
+	rbsp := unescape(Payload(nal)[1:])
+	if len(rbsp) < 2 || rbsp[0] != seiUserDataUnregistered {
+		return nil, false
+	}
+	size, i := 0, 1
+	for ; i < len(rbsp) && rbsp[i] == 0xff; i++ {
+		size += 255
+	}
+	if i >= len(rbsp) {
+		return nil, false
+	}
+	size += int(rbsp[i])
+	data := rbsp[i+1:]
+	if size > len(data) || size < len(timestampsUUID) || (size-len(timestampsUUID))%8 != 0 ||
+		!bytes.Equal(data[:len(timestampsUUID)], timestampsUUID[:]) {
+		return nil, false
+	}
+	var timestamps []time.Time
+	for data = data[len(timestampsUUID):size]; len(data) > 0; data = data[8:] {
+		var t time.Time
+		if nanos := int64(binary.BigEndian.Uint64(data)); nanos != 0 {
+			t = time.Unix(0, nanos)
+		}
+		timestamps = append(timestamps, t)
+	}
+	return timestamps, true
+}
+
+// vclIndex возвращает номер первого слайса кадра или число NAL units, если слайсов нет
+func (au *AccessUnit) vclIndex() int {
+	i := slices.IndexFunc(au.NALs, func(nal []byte) bool {
+		return isVCL(Type(nal))
+	})
+	if i < 0 {
+		return len(au.NALs)
+	}
+	return i
+}
+
+// InsertBeforeVCL вставляет NAL unit в кадр перед первым слайсом, как того требует стандарт для SEI
+func (au *AccessUnit) InsertBeforeVCL(nal []byte) {
+	au.NALs = slices.Insert(au.NALs, au.vclIndex(), nal)
+}
+
+// VCLOffset возвращает смещение первого слайса в Bytes(), чтобы вставить SEI в уже собранный кадр без разбора
+func (au *AccessUnit) VCLOffset() int {
+	offset := 0
+	for _, nal := range au.NALs[:au.vclIndex()] {
+		offset += len(nal)
+	}
+	return offset
+}
+
+// TakeTimestampsSEI удаляет из кадра NAL unit, созданный NewTimestampsSEI, и возвращает метки времени из него
+func (au *AccessUnit) TakeTimestampsSEI() ([]time.Time, bool) {
+	for i, nal := range au.NALs {
+		if timestamps, ok := ParseTimestampsSEI(nal); ok {
+			au.NALs = slices.Delete(au.NALs, i, i+1)
+			return timestamps, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,90 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTimestampsSEIRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		timestamps []time.Time
+	}{
+		{name: "none", timestamps: nil},
+		{name: "zero", timestamps: []time.Time{{}}},
+		{name: "three", timestamps: []time.Time{time.Unix(0, 1700000000000000000), {}, time.Unix(0, 1700000000000000003)}},
+		// нули в метках требуют emulation prevention bytes
+		{name: "escaped", timestamps: []time.Time{time.Unix(0, 1<<32), time.Unix(0, 3)}},
+		// размер больше 255 байт кодируется несколькими байтами
+		{name: "long", timestamps: make([]time.Time, 40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sei := NewTimestampsSEI(tt.timestamps...)
+			if Type(sei) != NALSEI {
+				t.Fatalf("Type = %d, want SEI", Type(sei))
+			}
+			if bytes.Contains(Payload(sei), startCode) {
+				t.Fatalf("SEI payload %x contains start code", Payload(sei))
+			}
+			got, ok := ParseTimestampsSEI(sei)
+			if !ok {
+				t.Fatal("ParseTimestampsSEI: not a timestamps SEI")
+			}
+			if len(got) != len(tt.timestamps) {
+				t.Fatalf("timestamps = %v, want %v", got, tt.timestamps)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.timestamps[i]) {
+					t.Errorf("timestamp %d = %v, want %v", i, got[i], tt.timestamps[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseTimestampsSEIRejects(t *testing.T) {
+	valid := NewTimestampsSEI(time.Unix(1, 0))
+	otherUUID := append([]byte{}, valid...)
+	otherUUID[8] ^= 0xff
+	tests := []struct {
+		name string
+		nal  []byte
+	}{
+		{name: "slice", nal: testSlice},
+		{name: "other sei type", nal: nal(byte(NALSEI), 4, 1, 0, 0x80)},
+		{name: "other uuid", nal: otherUUID},
+		{name: "truncated", nal: valid[:len(valid)-4]},
+		{name: "only header", nal: nal(byte(NALSEI))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ParseTimestampsSEI(tt.nal); ok {
+				t.Errorf("ParseTimestampsSEI(%x) = ok, want rejected", tt.nal)
+			}
+		})
+	}
+}
+
+func TestAccessUnitTimestampsSEI(t *testing.T) {
+	au := &AccessUnit{NALs: [][]byte{testAUD, testSPS, testIDR}}
+	sei := NewTimestampsSEI(time.Unix(5, 0))
+	au.InsertBeforeVCL(sei)
+	if !bytes.Equal(au.NALs[2], sei) {
+		t.Fatalf("NALs = %x, want SEI before IDR", au.NALs)
+	}
+	if got, want := au.VCLOffset(), len(testAUD)+len(testSPS)+len(sei); got != want {
+		t.Errorf("VCLOffset = %d, want %d", got, want)
+	}
+	timestamps, ok := au.TakeTimestampsSEI()
+	if !ok || len(timestamps) != 1 || !timestamps[0].Equal(time.Unix(5, 0)) {
+		t.Errorf("TakeTimestampsSEI = %v, %t, want [5s]", timestamps, ok)
+	}
+	if len(au.NALs) != 3 || au.Has(NALSEI) {
+		t.Errorf("NALs = %x, want SEI removed", au.NALs)
+	}
+	if _, ok := au.TakeTimestampsSEI(); ok {
+		t.Error("second TakeTimestampsSEI = ok, want false")
+	}
+}
@@ -1,6 +1,7 @@
 package password
 
 import (
+	"crypto/rand"
 	"encoding/base64"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -31,3 +32,13 @@ func CheckPassword(password, hash string) bool {
 	// Сравниваем пароль с хешем
 	return bcrypt.CompareHashAndPassword(decodedHash, []byte(password)) == nil
 }
+
+// GenerateSecret создаёт случайный пароль из 32 байт, закодированный в base64 без дополнения.
+// Такой пароль не нужно проверять на сложность, его выдаёт сервер при смене пароля
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
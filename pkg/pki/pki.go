@@ -0,0 +1,108 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// CA - удостоверяющий центр, который выпускает клиентские сертификаты
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// LoadCA читает сертификат и закрытый ключ удостоверяющего центра из PEM-файлов
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("сертификат %s не является сертификатом удостоверяющего центра", certFile)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("неподдерживаемый тип закрытого ключа")
+	}
+	return &CA{cert: cert, key: key}, nil
+}
+
+// LoadCertPool читает сертификаты удостоверяющих центров из PEM-файла
+func LoadCertPool(certFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("в %s нет сертификатов", certFile)
+	}
+	return pool, nil
+}
+
+// ClientCertificate - выпущенный клиентский сертификат вместе с закрытым ключом в формате PEM
+type ClientCertificate struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// Issue выпускает клиентский сертификат с именем commonName, действующий ttl. Ключ ECDSA P-256 создаётся здесь же,
+// поэтому сертификат и ключ нужно передать клиенту по защищённому каналу
+func (ca *CA) Issue(commonName string, ttl time.Duration) (*ClientCertificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		// небольшой запас на расхождение часов клиента и сервера
+		NotBefore:   now.Add(-time.Minute),
+		NotAfter:    now.Add(ttl),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientCertificate{
+		Cert:    cert,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// Serial возвращает серийный номер сертификата в шестнадцатеричном виде
+func Serial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// MaxFrameSize ограничивает размер одного кадра потока телеметрии в 8 КБ
+const MaxFrameSize = 1 << 13
+
+// frameHeaderSize - размер заголовка кадра: длина payload в формате big-endian uint32
+const frameHeaderSize = 4
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// WriteFrame записывает payload в поток одним кадром
+func WriteFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// ReadFrame читает из потока один кадр. Слишком большой кадр пропускается целиком, чтобы не потерять
+// границы следующих кадров, и возвращается ErrFrameTooLarge
+func ReadFrame(r io.Reader, maxSize int) ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(header))
+	if length > int64(maxSize) {
+		if _, err := io.CopyN(io.Discard, r, length); err != nil {
+			return nil, err
+		}
+		return nil, ErrFrameTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// FrameDecoder собирает кадры из фрагментов потока произвольного размера: один фрагмент может содержать
+// часть кадра или несколько кадров сразу
+type FrameDecoder struct {
+	maxSize int
+	buffer  []byte
+	// skip - сколько байт слишком большого кадра ещё нужно пропустить
+	skip int
+}
+
+func NewFrameDecoder(maxSize int) *FrameDecoder {
+	return &FrameDecoder{maxSize: maxSize}
+}
+
+// Write добавляет очередной фрагмент потока
+func (d *FrameDecoder) Write(p []byte) (int, error) {
+	written := len(p)
+	if d.skip > 0 {
+		n := min(d.skip, len(p))
+		d.skip -= n
+		p = p[n:]
+	}
+	d.buffer = append(d.buffer, p...)
+	return written, nil
+}
+
+// Next возвращает следующий собранный кадр или nil, если для него ещё не хватает данных.
+// Если очередной кадр больше maxSize, то он пропускается и возвращается ErrFrameTooLarge
+func (d *FrameDecoder) Next() ([]byte, error) {
+	if len(d.buffer) < frameHeaderSize {
+		return nil, nil
+	}
+	length := int(binary.BigEndian.Uint32(d.buffer))
+	if length > d.maxSize {
+		d.buffer = d.buffer[frameHeaderSize:]
+		n := min(length, len(d.buffer))
+		d.buffer = d.buffer[n:]
+		d.skip = length - n
+		return nil, ErrFrameTooLarge
+	}
+	if len(d.buffer) < frameHeaderSize+length {
+		return nil, nil
+	}
+	payload := make([]byte, length)
+	copy(payload, d.buffer[frameHeaderSize:])
+	d.buffer = d.buffer[frameHeaderSize+length:]
+	return payload, nil
+}
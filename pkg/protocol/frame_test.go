@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// frames записывает payloads кадрами подряд, без проверки размера
+func frames(payloads ...string) []byte {
+	var data []byte
+	for _, p := range payloads {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(p)))
+		data = append(data, p...)
+	}
+	return data
+}
+
+func TestWriteFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, []byte("telemetry")); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), frames("telemetry")) {
+		t.Errorf("frame = %x, want %x", buf.Bytes(), frames("telemetry"))
+	}
+	if err := WriteFrame(&buf, make([]byte, MaxFrameSize+1)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("oversized: err = %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	const maxSize = 8
+	type result struct {
+		payload string
+		err     error
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want []result
+	}{
+		{
+			name: "round trip",
+			data: frames("a", "", "12345678"),
+			want: []result{{payload: "a"}, {payload: ""}, {payload: "12345678"}, {err: io.EOF}},
+		},
+		{
+			// слишком большой кадр пропускается, следующий читается с правильной границы
+			name: "oversized skipped",
+			data: frames("123456789", "next"),
+			want: []result{{err: ErrFrameTooLarge}, {payload: "next"}, {err: io.EOF}},
+		},
+		{
+			name: "truncated header",
+			data: frames("abc")[:2],
+			want: []result{{err: io.ErrUnexpectedEOF}},
+		},
+		{
+			name: "truncated payload",
+			data: frames("abc")[:5],
+			want: []result{{err: io.ErrUnexpectedEOF}},
+		},
+		{
+			name: "truncated oversized",
+			data: frames("123456789")[:10],
+			want: []result{{err: io.EOF}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.data)
+			for i, want := range tt.want {
+				payload, err := ReadFrame(r, maxSize)
+				if !errors.Is(err, want.err) {
+					t.Fatalf("frame %d: err = %v, want %v", i, err, want.err)
+				}
+				if err == nil && string(payload) != want.payload {
+					t.Errorf("frame %d = %q, want %q", i, payload, want.payload)
+				}
+			}
+		})
+	}
+}
+
+// decodeChunks передает data в FrameDecoder фрагментами по chunk байт и собирает кадры и ошибки
+func decodeChunks(data []byte, maxSize, chunk int) []string {
+	d := NewFrameDecoder(maxSize)
+	var got []string
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		_, _ = d.Write(data[:n])
+		data = data[n:]
+		for {
+			payload, err := d.Next()
+			if errors.Is(err, ErrFrameTooLarge) {
+				got = append(got, "<too large>")
+				continue
+			}
+			if payload == nil {
+				break
+			}
+			got = append(got, string(payload))
+		}
+	}
+	return got
+}
+
+func TestFrameDecoder(t *testing.T) {
+	const maxSize = 8
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{name: "single", data: frames("abc"), want: []string{"abc"}},
+		{name: "several", data: frames("a", "bc", "12345678"), want: []string{"a", "bc", "12345678"}},
+		{name: "oversized between", data: frames("a", strings.Repeat("x", 20), "b"), want: []string{"a", "<too large>", "b"}},
+		{name: "oversized last", data: frames("a", strings.Repeat("x", 9)), want: []string{"a", "<too large>"}},
+		{name: "incomplete", data: frames("abc", "def")[:10], want: []string{"abc"}},
+	}
+	for _, tt := range tests {
+		// фрагменты разного размера: кадр приходит по байту, частями и несколько кадров в одном фрагменте
+		for _, chunk := range []int{1, 3, 5, len(tt.data)} {
+			got := decodeChunks(tt.data, maxSize, chunk)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("%s, chunk %d: frames = %q, want %q", tt.name, chunk, got, tt.want)
+			}
+		}
+	}
+}
+
+// TestFrameDecoderEmptyFrame проверяет, что пустой кадр отличается от нехватки данных
+func TestFrameDecoderEmptyFrame(t *testing.T) {
+	d := NewFrameDecoder(8)
+	_, _ = d.Write(frames(""))
+	payload, err := d.Next()
+	if err != nil || payload == nil || len(payload) != 0 {
+		t.Fatalf("Next = %q, %v, want empty frame", payload, err)
+	}
+	if payload, err = d.Next(); payload != nil || err != nil {
+		t.Errorf("Next after empty frame = %q, %v, want no frame", payload, err)
+	}
+}
@@ -0,0 +1,417 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/exp/slices"
+	"io"
+)
+
+// Version - текущая версия протокола. Сервер отклоняет клиентов с другой версией
+const Version uint16 = 6
+
+// MaxLayers - максимальное число слоёв качества видео, которые может передавать ТС
+const MaxLayers = 4
+
+// MaxCameras - максимальное число камер ТС
+const MaxCameras = 8
+
+// maxCameraIDLength ограничивает длину ID камеры
+const maxCameraIDLength = 32
+
+// maxPayloadSize ограничивает размер приветствия и ответа на него
+const maxPayloadSize = 1 << 10
+
+// magic - сигнатура, с которой начинается каждое сообщение рукопожатия
+var magic = [4]byte{'S', 'D', 'C', 'D'}
+
+var (
+	ErrBadMagic           = errors.New("bad handshake magic")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrMalformed          = errors.New("malformed handshake message")
+)
+
+// Role - роль клиента, который подключается к серверу
+type Role uint8
+
+const (
+	RoleVehicle    Role = 1
+	RoleDispatcher Role = 2
+)
+
+// Capability - набор возможностей клиента или сервера (битовая маска)
+type Capability uint32
+
+const (
+	// CapabilityCommands - поддержка потока команд телеуправления
+	CapabilityCommands Capability = 1 << iota
+	// CapabilityLease - поддержка потока аренды управления ТС (только для диспетчера)
+	CapabilityLease
+	// CapabilityDatagramVideo - передача видео диспетчеру в QUIC datagram вместо потоков (только для диспетчера).
+	// Потерянные пакеты не передаются повторно, поэтому задержка не растёт при потерях
+	CapabilityDatagramVideo
+	// CapabilityClock - поддержка потока синхронизации часов с сервером, который клиент открывает сразу после
+	// потоков, открываемых при подключении. По синхронизированным часам клиенты измеряют задержку телеметрии и видео
+	CapabilityClock
+	// CapabilityEvents - поддержка потока событий о потоках ТС (только для диспетчера). Сервер открывает его
+	// после потока аренды управления
+	CapabilityEvents
+	// CapabilitySubscriptions - подписки на ТС через управляющий поток (только для диспетчера). После ответа
+	// на приветствие диспетчер отправляет в управляющем потоке запросы подписки, а сервер - состояние подписок.
+	// На каждую подписку сервер открывает поток телеметрии и видеопотоки камер, которые начинаются со StreamHeader.
+	// С этой возможностью диспетчер может указать в приветствии ТС 0 и получать данные только по подпискам
+	CapabilitySubscriptions
+	// CapabilityFleet - поддержка потока обзора парка (только для диспетчера). Сервер открывает его после потока
+	// событий и передаёт в нём состояние всех ТС, к которым у диспетчера есть доступ. Поток не зависит от ТС
+	// в приветствии, поэтому доступен и без него
+	CapabilityFleet
+)
+
+// Has проверяет, что в наборе есть все возможности из other
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+// Encoding - формат сообщений телеметрии. ТС указывает формат, в котором отправляет телеметрию,
+// диспетчер - формат, в котором хочет её получать. Сервер при необходимости перекодирует сообщения
+type Encoding uint8
+
+const (
+	// EncodingJSON - JSON, удобен для отладки
+	EncodingJSON Encoding = iota
+	// EncodingProtobuf - Protobuf, компактен для передачи по сотовой связи
+	EncodingProtobuf
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case EncodingJSON:
+		return "json"
+	case EncodingProtobuf:
+		return "protobuf"
+	default:
+		return fmt.Sprintf("unknown encoding %d", uint8(e))
+	}
+}
+
+// Encodings - все поддерживаемые форматы телеметрии
+var Encodings = []Encoding{EncodingJSON, EncodingProtobuf}
+
+// IsValid проверяет, что формат поддерживается
+func (e Encoding) IsValid() bool {
+	return e == EncodingJSON || e == EncodingProtobuf
+}
+
+// ParseEncoding возвращает формат по его названию
+func ParseEncoding(name string) (Encoding, error) {
+	for _, encoding := range Encodings {
+		if encoding.String() == name {
+			return encoding, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown encoding %q", name)
+}
+
+// ReplyCode - код ответа сервера на приветствие
+type ReplyCode uint8
+
+const (
+	CodeOK ReplyCode = iota
+	CodeUnsupportedVersion
+	CodeMalformed
+	CodeWrongRole
+	CodeAuthFailed
+	CodeInternal
+	CodeUnsupportedEncoding
+	// CodeVehicleInactive - ТС на обслуживании или выведено из эксплуатации
+	CodeVehicleInactive
+)
+
+func (c ReplyCode) String() string {
+	switch c {
+	case CodeOK:
+		return "ok"
+	case CodeUnsupportedVersion:
+		return "unsupported protocol version"
+	case CodeMalformed:
+		return "malformed hello"
+	case CodeWrongRole:
+		return "wrong role"
+	case CodeAuthFailed:
+		return "authentication failed"
+	case CodeInternal:
+		return "internal server error"
+	case CodeUnsupportedEncoding:
+		return "unsupported encoding"
+	case CodeVehicleInactive:
+		return "vehicle is not in service"
+	default:
+		return fmt.Sprintf("unknown code %d", uint8(c))
+	}
+}
+
+// Hello - приветствие, которое клиент отправляет первым сообщением в управляющем потоке.
+// Формат (big-endian): magic[4] version[2] length[2], затем payload длиной length:
+// role[1] vehicleID[4] dispatcherID[4] capabilities[4] encoding[1] layers[1] cameras secretLength[2] secret,
+// где cameras - число камер[1] и для каждой камеры длина ID[1] и ID
+type Hello struct {
+	Version      uint16
+	Role         Role
+	VehicleID    uint32
+	DispatcherID uint32 // для ТС всегда 0
+	Capabilities Capability
+	Encoding     Encoding
+	// Layers - число слоёв качества видео, которые передаёт ТС, от 1 до MaxLayers. Для диспетчера всегда 0.
+	// ТС открывает по потоку на каждый слой, начиная с лучшего качества
+	Layers uint8
+	// Cameras - для ТС это ID всех его камер: ТС открывает Layers видеопотоков для каждой камеры в этом порядке.
+	// Для диспетчера это камеры, видео с которых он хочет получать, пустой список означает все камеры
+	Cameras []string
+	// Secret - пароль или токен сессии из HelloReply.Token прошлого подключения. ТС с клиентским сертификатом оставляет его пустым
+	Secret string
+}
+
+// HelloReply - ответ сервера на приветствие.
+// Payload: code[1] messageLength[2] message capabilities[4] encoding[1] cameras tokenLength[2] token,
+// cameras - как в Hello. Начиная с версии 6 code и message стоят в начале payload в любой версии протокола,
+// поэтому клиент другой версии может прочитать отказ и его причину
+type HelloReply struct {
+	Version uint16
+	Code    ReplyCode
+	// Capabilities - возможности, которые поддерживают и клиент, и сервер
+	Capabilities Capability
+	// Encoding - формат телеметрии, который будет использоваться в соединении
+	Encoding Encoding
+	// Cameras - камеры, видео с которых сервер передаёт диспетчеру, по потоку на камеру в этом порядке
+	Cameras []string
+	Message string
+	// Token - токен сессии, с которым клиент может переподключиться без пароля, пока токен действует.
+	// При отказе пустой
+	Token string
+}
+
+// RejectError - ошибка, которую возвращает Err, если сервер отклонил подключение
+type RejectError struct {
+	Code    ReplyCode
+	Message string
+}
+
+func (e *RejectError) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Err возвращает *RejectError, если сервер отклонил подключение
+func (r *HelloReply) Err() error {
+	if r.Code == CodeOK {
+		return nil
+	}
+	return &RejectError{Code: r.Code, Message: r.Message}
+}
+
+func writeMessage(w io.Writer, version uint16, payload []byte) error {
+	if len(payload) > maxPayloadSize {
+		return ErrMalformed
+	}
+	header := make([]byte, 8, 8+len(payload))
+	copy(header, magic[:])
+	binary.BigEndian.PutUint16(header[4:], version)
+	binary.BigEndian.PutUint16(header[6:], uint16(len(payload)))
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// readMessage читает заголовок и payload сообщения. Если версия не совпадает с Version и anyVersion не задан,
+// то payload не читается и возвращается ErrUnsupportedVersion вместе с версией отправителя
+func readMessage(r io.Reader, anyVersion bool) (uint16, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if [4]byte(header[:4]) != magic {
+		return 0, nil, ErrBadMagic
+	}
+	version := binary.BigEndian.Uint16(header[4:])
+	if version != Version && !anyVersion {
+		return version, nil, ErrUnsupportedVersion
+	}
+	length := binary.BigEndian.Uint16(header[6:])
+	if length > maxPayloadSize {
+		return version, nil, ErrMalformed
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return version, nil, err
+	}
+	return version, payload, nil
+}
+
+// readString читает строку с 2-байтовой длиной, которой заканчивается payload
+func readString(payload []byte) (string, error) {
+	s, rest, err := splitString(payload)
+	if err != nil {
+		return "", err
+	}
+	if len(rest) != 0 {
+		return "", ErrMalformed
+	}
+	return s, nil
+}
+
+// splitString читает строку с 2-байтовой длиной и возвращает оставшуюся часть payload
+func splitString(payload []byte) (string, []byte, error) {
+	if len(payload) < 2 {
+		return "", nil, ErrMalformed
+	}
+	length := int(binary.BigEndian.Uint16(payload))
+	if len(payload) < 2+length {
+		return "", nil, ErrMalformed
+	}
+	return string(payload[2 : 2+length]), payload[2+length:], nil
+}
+
+// IsCameraListValid проверяет, что камер не больше MaxCameras, а их ID непустые, не длиннее 32 байт и не повторяются
+func IsCameraListValid(cameras []string) bool {
+	if len(cameras) > MaxCameras {
+		return false
+	}
+	for i, camera := range cameras {
+		if camera == "" || len(camera) > maxCameraIDLength || slices.Contains(cameras[:i], camera) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendCameras добавляет список камер в payload
+func appendCameras(payload []byte, cameras []string) ([]byte, error) {
+	if !IsCameraListValid(cameras) {
+		return nil, ErrMalformed
+	}
+	payload = append(payload, byte(len(cameras)))
+	for _, camera := range cameras {
+		payload = append(payload, byte(len(camera)))
+		payload = append(payload, camera...)
+	}
+	return payload, nil
+}
+
+// readCameras читает список камер и возвращает оставшуюся часть payload
+func readCameras(payload []byte) ([]string, []byte, error) {
+	if len(payload) < 1 {
+		return nil, nil, ErrMalformed
+	}
+	count := int(payload[0])
+	payload = payload[1:]
+	cameras := make([]string, 0, count)
+	for range count {
+		if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+			return nil, nil, ErrMalformed
+		}
+		length := int(payload[0])
+		cameras = append(cameras, string(payload[1:1+length]))
+		payload = payload[1+length:]
+	}
+	if !IsCameraListValid(cameras) {
+		return nil, nil, ErrMalformed
+	}
+	return cameras, payload, nil
+}
+
+// WriteHello отправляет приветствие
+func WriteHello(w io.Writer, hello *Hello) error {
+	payload := make([]byte, 15, 32+len(hello.Secret))
+	payload[0] = byte(hello.Role)
+	binary.BigEndian.PutUint32(payload[1:], hello.VehicleID)
+	binary.BigEndian.PutUint32(payload[5:], hello.DispatcherID)
+	binary.BigEndian.PutUint32(payload[9:], uint32(hello.Capabilities))
+	payload[13] = byte(hello.Encoding)
+	payload[14] = hello.Layers
+	payload, err := appendCameras(payload, hello.Cameras)
+	if err != nil {
+		return err
+	}
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(hello.Secret)))
+	payload = append(payload, hello.Secret...)
+	return writeMessage(w, hello.Version, payload)
+}
+
+// ReadHello читает приветствие. При ErrUnsupportedVersion в Hello заполнено только поле Version
+func ReadHello(r io.Reader) (*Hello, error) {
+	version, payload, err := readMessage(r, false)
+	hello := &Hello{Version: version}
+	if err != nil {
+		return hello, err
+	}
+	if len(payload) < 15 {
+		return hello, ErrMalformed
+	}
+	hello.Role = Role(payload[0])
+	hello.VehicleID = binary.BigEndian.Uint32(payload[1:])
+	hello.DispatcherID = binary.BigEndian.Uint32(payload[5:])
+	hello.Capabilities = Capability(binary.BigEndian.Uint32(payload[9:]))
+	hello.Encoding = Encoding(payload[13])
+	hello.Layers = payload[14]
+	if hello.Cameras, payload, err = readCameras(payload[15:]); err != nil {
+		return hello, err
+	}
+	if hello.Secret, err = readString(payload); err != nil {
+		return hello, err
+	}
+	return hello, nil
+}
+
+// WriteHelloReply отправляет ответ на приветствие
+func WriteHelloReply(w io.Writer, reply *HelloReply) error {
+	payload := make([]byte, 1, 18+len(reply.Message)+len(reply.Token))
+	payload[0] = byte(reply.Code)
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(reply.Message)))
+	payload = append(payload, reply.Message...)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(reply.Capabilities))
+	payload = append(payload, byte(reply.Encoding))
+	payload, err := appendCameras(payload, reply.Cameras)
+	if err != nil {
+		return err
+	}
+	payload = binary.BigEndian.AppendUint16(payload, uint16(len(reply.Token)))
+	payload = append(payload, reply.Token...)
+	return writeMessage(w, reply.Version, payload)
+}
+
+// ReadHelloReply читает ответ сервера на приветствие. Отказ читается в любой версии протокола: в ответе
+// заполнены Version, Code и Message, и клиент узнаёт причину отказа из Err. Ответ другой версии без отказа
+// возвращается с ErrUnsupportedVersion
+func ReadHelloReply(r io.Reader) (*HelloReply, error) {
+	version, payload, err := readMessage(r, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 1 {
+		return nil, ErrMalformed
+	}
+	reply := &HelloReply{Version: version, Code: ReplyCode(payload[0])}
+	if reply.Message, payload, err = splitString(payload[1:]); err != nil {
+		return nil, err
+	}
+	if version != Version {
+		if reply.Code == CodeOK {
+			return reply, ErrUnsupportedVersion
+		}
+		return reply, nil
+	}
+	if len(payload) < 5 {
+		return nil, ErrMalformed
+	}
+	reply.Capabilities = Capability(binary.BigEndian.Uint32(payload))
+	reply.Encoding = Encoding(payload[4])
+	if reply.Cameras, payload, err = readCameras(payload[5:]); err != nil {
+		return nil, err
+	}
+	if reply.Token, err = readString(payload); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
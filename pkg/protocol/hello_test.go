@@ -0,0 +1,248 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHelloRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		hello Hello
+	}{
+		{
+			name: "vehicle",
+			hello: Hello{
+				Version:      Version,
+				Role:         RoleVehicle,
+				VehicleID:    42,
+				Capabilities: CapabilityCommands | CapabilityClock,
+				Encoding:     EncodingProtobuf,
+				Layers:       3,
+				Cameras:      []string{"front", "rear"},
+				Secret:       "password",
+			},
+		},
+		{
+			name: "dispatcher without cameras and secret",
+			hello: Hello{
+				Version:      Version,
+				Role:         RoleDispatcher,
+				VehicleID:    0,
+				DispatcherID: 7,
+				Capabilities: CapabilitySubscriptions | CapabilityFleet,
+				Cameras:      []string{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteHello(&buf, &tt.hello); err != nil {
+				t.Fatalf("WriteHello: %v", err)
+			}
+			got, err := ReadHello(&buf)
+			if err != nil {
+				t.Fatalf("ReadHello: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.hello) {
+				t.Errorf("ReadHello = %+v, want %+v", *got, tt.hello)
+			}
+		})
+	}
+}
+
+func TestHelloReplyRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply HelloReply
+	}{
+		{
+			name: "accepted",
+			reply: HelloReply{
+				Version:      Version,
+				Code:         CodeOK,
+				Capabilities: CapabilityLease | CapabilityEvents,
+				Encoding:     EncodingJSON,
+				Cameras:      []string{"front"},
+				Token:        "token",
+			},
+		},
+		{
+			name:  "rejected",
+			reply: HelloReply{Version: Version, Code: CodeAuthFailed, Message: "wrong password", Cameras: []string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteHelloReply(&buf, &tt.reply); err != nil {
+				t.Fatalf("WriteHelloReply: %v", err)
+			}
+			got, err := ReadHelloReply(&buf)
+			if err != nil {
+				t.Fatalf("ReadHelloReply: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.reply) {
+				t.Errorf("ReadHelloReply = %+v, want %+v", *got, tt.reply)
+			}
+		})
+	}
+}
+
+// message собирает сообщение рукопожатия с произвольными заголовком и payload
+func message(version uint16, length int, payload []byte) []byte {
+	header := make([]byte, 8, 8+len(payload))
+	copy(header, magic[:])
+	binary.BigEndian.PutUint16(header[4:], version)
+	binary.BigEndian.PutUint16(header[6:], uint16(length))
+	return append(header, payload...)
+}
+
+func TestReadHelloErrors(t *testing.T) {
+	var valid bytes.Buffer
+	if err := WriteHello(&valid, &Hello{Version: Version, Role: RoleVehicle, Layers: 1, Cameras: []string{"front"}, Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	payload := valid.Bytes()[8:]
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantErr     error
+		wantVersion uint16
+	}{
+		{name: "empty", data: nil, wantErr: io.EOF},
+		{name: "truncated header", data: valid.Bytes()[:5], wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated payload", data: valid.Bytes()[:valid.Len()-1], wantErr: io.ErrUnexpectedEOF, wantVersion: Version},
+		{name: "bad magic", data: append([]byte("XXXX"), valid.Bytes()[4:]...), wantErr: ErrBadMagic},
+		{name: "older version", data: message(Version-1, len(payload), payload), wantErr: ErrUnsupportedVersion, wantVersion: Version - 1},
+		{name: "newer version", data: message(Version+1, len(payload), payload), wantErr: ErrUnsupportedVersion, wantVersion: Version + 1},
+		{name: "oversized", data: message(Version, maxPayloadSize+1, make([]byte, maxPayloadSize+1)), wantErr: ErrMalformed, wantVersion: Version},
+		{name: "short payload", data: message(Version, 3, payload[:3]), wantErr: ErrMalformed, wantVersion: Version},
+		{name: "secret length beyond payload", data: message(Version, len(payload)-1, payload[:len(payload)-1]), wantErr: ErrMalformed, wantVersion: Version},
+		{name: "trailing bytes", data: message(Version, len(payload)+1, append(payload, 0)), wantErr: ErrMalformed, wantVersion: Version},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello, err := ReadHello(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if hello != nil && hello.Version != tt.wantVersion {
+				t.Errorf("Version = %d, want %d", hello.Version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestWriteHelloErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		hello Hello
+	}{
+		{name: "oversized secret", hello: Hello{Version: Version, Secret: strings.Repeat("s", maxPayloadSize)}},
+		{name: "too many cameras", hello: Hello{Version: Version, Cameras: strings.Split("a,b,c,d,e,f,g,h,i", ",")}},
+		{name: "duplicate camera", hello: Hello{Version: Version, Cameras: []string{"front", "front"}}},
+		{name: "empty camera", hello: Hello{Version: Version, Cameras: []string{""}}},
+		{name: "long camera", hello: Hello{Version: Version, Cameras: []string{strings.Repeat("c", maxCameraIDLength+1)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteHello(&buf, &tt.hello); !errors.Is(err, ErrMalformed) {
+				t.Errorf("err = %v, want ErrMalformed", err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("wrote %d bytes of malformed hello", buf.Len())
+			}
+		})
+	}
+}
+
+// TestReadHelloReplyOtherVersion проверяет, что клиент читает отказ сервера любой версии
+func TestReadHelloReplyOtherVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint16
+		code    ReplyCode
+		// tail - поля после message, которые в другой версии могут иметь другой формат
+		tail      []byte
+		wantErr   error
+		wantReply HelloReply
+	}{
+		{
+			name:      "newer server rejects",
+			version:   Version + 1,
+			code:      CodeUnsupportedVersion,
+			tail:      []byte{0xff, 0xff, 0xff},
+			wantReply: HelloReply{Version: Version + 1, Code: CodeUnsupportedVersion, Message: "server supports version 7"},
+		},
+		{
+			name:      "older server rejects",
+			version:   Version - 1,
+			code:      CodeAuthFailed,
+			wantReply: HelloReply{Version: Version - 1, Code: CodeAuthFailed, Message: "server supports version 7"},
+		},
+		{
+			name:      "newer server accepts",
+			version:   Version + 1,
+			code:      CodeOK,
+			tail:      []byte{1, 2, 3},
+			wantErr:   ErrUnsupportedVersion,
+			wantReply: HelloReply{Version: Version + 1, Code: CodeOK, Message: "server supports version 7"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := "server supports version 7"
+			payload := []byte{byte(tt.code)}
+			payload = binary.BigEndian.AppendUint16(payload, uint16(len(text)))
+			payload = append(payload, text...)
+			payload = append(payload, tt.tail...)
+			reply, err := ReadHelloReply(bytes.NewReader(message(tt.version, len(payload), payload)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(*reply, tt.wantReply) {
+				t.Errorf("ReadHelloReply = %+v, want %+v", *reply, tt.wantReply)
+			}
+			var reject *RejectError
+			if tt.code != CodeOK && (!errors.As(reply.Err(), &reject) || reject.Code != tt.code) {
+				t.Errorf("Err = %v, want reject with %s", reply.Err(), tt.code)
+			}
+		})
+	}
+}
+
+func TestReadHelloReplyErrors(t *testing.T) {
+	var valid bytes.Buffer
+	if err := WriteHelloReply(&valid, &HelloReply{Version: Version, Cameras: []string{"front"}, Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	payload := valid.Bytes()[8:]
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "truncated", data: valid.Bytes()[:valid.Len()-2], wantErr: io.ErrUnexpectedEOF},
+		{name: "empty payload", data: message(Version, 0, nil), wantErr: ErrMalformed},
+		{name: "message beyond payload", data: message(Version, 2, payload[:2]), wantErr: ErrMalformed},
+		{name: "missing capabilities", data: message(Version, 3, payload[:3]), wantErr: ErrMalformed},
+		{name: "missing token", data: message(Version, len(payload)-7, payload[:len(payload)-7]), wantErr: ErrMalformed},
+		{name: "oversized", data: message(Version, maxPayloadSize+1, make([]byte, maxPayloadSize+1)), wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadHelloReply(bytes.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
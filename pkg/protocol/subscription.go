@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// StreamKind - содержимое потока, который сервер открыл по подписке диспетчера
+type StreamKind uint8
+
+const (
+	// StreamInfo - телеметрия ТС, по сообщению в кадре, как в основном потоке телеметрии
+	StreamInfo StreamKind = 1
+	// StreamVideo - видео с камеры ТС в формате Annex-B
+	StreamVideo StreamKind = 2
+)
+
+// StreamHeader - заголовок, с которого начинается каждый поток, открытый сервером по подписке диспетчера.
+// Формат такой же, как у приветствия: magic[4] version[2] length[2], затем payload:
+// kind[1] vehicleID[4] cameraLength[1] camera
+type StreamHeader struct {
+	Kind      StreamKind
+	VehicleID uint32
+	// Camera - ID камеры для StreamVideo, для StreamInfo пустой
+	Camera string
+}
+
+// WriteStreamHeader отправляет заголовок потока подписки
+func WriteStreamHeader(w io.Writer, header *StreamHeader) error {
+	if len(header.Camera) > maxCameraIDLength {
+		return ErrMalformed
+	}
+	payload := make([]byte, 5, 6+len(header.Camera))
+	payload[0] = byte(header.Kind)
+	binary.BigEndian.PutUint32(payload[1:], header.VehicleID)
+	payload = append(payload, byte(len(header.Camera)))
+	payload = append(payload, header.Camera...)
+	return writeMessage(w, Version, payload)
+}
+
+// ReadStreamHeader читает заголовок потока подписки
+func ReadStreamHeader(r io.Reader) (*StreamHeader, error) {
+	_, payload, err := readMessage(r, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 6 || len(payload) != 6+int(payload[5]) {
+		return nil, ErrMalformed
+	}
+	header := &StreamHeader{
+		Kind:      StreamKind(payload[0]),
+		VehicleID: binary.BigEndian.Uint32(payload[1:]),
+		Camera:    string(payload[6:]),
+	}
+	if header.Kind != StreamInfo && header.Kind != StreamVideo {
+		return nil, ErrMalformed
+	}
+	return header, nil
+}
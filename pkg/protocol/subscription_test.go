@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestStreamHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		header StreamHeader
+	}{
+		{name: "info", header: StreamHeader{Kind: StreamInfo, VehicleID: 3}},
+		{name: "video", header: StreamHeader{Kind: StreamVideo, VehicleID: 3, Camera: "front"}},
+		{name: "max vehicle id", header: StreamHeader{Kind: StreamInfo, VehicleID: math.MaxUint32}},
+		{name: "max camera length", header: StreamHeader{Kind: StreamVideo, VehicleID: 1, Camera: strings.Repeat("c", maxCameraIDLength)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteStreamHeader(&buf, &tt.header); err != nil {
+				t.Fatalf("WriteStreamHeader: %v", err)
+			}
+			got, err := ReadStreamHeader(&buf)
+			if err != nil {
+				t.Fatalf("ReadStreamHeader: %v", err)
+			}
+			if *got != tt.header {
+				t.Errorf("ReadStreamHeader = %+v, want %+v", *got, tt.header)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("%d bytes left after header", buf.Len())
+			}
+		})
+	}
+}
+
+func TestReadStreamHeaderErrors(t *testing.T) {
+	var valid bytes.Buffer
+	if err := WriteStreamHeader(&valid, &StreamHeader{Kind: StreamVideo, VehicleID: 3, Camera: "front"}); err != nil {
+		t.Fatal(err)
+	}
+	payload := valid.Bytes()[8:]
+	// withKind возвращает копию payload с другим типом потока
+	withKind := func(kind StreamKind) []byte {
+		p := bytes.Clone(payload)
+		p[0] = byte(kind)
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "empty", data: nil, wantErr: io.EOF},
+		{name: "truncated header", data: valid.Bytes()[:5], wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated payload", data: valid.Bytes()[:valid.Len()-1], wantErr: io.ErrUnexpectedEOF},
+		{name: "bad magic", data: append([]byte("XXXX"), valid.Bytes()[4:]...), wantErr: ErrBadMagic},
+		{name: "other version", data: message(Version+1, len(payload), payload), wantErr: ErrUnsupportedVersion},
+		{name: "empty payload", data: message(Version, 0, nil), wantErr: ErrMalformed},
+		{name: "short payload", data: message(Version, 5, payload[:5]), wantErr: ErrMalformed},
+		{name: "camera beyond payload", data: message(Version, len(payload)-1, payload[:len(payload)-1]), wantErr: ErrMalformed},
+		{name: "trailing bytes", data: message(Version, len(payload)+1, append(bytes.Clone(payload), 0)), wantErr: ErrMalformed},
+		{name: "zero kind", data: message(Version, len(payload), withKind(0)), wantErr: ErrMalformed},
+		{name: "unknown kind", data: message(Version, len(payload), withKind(StreamVideo+1)), wantErr: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := ReadStreamHeader(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if header != nil {
+				t.Errorf("ReadStreamHeader = %+v, want nil", *header)
+			}
+		})
+	}
+}
+
+func TestWriteStreamHeaderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	header := StreamHeader{Kind: StreamVideo, VehicleID: 3, Camera: strings.Repeat("c", maxCameraIDLength+1)}
+	if err := WriteStreamHeader(&buf, &header); !errors.Is(err, ErrMalformed) {
+		t.Errorf("err = %v, want ErrMalformed", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %d bytes of malformed header", buf.Len())
+	}
+}
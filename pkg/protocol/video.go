@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// MaxVideoPacketSize - размер пакета видео вместе с заголовком, который гарантированно помещается в QUIC datagram
+const MaxVideoPacketSize = 1100
+
+// videoPacketHeaderSize - размер заголовка пакета видео
+const videoPacketHeaderSize = 8
+
+// VideoClockRate - частота часов меток времени видео, как в RTP для H.264
+const VideoClockRate = 90000
+
+const (
+	videoFlagStart    = 1 << 0
+	videoFlagEnd      = 1 << 1
+	videoFlagKeyframe = 1 << 2
+)
+
+// VideoPacket - фрагмент кадра видео, который передаётся в одной QUIC datagram. Заголовок устроен по образцу RTP
+// (big-endian): flags[1] camera[1] seq[2] timestamp[4], затем payload - часть кадра в формате Annex-B
+type VideoPacket struct {
+	// Camera - номер камеры в HelloReply.Cameras
+	Camera uint8
+	// Seq увеличивается на 1 с каждым пакетом камеры, по нему получатель находит потерянные пакеты
+	Seq uint16
+	// Timestamp - время кадра в единицах VideoClockRate, одинаковое у всех пакетов кадра
+	Timestamp uint32
+	// Start и End отмечают первый и последний пакет кадра
+	Start bool
+	End   bool
+	// Keyframe - пакет ключевого кадра, с которого можно начать декодирование
+	Keyframe bool
+	Payload  []byte
+}
+
+// VideoTimestamp переводит время в метку времени видео
+func VideoTimestamp(t time.Time) uint32 {
+	return uint32(t.UnixNano() * VideoClockRate / int64(time.Second))
+}
+
+// Marshal кодирует пакет для отправки в datagram
+func (p *VideoPacket) Marshal() []byte {
+	data := make([]byte, videoPacketHeaderSize, videoPacketHeaderSize+len(p.Payload))
+	if p.Start {
+		data[0] |= videoFlagStart
+	}
+	if p.End {
+		data[0] |= videoFlagEnd
+	}
+	if p.Keyframe {
+		data[0] |= videoFlagKeyframe
+	}
+	data[1] = p.Camera
+	binary.BigEndian.PutUint16(data[2:], p.Seq)
+	binary.BigEndian.PutUint32(data[4:], p.Timestamp)
+	return append(data, p.Payload...)
+}
+
+// UnmarshalVideoPacket декодирует пакет, полученный в datagram
+func UnmarshalVideoPacket(data []byte) (*VideoPacket, error) {
+	if len(data) < videoPacketHeaderSize {
+		return nil, ErrMalformed
+	}
+	return &VideoPacket{
+		Start:     data[0]&videoFlagStart != 0,
+		End:       data[0]&videoFlagEnd != 0,
+		Keyframe:  data[0]&videoFlagKeyframe != 0,
+		Camera:    data[1],
+		Seq:       binary.BigEndian.Uint16(data[2:]),
+		Timestamp: binary.BigEndian.Uint32(data[4:]),
+		Payload:   data[videoPacketHeaderSize:],
+	}, nil
+}
+
+// Packetizer разбивает кадры видео одной камеры на пакеты
+type Packetizer struct {
+	camera uint8
+	seq    uint16
+}
+
+func NewPacketizer(camera uint8) *Packetizer {
+	return &Packetizer{camera: camera}
+}
+
+// Packetize разбивает кадр на пакеты не больше MaxVideoPacketSize
+func (p *Packetizer) Packetize(frame []byte, timestamp uint32, keyframe bool) []*VideoPacket {
+	const maxPayload = MaxVideoPacketSize - videoPacketHeaderSize
+	packets := make([]*VideoPacket, 0, (len(frame)+maxPayload-1)/maxPayload)
+	for offset := 0; offset < len(frame); offset += maxPayload {
+		packets = append(packets, &VideoPacket{
+			Camera:    p.camera,
+			Seq:       p.seq,
+			Timestamp: timestamp,
+			Start:     offset == 0,
+			End:       offset+maxPayload >= len(frame),
+			Keyframe:  keyframe,
+			Payload:   frame[offset:min(offset+maxPayload, len(frame))],
+		})
+		p.seq++
+	}
+	return packets
+}
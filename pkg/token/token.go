@@ -0,0 +1,64 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// prefix отличает токен от пароля, который передаётся в том же поле
+const prefix = "sdcd."
+
+var (
+	ErrMalformed    = errors.New("malformed token")
+	ErrBadSignature = errors.New("bad token signature")
+)
+
+// Signer подписывает содержимое токена HMAC-SHA256. Токен имеет вид sdcd.<payload>.<подпись>,
+// payload и подпись закодированы в base64url без выравнивания. Содержимое не шифруется
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+func (s *Signer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// Sign возвращает токен с содержимым payload
+func (s *Signer) Sign(payload []byte) string {
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return prefix + encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded))
+}
+
+// Verify проверяет подпись токена и возвращает его содержимое
+func (s *Signer) Verify(token string) ([]byte, error) {
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, prefix), ".")
+	if !IsToken(token) || !ok {
+		return nil, ErrMalformed
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if !hmac.Equal(mac, s.mac(encoded)) {
+		return nil, ErrBadSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return payload, nil
+}
+
+// IsToken проверяет, что строка похожа на токен. Подпись при этом не проверяется
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
@@ -0,0 +1,60 @@
+package token
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	s := NewSigner([]byte("key"))
+	for _, payload := range [][]byte{[]byte(`{"role":"dispatcher","id":7}`), {}, {0, 0xff, '.'}} {
+		token := s.Sign(payload)
+		if !IsToken(token) {
+			t.Errorf("IsToken(%q) = false", token)
+		}
+		got, err := s.Verify(token)
+		if err != nil {
+			t.Fatalf("Verify(%q): %v", token, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("Verify = %q, want %q", got, payload)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	s := NewSigner([]byte("key"))
+	valid := s.Sign([]byte("payload"))
+	encoded, signature, _ := strings.Cut(strings.TrimPrefix(valid, prefix), ".")
+	tampered := s.Sign([]byte("other"))
+	tamperedPayload, _, _ := strings.Cut(strings.TrimPrefix(tampered, prefix), ".")
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "password", token: "password", wantErr: ErrMalformed},
+		{name: "no signature", token: prefix + encoded, wantErr: ErrMalformed},
+		{name: "signature not base64", token: prefix + encoded + ".!!!", wantErr: ErrMalformed},
+		{name: "payload not base64", token: s.signEncoded("!!!"), wantErr: ErrMalformed},
+		{name: "truncated signature", token: valid[:len(valid)-4], wantErr: ErrBadSignature},
+		{name: "other payload", token: prefix + tamperedPayload + "." + signature, wantErr: ErrBadSignature},
+		{name: "other key", token: NewSigner([]byte("other key")).Sign([]byte("payload")), wantErr: ErrBadSignature},
+		{name: "empty key", token: NewSigner(nil).Sign([]byte("payload")), wantErr: ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Verify(tt.token); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify(%q): err = %v, want %v", tt.token, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// signEncoded подписывает уже закодированный payload, чтобы получить токен с верной подписью и неверным base64
+func (s *Signer) signEncoded(encoded string) string {
+	return prefix + encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded))
+}